@@ -0,0 +1,42 @@
+// Package bsdiff4 makes and applies bsdiff 4 patches with one import. It
+// wraps pkg/bsdiff and pkg/bspatch, whose options it takes; those packages
+// have the rest of the API (streams, indexes, other formats).
+//
+// Example:
+//
+//	patch, err := bsdiff4.Diff(oldfile, newfile)
+//	...
+//	newfile2, err := bsdiff4.Patch(oldfile, patch)
+package bsdiff4
+
+import (
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// DiffOption configures how a patch is made, e.g. bsdiff.WithCompressor
+type DiffOption = bsdiff.Option
+
+// PatchOption configures how a patch is applied, e.g.
+// bspatch.WithMemoryLimit
+type PatchOption = bspatch.Option
+
+// Diff returns the patch from oldbs to newbs
+func Diff(oldbs, newbs []byte, opts ...DiffOption) ([]byte, error) {
+	return bsdiff.Bytes(oldbs, newbs, opts...)
+}
+
+// Patch applies patch to oldbs and returns the new bytes
+func Patch(oldbs, patch []byte, opts ...PatchOption) ([]byte, error) {
+	return bspatch.Bytes(oldbs, patch, opts...)
+}
+
+// DiffFile writes the patch from oldfile to newfile to patchfile
+func DiffFile(oldfile, newfile, patchfile string, opts ...DiffOption) error {
+	return bsdiff.File(oldfile, newfile, patchfile, opts...)
+}
+
+// PatchFile applies patchfile to oldfile and writes the result to newfile
+func PatchFile(oldfile, newfile, patchfile string, opts ...PatchOption) error {
+	return bspatch.File(oldfile, newfile, patchfile, opts...)
+}
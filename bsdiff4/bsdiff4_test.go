@@ -0,0 +1,49 @@
+package bsdiff4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+func TestRoundTrip(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	patch, err := Diff(w.Old, w.New, bsdiff.WithCompressor(bsdiff.Zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newbs, err := Patch(w.Old, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, w.New) {
+		t.Fatal("round trip failed")
+	}
+
+	dir := t.TempDir()
+	oldfile, newfile := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	patchfile, outfile := filepath.Join(dir, "patch"), filepath.Join(dir, "out")
+	if err = os.WriteFile(oldfile, w.Old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(newfile, w.New, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = DiffFile(oldfile, newfile, patchfile); err != nil {
+		t.Fatal(err)
+	}
+	if err = PatchFile(oldfile, outfile, patchfile); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, w.New) {
+		t.Fatal("file round trip failed")
+	}
+}
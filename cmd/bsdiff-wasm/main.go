@@ -0,0 +1,26 @@
+//go:build js && wasm
+
+// Command bsdiff-wasm is a WebAssembly module that sets the global bsdiff
+// to the functions of pkg/jsbsdiff. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o bsdiff.wasm ./cmd/bsdiff-wasm
+//
+// and load it with the wasm_exec.js of the Go release:
+//
+//	const go = new Go()
+//	const {instance} = await WebAssembly.instantiateStreaming(fetch("bsdiff.wasm"), go.importObject)
+//	go.run(instance)
+//	const patched = await bsdiff.patch(oldBytes, patchBytes)
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/gabstv/go-bsdiff/pkg/jsbsdiff"
+)
+
+func main() {
+	jsbsdiff.Register(js.Global(), "bsdiff")
+	// The functions live as long as the program
+	select {}
+}
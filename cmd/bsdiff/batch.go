@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+// batchMain runs "bsdiff batch [flags] manifest", making the patches the
+// manifest lists on a pool of workers. Entries with the same old file share
+// its index, which is sorted once and released after the last of them.
+func batchMain(args []string) {
+	fset := flag.NewFlagSet("batch", flag.ExitOnError)
+	var (
+		compress = fset.String("c", "bzip2", "compression of the patches: bzip2, zstd, xz, brotli or raw")
+		level    = fset.Int("level", 0, "compression level: 1-9 for bzip2, 1-22 for zstd, 1-11 for brotli (0: best)")
+		workers  = fset.Int("j", runtime.NumCPU(), "number of patches made at once")
+		jsonOut  = fset.Bool("json", false, "print the result of each entry as a line of JSON on stdout")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v batch [flags] manifest\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "manifest is a YAML list of old, new and patch files, relative to it, or - for standard input:")
+		fmt.Fprintln(os.Stderr, "  - old: v1/app\n    new: v2/app\n    patch: patches/app.patch")
+		fset.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 1 || *workers < 1 {
+		fset.Usage()
+	}
+	entries, err := readManifest(fset.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	c, err := compressor(*compress, *level)
+	if err != nil {
+		fail(err)
+	}
+	opts := []bsdiff.Option{bsdiff.WithCompressor(c)}
+
+	// Entries are diffed grouped by old file, so fewer indexes are alive at
+	// once, and reported in the order of the manifest
+	olds := make(map[string]*oldFile)
+	first := make(map[*oldFile]int)
+	for i, e := range entries {
+		f := olds[e.Old]
+		if f == nil {
+			f = &oldFile{name: e.Old}
+			olds[e.Old], first[f] = f, i
+		}
+		f.refs++
+	}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return first[olds[entries[order[i]].Old]] < first[olds[entries[order[j]].Old]]
+	})
+	results := make([]*result, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				e := entries[i]
+				results[i] = diffEntry(e, olds[e.Old], strings.ToLower(*compress), *level, opts)
+			}
+		}()
+	}
+	for _, i := range order {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	code := 0
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range results {
+		if code == 0 {
+			code = r.ExitCode
+		}
+		if *jsonOut {
+			if err = enc.Encode(r); err != nil {
+				fail(err)
+			}
+		} else if !r.OK {
+			fmt.Fprintf(os.Stderr, "bsdiff: %v: %v\n", r.Patch, r.Error)
+		}
+	}
+	os.Exit(code)
+}
+
+// readManifest parses the batch manifest name, standard input if it's -,
+// resolving the file names relative to it
+func readManifest(name string) ([]entry, error) {
+	var (
+		b   []byte
+		err error
+		dir = filepath.Dir(name)
+	)
+	if name == stdio {
+		b, err = io.ReadAll(os.Stdin)
+		dir = "."
+	} else {
+		b, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseManifest(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		for _, p := range []*string{&entries[i].Old, &entries[i].New, &entries[i].Patch} {
+			if filepath.IsAbs(*p) {
+				*p = filepath.Clean(*p)
+			} else {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// oldFile is an old file of a batch, indexed by the first entry diffed
+// against it and released by the last
+type oldFile struct {
+	name string
+	once sync.Once
+	idx  *bsdiff.Index
+	size int64
+	sum  string
+	err  error
+
+	mu   sync.Mutex
+	refs int
+}
+
+// index returns the index of f, reading and sorting it on the first call
+func (f *oldFile) index(opts []bsdiff.Option) (*bsdiff.Index, error) {
+	f.once.Do(func() {
+		b, err := os.ReadFile(f.name)
+		if err != nil {
+			f.err = err
+			return
+		}
+		s := sha256.Sum256(b)
+		f.size, f.sum = int64(len(b)), hex.EncodeToString(s[:])
+		f.idx, f.err = bsdiff.NewIndex(b, opts...)
+	})
+	return f.idx, f.err
+}
+
+// release drops a reference to f, freeing its index after the last
+func (f *oldFile) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.refs--; f.refs == 0 {
+		f.idx = nil
+	}
+}
+
+// diffEntry makes the patch of e against the index of old
+func diffEntry(e entry, old *oldFile, compression string, level int, opts []bsdiff.Option) *result {
+	defer old.release()
+	r := &result{Old: e.Old, New: e.New, Patch: e.Patch, Compression: compression, Level: level, OldSize: -1, NewSize: -1, PatchSize: -1}
+	start := time.Now()
+	err := func() error {
+		idx, err := old.index(opts)
+		if err != nil {
+			return err
+		}
+		r.OldSize, r.OldSHA256 = old.size, old.sum
+		newbs, err := os.ReadFile(e.New)
+		if err != nil {
+			return err
+		}
+		s := sha256.Sum256(newbs)
+		r.NewSize, r.NewSHA256 = int64(len(newbs)), hex.EncodeToString(s[:])
+		if err = os.MkdirAll(filepath.Dir(e.Patch), 0755); err != nil {
+			return err
+		}
+		return writeFile(e.Patch, func(patch io.WriteSeeker) error {
+			return idx.Write(newbs, patch)
+		})
+	}()
+	r.ElapsedSeconds = time.Since(start).Seconds()
+	r.setError(err)
+	if err == nil {
+		r.PatchSize, r.PatchSHA256 = fileDigest(e.Patch)
+	}
+	return r
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/dirdiff"
+)
+
+// dirMain runs "bsdiff dir [flags] oldtree newtree bundlefile", writing the
+// differences between two directory trees to a bundle for "bspatch dir"
+func dirMain(args []string) {
+	fset := flag.NewFlagSet("dir", flag.ExitOnError)
+	var (
+		compress    = fset.String("c", "bzip2", "compression of the patches: bzip2, zstd, xz, brotli or raw")
+		level       = fset.Int("level", 0, "compression level: 1-9 for bzip2, 1-22 for zstd, 1-11 for brotli (0: best)")
+		concurrency = fset.Int("j", 1, "number of goroutines to sort and compress with")
+		include     patterns
+		exclude     patterns
+	)
+	fset.Var(&include, "include", "diff only the files matching a gitignore style `pattern`, repeatable")
+	fset.Var(&exclude, "exclude", "skip the files matching a gitignore style `pattern`, repeatable")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v dir [flags] oldtree newtree bundlefile\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "bundlefile can be - for standard output")
+		fset.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 3 {
+		fset.Usage()
+	}
+	c, err := compressor(*compress, *level)
+	if err != nil {
+		fail(err)
+	}
+	rules, err := dirdiff.NewRules(include, exclude)
+	if err != nil {
+		fail(usageError(err.Error()))
+	}
+	opts := []bsdiff.Option{bsdiff.WithCompressor(c), bsdiff.WithConcurrency(*concurrency)}
+	oldtree, newtree, bundlefile := fset.Arg(0), fset.Arg(1), fset.Arg(2)
+	oldfs, newfs := dirdiff.DirFS(oldtree), dirdiff.DirFS(newtree)
+	if len(include) > 0 || len(exclude) > 0 {
+		oldfs, newfs = dirdiff.Filter(oldfs, rules), dirdiff.Filter(newfs, rules)
+	}
+	if bundlefile == stdio {
+		out := bufio.NewWriter(os.Stdout)
+		if err = dirdiff.DiffFS(oldfs, newfs, out, opts...); err == nil {
+			err = out.Flush()
+		}
+	} else {
+		err = writeFile(bundlefile, func(w io.WriteSeeker) error {
+			return dirdiff.DiffFS(oldfs, newfs, w, opts...)
+		})
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// patterns is a flag of patterns, one per use
+type patterns []string
+
+func (p *patterns) String() string { return strings.Join(*p, ",") }
+
+func (p *patterns) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
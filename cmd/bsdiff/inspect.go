@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/inspect"
+)
+
+// inspectMain runs "bsdiff inspect patchfile", printing a description of the
+// patch to stdout
+func inspectMain(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %v inspect patchfile\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "patchfile can be - for standard input")
+		os.Exit(exitUsage)
+	}
+	var info *inspect.Info
+	var err error
+	if args[0] == stdio {
+		var b []byte
+		if b, err = io.ReadAll(os.Stdin); err == nil {
+			info, err = inspect.Patch(bytes.NewReader(b))
+		}
+	} else {
+		info, err = inspect.File(args[0])
+	}
+	if err != nil {
+		fail(err)
+	}
+	if _, err = info.WriteTo(os.Stdout); err != nil {
+		fail(err)
+	}
+}
@@ -1,23 +1,289 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/inspect"
+	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		printusage(1)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "inspect":
+			inspectMain(os.Args[2:])
+			return
+		case "batch":
+			batchMain(os.Args[2:])
+			return
+		case "dir":
+			dirMain(os.Args[2:])
+			return
+		case "oci":
+			ociMain(os.Args[2:])
+			return
+		}
 	}
-	err := bsdiff.File(os.Args[1], os.Args[2], os.Args[3])
+	var (
+		compress    = flag.String("c", "bzip2", "compression of the patch: bzip2, zstd, xz, brotli or raw")
+		level       = flag.Int("level", 0, "compression level: 1-9 for bzip2, 1-22 for zstd, 1-11 for brotli (0: best)")
+		concurrency = flag.Int("j", 1, "number of goroutines to sort and compress with")
+		progress    = flag.Bool("progress", false, "show the progress of the diff on stderr")
+		verbose     = flag.Bool("v", false, "print a summary of the patch and its controls to stderr")
+		stats       = flag.Bool("stats", false, "print statistics of the diff to stderr")
+		jsonOut     = flag.Bool("json", false, "print the result as JSON, on stdout or on stderr when patchfile is -")
+		tar         = flag.Bool("tar", false, "diff tar archives entry by entry")
+		zip         = flag.Bool("zip", false, "diff zip, jar and apk archives entry by entry, inflated")
+		squashfs    = flag.Bool("squashfs", false, "diff squashfs images with their blocks inflated")
+		blockAlign  = flag.Int("block-align", 0, "diff firmware images aligned on blocks of `n` bytes")
+		reverse     = flag.String("reverse", "", "also write the undo patch, from newfile back to oldfile, to `file`")
+		hashes      = flag.Bool("sha256", false, "record the SHA-256 of oldfile and newfile in the patch, checked by bspatch and used by bspatch chain -plan")
+	)
+	flag.Usage = func() { printusage(exitUsage) }
+	flag.Parse()
+	if flag.NArg() != 3 {
+		printusage(exitUsage)
+	}
+	oldfile, newfile, patchfile := flag.Arg(0), flag.Arg(1), flag.Arg(2)
+	var (
+		res   *result
+		s     bsdiff.DiffStats
+		start = time.Now()
+	)
+	if *jsonOut {
+		res = newResult(oldfile, newfile, patchfile, strings.ToLower(*compress), *level)
+	}
+	finish := func(err error) {
+		if res == nil {
+			if err != nil {
+				fail(err)
+			}
+			return
+		}
+		res.ElapsedSeconds = time.Since(start).Seconds()
+		res.setStats(&s)
+		w := os.Stdout
+		if patchfile == stdio {
+			w = os.Stderr
+		}
+		res.write(w, err)
+	}
+	c, err := compressor(*compress, *level)
 	if err != nil {
-		println(err.Error())
-		printusage(1)
+		finish(err)
+	}
+	opts := []bsdiff.Option{bsdiff.WithCompressor(c), bsdiff.WithConcurrency(*concurrency)}
+	if *tar {
+		opts = append(opts, bsdiff.WithTar())
+	}
+	if *zip {
+		opts = append(opts, bsdiff.WithZip())
+	}
+	if *squashfs {
+		opts = append(opts, bsdiff.WithSquashfs())
+	}
+	if *blockAlign > 0 {
+		opts = append(opts, bsdiff.WithBlockAlign(*blockAlign))
+	}
+	if *hashes {
+		opts = append(opts, bsdiff.WithHashes())
+	}
+	// The undo patch is made without the progress bar and statistics
+	reverseOpts := opts
+	var bar *util.ProgressBar
+	if *progress {
+		bar = util.NewProgressBar(os.Stderr)
+		opts = append(opts, bsdiff.WithProgress(bar.Update))
+	}
+	if *stats || *jsonOut {
+		opts = append(opts, bsdiff.WithStats(&s))
+	}
+	// written hashes the patch as it's written to standard output
+	var written *digest
+	if *reverse != "" && (oldfile == stdio || newfile == stdio || *reverse == stdio) {
+		err = usageError("-reverse needs the old and new files and the undo patch to be named files")
+	} else if oldfile == stdio || newfile == stdio || patchfile == stdio {
+		var stdout io.Writer = os.Stdout
+		if res != nil {
+			written = newDigest()
+			stdout = io.MultiWriter(stdout, written)
+		}
+		err = diffStdio(oldfile, newfile, patchfile, stdout, *verbose, opts)
+	} else {
+		err = bsdiff.File(oldfile, newfile, patchfile, opts...)
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	if *reverse != "" && err == nil {
+		err = bsdiff.File(newfile, oldfile, *reverse, reverseOpts...)
+	}
+	if *verbose && err == nil && patchfile != stdio {
+		err = printSummary(inspect.File(patchfile))
+	}
+	if *stats && err == nil {
+		printStats(&s, time.Since(start))
+	}
+	if res != nil && err == nil {
+		if written != nil {
+			res.PatchSize, res.PatchSHA256 = written.n, hex.EncodeToString(written.h.Sum(nil))
+		} else {
+			res.PatchSize, res.PatchSHA256 = fileDigest(patchfile)
+		}
+	}
+	finish(err)
+}
+
+// stdio is the file name of standard input, and of standard output for the
+// patch
+const stdio = "-"
+
+// diffStdio diffs files of which some are standard input or output. A new
+// file read from standard input is diffed as it arrives, with Stream; an old
+// one is read into memory. A patch written to standard output is buffered,
+// in a temporary file once large, since its header is completed last, then
+// copied to stdout, after printing its summary if verbose.
+func diffStdio(oldfile, newfile, patchfile string, stdout io.Writer, verbose bool, opts []bsdiff.Option) error {
+	if oldfile == stdio && newfile == stdio {
+		return usageError("the old and new files can't both be read from standard input")
 	}
+	var old io.ReaderAt
+	if oldfile == stdio {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		old = bytes.NewReader(b)
+	} else {
+		f, err := os.Open(oldfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		old = f
+	}
+	diff := func(patch io.WriteSeeker) error {
+		if newfile == stdio {
+			return bsdiff.Stream(old, bufio.NewReader(os.Stdin), patch, opts...)
+		}
+		f, err := os.Open(newfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return bsdiff.Reader(io.NewSectionReader(old, 0, 1<<62), f, patch, opts...)
+	}
+	if patchfile != stdio {
+		return writeFile(patchfile, diff)
+	}
+	patch := util.NewSpillWriter(spillLimit)
+	defer patch.Close()
+	if err := diff(patch); err != nil {
+		return err
+	}
+	if verbose {
+		if err := printSummary(inspect.Patch(io.NewSectionReader(patch, 0, patch.Len()))); err != nil {
+			return err
+		}
+	}
+	_, err := patch.WriteTo(stdout)
+	return err
+}
+
+// spillLimit is the size of a patch for standard output kept in memory
+const spillLimit = 64 << 20
+
+// writeFile writes patchfile with fn through a temporary file next to it,
+// so it's never left half written
+func writeFile(patchfile string, fn func(patch io.WriteSeeker) error) error {
+	return util.WriteFile(patchfile, func(f *os.File) error {
+		return fn(f)
+	})
+}
+
+// compressor returns the compressor called name, at level unless it's 0
+func compressor(name string, level int) (bsdiff.Compressor, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "bzip2":
+		if level < 0 || level > 9 {
+			return nil, usageError("bzip2 levels are 1 to 9")
+		}
+		return bsdiff.NewBzip2(bsdiff.Bzip2Config{Level: level}), nil
+	case "zstd":
+		if level < 0 || level > 22 {
+			return nil, usageError("zstd levels are 1 to 22")
+		}
+		if level == 0 {
+			return bsdiff.Zstd, nil
+		}
+		return bsdiff.NewZstd(level), nil
+	case "brotli":
+		if level < 0 || level > 11 {
+			return nil, usageError("brotli levels are 1 to 11")
+		}
+		if level == 0 {
+			return bsdiff.Brotli, nil
+		}
+		return bsdiff.NewBrotli(level), nil
+	case "xz", "raw":
+		if level != 0 {
+			return nil, usageError(fmt.Sprintf("%v has no compression levels", name))
+		}
+		if name == "xz" {
+			return bsdiff.Xz, nil
+		}
+		return bsdiff.Raw, nil
+	}
+	return nil, usageError(fmt.Sprintf("unknown compression %q", name))
+}
+
+// printSummary prints the description of a patch to stderr
+func printSummary(info *inspect.Info, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = info.WriteTo(os.Stderr)
+	return err
+}
+
+func printStats(s *bsdiff.DiffStats, elapsed time.Duration) {
+	fmt.Fprintf(os.Stderr, "controls:     %d\n", s.Controls)
+	fmt.Fprintf(os.Stderr, "matched:      %d bytes\n", s.Matched)
+	fmt.Fprintf(os.Stderr, "extra:        %d bytes\n", s.Extra)
+	fmt.Fprintf(os.Stderr, "ctrl block:   %d bytes\n", s.CtrlSize)
+	fmt.Fprintf(os.Stderr, "diff block:   %d bytes\n", s.DiffSize)
+	fmt.Fprintf(os.Stderr, "extra block:  %d bytes\n", s.ExtraSize)
+	fmt.Fprintf(os.Stderr, "index memory: %d bytes\n", s.IndexMemory)
+	fmt.Fprintf(os.Stderr, "sort:         %v\n", s.SortTime)
+	fmt.Fprintf(os.Stderr, "scan:         %v\n", s.ScanTime)
+	fmt.Fprintf(os.Stderr, "compress:     %v\n", s.CompressTime)
+	fmt.Fprintf(os.Stderr, "total:        %v\n", elapsed)
+}
+
+// fail prints err and exits with its exit code
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "bsdiff:", err)
+	os.Exit(exitCode(err))
 }
 
 func printusage(exitcode int) {
-	println("usage: " + os.Args[0] + " oldfile newfile patchfile")
+	fmt.Fprintf(os.Stderr, "usage: %v [flags] oldfile newfile patchfile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v inspect patchfile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v batch [flags] manifest\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v dir [flags] oldtree newtree bundlefile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v oci [flags] oldlayout newlayout deltafile\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "oldfile or newfile can be - for standard input, and patchfile for standard output")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "exit codes: 1 error, 2 usage, 3 corrupt patch (inspect), 5 I/O error")
 	os.Exit(exitcode)
 }
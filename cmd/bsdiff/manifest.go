@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// entry is a diff listed in a batch manifest
+type entry struct {
+	Old, New, Patch string
+	// line is where the entry starts in the manifest
+	line int
+}
+
+// parseManifest reads a batch manifest: a YAML sequence of mappings of old,
+// new and patch file names, e.g.
+//
+//	# v1 to v2
+//	- old: v1/app
+//	  new: v2/app
+//	  patch: patches/app.patch
+//	- old: v1/app
+//	  new: v2/app-debug
+//	  patch: "patches/app debug.patch"
+//
+// Only this subset of YAML is supported: a block sequence of block mappings
+// of plain, single or double quoted scalars, and comments.
+func parseManifest(r io.Reader) ([]entry, error) {
+	var (
+		entries []entry
+		cur     *entry
+		indent  int // of the keys of cur, -1 until known
+		dash    int // of the - starting cur
+		seen    map[string]bool
+	)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text[0] == '#' || (n == 1 && text == "---") {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return nil, manifestError(n, "tabs can't indent YAML")
+		}
+		col := len(line) - len(text)
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			entries = append(entries, entry{line: n})
+			cur, seen = &entries[len(entries)-1], make(map[string]bool)
+			rest := strings.TrimLeft(text[1:], " ")
+			if rest == "" {
+				// The mapping starts on the next line, further indented
+				indent, dash = -1, col
+				continue
+			}
+			col, text = len(line)-len(rest), rest
+			indent = col
+		} else if cur != nil && indent < 0 && col > dash {
+			indent = col
+		}
+		if cur == nil || col != indent {
+			return nil, manifestError(n, "expected an entry: - old: file")
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || (value != "" && value[0] != ' ') {
+			return nil, manifestError(n, "expected key: value")
+		}
+		v, err := scalar(strings.TrimSpace(value))
+		if err != nil {
+			return nil, manifestError(n, err.Error())
+		}
+		key = strings.TrimSpace(key)
+		if seen[key] {
+			return nil, manifestError(n, fmt.Sprintf("duplicate key %q", key))
+		}
+		seen[key] = true
+		switch key {
+		case "old":
+			cur.Old = v
+		case "new":
+			cur.New = v
+		case "patch":
+			cur.Patch = v
+		default:
+			return nil, manifestError(n, fmt.Sprintf("unknown key %q", key))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Old == "" || e.New == "" || e.Patch == "" {
+			return nil, manifestError(e.line, "entries need old, new and patch files")
+		}
+	}
+	return entries, nil
+}
+
+// scalar returns the value of the YAML scalar s, stripped of any comment
+func scalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) || !comment(s[end+1:]) {
+			return "", fmt.Errorf("bad double quoted string %v", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			if !comment(s[i+1:]) {
+				break
+			}
+			return b.String(), nil
+		}
+		return "", fmt.Errorf("bad single quoted string %v", s)
+	}
+	if strings.HasPrefix(s, "#") {
+		return "", nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// comment reports whether s, following a value, is blank or a comment
+func comment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+func manifestError(line int, msg string) error {
+	return usageError(fmt.Sprintf("manifest line %v: %v", line, msg))
+}
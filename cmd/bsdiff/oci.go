@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/oci"
+)
+
+// ociMain runs "bsdiff oci [flags] oldlayout newlayout deltafile", writing
+// the delta between two container images in OCI image layouts for
+// "bspatch oci"
+func ociMain(args []string) {
+	fset := flag.NewFlagSet("oci", flag.ExitOnError)
+	var (
+		compress    = fset.String("c", "bzip2", "compression of the patches: bzip2, zstd, xz, brotli or raw")
+		level       = fset.Int("level", 0, "compression level: 1-9 for bzip2, 1-22 for zstd, 1-11 for brotli (0: best)")
+		concurrency = fset.Int("j", 1, "number of goroutines to sort and compress with")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v oci [flags] oldlayout newlayout deltafile\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "deltafile can be - for standard output")
+		fset.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 3 {
+		fset.Usage()
+	}
+	c, err := compressor(*compress, *level)
+	if err != nil {
+		fail(err)
+	}
+	opts := []bsdiff.Option{bsdiff.WithCompressor(c), bsdiff.WithConcurrency(*concurrency)}
+	oldlayout, newlayout, deltafile := fset.Arg(0), fset.Arg(1), fset.Arg(2)
+	oldfs, newfs := os.DirFS(oldlayout), os.DirFS(newlayout)
+	if deltafile == stdio {
+		out := bufio.NewWriter(os.Stdout)
+		if err = oci.DiffFS(oldfs, newfs, out, opts...); err == nil {
+			err = out.Flush()
+		}
+	} else {
+		err = writeFile(deltafile, func(w io.WriteSeeker) error {
+			return oci.DiffFS(oldfs, newfs, w, opts...)
+		})
+	}
+	if err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Exit codes, for scripts to tell failures apart. They're those of bspatch.
+const (
+	// exitError is any failure not covered below
+	exitError = 1
+	// exitUsage is a bad command line
+	exitUsage = 2
+	// exitCorrupt is a corrupt or unsupported patch given to inspect
+	exitCorrupt = 3
+	// exitIO is a file that can't be read or written
+	exitIO = 5
+)
+
+// usageError is a bad command line argument
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// exitCode returns the exit code of err
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		return exitUsage
+	case isIOError(err):
+		return exitIO
+	case errors.Is(err, bspatch.ErrCorruptPatch), errors.Is(err, bspatch.ErrUnsupportedFormat):
+		return exitCorrupt
+	}
+	return exitError
+}
+
+// isIOError reports whether err is the failure of a file system operation
+func isIOError(err error) bool {
+	var (
+		pe *fs.PathError
+		le *os.LinkError
+		se *os.SyscallError
+	)
+	return errors.As(err, &pe) || errors.As(err, &le) || errors.As(err, &se)
+}
+
+// errorKinds name the exit codes in JSON results
+var errorKinds = map[int]string{
+	exitError:   "error",
+	exitUsage:   "usage",
+	exitCorrupt: "corrupt_patch",
+	exitIO:      "io",
+}
+
+// result is what -json prints. Sizes are -1 and digests empty when unknown,
+// as for files piped through standard input. batch leaves the statistics
+// out.
+type result struct {
+	OK          bool   `json:"ok"`
+	Old         string `json:"old"`
+	New         string `json:"new"`
+	Patch       string `json:"patch"`
+	Compression string `json:"compression"`
+	Level       int    `json:"level"`
+	OldSize     int64  `json:"old_size"`
+	NewSize     int64  `json:"new_size"`
+	PatchSize   int64  `json:"patch_size"`
+	OldSHA256   string `json:"old_sha256,omitempty"`
+	NewSHA256   string `json:"new_sha256,omitempty"`
+	PatchSHA256 string `json:"patch_sha256,omitempty"`
+	// The rest up to ElapsedSeconds are those of bsdiff.DiffStats
+	Controls        int     `json:"controls,omitempty"`
+	Matched         int64   `json:"matched,omitempty"`
+	Extra           int64   `json:"extra,omitempty"`
+	CtrlSize        int64   `json:"ctrl_size,omitempty"`
+	DiffSize        int64   `json:"diff_size,omitempty"`
+	ExtraSize       int64   `json:"extra_size,omitempty"`
+	IndexMemory     int64   `json:"index_memory,omitempty"`
+	SortSeconds     float64 `json:"sort_seconds,omitempty"`
+	ScanSeconds     float64 `json:"scan_seconds,omitempty"`
+	CompressSeconds float64 `json:"compress_seconds,omitempty"`
+	ElapsedSeconds  float64 `json:"elapsed_seconds"`
+	Error           string  `json:"error,omitempty"`
+	Kind            string  `json:"kind,omitempty"`
+	ExitCode        int     `json:"exit_code"`
+}
+
+// newResult returns the result of diffing oldfile and newfile into
+// patchfile, with the sizes and digests of the files not read from standard
+// input
+func newResult(oldfile, newfile, patchfile, compression string, level int) *result {
+	r := &result{Old: oldfile, New: newfile, Patch: patchfile, Compression: compression, Level: level, OldSize: -1, NewSize: -1, PatchSize: -1}
+	r.OldSize, r.OldSHA256 = fileDigest(oldfile)
+	r.NewSize, r.NewSHA256 = fileDigest(newfile)
+	return r
+}
+
+// setStats copies s into r
+func (r *result) setStats(s *bsdiff.DiffStats) {
+	r.Controls, r.Matched, r.Extra = s.Controls, s.Matched, s.Extra
+	r.CtrlSize, r.DiffSize, r.ExtraSize = s.CtrlSize, s.DiffSize, s.ExtraSize
+	r.IndexMemory = s.IndexMemory
+	r.SortSeconds, r.ScanSeconds, r.CompressSeconds = s.SortTime.Seconds(), s.ScanTime.Seconds(), s.CompressTime.Seconds()
+}
+
+// setError records err, if the diff failed
+func (r *result) setError(err error) {
+	r.OK = err == nil
+	if err != nil {
+		r.ExitCode = exitCode(err)
+		r.Error, r.Kind = err.Error(), errorKinds[r.ExitCode]
+	}
+}
+
+// write prints r as a line of JSON to w, with err if it failed, and exits
+// with the exit code of err
+func (r *result) write(w io.Writer, err error) {
+	r.setError(err)
+	if jerr := json.NewEncoder(w).Encode(r); jerr != nil && err == nil {
+		fail(jerr)
+	}
+	os.Exit(r.ExitCode)
+}
+
+// digest hashes and counts the bytes written to it
+type digest struct {
+	h hash.Hash
+	n int64
+}
+
+func newDigest() *digest {
+	return &digest{h: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+// fileDigest returns the size and hex SHA-256 of the file name, -1 and ""
+// for standard input or if it can't be read
+func fileDigest(name string) (int64, string) {
+	if name == stdio {
+		return -1, ""
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return -1, ""
+	}
+	defer f.Close()
+	d := newDigest()
+	if _, err = io.Copy(d, f); err != nil {
+		return -1, ""
+	}
+	return d.n, hex.EncodeToString(d.h.Sum(nil))
+}
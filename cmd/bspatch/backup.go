@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Kinds of -backup
+const (
+	// backupCopy copies the file to file.bak
+	backupCopy = "copy"
+	// backupPatch writes a reverse patch from the new file to the old one
+	// to file.bak.patch, smaller than a copy
+	backupPatch = "patch"
+)
+
+// Suffixes of the backups of a file, by kind
+const (
+	copySuffix    = ".bak"
+	reverseSuffix = ".bak.patch"
+)
+
+// withBackup runs fn, which overwrites target, keeping a backup of target
+// of kind. target is restored if fn fails. There's nothing to back up if
+// target doesn't exist yet.
+func withBackup(target, kind string, fn func() error) error {
+	if _, err := os.Stat(target); err != nil {
+		return fn()
+	}
+	copyName := target + copySuffix
+	if kind == backupPatch {
+		// The copy is only needed until the reverse patch is made
+		tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".orig*")
+		if err != nil {
+			return err
+		}
+		copyName = tmp.Name()
+		tmp.Close()
+		defer os.Remove(copyName)
+	}
+	if err := bspatch.Backup(target, copyName); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		bspatch.Rollback(target, copyName)
+		return err
+	}
+	// A stale backup of the other kind would be rolled back to instead
+	if kind == backupCopy {
+		os.Remove(target + reverseSuffix)
+		return nil
+	}
+	os.Remove(target + copySuffix)
+	return writeReverse(target, copyName, target+reverseSuffix)
+}
+
+// writeReverse writes the patch from newfile back to oldfile to patchfile,
+// with the digests of both so rollback checks newfile is unchanged
+func writeReverse(newfile, oldfile, patchfile string) error {
+	return bsdiff.File(newfile, oldfile, patchfile, bsdiff.WithHashes())
+}
+
+// rollbackMain runs "bspatch rollback file", restoring the file a patch
+// applied with -backup overwrote
+func rollbackMain(args []string) {
+	fset := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v rollback file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "restores file from file%v or file%v, left by bspatch -backup\n", reverseSuffix, copySuffix)
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		fset.Usage()
+	}
+	if err := rollback(fset.Arg(0)); err != nil {
+		fail(err)
+	}
+}
+
+// rollback restores file from its reverse patch or its copy, and removes
+// the backup
+func rollback(file string) error {
+	reverse := file + reverseSuffix
+	if _, err := os.Stat(reverse); err != nil {
+		return bspatch.Rollback(file, file+copySuffix)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	err = writeFile(file, true, func(w io.Writer) error {
+		return apply(file, reverse, w, nil)
+	})
+	if err == nil {
+		err = os.Chmod(file, fi.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Remove(reverse)
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/plan"
+)
+
+// chainMain runs "bspatch chain [flags] oldfile newfile patchfile...",
+// applying the patches in order to catch up several versions at once
+func chainMain(args []string) {
+	fset := flag.NewFlagSet("chain", flag.ExitOnError)
+	var (
+		oldSHA256 = fset.String("old-sha256", "", "refuse an old file whose SHA-256 isn't this hex digest")
+		newSHA256 = fset.String("new-sha256", "", "fail if the SHA-256 of the last new file isn't this hex digest")
+		planned   = fset.Bool("plan", false, "apply the smallest chain of the patchfiles from oldfile to the -new-sha256 version, by the digests they record")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v chain [flags] oldfile newfile patchfile...\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "applies each patch to the result of the previous one; newfile is written once all applied")
+		fmt.Fprintln(os.Stderr, "oldfile or one patchfile can be - for standard input, and newfile for standard output")
+		fset.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() < 3 {
+		fset.Usage()
+	}
+	opts, err := digestOptions(*oldSHA256, *newSHA256)
+	if err != nil {
+		fail(err)
+	}
+	oldfile, newfile, patchfiles := fset.Arg(0), fset.Arg(1), fset.Args()[2:]
+	if *planned {
+		if patchfiles, err = planChain(oldfile, *newSHA256, patchfiles); err != nil {
+			fail(err)
+		}
+	}
+	if err = chain(oldfile, newfile, patchfiles, opts); err != nil {
+		fail(err)
+	}
+}
+
+// chain applies patchfiles to oldfile in order and writes the result to
+// newfile, through a temporary file renamed once complete
+func chain(oldfile, newfile string, patchfiles []string, opts []bspatch.Option) error {
+	stdin := 0
+	for _, name := range append([]string{oldfile}, patchfiles...) {
+		if name == stdio {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		return usageError("only one of the old file and the patches can be read from standard input")
+	}
+	old, c, err := readerAt(oldfile)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	patches := make([]io.ReaderAt, len(patchfiles))
+	for i, name := range patchfiles {
+		if patches[i], c, err = readerAt(name); err != nil {
+			return err
+		}
+		defer c.Close()
+	}
+	if newfile == stdio {
+		out := bufio.NewWriter(os.Stdout)
+		if err = bspatch.Chain(old, out, patches, opts...); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+	return writeFile(newfile, true, func(w io.Writer) error {
+		return bspatch.Chain(old, w, patches, opts...)
+	})
+}
+
+// planChain returns the patchfiles of the smallest chain from oldfile to
+// the version whose SHA-256 is newSHA256
+func planChain(oldfile, newSHA256 string, patchfiles []string) ([]string, error) {
+	if newSHA256 == "" {
+		return nil, usageError("-plan needs -new-sha256")
+	}
+	if oldfile == stdio {
+		return nil, usageError("-plan can't read the old file from standard input")
+	}
+	for _, name := range patchfiles {
+		if name == stdio {
+			return nil, usageError("-plan can't read patches from standard input")
+		}
+	}
+	g, err := plan.Files(patchfiles)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(oldfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	path, err := g.Path(hex.EncodeToString(h.Sum(nil)), strings.ToLower(newSHA256))
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("'%v' already is the -new-sha256 version", oldfile)
+	}
+	names := make([]string, len(path))
+	for i, p := range path {
+		names[i] = p.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/dirdiff"
+)
+
+// dirMain runs "bspatch dir [flags] oldtree bundlefile newtree", making a
+// directory tree from an old one and a bundle made by "bsdiff dir"
+func dirMain(args []string) {
+	fset := flag.NewFlagSet("dir", flag.ExitOnError)
+	var include, exclude patterns
+	fset.Var(&include, "include", "make only the files matching a gitignore style `pattern`, repeatable")
+	fset.Var(&exclude, "exclude", "skip the files matching a gitignore style `pattern`, repeatable")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v dir [flags] oldtree bundlefile newtree\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "bundlefile can be - for standard input; newtree must not exist")
+		fset.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 3 {
+		fset.Usage()
+	}
+	rules, err := dirdiff.NewRules(include, exclude)
+	if err != nil {
+		fail(usageError(err.Error()))
+	}
+	oldtree, bundlefile, newtree := fset.Arg(0), fset.Arg(1), fset.Arg(2)
+	oldfs := os.DirFS(oldtree)
+	if len(include) > 0 || len(exclude) > 0 {
+		oldfs = dirdiff.Filter(oldfs, rules)
+	}
+	if bundlefile == stdio {
+		err = dirdiff.ApplyFS(oldfs, os.Stdin, newtree)
+	} else {
+		err = applyDir(oldfs, bundlefile, newtree)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// applyDir applies bundlefile to oldfs, making the directory newtree
+func applyDir(oldfs fs.FS, bundlefile, newtree string) error {
+	f, err := os.Open(bundlefile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return dirdiff.ApplyFS(oldfs, f, newtree)
+}
+
+// patterns is a flag of patterns, one per use
+type patterns []string
+
+func (p *patterns) String() string { return strings.Join(*p, ",") }
+
+func (p *patterns) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
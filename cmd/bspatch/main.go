@@ -1,23 +1,332 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/inspect"
+	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		printusage(1)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "dir":
+			dirMain(os.Args[2:])
+			return
+		case "oci":
+			ociMain(os.Args[2:])
+			return
+		case "rollback":
+			rollbackMain(os.Args[2:])
+			return
+		case "chain":
+			chainMain(os.Args[2:])
+			return
+		}
 	}
-	err := bspatch.File(os.Args[1], os.Args[2], os.Args[3])
+	var (
+		verify    = flag.Bool("verify", false, "only check that the patch applies: bspatch -verify oldfile patchfile")
+		oldSHA256 = flag.String("old-sha256", "", "refuse an old file whose SHA-256 isn't this hex digest")
+		newSHA256 = flag.String("new-sha256", "", "fail if the SHA-256 of the new file isn't this hex digest")
+		atomic    = flag.Bool("atomic", false, "write the new file to a temporary file and rename it, so newfile is never left half written")
+		inPlace   = flag.Bool("inplace", false, "overwrite the old file with the new one: bspatch -inplace file patchfile")
+		jsonOut   = flag.Bool("json", false, "print the result as JSON, on stdout or on stderr when newfile is -")
+		progress  = flag.Bool("progress", false, "show the progress of patching on stderr")
+		verbose   = flag.Bool("v", false, "print a summary of the patch and its controls to stderr, unless it's read from standard input")
+		backup    = flag.String("backup", "", "keep the file overwritten for bspatch rollback: copy (to file.bak) or patch (a reverse patch, file.bak.patch)")
+	)
+	flag.Usage = func() { printusage(exitUsage) }
+	flag.Parse()
+	var mode, oldfile, newfile, patchfile string
+	switch {
+	case *verify && *inPlace, *atomic && (*verify || *inPlace):
+		printusage(exitUsage)
+	case *verify:
+		if flag.NArg() != 2 {
+			printusage(exitUsage)
+		}
+		mode, oldfile, patchfile = "verify", flag.Arg(0), flag.Arg(1)
+	case *inPlace:
+		if flag.NArg() != 2 || flag.Arg(0) == stdio {
+			printusage(exitUsage)
+		}
+		mode, oldfile, newfile, patchfile = "inplace", flag.Arg(0), flag.Arg(0), flag.Arg(1)
+	default:
+		if flag.NArg() != 3 {
+			printusage(exitUsage)
+		}
+		mode, oldfile, newfile, patchfile = "apply", flag.Arg(0), flag.Arg(1), flag.Arg(2)
+	}
+	if (*backup != "" && *backup != backupCopy && *backup != backupPatch) || (*backup != "" && (mode == "verify" || newfile == stdio)) {
+		printusage(exitUsage)
+	}
+	var (
+		opts  []bspatch.Option
+		res   *result
+		stats bspatch.PatchStats
+		start = time.Now()
+	)
+	if *jsonOut {
+		res = newResult(mode, oldfile, newfile, patchfile)
+		opts = append(opts, bspatch.WithStats(&stats))
+	}
+	var bar *util.ProgressBar
+	if *progress {
+		bar = util.NewProgressBar(os.Stderr)
+		opts = append(opts, bspatch.WithProgress(bar.Update))
+	}
+	finish := func(err error) {
+		if res == nil {
+			if err != nil {
+				fail(err)
+			}
+			return
+		}
+		res.ElapsedSeconds = time.Since(start).Seconds()
+		res.setStats(&stats)
+		w := os.Stdout
+		if newfile == stdio {
+			w = os.Stderr
+		}
+		res.write(w, err)
+	}
+	dopts, err := digestOptions(*oldSHA256, *newSHA256)
 	if err != nil {
-		println(err.Error())
-		printusage(1)
+		finish(err)
+	}
+	opts = append(opts, dopts...)
+	// written hashes the new file as it's written when it isn't a file
+	// hashed once complete
+	var written *digest
+	hashed := func(w io.Writer) io.Writer {
+		if res == nil {
+			return w
+		}
+		written = newDigest()
+		return io.MultiWriter(w, written)
+	}
+	run := func() error {
+		switch {
+		case mode == "verify":
+			return apply(oldfile, patchfile, hashed(io.Discard), opts)
+		case mode == "inplace":
+			return inPlaceFile(oldfile, patchfile, opts)
+		case newfile == stdio:
+			out := bufio.NewWriter(os.Stdout)
+			if err := apply(oldfile, patchfile, hashed(out), opts); err != nil {
+				return err
+			}
+			return out.Flush()
+		case oldfile == stdio || patchfile == stdio:
+			return writeFile(newfile, *atomic, func(w io.Writer) error {
+				return apply(oldfile, patchfile, w, opts)
+			})
+		case *atomic:
+			return atomicFile(oldfile, newfile, patchfile, opts)
+		}
+		return bspatch.File(oldfile, newfile, patchfile, opts...)
+	}
+	// A newfile already patched is left alone, so retries are safe
+	applied := false
+	if mode != "verify" && newfile != stdio && patchfile != stdio {
+		applied, err = alreadyApplied(newfile, patchfile, opts)
+	}
+	switch {
+	case err != nil || applied:
+	case *backup != "":
+		err = withBackup(newfile, *backup, run)
+	default:
+		err = run()
+	}
+	if applied {
+		if res != nil {
+			res.AlreadyApplied = true
+		} else {
+			fmt.Fprintf(os.Stderr, "bspatch: '%v' is already patched\n", newfile)
+		}
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	if *verbose && err == nil && patchfile != stdio {
+		var info *inspect.Info
+		if info, err = inspect.File(patchfile); err == nil {
+			_, err = info.WriteTo(os.Stderr)
+		}
+	}
+	if res != nil && err == nil {
+		if written != nil {
+			res.NewSize, res.NewSHA256 = written.n, hex.EncodeToString(written.h.Sum(nil))
+		} else if n, sum, herr := hashFile(newfile); herr == nil {
+			res.NewSize, res.NewSHA256 = n, hex.EncodeToString(sum)
+		}
+	}
+	finish(err)
+}
+
+// digestOptions returns the options checking the old and new files against
+// the hex digests oldSHA256 and newSHA256, if not empty
+func digestOptions(oldSHA256, newSHA256 string) ([]bspatch.Option, error) {
+	var opts []bspatch.Option
+	for _, d := range []struct {
+		hex string
+		opt func([]byte) bspatch.Option
+	}{{oldSHA256, bspatch.WithOldSHA256}, {newSHA256, bspatch.WithNewSHA256}} {
+		if d.hex == "" {
+			continue
+		}
+		sum, err := hex.DecodeString(d.hex)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, usageError(fmt.Sprintf("invalid SHA-256 %q", d.hex))
+		}
+		opts = append(opts, d.opt(sum))
+	}
+	return opts, nil
+}
+
+// stdio is the file name of standard input, and of standard output for the
+// new file
+const stdio = "-"
+
+// readerAt opens name for reading at any offset. Standard input is read into
+// memory.
+func readerAt(name string) (io.ReaderAt, io.Closer, error) {
+	if name == stdio {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(b), io.NopCloser(nil), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+// alreadyApplied reports whether newfile already is the new file of
+// patchfile, by its SHA-256
+func alreadyApplied(newfile, patchfile string, opts []bspatch.Option) (bool, error) {
+	f, err := os.Open(newfile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	patch, err := os.Open(patchfile)
+	if err != nil {
+		return false, err
+	}
+	defer patch.Close()
+	return bspatch.Applied(f, patch, opts...)
+}
+
+// apply applies patchfile to oldfile and writes the new file to out. A patch
+// read from standard input is applied as it arrives, with ApplyStream.
+func apply(oldfile, patchfile string, out io.Writer, opts []bspatch.Option) error {
+	if oldfile == stdio && patchfile == stdio {
+		return usageError("the old file and the patch can't both be read from standard input")
+	}
+	old, c, err := readerAt(oldfile)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if patchfile == stdio {
+		return bspatch.ApplyStream(old, os.Stdin, out, opts...)
+	}
+	patch, c, err := readerAt(patchfile)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return bspatch.Apply(old, patch, out, opts...)
+}
+
+// inPlaceFile applies patchfile to file, overwriting it
+func inPlaceFile(file, patchfile string, opts []bspatch.Option) error {
+	patch, c, err := readerAt(patchfile)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return bspatch.InPlace(file, patch, opts...)
+}
+
+// writeFile writes newfile with fn, through a temporary file renamed once
+// complete if atomic. newfile is removed if fn fails.
+func writeFile(newfile string, atomic bool, fn func(w io.Writer) error) error {
+	if !atomic {
+		f, err := os.Create(newfile)
+		if err != nil {
+			return err
+		}
+		out := bufio.NewWriter(f)
+		err = fn(out)
+		if err == nil {
+			err = out.Flush()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(newfile)
+		}
+		return err
+	}
+	return util.WriteFile(newfile, func(f *os.File) error {
+		out := bufio.NewWriter(f)
+		if err := fn(out); err != nil {
+			return err
+		}
+		return out.Flush()
+	})
+}
+
+// atomicFile applies the patch to a temporary file next to newfile, then
+// renames it to newfile, so newfile is replaced atomically and left as it
+// was if patching fails
+func atomicFile(oldfile, newfile, patchfile string, opts []bspatch.Option) error {
+	return util.WriteFile(newfile, func(f *os.File) error {
+		return bspatch.File(oldfile, f.Name(), patchfile, opts...)
+	})
+}
+
+// fail prints err and exits with its exit code
+func fail(err error) {
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "bspatch: ") {
+		msg = "bspatch: " + msg
 	}
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(exitCode(err))
 }
 
 func printusage(exitcode int) {
-	println("usage: " + os.Args[0] + " oldfile newfile patchfile")
+	fmt.Fprintf(os.Stderr, "usage: %v [flags] oldfile newfile patchfile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v -verify [flags] oldfile patchfile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v -inplace [flags] file patchfile\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v dir [flags] oldtree bundlefile newtree\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v oci oldlayout deltafile newlayout\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v rollback file\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v chain [flags] oldfile newfile patchfile...\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "oldfile or patchfile can be - for standard input, and newfile for standard output")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "exit codes: 1 error, 2 usage, 3 corrupt patch, 4 wrong old file, 5 I/O error")
 	os.Exit(exitcode)
 }
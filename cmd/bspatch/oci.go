@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/oci"
+)
+
+// ociMain runs "bspatch oci oldlayout deltafile newlayout", making the OCI
+// image layout of a container image from an old one and a delta made by
+// "bsdiff oci"
+func ociMain(args []string) {
+	fset := flag.NewFlagSet("oci", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v oci oldlayout deltafile newlayout\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "deltafile can be - for standard input; newlayout must not exist")
+		os.Exit(exitUsage)
+	}
+	fset.Parse(args)
+	if fset.NArg() != 3 {
+		fset.Usage()
+	}
+	oldlayout, deltafile, newlayout := fset.Arg(0), fset.Arg(1), fset.Arg(2)
+	var err error
+	if deltafile == stdio {
+		err = oci.ApplyFS(os.DirFS(oldlayout), os.Stdin, newlayout)
+	} else {
+		err = oci.Apply(oldlayout, deltafile, newlayout)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
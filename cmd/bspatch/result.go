@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Exit codes, for scripts to tell failures apart
+const (
+	// exitError is any failure not covered below
+	exitError = 1
+	// exitUsage is a bad command line
+	exitUsage = 2
+	// exitCorrupt is a corrupt or unsupported patch, or a new file that
+	// doesn't match its digest
+	exitCorrupt = 3
+	// exitWrongOld is an old file that isn't the one the patch was made from
+	exitWrongOld = 4
+	// exitIO is a file that can't be read or written
+	exitIO = 5
+)
+
+// usageError is a bad command line argument
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// exitCode returns the exit code of err
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		return exitUsage
+	case isIOError(err):
+		return exitIO
+	case errors.Is(err, bspatch.ErrWrongOld):
+		return exitWrongOld
+	case errors.Is(err, bspatch.ErrCorruptPatch), errors.Is(err, bspatch.ErrUnsupportedFormat):
+		return exitCorrupt
+	}
+	return exitError
+}
+
+// isIOError reports whether err is the failure of a file system operation
+func isIOError(err error) bool {
+	var (
+		pe *fs.PathError
+		le *os.LinkError
+		se *os.SyscallError
+	)
+	return errors.As(err, &pe) || errors.As(err, &le) || errors.As(err, &se)
+}
+
+// errorKinds name the exit codes in JSON results
+var errorKinds = map[int]string{
+	exitError:    "error",
+	exitUsage:    "usage",
+	exitCorrupt:  "corrupt_patch",
+	exitWrongOld: "wrong_old",
+	exitIO:       "io",
+}
+
+// result is what -json prints. Sizes are -1 and digests empty when unknown,
+// as for files piped through standard input or output.
+type result struct {
+	OK        bool   `json:"ok"`
+	Mode      string `json:"mode"`
+	Old       string `json:"old"`
+	New       string `json:"new,omitempty"`
+	Patch     string `json:"patch"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	PatchSize int64  `json:"patch_size"`
+	OldSHA256 string `json:"old_sha256,omitempty"`
+	NewSHA256 string `json:"new_sha256,omitempty"`
+	// Controls, Matched, Extra, ApplySeconds and PeakMemory are those of
+	// bspatch.PatchStats
+	Controls       int     `json:"controls"`
+	Matched        int64   `json:"matched"`
+	Extra          int64   `json:"extra"`
+	PeakMemory     int64   `json:"peak_memory"`
+	ApplySeconds   float64 `json:"apply_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Error          string  `json:"error,omitempty"`
+	Kind           string  `json:"kind,omitempty"`
+	ExitCode       int     `json:"exit_code"`
+
+	// AlreadyApplied is set when the new file was already patched and left
+	// alone
+	AlreadyApplied bool `json:"already_applied,omitempty"`
+}
+
+// newResult returns the result of applying patchfile to oldfile in mode,
+// with the size and digest of oldfile unless it's standard input, and the
+// size of patchfile
+func newResult(mode, oldfile, newfile, patchfile string) *result {
+	r := &result{Mode: mode, Old: oldfile, New: newfile, Patch: patchfile, OldSize: -1, NewSize: -1, PatchSize: -1}
+	if oldfile != stdio {
+		if n, sum, err := hashFile(oldfile); err == nil {
+			r.OldSize, r.OldSHA256 = n, hex.EncodeToString(sum)
+		}
+	}
+	if patchfile != stdio {
+		if fi, err := os.Stat(patchfile); err == nil {
+			r.PatchSize = fi.Size()
+		}
+	}
+	return r
+}
+
+// setStats copies s into r
+func (r *result) setStats(s *bspatch.PatchStats) {
+	r.Controls, r.Matched, r.Extra = s.Controls, s.Matched, s.Extra
+	r.PeakMemory, r.ApplySeconds = s.PeakMemory, s.ApplyTime.Seconds()
+}
+
+// write prints r as a line of JSON to w, with err if it failed, and exits
+// with the exit code of err
+func (r *result) write(w io.Writer, err error) {
+	r.OK = err == nil
+	if err != nil {
+		r.ExitCode = exitCode(err)
+		r.Error, r.Kind = err.Error(), errorKinds[r.ExitCode]
+	}
+	if jerr := json.NewEncoder(w).Encode(r); jerr != nil && err == nil {
+		fail(jerr)
+	}
+	os.Exit(r.ExitCode)
+}
+
+// digest hashes and counts the bytes written to it
+type digest struct {
+	h hash.Hash
+	n int64
+}
+
+func newDigest() *digest {
+	return &digest{h: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+// hashFile returns the size and SHA-256 of the file name
+func hashFile(name string) (int64, []byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	d := newDigest()
+	if _, err = io.Copy(d, f); err != nil {
+		return 0, nil, err
+	}
+	return d.n, d.h.Sum(nil), nil
+}
@@ -1,3 +1,20 @@
 module github.com/gabstv/go-bsdiff
 
-require github.com/dsnet/compress v0.0.0-20171208185109-cc9eb1d7ad76
+go 1.20
+
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/dsnet/compress v0.0.0-20171208185109-cc9eb1d7ad76
+	github.com/klauspost/compress v1.17.9
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.24.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
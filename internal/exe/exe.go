@@ -0,0 +1,220 @@
+// Package exe normalizes the code of executables before diffing, in the
+// spirit of Courgette. Recompiling a program shifts code around, which
+// changes the relative displacement of nearly every branch even when the
+// code itself didn't change. Rewriting the displacements of calls and jumps
+// in the executable sections as absolute targets makes the old and new code
+// much more alike, and the rewrite is exactly reversible.
+//
+// The executable sections are found by parsing ELF, PE and Mach-O files.
+// x86 (CALL/JMP rel32) and arm64 (BL) code is supported.
+package exe
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+)
+
+// Arch selects the branch encoding of the code
+type Arch byte
+
+// Supported architectures
+const (
+	X86   Arch = 1
+	ARM64 Arch = 2
+)
+
+// Range is an executable section: Len bytes at file offset Off, loaded at
+// the virtual address Addr
+type Range struct {
+	Off  int
+	Len  int
+	Addr int
+}
+
+// Transform records how the old and new files of a patch were normalized
+type Transform struct {
+	Arch Arch
+	Old  []Range
+	New  []Range
+}
+
+// errCorrupt is returned by Unmarshal for a malformed transform record
+var errCorrupt = errors.New("corrupt executable transform")
+
+// Parse returns the architecture and executable sections of the ELF, PE or
+// Mach-O file b. ok is false when b isn't an executable of a supported
+// architecture.
+func Parse(b []byte) (arch Arch, ranges []Range, ok bool) {
+	r := bytes.NewReader(b)
+	if f, err := elf.NewFile(r); err == nil {
+		switch f.Machine {
+		case elf.EM_386, elf.EM_X86_64:
+			arch = X86
+		case elf.EM_AARCH64:
+			arch = ARM64
+		}
+		for _, s := range f.Sections {
+			if s.Flags&elf.SHF_EXECINSTR != 0 && s.Type != elf.SHT_NOBITS {
+				ranges = append(ranges, Range{int(s.Offset), int(s.Size), int(s.Addr)})
+			}
+		}
+	} else if f, err := pe.NewFile(r); err == nil {
+		switch f.Machine {
+		case pe.IMAGE_FILE_MACHINE_I386, pe.IMAGE_FILE_MACHINE_AMD64:
+			arch = X86
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			arch = ARM64
+		}
+		for _, s := range f.Sections {
+			if s.Characteristics&pe.IMAGE_SCN_MEM_EXECUTE != 0 {
+				ranges = append(ranges, Range{int(s.Offset), int(s.Size), int(s.VirtualAddress)})
+			}
+		}
+	} else if f, err := macho.NewFile(r); err == nil {
+		switch f.Cpu {
+		case macho.Cpu386, macho.CpuAmd64:
+			arch = X86
+		case macho.CpuArm64:
+			arch = ARM64
+		}
+		for _, s := range f.Sections {
+			// S_ATTR_PURE_INSTRUCTIONS | S_ATTR_SOME_INSTRUCTIONS
+			if s.Flags&0x80000400 != 0 && s.Offset != 0 {
+				ranges = append(ranges, Range{int(s.Offset), int(s.Size), int(s.Addr)})
+			}
+		}
+	}
+	if arch == 0 {
+		return 0, nil, false
+	}
+	// drop sections that are out of bounds of a truncated or odd file
+	valid := ranges[:0]
+	for _, rg := range ranges {
+		if rg.Off >= 0 && rg.Len > 0 && rg.Off+rg.Len <= len(b) && rg.Addr >= 0 {
+			valid = append(valid, rg)
+		}
+	}
+	return arch, valid, len(valid) > 0
+}
+
+// Encode rewrites the branch displacements in the ranges of b as absolute
+// targets, in place
+func Encode(arch Arch, b []byte, ranges []Range) {
+	for _, r := range ranges {
+		convert(arch, b[r.Off:r.Off+r.Len], r.Addr, true)
+	}
+}
+
+// Decode reverses Encode, in place
+func Decode(arch Arch, b []byte, ranges []Range) {
+	for i := len(ranges) - 1; i >= 0; i-- {
+		r := ranges[i]
+		convert(arch, b[r.Off:r.Off+r.Len], r.Addr, false)
+	}
+}
+
+func convert(arch Arch, b []byte, addr int, encode bool) {
+	switch arch {
+	case X86:
+		// The opcode bytes are never rewritten and the operands are
+		// skipped, so decoding visits the same instructions as encoding
+		for i := 0; i+5 <= len(b); i++ {
+			if b[i] != 0xE8 && b[i] != 0xE9 {
+				continue
+			}
+			pc := uint32(addr + i + 5)
+			v := binary.LittleEndian.Uint32(b[i+1:])
+			if encode {
+				v += pc
+			} else {
+				v -= pc
+			}
+			binary.LittleEndian.PutUint32(b[i+1:], v)
+			i += 4
+		}
+	case ARM64:
+		if addr%4 != 0 {
+			return
+		}
+		for i := 0; i+4 <= len(b); i += 4 {
+			w := binary.LittleEndian.Uint32(b[i:])
+			if w>>26 != 0x25 { // BL
+				continue
+			}
+			pc := uint32(addr+i) / 4
+			imm := w & 0x03FFFFFF
+			if encode {
+				imm += pc
+			} else {
+				imm -= pc
+			}
+			binary.LittleEndian.PutUint32(b[i:], w&0xFC000000|imm&0x03FFFFFF)
+		}
+	}
+}
+
+// Marshal encodes the transform as the architecture followed by the old and
+// new ranges, each a uvarint count of uvarint offset, length and address
+// triples
+func (t *Transform) Marshal() []byte {
+	out := []byte{byte(t.Arch)}
+	for _, ranges := range [][]Range{t.Old, t.New} {
+		out = binary.AppendUvarint(out, uint64(len(ranges)))
+		for _, r := range ranges {
+			out = binary.AppendUvarint(out, uint64(r.Off))
+			out = binary.AppendUvarint(out, uint64(r.Len))
+			out = binary.AppendUvarint(out, uint64(r.Addr))
+		}
+	}
+	return out
+}
+
+// Unmarshal decodes a transform encoded by Marshal
+func Unmarshal(b []byte) (*Transform, error) {
+	if len(b) == 0 || (Arch(b[0]) != X86 && Arch(b[0]) != ARM64) {
+		return nil, errCorrupt
+	}
+	t := &Transform{Arch: Arch(b[0])}
+	r := bytes.NewReader(b[1:])
+	read := func() (int, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > 1<<62 {
+			return 0, errCorrupt
+		}
+		return int(v), nil
+	}
+	for _, ranges := range []*[]Range{&t.Old, &t.New} {
+		n, err := read()
+		if err != nil || n > r.Len() {
+			return nil, errCorrupt
+		}
+		for i := 0; i < n; i++ {
+			var rg Range
+			if rg.Off, err = read(); err != nil {
+				return nil, err
+			}
+			if rg.Len, err = read(); err != nil {
+				return nil, err
+			}
+			if rg.Addr, err = read(); err != nil {
+				return nil, err
+			}
+			*ranges = append(*ranges, rg)
+		}
+	}
+	return t, nil
+}
+
+// Check reports whether the ranges fit in a file of size bytes
+func Check(ranges []Range, size int) bool {
+	for _, r := range ranges {
+		if r.Off < 0 || r.Len < 0 || r.Off > size || r.Len > size-r.Off {
+			return false
+		}
+	}
+	return true
+}
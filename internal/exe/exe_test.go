@@ -0,0 +1,97 @@
+package exe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	b := make([]byte, 1024*64)
+	rng.Read(b)
+	for i := 0; i < len(b); i += 17 {
+		b[i] = 0xE8
+	}
+	ranges := []Range{{0, 1024 * 32, 0x401000}, {1024*32 + 3, 1024 * 16, 0x10000}}
+	for _, arch := range []Arch{X86, ARM64} {
+		enc := append([]byte(nil), b...)
+		Encode(arch, enc, ranges)
+		if bytes.Equal(enc, b) {
+			t.Fatal(arch, "nothing was rewritten")
+		}
+		Decode(arch, enc, ranges)
+		if !bytes.Equal(enc, b) {
+			t.Fatal(arch, "round trip failed")
+		}
+	}
+}
+
+// code calls the absolute target from each of n call sites of x86 code
+// loaded at addr
+func code(addr, target, n int) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, 0x90, 0x90, 0xE8, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(target-(addr+len(b))))
+	}
+	return b
+}
+
+func TestMovedCode(t *testing.T) {
+	// the same code, moved by 16 bytes
+	oldbs := code(0x1000, 0x8000, 100)
+	newbs := code(0x1010, 0x8000, 100)
+	if bytes.Equal(oldbs, newbs) {
+		t.Fatal("the displacements should differ")
+	}
+	Encode(X86, oldbs, []Range{{0, len(oldbs), 0x1000}})
+	Encode(X86, newbs, []Range{{0, len(newbs), 0x1010}})
+	if !bytes.Equal(oldbs, newbs) {
+		t.Fatal("the normalized code should be identical")
+	}
+}
+
+func TestParse(t *testing.T) {
+	switch runtime.GOARCH {
+	case "386", "amd64", "arm64":
+	default:
+		t.Skip("unsupported architecture", runtime.GOARCH)
+	}
+	name, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	if _, ranges, ok := Parse(b); !ok || len(ranges) == 0 {
+		t.Fatal("the test binary should be a supported executable")
+	}
+	if _, _, ok := Parse([]byte("not an executable")); ok {
+		t.Fatal("parsed a text file")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tr := &Transform{
+		Arch: ARM64,
+		Old:  []Range{{64, 1000, 0x400040}},
+		New:  []Range{{64, 1200, 0x400040}, {2000, 10, 0x600000}},
+	}
+	got, err := Unmarshal(tr.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tr) {
+		t.Fatal(got, "!=", tr)
+	}
+	if _, err = Unmarshal([]byte{byte(X86), 200}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
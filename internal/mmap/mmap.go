@@ -0,0 +1,54 @@
+// Package mmap maps files into memory read-only
+package mmap
+
+import "os"
+
+// File is a file mapped into memory
+type File struct {
+	// Data is the content of the file. It must not be written to.
+	Data []byte
+	f    *os.File
+}
+
+// Open maps the file at path. Empty files and platforms without mmap fall
+// back to reading the file.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		f.Close()
+		return &File{}, nil
+	}
+	if int64(int(size)) != size {
+		f.Close()
+		return nil, errTooLarge
+	}
+	data, err := mmap(f, int(size))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{Data: data, f: f}, nil
+}
+
+// Close unmaps the file. Data can't be used afterwards.
+func (m *File) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	err := munmap(m.Data)
+	m.Data = nil
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	m.f = nil
+	return err
+}
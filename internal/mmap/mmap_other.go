@@ -0,0 +1,24 @@
+//go:build !unix && !windows
+
+package mmap
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+var errTooLarge = errors.New("file too large to read")
+
+// mmap reads the file where mmap isn't available
+func mmap(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func munmap(b []byte) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package mmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errTooLarge = errors.New("file too large to map")
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
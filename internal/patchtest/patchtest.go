@@ -0,0 +1,53 @@
+// Package patchtest has the fixtures shared by the tests that diff files and
+// apply the patches: the files, the round trip through bsdiff and bspatch
+// and the files on disk.
+package patchtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Files returns an old file of size pseudo-random bytes and a new file with
+// bytes 100 to 400 of it changed and size/16 pseudo-random bytes appended
+func Files(size int) (oldbs, newbs []byte) {
+	oldbs = testdata.Random(1, size)
+	newbs = append(append([]byte(nil), oldbs...), testdata.Random(2, size/16)...)
+	copy(newbs[100:400], testdata.Random(3, 300))
+	return oldbs, newbs
+}
+
+// RoundTrip diffs oldbs and newbs with opts and applies the patch with
+// patchOpts, failing t unless that makes newbs. It returns the patch.
+func RoundTrip(t testing.TB, oldbs, newbs []byte, opts []bsdiff.Option, patchOpts ...bspatch.Option) []byte {
+	t.Helper()
+	patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newbs2, err := bspatch.Bytes(oldbs, patch, patchOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, newbs2) {
+		t.Fatalf("round trip of a %q patch failed", patch[:8])
+	}
+	return patch
+}
+
+// WriteFile writes b to the file name in dir, failing t on error, and
+// returns its path
+func WriteFile(t testing.TB, dir, name string, b []byte) string {
+	t.Helper()
+	name = filepath.Join(dir, name)
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
@@ -0,0 +1,325 @@
+// Package squashfs finds the compressed blocks of squashfs images, the
+// read-only filesystems of embedded Linux firmware, so they can be inflated
+// before diffing. A small edit to a file changes most of its compressed
+// blocks, and of the inode table, which bsdiff can't match much of.
+//
+// Only images compressed with gzip (zlib streams) are read: the data blocks
+// of regular files, the fragment blocks holding their tails and the
+// metadata blocks of the inode table.
+package squashfs
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"sort"
+)
+
+// Block is a zlib stream of Len bytes at offset Off of an image
+type Block struct {
+	Off int
+	Len int
+}
+
+const (
+	magic = 0x73717368
+	// gzipCompression is the compression id of zlib streams
+	gzipCompression = 1
+	// noFragments is the superblock flag of images without fragments
+	noFragments = 0x10
+	// metadataSize is the most a metadata block inflates to
+	metadataSize = 8192
+	// uncompressedMetadata and uncompressedData flag blocks stored as they
+	// are
+	uncompressedMetadata = 0x8000
+	uncompressedData     = 1 << 24
+	// noFragment is the fragment index of files without a fragment
+	noFragment = 0xffffffff
+)
+
+// superblock is the start of an image
+type superblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModificationTime    uint32
+	BlockSize           uint32
+	FragmentEntryCount  uint32
+	CompressionID       uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInodeRef        uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+// image reads the tables of an image, failing on anything out of bounds
+type image struct {
+	b      []byte
+	sb     superblock
+	blocks []Block
+	ok     bool
+}
+
+// Blocks returns the compressed data, fragment and inode table blocks of the
+// gzip compressed squashfs image b, sorted by offset, or false if b isn't
+// one
+func Blocks(b []byte) ([]Block, bool) {
+	img := &image{b: b, ok: true}
+	if binary.Read(bytes.NewReader(b), binary.LittleEndian, &img.sb) != nil {
+		return nil, false
+	}
+	sb := &img.sb
+	if sb.Magic != magic || sb.VersionMajor != 4 || sb.CompressionID != gzipCompression ||
+		sb.BlockSize < 4096 || sb.BlockSize > 1<<20 || 1<<sb.BlockLog != sb.BlockSize ||
+		sb.BytesUsed > uint64(len(b)) || sb.InodeTableStart > sb.DirectoryTableStart {
+		return nil, false
+	}
+	inodes := img.metadata(int(sb.InodeTableStart), int(sb.DirectoryTableStart))
+	img.files(inodes)
+	if sb.Flags&noFragments == 0 && sb.FragmentEntryCount > 0 {
+		img.fragments()
+	}
+	if !img.ok {
+		return nil, false
+	}
+	sort.Slice(img.blocks, func(i, j int) bool { return img.blocks[i].Off < img.blocks[j].Off })
+	blocks := img.blocks[:0]
+	for _, blk := range img.blocks {
+		// Files of the same contents share their blocks
+		if n := len(blocks); n > 0 && blocks[n-1].Off == blk.Off {
+			continue
+		}
+		if n := len(blocks); n > 0 && blocks[n-1].Off+blocks[n-1].Len > blk.Off {
+			return nil, false
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks, true
+}
+
+// metadata returns the contents of the metadata blocks from off to end
+func (img *image) metadata(off, end int) []byte {
+	var out []byte
+	for img.ok && off < end {
+		data, n := img.metadataBlock(off)
+		out = append(out, data...)
+		off += n
+	}
+	return out
+}
+
+// metadataBlock returns the contents of the metadata block at off, and its
+// length
+func (img *image) metadataBlock(off int) ([]byte, int) {
+	if off < 0 || off > len(img.b)-2 {
+		img.ok = false
+		return nil, 0
+	}
+	h := binary.LittleEndian.Uint16(img.b[off:])
+	size := int(h &^ uncompressedMetadata)
+	if size == 0 || off+2+size > len(img.b) {
+		img.ok = false
+		return nil, 0
+	}
+	data := img.b[off+2 : off+2+size]
+	if h&uncompressedMetadata == 0 {
+		blk := Block{Off: off + 2, Len: size}
+		var err error
+		if data, err = inflate(data, metadataSize); err != nil {
+			img.ok = false
+			return nil, 0
+		}
+		img.blocks = append(img.blocks, blk)
+	}
+	return data, 2 + size
+}
+
+// files adds the data blocks of the regular files of the inode table t
+func (img *image) files(t []byte) {
+	r := &reader{b: t, ok: true}
+	bs := int(img.sb.BlockSize)
+	for i := uint32(0); i < img.sb.InodeCount && img.ok && r.ok; i++ {
+		typ := r.u16()
+		r.skip(14)
+		switch typ {
+		case 1: // directory
+			r.skip(16)
+		case 2: // regular file
+			start, fragment := int(r.u32()), r.u32()
+			r.skip(4)
+			img.data(r, start, int(r.u32()), bs, fragment != noFragment)
+		case 3, 10: // symlink
+			r.skip(4)
+			r.skip(int(r.u32()))
+			if typ == 10 {
+				r.skip(4)
+			}
+		case 4, 5: // block and character devices
+			r.skip(8)
+		case 6, 7: // fifo and socket
+			r.skip(4)
+		case 8: // extended directory
+			r.skip(16)
+			n := int(r.u16())
+			r.skip(6)
+			for j := 0; j < n && r.ok; j++ {
+				r.skip(8)
+				r.skip(int(r.u32()) + 1)
+			}
+		case 9: // extended regular file
+			start, size := r.u64(), r.u64()
+			r.skip(12)
+			fragment := r.u32()
+			r.skip(8)
+			if start > uint64(len(img.b)) || size > uint64(len(img.b))*uint64(bs) {
+				img.ok = false
+				return
+			}
+			img.data(r, int(start), int(size), bs, fragment != noFragment)
+		case 11, 12: // extended devices
+			r.skip(12)
+		case 13, 14: // extended fifo and socket
+			r.skip(8)
+		default:
+			img.ok = false
+		}
+	}
+	img.ok = img.ok && r.ok
+}
+
+// data adds the data blocks of a file of size bytes from start, whose sizes
+// r reads
+func (img *image) data(r *reader, start, size, bs int, fragment bool) {
+	n := size / bs
+	if !fragment && size%bs != 0 {
+		n++
+	}
+	off := start
+	for j := 0; j < n && r.ok; j++ {
+		v := r.u32()
+		length := int(v &^ uncompressedData)
+		if length > bs+bs/8 || off+length > len(img.b) {
+			img.ok = false
+			return
+		}
+		if length > 0 && v&uncompressedData == 0 {
+			img.blocks = append(img.blocks, Block{Off: off, Len: length})
+		}
+		off += length
+	}
+}
+
+// fragments adds the fragment blocks of the fragment table
+func (img *image) fragments() {
+	count := int(img.sb.FragmentEntryCount)
+	ptrs := (count*16 + metadataSize - 1) / metadataSize
+	start := img.sb.FragmentTableStart
+	if start > uint64(len(img.b)) || count > len(img.b) || int(start)+8*ptrs > len(img.b) {
+		img.ok = false
+		return
+	}
+	var table []byte
+	for i := 0; i < ptrs && img.ok; i++ {
+		ptr := binary.LittleEndian.Uint64(img.b[int(start)+8*i:])
+		if ptr > uint64(len(img.b)) {
+			img.ok = false
+			return
+		}
+		data, _ := img.metadataBlock(int(ptr))
+		table = append(table, data...)
+	}
+	if !img.ok || len(table) < count*16 {
+		img.ok = false
+		return
+	}
+	for i := 0; i < count; i++ {
+		off := binary.LittleEndian.Uint64(table[16*i:])
+		v := binary.LittleEndian.Uint32(table[16*i+8:])
+		length := int(v &^ uncompressedData)
+		if off > uint64(len(img.b)) || int(off)+length > len(img.b) {
+			img.ok = false
+			return
+		}
+		if length > 0 && v&uncompressedData == 0 {
+			img.blocks = append(img.blocks, Block{Off: int(off), Len: length})
+		}
+	}
+}
+
+// Inflate returns the raw deflate stream of the zlib stream blk of b and
+// its contents, at most max bytes, or false if it isn't a zlib stream
+func Inflate(b []byte, blk Block, max int) (Block, []byte, bool) {
+	if blk.Off < 0 || blk.Len < 6 || blk.Off > len(b)-blk.Len {
+		return Block{}, nil, false
+	}
+	z := b[blk.Off : blk.Off+blk.Len]
+	if z[0]&0x0f != 8 || z[1]&0x20 != 0 || (uint16(z[0])<<8|uint16(z[1]))%31 != 0 {
+		return Block{}, nil, false
+	}
+	stream := Block{Off: blk.Off + 2, Len: blk.Len - 6}
+	r := bytes.NewReader(b[stream.Off : stream.Off+stream.Len])
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(flate.NewReader(r), int64(max)+1))
+	if err != nil || n > int64(max) || r.Len() != 0 {
+		return Block{}, nil, false
+	}
+	if adler32.Checksum(buf.Bytes()) != binary.BigEndian.Uint32(z[len(z)-4:]) {
+		return Block{}, nil, false
+	}
+	return stream, buf.Bytes(), true
+}
+
+// inflate decompresses the zlib stream b, of at most max bytes
+func inflate(b []byte, max int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, io.LimitReader(zr, int64(max)+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > max {
+		return nil, io.ErrShortBuffer
+	}
+	return buf.Bytes(), nil
+}
+
+// reader reads little endian integers, failing past the end
+type reader struct {
+	b  []byte
+	p  int
+	ok bool
+}
+
+func (r *reader) skip(n int) {
+	if n < 0 || n > len(r.b)-r.p {
+		r.ok, r.p = false, len(r.b)
+		return
+	}
+	r.p += n
+}
+
+func (r *reader) bytes(n int) []byte {
+	p := r.p
+	r.skip(n)
+	if !r.ok {
+		return make([]byte, n)
+	}
+	return r.b[p : p+n]
+}
+
+func (r *reader) u16() uint16 { return binary.LittleEndian.Uint16(r.bytes(2)) }
+func (r *reader) u32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+func (r *reader) u64() uint64 { return binary.LittleEndian.Uint64(r.bytes(8)) }
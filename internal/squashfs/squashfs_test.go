@@ -0,0 +1,54 @@
+package squashfs
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+)
+
+func TestBlocks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var files [][]byte
+	total := 0
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 1000+rng.Intn(10000))
+		files = append(files, data)
+		total += len(data)
+	}
+	// Incompressible, stored as it is
+	files = append(files, testdata.Random(1, 5000))
+	img := testdata.Squashfs(files)
+
+	blocks, ok := Blocks(img)
+	if !ok {
+		t.Fatal("not an image")
+	}
+	inflated := 0
+	for i, blk := range blocks {
+		if i > 0 && blk.Off < blocks[i-1].Off+blocks[i-1].Len {
+			t.Fatalf("block %v overlaps", i)
+		}
+		stream, data, ok := Inflate(img, blk, 1<<20)
+		if !ok || stream.Off != blk.Off+2 || stream.Len != blk.Len-6 {
+			t.Fatalf("block %v at %v isn't a zlib stream", i, blk.Off)
+		}
+		if bytes.Contains(data, files[20][:100]) {
+			t.Fatal("uncompressed block listed")
+		}
+		inflated += len(data)
+	}
+	if inflated < total {
+		t.Fatalf("blocks inflate to %v bytes, expected at least %v", inflated, total)
+	}
+
+	for _, b := range [][]byte{nil, img[:95], img[:len(img)/2], []byte("hsqs" + string(make([]byte, 92)))} {
+		if _, ok := Blocks(b); ok {
+			t.Fatalf("%v bytes parsed", len(b))
+		}
+	}
+	if _, _, ok := Inflate(img, Block{Off: 0, Len: 100}, 1<<20); ok {
+		t.Fatal("superblock inflated")
+	}
+}
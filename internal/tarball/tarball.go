@@ -0,0 +1,308 @@
+// Package tarball aligns tar archives before diffing. bsdiff matches the new
+// file against the old one mostly in order, so entries that were reordered,
+// or that moved because an earlier entry grew, cost seeks and broken
+// matches. Permuting the entries of the old archive into the order of their
+// namesakes in the new one lines each entry up with its counterpart, and the
+// permutation is recorded so the old archive can be permuted again when
+// patching.
+//
+// Entries are matched by name, then by name less its first component, which
+// release tarballs usually version ("app-1.0/main.go").
+//
+// AlignBlocks permutes images that aren't archives, firmware and filesystem
+// images, block by block instead, the same way.
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"strings"
+)
+
+// Range is Len bytes at offset Off of the old archive
+type Range struct {
+	Off int
+	Len int
+}
+
+// entry is an entry of an archive: its name, and its headers, data and
+// padding at [start, end)
+type entry struct {
+	name       string
+	start, end int
+}
+
+// errCorrupt is returned by Unmarshal for a malformed permutation record
+var errCorrupt = errors.New("corrupt tar permutation")
+
+// parse returns the entries of the tar archive b and the offset of its
+// trailer. ok is false when b isn't a tar archive, or has sparse entries,
+// whose data can't be located.
+func parse(b []byte) (entries []entry, trailer int, ok bool) {
+	cr := &countReader{r: bytes.NewReader(b)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || hdr.Typeflag == tar.TypeGNUSparse {
+			return nil, 0, false
+		}
+		for k := range hdr.PAXRecords {
+			if strings.HasPrefix(k, "GNU.sparse.") {
+				return nil, 0, false
+			}
+		}
+		size := hdr.Size
+		switch hdr.Typeflag {
+		case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+			// header only, whatever their size
+			size = 0
+		}
+		start := trailer
+		end := cr.n + int((size+511)&^511)
+		if size < 0 || end > len(b) {
+			return nil, 0, false
+		}
+		entries = append(entries, entry{name: hdr.Name, start: start, end: end})
+		trailer = end
+	}
+	if len(entries) == 0 {
+		return nil, 0, false
+	}
+	return entries, trailer, true
+}
+
+// Align returns the permutation of the old archive that lines its entries
+// up with those of the new one: the entries matching a new one, in the new
+// order, then the others and the trailer in the old order. ok is false
+// unless both are tar archives.
+func Align(oldbs, newbs []byte) (ranges []Range, ok bool) {
+	oldEntries, trailer, ok := parse(oldbs)
+	if !ok {
+		return nil, false
+	}
+	newEntries, _, ok := parse(newbs)
+	if !ok {
+		return nil, false
+	}
+	// byName and byBase are the unmatched old entries by name and by name
+	// less its first component, in order
+	byName := make(map[string][]int)
+	byBase := make(map[string][]int)
+	for i, e := range oldEntries {
+		byName[e.name] = append(byName[e.name], i)
+		byBase[base(e.name)] = append(byBase[base(e.name)], i)
+	}
+	used := make([]bool, len(oldEntries))
+	take := func(m map[string][]int, key string) bool {
+		for len(m[key]) > 0 {
+			i := m[key][0]
+			m[key] = m[key][1:]
+			if !used[i] {
+				used[i] = true
+				ranges = append(ranges, Range{oldEntries[i].start, oldEntries[i].end - oldEntries[i].start})
+				return true
+			}
+		}
+		return false
+	}
+	for _, e := range newEntries {
+		if !take(byName, e.name) {
+			take(byBase, base(e.name))
+		}
+	}
+	for i, e := range oldEntries {
+		if !used[i] {
+			ranges = append(ranges, Range{e.start, e.end - e.start})
+		}
+	}
+	ranges = append(ranges, Range{trailer, len(oldbs) - trailer})
+	return merge(ranges), true
+}
+
+// AlignBlocks returns the permutation of the old image that lines its
+// blocks of n bytes up with those of the new one: for each new block, an
+// unused old block of the same contents, preferably the one after the last;
+// else the old block continuing the run before it, or leading into the run
+// after it, or at the same position, if unused; then the unused blocks and
+// the tail in the old order. ok is false if that's the old image unchanged.
+func AlignBlocks(oldbs, newbs []byte, n int) (ranges []Range, ok bool) {
+	if n <= 0 || len(oldbs) < n {
+		return nil, false
+	}
+	blocks := len(oldbs) / n
+	byHash := make(map[uint64][]int)
+	for i := 0; i < blocks; i++ {
+		h := hashBlock(oldbs[i*n : (i+1)*n])
+		byHash[h] = append(byHash[h], i)
+	}
+	// match is the old block of each new block, -1 for none
+	match := make([]int, len(newbs)/n)
+	used := make([]bool, blocks)
+	prev := -1
+	for k := range match {
+		b := newbs[k*n : (k+1)*n]
+		match[k] = -1
+		for _, j := range byHash[hashBlock(b)] {
+			if used[j] || !bytes.Equal(oldbs[j*n:(j+1)*n], b) {
+				continue
+			}
+			if match[k] < 0 || j == prev+1 {
+				match[k] = j
+			}
+		}
+		if prev = match[k]; prev >= 0 {
+			used[prev] = true
+		}
+	}
+	// Edited blocks face the neighbours of the blocks around them
+	free := func(i int) bool { return i >= 0 && i < blocks && !used[i] }
+	for k := range match {
+		if match[k] >= 0 {
+			continue
+		}
+		switch {
+		case k > 0 && match[k-1] >= 0 && free(match[k-1]+1):
+			match[k] = match[k-1] + 1
+		case k+1 < len(match) && match[k+1] >= 0 && free(match[k+1]-1):
+			match[k] = match[k+1] - 1
+		case free(k):
+			match[k] = k
+		default:
+			continue
+		}
+		used[match[k]] = true
+	}
+	for _, i := range match {
+		if i >= 0 {
+			ranges = append(ranges, Range{i * n, n})
+		}
+	}
+	for i := range used {
+		if !used[i] {
+			ranges = append(ranges, Range{i * n, n})
+		}
+	}
+	ranges = merge(append(ranges, Range{blocks * n, len(oldbs) - blocks*n}))
+	if len(ranges) == 1 && ranges[0] == (Range{0, len(oldbs)}) {
+		return nil, false
+	}
+	return ranges, true
+}
+
+func hashBlock(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// base returns name less its first component, empty for the top directory
+func base(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// merge joins adjacent ranges, so an unchanged order is a single range
+func merge(ranges []Range) []Range {
+	out := ranges[:0]
+	for _, r := range ranges {
+		if r.Len == 0 {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Off+out[n-1].Len == r.Off {
+			out[n-1].Len += r.Len
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Permute returns the ranges of b, concatenated
+func Permute(b []byte, ranges []Range) []byte {
+	n := 0
+	for _, r := range ranges {
+		n += r.Len
+	}
+	out := make([]byte, 0, n)
+	for _, r := range ranges {
+		out = append(out, b[r.Off:r.Off+r.Len]...)
+	}
+	return out
+}
+
+// Marshal encodes ranges as a uvarint count of uvarint offset and length
+// pairs
+func Marshal(ranges []Range) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(ranges)))
+	for _, r := range ranges {
+		out = binary.AppendUvarint(out, uint64(r.Off))
+		out = binary.AppendUvarint(out, uint64(r.Len))
+	}
+	return out
+}
+
+// Unmarshal decodes ranges encoded by Marshal
+func Unmarshal(b []byte) ([]Range, error) {
+	r := bytes.NewReader(b)
+	read := func() (int, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > 1<<62 {
+			return 0, errCorrupt
+		}
+		return int(v), nil
+	}
+	n, err := read()
+	if err != nil || n > r.Len() {
+		return nil, errCorrupt
+	}
+	ranges := make([]Range, 0, n)
+	for i := 0; i < n; i++ {
+		var rg Range
+		if rg.Off, err = read(); err != nil {
+			return nil, err
+		}
+		if rg.Len, err = read(); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rg)
+	}
+	if r.Len() > 0 {
+		return nil, errCorrupt
+	}
+	return ranges, nil
+}
+
+// Check reports whether the ranges fit in a file of size bytes, and
+// together are no larger than it
+func Check(ranges []Range, size int) bool {
+	total := 0
+	for _, r := range ranges {
+		if r.Off < 0 || r.Len < 0 || r.Off > size || r.Len > size-r.Off || r.Len > size-total {
+			return false
+		}
+		total += r.Len
+	}
+	return true
+}
+
+// countReader counts the bytes read from r
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,132 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// archive returns a tar archive of the name and data pairs of files
+func archive(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAlign(t *testing.T) {
+	long := string(bytes.Repeat([]byte("long/"), 30)) + "name"
+	oldbs := archive(t, "app-1.0/a", "aaaa", "app-1.0/b", "bbbb", long, "long name", "app-1.0/gone", "gone")
+	newbs := archive(t, long, "long name 2", "app-1.1/b", "bbbb", "app-1.1/new", "new", "app-1.1/a", "aaaa")
+	entries, trailer, ok := parse(oldbs)
+	if !ok || len(entries) != 4 {
+		t.Fatal("could not parse", entries, ok)
+	}
+	if trailer != entries[3].end || len(oldbs)-trailer < 1024 {
+		t.Fatal("bad trailer offset", trailer, len(oldbs))
+	}
+	for i, e := range entries {
+		tr := tar.NewReader(bytes.NewReader(oldbs[e.start:e.end]))
+		if hdr, err := tr.Next(); err != nil || hdr.Name != e.name {
+			t.Fatalf("entry %v: %v (%v)", i, hdr, err)
+		}
+	}
+	ranges, ok := Align(oldbs, newbs)
+	if !ok {
+		t.Fatal("could not align")
+	}
+	r := func(i int) Range { return Range{entries[i].start, entries[i].end - entries[i].start} }
+	// long name, b, a, then gone and the trailer
+	want := []Range{r(2), r(1), r(0), {entries[3].start, len(oldbs) - entries[3].start}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("got %v, expected %v", ranges, want)
+	}
+	aligned := Permute(oldbs, ranges)
+	if len(aligned) != len(oldbs) {
+		t.Fatal("permutation changed the size")
+	}
+
+	// Unchanged order
+	ranges, ok = Align(oldbs, oldbs)
+	if !ok || !reflect.DeepEqual(ranges, []Range{{0, len(oldbs)}}) {
+		t.Fatal("expected the identity permutation, got", ranges)
+	}
+
+	// Not archives
+	if _, ok = Align([]byte("not a tar archive"), newbs); ok {
+		t.Fatal("aligned a text file")
+	}
+	if _, ok = Align(oldbs, oldbs[:700]); ok {
+		t.Fatal("aligned a truncated archive")
+	}
+}
+
+func TestAlignBlocks(t *testing.T) {
+	oldbs := []byte("AAAABBBBCCCCDDDDEEEExy")
+	newbs := []byte("dDDDEEEEAAAABBBBCCCcFFFFz")
+	ranges, ok := AlignBlocks(oldbs, newbs, 4)
+	if !ok {
+		t.Fatal("could not align")
+	}
+	// The edited D leading into E, A and B, the edited C continuing them,
+	// nothing left for F, then the tail
+	want := []Range{{12, 8}, {0, 12}, {20, 2}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("got %v, expected %v", ranges, want)
+	}
+	if !Check(ranges, len(oldbs)) || string(Permute(oldbs, ranges)) != "DDDDEEEEAAAABBBBCCCCxy" {
+		t.Fatalf("bad permutation %q", Permute(oldbs, ranges))
+	}
+	for _, newbs := range []string{string(oldbs), "AAAABBBBCCCCdddd", "AAAA"} {
+		if _, ok := AlignBlocks(oldbs, []byte(newbs), 4); ok {
+			t.Fatalf("%q aligned against an unchanged order", newbs)
+		}
+	}
+	if _, ok := AlignBlocks([]byte("AAA"), newbs, 4); ok {
+		t.Fatal("aligned an image smaller than a block")
+	}
+}
+
+func TestBase(t *testing.T) {
+	for name, want := range map[string]string{
+		"app-1.0/src/main.go": "src/main.go",
+		"./app-1.0/main.go":   "main.go",
+		"app-1.0/":            "",
+		"README":              "README",
+	} {
+		if got := base(name); got != want {
+			t.Errorf("base(%q) = %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	ranges := []Range{{512, 1024}, {0, 512}, {1536, 1 << 20}}
+	got, err := Unmarshal(Marshal(ranges))
+	if err != nil || !reflect.DeepEqual(got, ranges) {
+		t.Fatal(got, err)
+	}
+	if !Check(ranges, 1536+1<<20) || Check(ranges, 1536) {
+		t.Fatal("bad bounds check")
+	}
+	if Check([]Range{{0, 10}, {0, 10}}, 15) {
+		t.Fatal("overlapping ranges larger than the file passed")
+	}
+	for _, b := range [][]byte{nil, {5, 1}, append(Marshal(ranges), 0)} {
+		if _, err := Unmarshal(b); err == nil {
+			t.Fatalf("%v: expected an error", b)
+		}
+	}
+}
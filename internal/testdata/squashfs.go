@@ -0,0 +1,148 @@
+package testdata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+)
+
+// squashfsBlockSize is the block size of the images Squashfs returns
+const squashfsBlockSize = 4096
+
+// Squashfs returns a gzip compressed squashfs image of files, laid out the
+// way mksquashfs lays them out: zlib compressed data blocks, file tails
+// packed into fragment blocks, and the inode table, fragment table and
+// superblock pointing at them. Its root directory lists nothing, so it's an
+// image to diff, not to mount.
+func Squashfs(files [][]byte) []byte {
+	const (
+		uncompressedData = 1 << 24
+		noFragment       = 0xffffffff
+		unused           = uint64(0xffffffffffffffff)
+	)
+	zlibBlock := func(b []byte) []byte {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(b)
+		zw.Close()
+		return buf.Bytes()
+	}
+	out := make([]byte, 96)
+	le := binary.LittleEndian
+	// writeBlock appends b compressed, or as it is if that's no smaller,
+	// returning its size field
+	writeBlock := func(b []byte) uint32 {
+		if z := zlibBlock(b); len(z) < len(b) {
+			out = append(out, z...)
+			return uint32(len(z))
+		}
+		out = append(out, b...)
+		return uint32(len(b)) | uncompressedData
+	}
+
+	// Data blocks, with the tails of the files packed into fragments
+	var inodes, fragments, pending []byte
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		start := uint64(len(out))
+		size := writeBlock(pending)
+		fragments = le.AppendUint64(fragments, start)
+		fragments = le.AppendUint32(fragments, size)
+		fragments = le.AppendUint32(fragments, 0)
+		pending = pending[:0]
+	}
+	inodeHeader := func(typ uint16, n int) {
+		inodes = le.AppendUint16(inodes, typ)
+		inodes = le.AppendUint16(inodes, 0644)
+		inodes = le.AppendUint32(inodes, 0)
+		inodes = le.AppendUint32(inodes, 0)
+		inodes = le.AppendUint32(inodes, uint32(n))
+	}
+	for i, data := range files {
+		start := len(out)
+		var sizes []uint32
+		full := len(data) / squashfsBlockSize * squashfsBlockSize
+		for off := 0; off < full; off += squashfsBlockSize {
+			sizes = append(sizes, writeBlock(data[off:off+squashfsBlockSize]))
+		}
+		fragment, offset := uint32(noFragment), uint32(0)
+		if tail := data[full:]; len(tail) > 0 {
+			if len(pending)+len(tail) > squashfsBlockSize {
+				flush()
+			}
+			fragment, offset = uint32(len(fragments)/16), uint32(len(pending))
+			pending = append(pending, tail...)
+		}
+		inodeHeader(2, i+2)
+		inodes = le.AppendUint32(inodes, uint32(start))
+		inodes = le.AppendUint32(inodes, fragment)
+		inodes = le.AppendUint32(inodes, offset)
+		inodes = le.AppendUint32(inodes, uint32(len(data)))
+		for _, s := range sizes {
+			inodes = le.AppendUint32(inodes, s)
+		}
+	}
+	flush()
+	// The root directory
+	root := len(inodes)
+	inodeHeader(1, 1)
+	inodes = le.AppendUint32(inodes, 0)
+	inodes = le.AppendUint32(inodes, 2)
+	inodes = le.AppendUint16(inodes, 3)
+	inodes = le.AppendUint16(inodes, 0)
+	inodes = le.AppendUint32(inodes, uint32(len(files)+2))
+
+	// metadata appends b as metadata blocks, returning their offsets
+	metadata := func(b []byte) []uint64 {
+		var offsets []uint64
+		for len(b) > 0 {
+			n := len(b)
+			if n > 8192 {
+				n = 8192
+			}
+			offsets = append(offsets, uint64(len(out)))
+			if z := zlibBlock(b[:n]); len(z) < n {
+				out = le.AppendUint16(out, uint16(len(z)))
+				out = append(out, z...)
+			} else {
+				out = le.AppendUint16(out, uint16(n)|0x8000)
+				out = append(out, b[:n]...)
+			}
+			b = b[n:]
+		}
+		return offsets
+	}
+	inodeTable := len(out)
+	inodeBlocks := metadata(inodes)
+	rootRef := (inodeBlocks[root/8192]-uint64(inodeTable))<<16 | uint64(root%8192)
+	directoryTable := len(out)
+	fragmentTable := unused
+	if len(fragments) > 0 {
+		offsets := metadata(fragments)
+		fragmentTable = uint64(len(out))
+		for _, off := range offsets {
+			out = le.AppendUint64(out, off)
+		}
+	}
+
+	sb := out[:0:96]
+	sb = le.AppendUint32(sb, 0x73717368)
+	sb = le.AppendUint32(sb, uint32(len(files)+1))
+	sb = le.AppendUint32(sb, 0)
+	sb = le.AppendUint32(sb, squashfsBlockSize)
+	sb = le.AppendUint32(sb, uint32(len(fragments)/16))
+	sb = le.AppendUint16(sb, 1)  // gzip
+	sb = le.AppendUint16(sb, 12) // block log
+	sb = le.AppendUint16(sb, 0)  // flags
+	sb = le.AppendUint16(sb, 0)  // ids
+	sb = le.AppendUint16(sb, 4)
+	sb = le.AppendUint16(sb, 0)
+	sb = le.AppendUint64(sb, rootRef)
+	sb = le.AppendUint64(sb, uint64(len(out)))
+	for _, v := range []uint64{unused, unused, uint64(inodeTable), uint64(directoryTable), fragmentTable, unused} {
+		sb = le.AppendUint64(sb, v)
+	}
+	return out
+}
@@ -0,0 +1,222 @@
+// Package testdata generates deterministic synthetic files for tests and
+// benchmarks. The same size always yields the same bytes, so results can be
+// compared across machines and revisions.
+package testdata
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"math/rand"
+)
+
+// Workload is a pair of old and new files
+type Workload struct {
+	Name string
+	Old  []byte
+	New  []byte
+}
+
+// Workloads returns every workload with files of roughly size bytes
+func Workloads(size int) []Workload {
+	return []Workload{
+		Identical(size),
+		SmallEdits(size),
+		LargeChanges(size),
+		Repetitive(size),
+		Compressed(size),
+		Unrelated(size),
+		Executable(size),
+		Append(size),
+	}
+}
+
+// Random returns size pseudo-random bytes derived from seed
+func Random(seed int64, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// Identical returns a workload where the old and new files are the same
+func Identical(size int) Workload {
+	old := Random(1, size)
+	return Workload{"identical", old, append([]byte(nil), old...)}
+}
+
+// SmallEdits returns a workload where the new file has a few scattered byte
+// edits, insertions and deletions, like a recompiled executable
+func SmallEdits(size int) Workload {
+	old := Random(2, size)
+	rng := rand.New(rand.NewSource(2))
+	nw := append([]byte(nil), old...)
+	for i := 0; i < 1+size/4096; i++ {
+		pos := rng.Intn(len(nw) + 1)
+		switch rng.Intn(3) {
+		case 0:
+			if pos < len(nw) {
+				nw[pos] ^= byte(1 + rng.Intn(255))
+			}
+		case 1:
+			ins := make([]byte, 1+rng.Intn(16))
+			rng.Read(ins)
+			nw = append(nw[:pos], append(ins, nw[pos:]...)...)
+		case 2:
+			end := pos + 1 + rng.Intn(16)
+			if end > len(nw) {
+				end = len(nw)
+			}
+			nw = append(nw[:pos], nw[end:]...)
+		}
+	}
+	return Workload{"small-edits", old, nw}
+}
+
+// LargeChanges returns a workload where half of the new file is replaced by
+// unrelated random data
+func LargeChanges(size int) Workload {
+	old := Random(3, size)
+	nw := append([]byte(nil), old...)
+	rng := rand.New(rand.NewSource(3))
+	for off := 0; off < len(nw); off += 8192 {
+		if rng.Intn(2) == 0 {
+			end := off + 8192
+			if end > len(nw) {
+				end = len(nw)
+			}
+			rng.Read(nw[off:end])
+		}
+	}
+	return Workload{"large-changes", old, nw}
+}
+
+// Repetitive returns a workload of highly repetitive files, which is the
+// worst case for suffix sorting
+func Repetitive(size int) Workload {
+	pattern := []byte("0123456789abcdef")
+	old := bytes.Repeat(pattern, size/len(pattern)+1)[:size]
+	nw := append([]byte(nil), old...)
+	for i := 0; i < len(nw); i += 4099 {
+		nw[i] = 'X'
+	}
+	return Workload{"repetitive", old, nw}
+}
+
+// Compressed returns a workload of deflate streams whose uncompressed
+// contents differ slightly, so the compressed bytes share very little
+func Compressed(size int) Workload {
+	text := func(seed int64) []byte {
+		words := []string{"bsdiff ", "patch ", "suffix ", "sort ", "block ", "delta ", "\n"}
+		rng := rand.New(rand.NewSource(seed))
+		var b bytes.Buffer
+		for b.Len() < size*3 {
+			b.WriteString(words[rng.Intn(len(words))])
+		}
+		return b.Bytes()
+	}
+	deflate := func(b []byte) []byte {
+		var out bytes.Buffer
+		w, _ := flate.NewWriter(&out, flate.BestCompression)
+		w.Write(b)
+		w.Close()
+		if out.Len() > size {
+			return out.Bytes()[:size]
+		}
+		return out.Bytes()
+	}
+	oldtext := text(5)
+	newtext := append([]byte(nil), oldtext...)
+	copy(newtext[len(newtext)/2:], "a small change in the middle")
+	return Workload{"compressed", deflate(oldtext), deflate(newtext)}
+}
+
+// Unrelated returns a workload of two unrelated random files, the worst case
+// for patch size
+func Unrelated(size int) Workload {
+	return Workload{"random", Random(6, size), Random(7, size)}
+}
+
+// function is a function of an executable-like file: code with call
+// instructions (0xe8 and a 32-bit relative address) to other functions
+type function struct {
+	code    []byte
+	calls   []int
+	targets []int
+}
+
+// link lays out the functions and fills in the call addresses
+func link(funcs []function) []byte {
+	starts := make([]int, len(funcs))
+	var out []byte
+	for i, f := range funcs {
+		starts[i] = len(out)
+		out = append(out, f.code...)
+	}
+	for i, f := range funcs {
+		for k, off := range f.calls {
+			at := starts[i] + off
+			rel := starts[f.targets[k]] - (at + 5)
+			binary.LittleEndian.PutUint32(out[at+1:], uint32(int32(rel)))
+		}
+	}
+	return out
+}
+
+// Executable returns a workload of executable-like files where code was
+// inserted in a few functions, which moves the later ones and so changes the
+// addresses of many calls, like in a recompiled program
+func Executable(size int) Workload {
+	rng := rand.New(rand.NewSource(8))
+	var funcs []function
+	for n := 0; n < size; {
+		var f function
+		for l := 64 + rng.Intn(384); len(f.code) < l; {
+			if rng.Intn(16) == 0 {
+				f.calls = append(f.calls, len(f.code))
+				f.targets = append(f.targets, rng.Intn(1+size/256))
+				f.code = append(f.code, 0xe8, 0, 0, 0, 0)
+				continue
+			}
+			// A small alphabet of opcodes, as in real code
+			f.code = append(f.code, byte(rng.Intn(48)))
+		}
+		funcs = append(funcs, f)
+		n += len(f.code)
+	}
+	for i, f := range funcs {
+		for k, t := range f.targets {
+			if t >= len(funcs) {
+				funcs[i].targets[k] = t % len(funcs)
+			}
+		}
+	}
+	old := link(funcs)
+	for i := range funcs {
+		if rng.Intn(50) != 0 {
+			continue
+		}
+		f := &funcs[i]
+		ins := make([]byte, 1+rng.Intn(32))
+		for j := range ins {
+			ins[j] = byte(rng.Intn(48))
+		}
+		f.code = append(ins, f.code...)
+		calls := make([]int, len(f.calls))
+		for k, off := range f.calls {
+			calls[k] = off + len(ins)
+		}
+		f.calls = calls
+	}
+	return Workload{"executable", old, link(funcs)}
+}
+
+// Append returns a workload where data was appended to the new file and its
+// header updated, like a log or an archive that grew
+func Append(size int) Workload {
+	old := Random(9, size)
+	nw := append(append([]byte(nil), old...), Random(10, size/4)...)
+	if len(nw) >= 8 {
+		binary.LittleEndian.PutUint64(nw, uint64(len(nw)))
+	}
+	return Workload{"append", old, nw}
+}
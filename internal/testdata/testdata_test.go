@@ -0,0 +1,19 @@
+package testdata
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeterministic(t *testing.T) {
+	a := Workloads(1 << 14)
+	b := Workloads(1 << 14)
+	for i := range a {
+		if !bytes.Equal(a[i].Old, b[i].Old) || !bytes.Equal(a[i].New, b[i].New) {
+			t.Fatal(a[i].Name, "is not deterministic")
+		}
+		if len(a[i].Old) == 0 || len(a[i].New) == 0 {
+			t.Fatal(a[i].Name, "is empty")
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package vcdiff
+
+import (
+	"bufio"
+	"fmt"
+	"hash/adler32"
+	"io"
+)
+
+// Decode applies the VCDIFF delta read from delta to the source old and
+// writes the target to w. Windows are decoded one at a time, so memory use
+// is bounded by the target window size rather than the target size.
+func Decode(old io.ReaderAt, delta io.Reader, w io.Writer) error {
+	return DecodeLimit(old, delta, w, 0)
+}
+
+// DecodeLimit is Decode with the memory of a window (its delta encoding and
+// target, and the previous target) limited to limit bytes, or unlimited if
+// limit is 0. A *LimitError is returned for larger windows.
+func DecodeLimit(old io.ReaderAt, delta io.Reader, w io.Writer, limit int) error {
+	dec, err := newDecoder(delta, old, limit)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := dec.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(d.target); err != nil {
+			return err
+		}
+	}
+}
+
+// decoder decodes the windows of a delta one at a time, keeping what a
+// window may need from the ones before it
+type decoder struct {
+	r     *bufio.Reader
+	old   io.ReaderAt
+	limit int
+	// newSec makes the secondary decompressors, nil without secondary
+	// compression
+	newSec func() secondary
+	// sec are the decompressors of the data, instructions and addresses
+	// sections, made on first use
+	sec [3]secondary
+	// prev is the last window, the only one a VCD_TARGET window may copy
+	// from as in xdelta3, and prevPos its position in the target
+	prev    *windowDecoder
+	prevPos int
+	// pos is the size of the target decoded so far
+	pos int
+}
+
+// newDecoder reads the header of delta and returns its decoder. Without a
+// source (old == nil) the bytes copied from the source are left zero and
+// their positions recorded in the origin of the windows. A limit other
+// than 0 bounds the memory of a window.
+func newDecoder(delta io.Reader, old io.ReaderAt, limit int) (*decoder, error) {
+	dec := &decoder{r: newReader(delta), old: old, limit: limit}
+	if err := dec.readHeader(); err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+func (dec *decoder) readHeader() error {
+	r := dec.r
+	for i := range Magic {
+		b, err := r.ReadByte()
+		if err != nil || b != Magic[i] {
+			return fmt.Errorf("%w (bad magic)", ErrCorrupt)
+		}
+	}
+	ind, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w (header)", ErrCorrupt)
+	}
+	if ind&vcdDecompress != 0 {
+		id, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w (header)", ErrCorrupt)
+		}
+		if dec.newSec, err = newSecondary(id); err != nil {
+			return err
+		}
+	}
+	if ind&vcdCodetable != 0 {
+		return fmt.Errorf("%w (custom code table)", ErrUnsupported)
+	}
+	if ind&vcdAppheader != 0 {
+		n, err := readInt(r)
+		if err != nil {
+			return fmt.Errorf("%w (application header)", ErrCorrupt)
+		}
+		for i := 0; i < n; i++ {
+			if _, err = r.ReadByte(); err != nil {
+				return fmt.Errorf("%w (application header)", ErrCorrupt)
+			}
+		}
+	}
+	return nil
+}
+
+// next decodes the next window, or returns io.EOF after the last one
+func (dec *decoder) next() (*windowDecoder, error) {
+	r := dec.r
+	ind, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if ind&vcdSource != 0 && ind&vcdTarget != 0 {
+		return nil, fmt.Errorf("%w (window indicator)", ErrCorrupt)
+	}
+	var srclen, srcpos int
+	if ind&(vcdSource|vcdTarget) != 0 {
+		if srclen, err = readInt(r); err != nil {
+			return nil, fmt.Errorf("%w (source segment size)", ErrCorrupt)
+		}
+		if srcpos, err = readInt(r); err != nil {
+			return nil, fmt.Errorf("%w (source segment position)", ErrCorrupt)
+		}
+	}
+	// VCD_TARGET segments must lie in the last window, which is kept
+	prevlen := 0
+	if dec.prev != nil {
+		prevlen = len(dec.prev.target)
+	}
+	if ind&vcdTarget != 0 {
+		if srclen > dec.pos || srcpos > dec.pos-srclen {
+			return nil, fmt.Errorf("%w (VCD_TARGET segment past the target)", ErrCorrupt)
+		}
+		if srclen > 0 && srcpos < dec.prevPos {
+			return nil, fmt.Errorf("%w (VCD_TARGET segment before the last window)", ErrUnsupported)
+		}
+	}
+	enclen, err := readInt(r)
+	if err != nil || enclen > 3*maxWindow {
+		return nil, fmt.Errorf("%w (delta encoding length)", ErrCorrupt)
+	}
+	if dec.limit > 0 && enclen+prevlen > dec.limit {
+		return nil, &LimitError{Need: enclen + prevlen}
+	}
+	enc := make([]byte, enclen)
+	if _, err = io.ReadFull(r, enc); err != nil {
+		return nil, fmt.Errorf("%w (delta encoding) %v", ErrCorrupt, err.Error())
+	}
+	s := &section{b: enc}
+	tlen, err := s.readInt()
+	if err != nil || tlen > maxWindow {
+		return nil, fmt.Errorf("%w (target window length)", ErrCorrupt)
+	}
+	if dec.limit > 0 && enclen+tlen+prevlen > dec.limit {
+		return nil, &LimitError{Need: enclen + tlen + prevlen}
+	}
+	deltaInd, err := s.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if deltaInd&^(vcdDatacomp|vcdInstcomp|vcdAddrcomp) != 0 || deltaInd != 0 && dec.newSec == nil {
+		return nil, fmt.Errorf("%w (delta indicator)", ErrCorrupt)
+	}
+	var lens [3]int
+	for i := range lens {
+		if lens[i], err = s.readInt(); err != nil {
+			return nil, err
+		}
+	}
+	var checksum []byte
+	if ind&vcdAdler32 != 0 {
+		if checksum, err = s.next(4); err != nil {
+			return nil, err
+		}
+	}
+	var sections [3][]byte
+	for i := range sections {
+		if sections[i], err = s.next(lens[i]); err != nil {
+			return nil, err
+		}
+		if deltaInd&(1<<i) == 0 {
+			continue
+		}
+		if dec.sec[i] == nil {
+			dec.sec[i] = dec.newSec()
+		}
+		if sections[i], err = decodeSecondary(dec.sec[i], sections[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &windowDecoder{
+		old:    dec.old,
+		srcpos: srcpos,
+		srclen: srclen,
+		target: make([]byte, 0, tlen),
+		data:   &section{b: sections[0]},
+		inst:   &section{b: sections[1]},
+		addrs:  &section{b: sections[2]},
+	}
+	if ind&vcdTarget != 0 && srclen > 0 {
+		off := srcpos - dec.prevPos
+		d.tsrc = dec.prev.target[off : off+srclen]
+		if dec.prev.origin != nil {
+			d.tsrcOrigin = dec.prev.origin[off : off+srclen]
+		}
+	}
+	if dec.old == nil {
+		d.origin = make([]int, 0, tlen)
+	}
+	if err = d.run(tlen); err != nil {
+		return nil, err
+	}
+	if checksum != nil && dec.old != nil {
+		sum := uint32(checksum[0])<<24 | uint32(checksum[1])<<16 | uint32(checksum[2])<<8 | uint32(checksum[3])
+		if adler32.Checksum(d.target) != sum {
+			return nil, fmt.Errorf("%w (window checksum mismatch)", ErrCorrupt)
+		}
+	}
+	dec.prev, dec.prevPos = d, dec.pos
+	dec.pos += tlen
+	return d, nil
+}
+
+type windowDecoder struct {
+	old    io.ReaderAt
+	srcpos int
+	srclen int
+	// tsrc is the source segment of a VCD_TARGET window, and tsrcOrigin
+	// its origin
+	tsrc       []byte
+	tsrcOrigin []int
+	target     []byte
+	// origin is the source position of each target byte, or -1 for
+	// literals. It's only tracked when there's no source.
+	origin []int
+	data   *section
+	inst   *section
+	addrs  *section
+	cache  addrCache
+}
+
+// literal records n literal bytes in the origin, if it's tracked
+func (d *windowDecoder) literal(n int) {
+	if d.origin == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		d.origin = append(d.origin, -1)
+	}
+}
+
+func (d *windowDecoder) run(tlen int) error {
+	for d.inst.pos < len(d.inst.b) {
+		code, _ := d.inst.ReadByte()
+		for _, in := range codeTable[code] {
+			if in.typ == instNoop {
+				continue
+			}
+			size := int(in.size)
+			if size == 0 {
+				var err error
+				if size, err = d.inst.readInt(); err != nil {
+					return err
+				}
+			}
+			if size > tlen-len(d.target) {
+				return fmt.Errorf("%w (instruction overflows the target window)", ErrCorrupt)
+			}
+			if err := d.exec(in, size); err != nil {
+				return err
+			}
+		}
+	}
+	if len(d.target) != tlen {
+		return fmt.Errorf("%w (target window is %v bytes, want %v)", ErrCorrupt, len(d.target), tlen)
+	}
+	return nil
+}
+
+func (d *windowDecoder) exec(in instruction, size int) error {
+	switch in.typ {
+	case instAdd:
+		b, err := d.data.next(size)
+		if err != nil {
+			return err
+		}
+		d.target = append(d.target, b...)
+		d.literal(size)
+	case instRun:
+		b, err := d.data.ReadByte()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			d.target = append(d.target, b)
+		}
+		d.literal(size)
+	case instCopy:
+		here := d.srclen + len(d.target)
+		addr, err := d.cache.decode(d.addrs, here, in.mode)
+		if err != nil {
+			return err
+		}
+		if addr < d.srclen {
+			n := size
+			if addr+n > d.srclen {
+				n = d.srclen - addr
+			}
+			if err := d.copySource(addr, n); err != nil {
+				return err
+			}
+			addr += n
+			size -= n
+		}
+		// copies from the target window may overlap the bytes being written
+		for i := 0; i < size; i++ {
+			d.target = append(d.target, d.target[addr-d.srclen+i])
+			if d.origin != nil {
+				d.origin = append(d.origin, d.origin[addr-d.srclen+i])
+			}
+		}
+	}
+	return nil
+}
+
+// copySource appends n bytes of the source segment at addr to the target
+func (d *windowDecoder) copySource(addr, n int) error {
+	if d.tsrc != nil {
+		d.target = append(d.target, d.tsrc[addr:addr+n]...)
+		if d.origin != nil {
+			d.origin = append(d.origin, d.tsrcOrigin[addr:addr+n]...)
+		}
+		return nil
+	}
+	start := len(d.target)
+	d.target = append(d.target, make([]byte, n)...)
+	if d.old == nil {
+		for i := 0; i < n; i++ {
+			d.origin = append(d.origin, d.srcpos+addr+i)
+		}
+	} else if m, err := d.old.ReadAt(d.target[start:], int64(d.srcpos+addr)); m < n {
+		if err == nil || err == io.EOF {
+			return fmt.Errorf("%w (source segment is past the end of the source)", ErrCorrupt)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package vcdiff
+
+import "fmt"
+
+// Parameters of xdelta3's DJW static Huffman coder (xdelta3-djw.h). A
+// section is split in sectors, each coded with one of up to 8 Huffman
+// tables. The code lengths of the tables, and the table of every sector,
+// are themselves Huffman coded after move-to-front and run-length coding.
+const (
+	djwMaxCodelen    = 20
+	djwTotalCodes    = djwMaxCodelen + 2
+	djwRun1          = 1
+	djwExtra12Offset = 7 // basic and run codes
+	djwExtraCodeBits = 4
+	djwMaxGroups     = 8
+	djwGroupBits     = 3
+	djwSectorszMult  = 5
+	djwSectorszBits  = 5
+	djwMaxClclen     = 15
+	djwClclenBits    = 4
+	djwMaxGbclen     = 7
+	djwGbclenBits    = 3
+)
+
+// djwClenMTF is the initial move-to-front order of the code lengths
+var djwClenMTF = [djwTotalCodes]byte{0, 4, 5, 6, 7, 8, 9, 10, 3, 11, 2, 12, 13, 1, 14, 15, 16, 17, 18, 19, 20}
+
+// djwSection decompresses a section coded by DJW. Every section carries its
+// own tables.
+type djwSection struct{}
+
+// bitReader reads bits starting from the least significant of each byte
+type bitReader struct {
+	in   []byte
+	cur  byte
+	mask uint
+}
+
+func (b *bitReader) bit() (int, error) {
+	if b.mask == 0x100 {
+		if len(b.in) == 0 {
+			return 0, fmt.Errorf("%w (djw section ended)", ErrCorrupt)
+		}
+		b.cur, b.in, b.mask = b.in[0], b.in[1:], 1
+	}
+	v := 0
+	if uint(b.cur)&b.mask != 0 {
+		v = 1
+	}
+	b.mask <<= 1
+	return v, nil
+}
+
+// bits reads an n bit value, most significant bit first
+func (b *bitReader) bits(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+// huffDecoder decodes canonical Huffman codes
+type huffDecoder struct {
+	// inorder are the symbols in code order
+	inorder        []byte
+	base, limit    [djwTotalCodes]int
+	minLen, maxLen int
+}
+
+// newHuffDecoder returns the decoder of the code lengths clen, which are
+// at most absMax
+func newHuffDecoder(clen []byte, absMax int) (*huffDecoder, error) {
+	var count, start [djwTotalCodes]int
+	for _, l := range clen {
+		if int(l) > absMax {
+			return nil, fmt.Errorf("%w (djw code length %v)", ErrCorrupt, l)
+		}
+		count[l]++
+	}
+	d := &huffDecoder{minLen: absMax + 1}
+	for i := absMax; i >= 1; i-- {
+		if count[i] != 0 {
+			d.minLen = i
+			if d.maxLen == 0 {
+				d.maxLen = i
+			}
+		}
+	}
+	if d.maxLen == 0 {
+		// no codes, so any symbol is corrupt
+		return d, nil
+	}
+	d.limit[d.minLen] = count[d.minLen] - 1
+	for i := d.minLen + 1; i <= d.maxLen; i++ {
+		first := (d.limit[i-1] + 1) << 1
+		start[i] = start[i-1] + count[i-1]
+		d.limit[i] = first + count[i] - 1
+		d.base[i] = first - start[i]
+	}
+	d.inorder = make([]byte, len(clen)-count[0])
+	for sym, l := range clen {
+		if l != 0 {
+			d.inorder[start[l]] = byte(sym)
+			start[l]++
+		}
+	}
+	return d, nil
+}
+
+func (d *huffDecoder) decode(b *bitReader) (int, error) {
+	code, n := 0, 0
+	for {
+		if n == d.maxLen {
+			return 0, fmt.Errorf("%w (djw invalid code)", ErrCorrupt)
+		}
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		n++
+		code = code<<1 | bit
+		if n >= d.minLen && code <= d.limit[n] {
+			break
+		}
+	}
+	if off := code - d.base[n]; off >= 0 && off < len(d.inorder) {
+		return int(d.inorder[off]), nil
+	}
+	return 0, fmt.Errorf("%w (djw invalid code)", ErrCorrupt)
+}
+
+// decodeMTF decodes len(values) move-to-front coded values, where RUN_0 and
+// RUN_1 code the repeats of the front value in bijective base 2. With
+// skip other than 0, a value is 0 if the one skip before it is.
+func decodeMTF(b *bitReader, d *huffDecoder, mtf []byte, values []byte, skip int) error {
+	rep, next, shift := 0, 0, 0
+	for n := 0; n < len(values); {
+		switch {
+		case skip != 0 && n >= skip && values[n-skip] == 0:
+			values[n] = 0
+			n++
+		case rep != 0:
+			values[n] = mtf[0]
+			n++
+			rep--
+		case next != 0:
+			v := mtf[next]
+			copy(mtf[1:next+1], mtf[:next])
+			mtf[0] = v
+			values[n] = v
+			n++
+			next = 0
+		default:
+			sym, err := d.decode(b)
+			if err != nil {
+				return err
+			}
+			if sym <= djwRun1 {
+				if shift > 30 {
+					return fmt.Errorf("%w (djw invalid repeat code)", ErrCorrupt)
+				}
+				rep = (sym + 1) << shift
+				shift++
+			} else {
+				if next = sym - 1; next >= len(mtf) {
+					return fmt.Errorf("%w (djw invalid symbol)", ErrCorrupt)
+				}
+				shift = 0
+			}
+		}
+	}
+	if rep != 0 {
+		return fmt.Errorf("%w (djw invalid repeat code)", ErrCorrupt)
+	}
+	return nil
+}
+
+func (djwSection) decode(in []byte, n int) ([]byte, error) {
+	b := &bitReader{in: in, mask: 0x100}
+	groups, err := b.bits(djwGroupBits)
+	if err != nil {
+		return nil, err
+	}
+	groups++
+	sectorSize := n
+	if groups > 1 {
+		if sectorSize, err = b.bits(djwSectorszBits); err != nil {
+			return nil, err
+		}
+		sectorSize = (sectorSize + 1) * djwSectorszMult
+	}
+	sectors := 1 + (n-1)/sectorSize
+
+	// The code lengths of the code lengths, then the code lengths of every
+	// group
+	ncodes, err := b.bits(djwExtraCodeBits)
+	if err != nil {
+		return nil, err
+	}
+	var clclen [djwTotalCodes]byte
+	for i := 0; i < ncodes+djwExtra12Offset; i++ {
+		v, err := b.bits(djwClclenBits)
+		if err != nil {
+			return nil, err
+		}
+		clclen[i] = byte(v)
+	}
+	cl, err := newHuffDecoder(clclen[:], djwMaxClclen)
+	if err != nil {
+		return nil, err
+	}
+	clmtf := djwClenMTF
+	clen := make([]byte, 256*groups)
+	if err = decodeMTF(b, cl, clmtf[:], clen, 256); err != nil {
+		return nil, err
+	}
+	var dec [djwMaxGroups]*huffDecoder
+	for g := 0; g < groups; g++ {
+		if dec[g], err = newHuffDecoder(clen[g*256:(g+1)*256], djwMaxCodelen); err != nil {
+			return nil, err
+		}
+	}
+
+	// The group of every sector
+	sel := make([]byte, sectors)
+	if groups > 1 {
+		var selclen, selmtf [djwMaxGroups + 1]byte
+		for g := 0; g <= groups; g++ {
+			v, err := b.bits(djwGbclenBits)
+			if err != nil {
+				return nil, err
+			}
+			selclen[g], selmtf[g] = byte(v), byte(g)
+		}
+		seld, err := newHuffDecoder(selclen[:groups+1], djwMaxGbclen)
+		if err != nil {
+			return nil, err
+		}
+		if err = decodeMTF(b, seld, selmtf[:groups+1], sel, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]byte, 0, n)
+	for _, g := range sel {
+		if int(g) >= groups {
+			return nil, fmt.Errorf("%w (djw group %v)", ErrCorrupt, g)
+		}
+		for m := 0; m < sectorSize && len(out) < n; m++ {
+			sym, err := dec[g].decode(b)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(sym))
+		}
+	}
+	if len(b.in) != 0 {
+		return nil, fmt.Errorf("%w (djw section has unused input)", ErrCorrupt)
+	}
+	return out, nil
+}
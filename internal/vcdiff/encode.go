@@ -0,0 +1,121 @@
+package vcdiff
+
+import (
+	"io"
+)
+
+// WindowSize is the number of target bytes encoded per window
+var WindowSize = 1 << 22
+
+// Encoder writes a VCDIFF delta one instruction at a time. The whole
+// source is the source segment of every window.
+type Encoder struct {
+	w      io.Writer
+	srclen int
+	tlen   int
+	data   []byte
+	inst   []byte
+	addrs  []byte
+}
+
+// NewEncoder writes the VCDIFF header to w and returns an encoder for a
+// source of srclen bytes
+func NewEncoder(w io.Writer, srclen int) (*Encoder, error) {
+	if _, err := w.Write([]byte{Magic[0], Magic[1], Magic[2], Magic[3], 0}); err != nil {
+		return nil, err
+	}
+	return &Encoder{w: w, srclen: srclen}, nil
+}
+
+// Add appends the literal bytes b to the target
+func (e *Encoder) Add(b []byte) error {
+	for len(b) > 0 {
+		n := len(b)
+		if n > WindowSize-e.tlen {
+			n = WindowSize - e.tlen
+		}
+		if n <= 17 {
+			e.inst = append(e.inst, byte(1+n))
+		} else {
+			e.inst = appendInt(append(e.inst, 1), n)
+		}
+		e.data = append(e.data, b[:n]...)
+		b = b[n:]
+		if err := e.advance(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy appends size bytes of the source at addr to the target
+func (e *Encoder) Copy(addr, size int) error {
+	for size > 0 {
+		n := size
+		if n > WindowSize-e.tlen {
+			n = WindowSize - e.tlen
+		}
+		if n >= 4 && n <= 18 {
+			e.inst = append(e.inst, byte(19+n-3))
+		} else {
+			e.inst = appendInt(append(e.inst, 19), n)
+		}
+		e.addrs = appendInt(e.addrs, addr)
+		addr += n
+		size -= n
+		if err := e.advance(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) advance(n int) error {
+	e.tlen += n
+	if e.tlen == WindowSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// Close writes the last window. It doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	return e.flush()
+}
+
+// flush writes the current window
+func (e *Encoder) flush() error {
+	if e.tlen == 0 {
+		return nil
+	}
+	var enc []byte
+	enc = appendInt(enc, e.tlen)
+	enc = append(enc, 0)
+	enc = appendInt(enc, len(e.data))
+	enc = appendInt(enc, len(e.inst))
+	enc = appendInt(enc, len(e.addrs))
+	enc = append(enc, e.data...)
+	enc = append(enc, e.inst...)
+	enc = append(enc, e.addrs...)
+
+	var win []byte
+	if e.srclen > 0 {
+		win = append(win, vcdSource)
+		win = appendInt(win, e.srclen)
+		win = appendInt(win, 0)
+	} else {
+		win = append(win, 0)
+	}
+	win = appendInt(win, len(enc))
+	if _, err := e.w.Write(win); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(enc); err != nil {
+		return err
+	}
+	e.tlen = 0
+	e.data = e.data[:0]
+	e.inst = e.inst[:0]
+	e.addrs = e.addrs[:0]
+	return nil
+}
@@ -0,0 +1,50 @@
+package vcdiff
+
+import "io"
+
+// Op is a piece of a target: the literal bytes Data, or, when Data is nil,
+// Len bytes of the source at Pos
+type Op struct {
+	Data []byte
+	Pos  int
+	Len  int
+}
+
+// Scan reads the VCDIFF delta without its source and calls fn with the
+// pieces of the target, in order. COPYs from the target window are resolved
+// to the literals and source positions they repeat. Window checksums can't
+// be verified without the source and are ignored. Data is only valid
+// during the call.
+func Scan(delta io.Reader, fn func(op Op) error) error {
+	dec, err := newDecoder(delta, nil, 0)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := dec.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(d.origin); {
+			j := i + 1
+			if d.origin[i] < 0 {
+				for j < len(d.origin) && d.origin[j] < 0 {
+					j++
+				}
+				err = fn(Op{Data: d.target[i:j], Len: j - i})
+			} else {
+				for j < len(d.origin) && d.origin[j] == d.origin[j-1]+1 {
+					j++
+				}
+				err = fn(Op{Pos: d.origin[i], Len: j - i})
+			}
+			if err != nil {
+				return err
+			}
+			i = j
+		}
+	}
+}
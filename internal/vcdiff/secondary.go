@@ -0,0 +1,89 @@
+package vcdiff
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Secondary compressor IDs of xdelta3. They aren't registered with IANA.
+const (
+	secDJW  = 1
+	secLZMA = 2
+	secFGK  = 16
+)
+
+// Delta indicator bits: the sections compressed by the secondary compressor
+const (
+	vcdDatacomp = 0x01
+	vcdInstcomp = 0x02
+	vcdAddrcomp = 0x04
+)
+
+// secondary decompresses one kind of section (data, instructions or
+// addresses). xdelta3 keeps a compressor per kind of section for the whole
+// delta, so a decompressor may carry state from one window to the next.
+type secondary interface {
+	// decode decompresses the section in to n bytes
+	decode(in []byte, n int) ([]byte, error)
+}
+
+// newSecondary returns a function making the decompressors of the
+// secondary compressor id
+func newSecondary(id byte) (func() secondary, error) {
+	switch id {
+	case secDJW:
+		return func() secondary { return djwSection{} }, nil
+	case secLZMA:
+		return func() secondary { return &lzmaSection{} }, nil
+	case secFGK:
+		return nil, fmt.Errorf("%w (fgk secondary compression)", ErrUnsupported)
+	}
+	return nil, fmt.Errorf("%w (secondary compressor %v)", ErrCorrupt, id)
+}
+
+// decodeSecondary decompresses a section: its decompressed size, then the
+// compressed bytes
+func decodeSecondary(sec secondary, b []byte) ([]byte, error) {
+	s := &section{b: b}
+	n, err := s.readInt()
+	if err != nil || n == 0 || n > 3*maxWindow {
+		return nil, fmt.Errorf("%w (secondary section size)", ErrCorrupt)
+	}
+	return sec.decode(s.b[s.pos:], n)
+}
+
+// lzmaSection decompresses the sections of an xz stream. xdelta3 flushes
+// the stream at the end of every window without ending it, so each window
+// continues the stream of the previous one.
+type lzmaSection struct {
+	// in is the compressed input r hasn't read yet
+	in []byte
+	r  *xz.Reader
+}
+
+func (s *lzmaSection) Read(p []byte) (int, error) {
+	if len(s.in) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, s.in)
+	s.in = s.in[n:]
+	return n, nil
+}
+
+func (s *lzmaSection) decode(in []byte, n int) ([]byte, error) {
+	s.in = append(s.in, in...)
+	if s.r == nil {
+		r, err := xz.ReaderConfig{SingleStream: true}.NewReader(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w (lzma) %v", ErrCorrupt, err.Error())
+		}
+		s.r = r
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(s.r, out); err != nil {
+		return nil, fmt.Errorf("%w (lzma) %v", ErrCorrupt, err.Error())
+	}
+	return out, nil
+}
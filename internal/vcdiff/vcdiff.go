@@ -0,0 +1,227 @@
+// Package vcdiff implements the VCDIFF (RFC 3284) wire format. It's shared
+// by pkg/vcdiff, which feeds the encoder with the bsdiff matcher, and
+// pkg/bspatch, which applies xdelta3 patches with the decoder. The decoder
+// reads xdelta3's lzma and djw secondary compression, but not fgk or custom
+// code tables.
+package vcdiff
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic starts every VCDIFF delta
+var Magic = []byte{0xD6, 0xC3, 0xC4, 0x00}
+
+// Header indicator bits
+const (
+	vcdDecompress = 0x01
+	vcdCodetable  = 0x02
+	vcdAppheader  = 0x04 // xdelta3 application header
+)
+
+// Window indicator bits
+const (
+	vcdSource  = 0x01
+	vcdTarget  = 0x02
+	vcdAdler32 = 0x04 // xdelta3 window checksum
+)
+
+// Instruction types
+const (
+	instNoop = iota
+	instAdd
+	instRun
+	instCopy
+)
+
+// Address cache sizes of the default code table
+const (
+	nearSize = 4
+	sameSize = 3
+)
+
+// maxWindow bounds the size of a target window, so a corrupt delta can't
+// make the decoder allocate unbounded memory
+const maxWindow = 1 << 26
+
+// ErrUnsupported is returned when a delta uses a VCDIFF feature this package
+// can't decode, e.g. fgk secondary compression or a custom code table
+var ErrUnsupported = errors.New("vcdiff: unsupported feature")
+
+// ErrCorrupt is returned when a delta is malformed
+var ErrCorrupt = errors.New("vcdiff: corrupt delta")
+
+// LimitError is returned by DecodeLimit when a window needs more memory than
+// allowed
+type LimitError struct {
+	Need int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("vcdiff: window needs %v bytes", e.Need)
+}
+
+type instruction struct {
+	typ  byte
+	size byte
+	mode byte
+}
+
+// codeTable is the default instruction code table (RFC 3284 section 5.6)
+var codeTable = func() (t [256][2]instruction) {
+	i := 0
+	t[i][0] = instruction{typ: instRun}
+	i++
+	for size := 0; size <= 17; size++ {
+		t[i][0] = instruction{typ: instAdd, size: byte(size)}
+		i++
+	}
+	for mode := 0; mode <= 8; mode++ {
+		t[i][0] = instruction{typ: instCopy, mode: byte(mode)}
+		i++
+		for size := 4; size <= 18; size++ {
+			t[i][0] = instruction{typ: instCopy, size: byte(size), mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 5; mode++ {
+		for add := 1; add <= 4; add++ {
+			for size := 4; size <= 6; size++ {
+				t[i][0] = instruction{typ: instAdd, size: byte(add)}
+				t[i][1] = instruction{typ: instCopy, size: byte(size), mode: byte(mode)}
+				i++
+			}
+		}
+	}
+	for mode := 6; mode <= 8; mode++ {
+		for add := 1; add <= 4; add++ {
+			t[i][0] = instruction{typ: instAdd, size: byte(add)}
+			t[i][1] = instruction{typ: instCopy, size: 4, mode: byte(mode)}
+			i++
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		t[i][0] = instruction{typ: instCopy, size: 4, mode: byte(mode)}
+		t[i][1] = instruction{typ: instAdd, size: 1}
+		i++
+	}
+	return t
+}()
+
+// addrCache is the near and same address cache (RFC 3284 section 5.1)
+type addrCache struct {
+	near     [nearSize]int
+	nextSlot int
+	same     [sameSize * 256]int
+}
+
+func (c *addrCache) update(addr int) {
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % nearSize
+	c.same[addr%(sameSize*256)] = addr
+}
+
+// decode reads the address of a COPY instruction in mode
+func (c *addrCache) decode(r *section, here int, mode byte) (int, error) {
+	var addr int
+	switch {
+	case mode == 0:
+		v, err := r.readInt()
+		if err != nil {
+			return 0, err
+		}
+		addr = v
+	case mode == 1:
+		v, err := r.readInt()
+		if err != nil {
+			return 0, err
+		}
+		addr = here - v
+	case int(mode) < 2+nearSize:
+		v, err := r.readInt()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.near[mode-2] + v
+	case int(mode) < 2+nearSize+sameSize:
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.same[(int(mode)-2-nearSize)*256+int(b)]
+	default:
+		return 0, fmt.Errorf("%w (address mode %v)", ErrCorrupt, mode)
+	}
+	if addr < 0 || addr >= here {
+		return 0, fmt.Errorf("%w (address %v out of range)", ErrCorrupt, addr)
+	}
+	c.update(addr)
+	return addr, nil
+}
+
+// appendInt appends v as a VCDIFF integer (big endian base 128)
+func appendInt(b []byte, v int) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7F)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7F) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// readInt reads a VCDIFF integer
+func readInt(r io.ByteReader) (int, error) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<7 | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			if v > 1<<62 {
+				break
+			}
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%w (integer overflow)", ErrCorrupt)
+}
+
+// section is a window section being decoded
+type section struct {
+	b   []byte
+	pos int
+}
+
+func (s *section) ReadByte() (byte, error) {
+	if s.pos >= len(s.b) {
+		return 0, fmt.Errorf("%w (section ended)", ErrCorrupt)
+	}
+	s.pos++
+	return s.b[s.pos-1], nil
+}
+
+func (s *section) readInt() (int, error) {
+	return readInt(s)
+}
+
+func (s *section) next(n int) ([]byte, error) {
+	if n < 0 || n > len(s.b)-s.pos {
+		return nil, fmt.Errorf("%w (section ended)", ErrCorrupt)
+	}
+	s.pos += n
+	return s.b[s.pos-n : s.pos], nil
+}
+
+func newReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
@@ -0,0 +1,108 @@
+package vcdiff
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func patch(oldbs, delta []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := Decode(bytes.NewReader(oldbs), bytes.NewReader(delta), &out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func TestDecodeCodeTable(t *testing.T) {
+	// source "abcdefgh", target "abcdXXabcdabcdZ" using an ADD+COPY
+	// double instruction and a HERE mode COPY+ADD
+	delta := []byte{
+		0xD6, 0xC3, 0xC4, 0x00, 0x00, // header
+		vcdSource, 8, 0, // source segment
+		14,            // delta encoding length
+		15,            // target window length
+		0,             // delta indicator
+		3,             // data length
+		3,             // instructions length
+		3,             // addresses length
+		'X', 'X', 'Z', // data
+		20,      // COPY 4 mode 0
+		163 + 3, // ADD 2 + COPY 4 mode 0
+		247 + 1, // COPY 4 mode 1 (HERE) + ADD 1
+		0, 0, 4, // addresses: 0, 0, here(18)-14
+	}
+	got, err := patch([]byte("abcdefgh"), delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdXXabcdabcdZ" {
+		t.Fatal(string(got))
+	}
+	delta[len(delta)-1] = 20 // address before the start
+	if _, err = patch([]byte("abcdefgh"), delta); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := patch(nil, []byte{0xD6, 0xC3, 0xC4, 0x01, 0x00}); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+	if _, err := patch(nil, []byte{0xD6, 0xC3, 0xC4, 0x00, vcdDecompress, secFGK}); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+	if _, err := patch(nil, []byte{0xD6, 0xC3, 0xC4, 0x00, vcdDecompress, 3}); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+	if _, err := patch(nil, []byte{0xD6, 0xC3, 0xC4, 0x00, 0x00, vcdTarget, 1, 0}); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+}
+
+func TestDecodeTargetWindow(t *testing.T) {
+	delta := []byte{
+		0xD6, 0xC3, 0xC4, 0x00, 0x00, // header
+		// "abcdefgh"
+		0,       // no source segment
+		14,      // delta encoding length
+		8,       // target window length
+		0,       // delta indicator
+		8, 1, 0, // data, instructions and addresses lengths
+		'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h',
+		1 + 8, // ADD 8
+		// "cdefXY" copied from the first window
+		vcdTarget, 8, 0, // target segment
+		10,      // delta encoding length
+		6,       // target window length
+		0,       // delta indicator
+		2, 2, 1, // data, instructions and addresses lengths
+		'X', 'Y', // data
+		20, 1 + 2, // COPY 4 mode 0, ADD 2
+		2, // address
+	}
+	got, err := patch(nil, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdefghcdefXY" {
+		t.Fatal(string(got))
+	}
+	var ops []Op
+	err = Scan(bytes.NewReader(delta), func(op Op) error {
+		op.Data = append([]byte(nil), op.Data...)
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || string(ops[1].Data) != "cdefXY" {
+		t.Fatal(ops)
+	}
+	// only the last window is kept for VCD_TARGET segments
+	delta = append(delta, vcdTarget, 4, 0, 4, 4, 0, 0, 0, 0)
+	if _, err = patch(nil, delta); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+}
@@ -0,0 +1,278 @@
+// Package zipfile expands zip archives (and JAR and APK files, which are zip
+// archives) before diffing, in the spirit of archive-patcher. A small edit
+// to a deflated entry changes most of its compressed bytes, so bsdiff can't
+// match much of it. Inflating the entries the old and new archives share
+// lets bsdiff diff their contents instead, and the new entries are deflated
+// again after patching.
+//
+// Deflating again must give back the exact bytes of the new archive, so only
+// new entries that compress/flate reproduces at some level are expanded: in
+// practice, archives written by Go. The others are diffed as they are.
+package zipfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Entry is a deflated entry of an archive: Len bytes at offset Off that
+// inflate to Size bytes, and that Size bytes deflate back to at Level
+type Entry struct {
+	Off   int
+	Len   int
+	Size  int
+	Level int
+}
+
+// Transform records the entries expanded in the old and new archives,
+// sorted by offset
+type Transform struct {
+	Old []Entry
+	New []Entry
+}
+
+// errCorrupt is returned for a malformed or inconsistent transform
+var errCorrupt = errors.New("corrupt zip transform")
+
+// levels are the compression levels tried, most likely first: archive/zip
+// deflates at 5
+var levels = []int{5, flate.DefaultCompression, flate.BestCompression, 1, 2, 3, 4, 7, 8, flate.HuffmanOnly, flate.NoCompression}
+
+// maxSize is the largest expanded archive
+const maxSize = 1 << 40
+
+// Parse returns the transform expanding the deflated entries of the zip
+// archives oldbs and newbs that share a name, when the new one can be
+// deflated again exactly. ok is false if there are none.
+func Parse(oldbs, newbs []byte) (t *Transform, ok bool) {
+	oldEntries, ok := entries(oldbs)
+	if !ok {
+		return nil, false
+	}
+	newEntries, ok := entries(newbs)
+	if !ok {
+		return nil, false
+	}
+	t = &Transform{}
+	for name, ne := range newEntries {
+		oe, ok := oldEntries[name]
+		if !ok {
+			continue
+		}
+		data, err := inflate(newbs[ne.Off:ne.Off+ne.Len], ne.Size)
+		if err != nil {
+			continue
+		}
+		if ne.Level, ok = Level(data, newbs[ne.Off:ne.Off+ne.Len]); !ok {
+			continue
+		}
+		t.Old = append(t.Old, oe)
+		t.New = append(t.New, ne)
+	}
+	if len(t.New) == 0 {
+		return nil, false
+	}
+	sort.Slice(t.Old, func(i, j int) bool { return t.Old[i].Off < t.Old[j].Off })
+	sort.Slice(t.New, func(i, j int) bool { return t.New[i].Off < t.New[j].Off })
+	return t, true
+}
+
+// entries returns the deflated entries of the zip archive b by name, less
+// those of duplicate names
+func entries(b []byte) (map[string]Entry, bool) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, false
+	}
+	m := make(map[string]Entry)
+	dups := make(map[string]bool)
+	for _, f := range zr.File {
+		if f.Method != zip.Deflate {
+			continue
+		}
+		off, err := f.DataOffset()
+		if err != nil || f.CompressedSize64 > uint64(len(b)) || off+int64(f.CompressedSize64) > int64(len(b)) || f.UncompressedSize64 > maxSize {
+			continue
+		}
+		if _, ok := m[f.Name]; ok {
+			dups[f.Name] = true
+		}
+		m[f.Name] = Entry{Off: int(off), Len: int(f.CompressedSize64), Size: int(f.UncompressedSize64)}
+	}
+	for name := range dups {
+		delete(m, name)
+	}
+	return m, true
+}
+
+// Level returns the level compress/flate deflates data to compressed at, if
+// any
+func Level(data, compressed []byte) (int, bool) {
+	for _, level := range levels {
+		w := &matchWriter{want: compressed}
+		fw, _ := flate.NewWriter(w, level)
+		if _, err := fw.Write(data); err != nil {
+			continue
+		}
+		if err := fw.Close(); err == nil && w.n == len(compressed) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// matchWriter fails as soon as what's written differs from want
+type matchWriter struct {
+	want []byte
+	n    int
+}
+
+var errMismatch = errors.New("mismatch")
+
+func (w *matchWriter) Write(p []byte) (int, error) {
+	if len(p) > len(w.want)-w.n || !bytes.Equal(p, w.want[w.n:w.n+len(p)]) {
+		return 0, errMismatch
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func inflate(compressed []byte, size int) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(compressed))
+	defer fr.Close()
+	data := make([]byte, 0, size)
+	buf := bytes.NewBuffer(data)
+	if _, err := io.Copy(buf, io.LimitReader(fr, int64(size)+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() != size {
+		return nil, errCorrupt
+	}
+	return buf.Bytes(), nil
+}
+
+// ExpandedSize returns the size of an archive of size bytes with entries
+// inflated, or an error if entries don't fit in it
+func ExpandedSize(entries []Entry, size int) (int, error) {
+	end := 0
+	for _, e := range entries {
+		if e.Off < end || e.Len < 0 || e.Off > size || e.Len > size-e.Off || e.Size < 0 || e.Size > maxSize {
+			return 0, errCorrupt
+		}
+		end = e.Off + e.Len
+		if size += e.Size - e.Len; size > maxSize {
+			return 0, errCorrupt
+		}
+	}
+	return size, nil
+}
+
+// Expand returns b with entries inflated
+func Expand(b []byte, entries []Entry) ([]byte, error) {
+	size, err := ExpandedSize(entries, len(b))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, size)
+	pos := 0
+	for _, e := range entries {
+		data, err := inflate(b[e.Off:e.Off+e.Len], e.Size)
+		if err != nil {
+			return nil, errCorrupt
+		}
+		out = append(append(out, b[pos:e.Off]...), data...)
+		pos = e.Off + e.Len
+	}
+	return append(out, b[pos:]...), nil
+}
+
+// Compress returns the archive b expanded with entries, with them deflated
+// again. It fails if an entry doesn't deflate back to its length.
+func Compress(b []byte, entries []Entry) ([]byte, error) {
+	var out bytes.Buffer
+	pos, shift := 0, 0
+	for _, e := range entries {
+		// e.Off is an offset of the archive, before expanding
+		off := e.Off + shift
+		if e.Off < pos-shift || e.Size < 0 || off > len(b) || e.Size > len(b)-off {
+			return nil, errCorrupt
+		}
+		out.Write(b[pos:off])
+		n := out.Len()
+		fw, err := flate.NewWriter(&out, e.Level)
+		if err != nil {
+			return nil, errCorrupt
+		}
+		fw.Write(b[off : off+e.Size])
+		fw.Close()
+		if out.Len()-n != e.Len {
+			return nil, errCorrupt
+		}
+		pos = off + e.Size
+		shift += e.Size - e.Len
+	}
+	out.Write(b[pos:])
+	return out.Bytes(), nil
+}
+
+// Marshal encodes the transform as the old and new entries, each a uvarint
+// count of uvarint offset, length, size and level quadruples, the level
+// offset by 2 so HuffmanOnly is 0
+func (t *Transform) Marshal() []byte {
+	var out []byte
+	for _, entries := range [][]Entry{t.Old, t.New} {
+		out = binary.AppendUvarint(out, uint64(len(entries)))
+		for _, e := range entries {
+			out = binary.AppendUvarint(out, uint64(e.Off))
+			out = binary.AppendUvarint(out, uint64(e.Len))
+			out = binary.AppendUvarint(out, uint64(e.Size))
+			out = binary.AppendUvarint(out, uint64(e.Level+2))
+		}
+	}
+	return out
+}
+
+// Unmarshal decodes a transform encoded by Marshal
+func Unmarshal(b []byte) (*Transform, error) {
+	t := &Transform{}
+	r := bytes.NewReader(b)
+	read := func() (int, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > 1<<62 {
+			return 0, errCorrupt
+		}
+		return int(v), nil
+	}
+	for _, entries := range []*[]Entry{&t.Old, &t.New} {
+		n, err := read()
+		if err != nil || n > r.Len() {
+			return nil, errCorrupt
+		}
+		for i := 0; i < n; i++ {
+			var e Entry
+			if e.Off, err = read(); err != nil {
+				return nil, err
+			}
+			if e.Len, err = read(); err != nil {
+				return nil, err
+			}
+			if e.Size, err = read(); err != nil {
+				return nil, err
+			}
+			if e.Level, err = read(); err != nil || e.Level > flate.BestCompression+2 {
+				return nil, errCorrupt
+			}
+			e.Level -= 2
+			*entries = append(*entries, e)
+		}
+	}
+	if r.Len() > 0 {
+		return nil, errCorrupt
+	}
+	return t, nil
+}
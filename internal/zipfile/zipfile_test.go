@@ -0,0 +1,112 @@
+package zipfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// archive returns a zip archive of the name and data pairs of files, deflated
+// at level, but for names ending in ".raw", stored
+func archive(t *testing.T, level int, files ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	for i := 0; i < len(files); i += 2 {
+		method := zip.Deflate
+		if strings.HasSuffix(files[i], ".raw") {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: files[i], Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(files[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTransform(t *testing.T) {
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog ", 50)
+	oldbs := archive(t, 5, "a.txt", text, "b.txt", text+"b", "c.raw", "stored", "gone.txt", "gone")
+	for _, level := range []int{5, flate.BestCompression, flate.BestSpeed} {
+		newbs := archive(t, level, "b.txt", text+"b2", "new.txt", "new", "a.txt", "A"+text, "c.raw", "stored")
+		tr, ok := Parse(oldbs, newbs)
+		if !ok || len(tr.Old) != 2 || len(tr.New) != 2 {
+			t.Fatal("expected 2 shared entries, got", tr, ok)
+		}
+		if tr.Old[0].Off > tr.Old[1].Off || tr.New[0].Off > tr.New[1].Off {
+			t.Fatal("entries not sorted", tr)
+		}
+		oldx, err := Expand(oldbs, tr.Old)
+		if err != nil || !bytes.Contains(oldx, []byte(text+"b")) {
+			t.Fatal("old entries not inflated", err)
+		}
+		newx, err := Expand(newbs, tr.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size, _ := ExpandedSize(tr.New, len(newbs)); size != len(newx) {
+			t.Fatalf("expanded size %v, expected %v", size, len(newx))
+		}
+		got, err := Compress(newx, tr.New)
+		if err != nil || !bytes.Equal(got, newbs) {
+			t.Fatal("round trip failed", err)
+		}
+		u, err := Unmarshal(tr.Marshal())
+		if err != nil || !reflect.DeepEqual(u, tr) {
+			t.Fatal(u, err)
+		}
+	}
+
+	// Not archives, nothing shared
+	if _, ok := Parse([]byte("not a zip archive"), oldbs); ok {
+		t.Fatal("parsed a text file")
+	}
+	if _, ok := Parse(oldbs, archive(t, 5, "other.txt", text)); ok {
+		t.Fatal("expected no shared entries")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	text := strings.Repeat("lorem ipsum ", 100)
+	oldbs := archive(t, 5, "a", text)
+	newbs := archive(t, 5, "a", text+"!")
+	tr, ok := Parse(oldbs, newbs)
+	if !ok {
+		t.Fatal("could not parse")
+	}
+	newx, err := Expand(newbs, tr.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another level deflates to another length
+	bad := []Entry{tr.New[0]}
+	bad[0].Level = flate.HuffmanOnly
+	if _, err = Compress(newx, bad); err == nil {
+		t.Fatal("expected an error deflating at the wrong level")
+	}
+	bad[0] = tr.Old[0]
+	bad[0].Size--
+	if _, err = Expand(oldbs, bad); err == nil {
+		t.Fatal("expected an error inflating to the wrong size")
+	}
+	bad[0].Len = len(oldbs)
+	if _, err = ExpandedSize(bad, len(oldbs)); err == nil {
+		t.Fatal("expected an out of bounds error")
+	}
+	for _, b := range [][]byte{nil, {1, 0, 0, 0, 20}, append(tr.Marshal(), 0)} {
+		if _, err := Unmarshal(b); err == nil {
+			t.Fatalf("%v: expected an error", b)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package bench holds the diff and patch benchmarks.
+//
+// The workloads are generated by internal/testdata, so numbers are comparable
+// across machines and revisions. Besides throughput, the diff benchmarks
+// report the patch size in bytes and as a percentage of the new file. Run
+// them with:
+//
+//	go test -bench . ./pkg/bench -args -size 4194304
+//
+// and compare revisions with benchstat.
+package bench
@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"flag"
+	"runtime"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+var size = flag.Int("size", 1<<20, "approximate size in bytes of the generated files")
+
+func BenchmarkDiff(b *testing.B) {
+	benchmarkDiff(b)
+}
+
+// BenchmarkDiffConcurrent is BenchmarkDiff on every CPU
+func BenchmarkDiffConcurrent(b *testing.B) {
+	benchmarkDiff(b, bsdiff.WithConcurrency(runtime.NumCPU()))
+}
+
+// BenchmarkDiffFast is BenchmarkDiff with the Fast quality
+func BenchmarkDiffFast(b *testing.B) {
+	benchmarkDiff(b, bsdiff.WithQuality(bsdiff.Fast))
+}
+
+func benchmarkDiff(b *testing.B, opts ...bsdiff.Option) {
+	for _, w := range testdata.Workloads(*size) {
+		w := w
+		b.Run(w.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(w.New)))
+			b.ReportAllocs()
+			var patch []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				if patch, err = bsdiff.Bytes(w.Old, w.New, opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportPatchSize(b, patch, w.New)
+		})
+	}
+}
+
+// reportPatchSize reports the size of patch, and how it compares to the
+// new file
+func reportPatchSize(b *testing.B, patch, newbs []byte) {
+	b.ReportMetric(float64(len(patch)), "patch-bytes")
+	if len(newbs) > 0 {
+		b.ReportMetric(100*float64(len(patch))/float64(len(newbs)), "patch-%")
+	}
+}
+
+// BenchmarkMatch measures the matcher alone, without compressing the patch
+func BenchmarkMatch(b *testing.B) {
+	for _, w := range testdata.Workloads(*size) {
+		w := w
+		b.Run(w.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(w.New)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := bsdiff.Match(w.Old, w.New, func(c bsdiff.Control) error { return nil })
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPatch(b *testing.B) {
+	for _, w := range testdata.Workloads(*size) {
+		w := w
+		patch, err := bsdiff.Bytes(w.Old, w.New)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(w.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(w.New)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bspatch.Bytes(w.Old, patch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package bsdiff
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// appendChunk is the size of the runs of zero diff bytes the old file is
+// copied in by writeAppend, so a large old file isn't matched by as large a
+// buffer
+const appendChunk = 8 << 20
+
+// appended reports whether newbin is oldbin with bytes appended, as logs,
+// ledgers and backups grow, so the diff needs no suffix array
+func appended(oldbin, newbin []byte) bool {
+	return len(oldbin) > 0 && bytes.HasPrefix(newbin, oldbin)
+}
+
+// writeAppend writes the patch of appended files: the old file copied as
+// is, then the appended bytes as extra, in O(n). It closes w.
+func writeAppend(w *Writer, oldbin, newbin []byte, o *options) error {
+	start := time.Now()
+	m := util.NewMeter(o.progress, StageScan, int64(len(newbin)))
+	n := appendChunk
+	if len(oldbin) < n {
+		n = len(oldbin)
+	}
+	zeros := make([]byte, n)
+	for pos := 0; ; pos += len(zeros) {
+		if rest := len(oldbin) - pos; rest <= len(zeros) {
+			if err := w.WriteControl(zeros[:rest], newbin[len(oldbin):], 0); err != nil {
+				return err
+			}
+			break
+		}
+		if err := w.WriteControl(zeros, nil, 0); err != nil {
+			return err
+		}
+		m.Update(int64(pos + len(zeros)))
+	}
+	m.Done()
+	if o.stats != nil {
+		o.stats.ScanTime = time.Since(start)
+	}
+	return w.Close()
+}
@@ -0,0 +1,117 @@
+package bsdiff
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// asyncChunk is the size of the chunks an asyncWriter hands to its
+// goroutine, and asyncChunks how many of them can be queued
+const (
+	asyncChunk  = 64 * 1024
+	asyncChunks = 4
+)
+
+// asyncWriter writes to and closes w on its own goroutine, so the blocks of
+// a patch are compressed concurrently. Writes are copied into chunks, and
+// block when all of them are queued.
+type asyncWriter struct {
+	w    io.WriteCloser
+	buf  []byte
+	ch   chan []byte
+	free chan []byte
+	done chan struct{}
+	// finished is whether ch is closed
+	finished bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func newAsyncWriter(w io.WriteCloser) *asyncWriter {
+	a := &asyncWriter{
+		w:    w,
+		ch:   make(chan []byte, asyncChunks),
+		free: make(chan []byte, asyncChunks),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < asyncChunks; i++ {
+		a.free <- make([]byte, 0, asyncChunk)
+	}
+	a.buf = <-a.free
+	go a.run()
+	return a
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	var err error
+	for b := range a.ch {
+		if err == nil {
+			if err = a.call(func() error { _, err := a.w.Write(b); return err }); err != nil {
+				a.setErr(err)
+			}
+		}
+		a.free <- b[:0]
+	}
+	if err == nil {
+		a.setErr(a.call(a.w.Close))
+	}
+}
+
+// call returns the error of fn, or of a panic in it, which would otherwise
+// crash the program from this goroutine
+func (a *asyncWriter) call(fn func() error) (err error) {
+	defer util.Recover(&err)
+	return fn()
+}
+
+func (a *asyncWriter) setErr(err error) {
+	a.mu.Lock()
+	a.err = err
+	a.mu.Unlock()
+}
+
+func (a *asyncWriter) getErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Write queues p to be written, returning the error of an earlier write
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	if err := a.getErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(a.buf[len(a.buf):cap(a.buf)], p)
+		a.buf, p = a.buf[:len(a.buf)+k], p[k:]
+		if len(a.buf) == cap(a.buf) {
+			a.ch <- a.buf
+			a.buf = <-a.free
+		}
+	}
+	return n, nil
+}
+
+// finish queues the last chunk, after which the goroutine closes w
+func (a *asyncWriter) finish() {
+	if a.finished {
+		return
+	}
+	a.finished = true
+	if len(a.buf) > 0 {
+		a.ch <- a.buf
+	}
+	close(a.ch)
+}
+
+// Close waits for w to be written and closed
+func (a *asyncWriter) Close() error {
+	a.finish()
+	<-a.done
+	return a.getErr()
+}
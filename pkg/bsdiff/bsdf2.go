@@ -0,0 +1,34 @@
+package bsdiff
+
+import "fmt"
+
+// FormatBSDF2 is the layout of the ChromiumOS/Android bsdiff: BSDIFF40 with
+// a compression type per block in the header. Its blocks can only be
+// compressed with Bzip2, Brotli or Raw.
+const FormatBSDF2 Format = magicBSDF2
+
+const magicBSDF2 = "BSDF2"
+
+// bsdf2Types are the BSDF2 compression types of the compressor magics
+var bsdf2Types = map[string]byte{magicRaw: 0, magicBSDIFF40: 1, magicBrotli: 2}
+
+// bsdf2Header returns the header of a BSDF2 patch
+//
+//	0	5	"BSDF2"
+//	5	3	compression type of the ctrl, diff and extra blocks
+//	8	24	lengths as in BSDIFF40
+func bsdf2Header(o *options) ([]byte, error) {
+	if o.ext != nil {
+		return nil, fmt.Errorf("%v patches can't carry an extended header", magicBSDF2)
+	}
+	header := make([]byte, 32)
+	copy(header, magicBSDF2)
+	for i, c := range o.compressors {
+		typ, ok := bsdf2Types[c.Magic()]
+		if !ok {
+			return nil, fmt.Errorf("%v patches can't be compressed with %q", magicBSDF2, c.Magic())
+		}
+		header[5+i] = typ
+	}
+	return header, nil
+}
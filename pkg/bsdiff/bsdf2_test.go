@@ -0,0 +1,33 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestBSDF2(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	for _, opt := range []bsdiff.Option{
+		bsdiff.WithCompressor(bsdiff.Bzip2),
+		bsdiff.WithBlockCompressors(bsdiff.Bzip2, bsdiff.Brotli, bsdiff.Raw),
+	} {
+		patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithFormat(bsdiff.FormatBSDF2), opt})
+		if string(patch[:5]) != "BSDF2" {
+			t.Fatal("expected BSDF2 magic, got", string(patch[:5]))
+		}
+		f, err := bspatch.Detect(bytes.NewReader(patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != bspatch.FormatBSDF2 {
+			t.Fatal(f, "!=", bspatch.FormatBSDF2)
+		}
+	}
+	if _, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithFormat(bsdiff.FormatBSDF2), bsdiff.WithCompressor(bsdiff.Zstd)); err == nil {
+		t.Fatal("BSDF2 patches can't be zstd compressed")
+	}
+}
@@ -27,18 +27,30 @@ package bsdiff
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"time"
 
-	"github.com/dsnet/compress/bzip2"
 	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
 // Bytes takes the old and new byte slices and outputs the diff
-func Bytes(oldbs, newbs []byte) ([]byte, error) {
+func Bytes(oldbs, newbs []byte, opts ...Option) ([]byte, error) {
+	return BytesCtx(context.Background(), oldbs, newbs, opts...)
+}
+
+// BytesCtx is Bytes, stopping with ctx.Err() when ctx is cancelled. The
+// suffix sort and the matcher check ctx as they go, so long diffs stop
+// promptly.
+func BytesCtx(ctx context.Context, oldbs, newbs []byte, opts ...Option) (_ []byte, err error) {
+	defer util.Recover(&err)
 	var patch util.BufWriter
-	err := diffb(oldbs, newbs, &patch)
+	o := newOptions(opts)
+	o.ctx = ctx
+	err = diffb(oldbs, newbs, &patch, o, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +58,8 @@ func Bytes(oldbs, newbs []byte) ([]byte, error) {
 }
 
 // Reader takes the old and new binaries and outputs to a stream of the diff file
-func Reader(oldbin io.Reader, newbin io.Reader, patchf io.WriteSeeker) error {
+func Reader(oldbin io.Reader, newbin io.Reader, patchf io.WriteSeeker, opts ...Option) (err error) {
+	defer util.Recover(&err)
 	oldbs, err := io.ReadAll(oldbin)
 	if err != nil {
 		return err
@@ -55,41 +68,67 @@ func Reader(oldbin io.Reader, newbin io.Reader, patchf io.WriteSeeker) error {
 	if err != nil {
 		return err
 	}
-	return diffb(oldbs, newbs, patchf)
+	return diffb(oldbs, newbs, patchf, newOptions(opts), nil)
 }
 
-// File reads the old and new files to create a diff patch file
-func File(oldfile, newfile, patchfile string) error {
+// File reads the old and new files to create a diff patch file. The patch
+// is written to a temporary file in the same directory and renamed to
+// patchfile once complete, so an existing patchfile is replaced atomically,
+// and left as it was if diffing fails.
+func File(oldfile, newfile, patchfile string, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	if err := o.statFileInfo(newfile, os.Stat); err != nil {
+		return err
+	}
+	if o.window > 0 {
+		return streamFile(oldfile, newfile, patchfile, o)
+	}
 	oldbs, err := os.ReadFile(oldfile)
 	if err != nil {
-		return fmt.Errorf("could not read oldfile '%v': %v", oldfile, err.Error())
+		return fmt.Errorf("could not read oldfile '%v': %w", oldfile, err)
 	}
 	newbs, err := os.ReadFile(newfile)
 	if err != nil {
-		return fmt.Errorf("could not read newfile '%v': %v", newfile, err.Error())
+		return fmt.Errorf("could not read newfile '%v': %w", newfile, err)
 	}
-	patchF, err := os.OpenFile(patchfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("could not create patchfile '%v': %v", patchfile, err.Error())
+	return writePatchFile(patchfile, func(pf *os.File) error {
+		return diffb(oldbs, newbs, pf, o, nil)
+	})
+}
+
+// writePatchFile writes a patch with fn through a temporary file next to
+// patchfile, so that patchfile is replaced atomically and never left half
+// written
+func writePatchFile(patchfile string, fn func(pf *os.File) error) error {
+	return util.WriteFile(patchfile, func(pf *os.File) error {
+		if err := fn(pf); err != nil {
+			return fmt.Errorf("bsdiff: %w", err)
+		}
+		return nil
+	})
+}
+
+// statFileInfo records the attributes of newfile, as returned by stat, if
+// WithFileInfo is set
+func (o *options) statFileInfo(newfile string, stat func(name string) (fs.FileInfo, error)) error {
+	if !o.fileInfo {
+		return nil
 	}
-	err = diffb(oldbs, newbs, patchF)
-	_ = patchF.Close()
+	fi, err := stat(newfile)
 	if err != nil {
-		return fmt.Errorf("bsdiff: %v", err.Error())
+		return fmt.Errorf("could not stat newfile '%v': %w", newfile, err)
 	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	o.ext.setFileInfo(fi, !o.reproducible)
 	return nil
 }
 
-func diffb(oldbin, newbin []byte, pf io.WriteSeeker) error {
-	bziprule := &bzip2.WriterConfig{
-		Level: bzip2.BestCompression,
-	}
-	iii := make([]int, len(oldbin)+1)
-	qsufsort(iii, oldbin)
-
-	//var db
-	var dblen, eblen int
-
+// diffb writes the patch from oldbin to newbin to pf. The buffers of a are
+// reused if it isn't nil.
+func diffb(oldbin, newbin []byte, pf io.WriteSeeker, o *options, a *arena) error {
 	// Header is
 	//	0	8	 "BSDIFF40"
 	//	8	8	length of bzip2ed ctrl block
@@ -97,239 +136,100 @@ func diffb(oldbin, newbin []byte, pf io.WriteSeeker) error {
 	//	24	8	length of pnew file */
 	// File is
 	//  0	32	Header
-	//  32	??	Bzip2ed ctrl block
+	//  32	??	Extension area (BSDIFF4X only, see header.go)
+	//  ??	??	Bzip2ed ctrl block
 	//  ??	??	Bzip2ed diff block
 	//  ??	??	Bzip2ed extra block
-
-	newsize := len(newbin)
-	oldsize := len(oldbin)
-
-	header := make([]byte, 32)
-	buf := make([]byte, 8)
-
-	copy(header, []byte("BSDIFF40"))
-	offtout(0, header[8:])
-	offtout(0, header[16:])
-	offtout(newsize, header[24:])
-	if _, err := pf.Write(header); err != nil {
-		return err
-	}
-	// Compute the differences, writing ctrl as we go
-	pfbz2, err := bzip2.NewWriter(pf, bziprule)
-	if err != nil {
-		return err
-	}
-	var scan, ln, lastscan, lastpos, lastoffset int
-
-	var oldscore, scsc int
-	var pos int
-
-	var s, Sf, lenf, Sb, lenb int
-	var overlap, Ss, lens int
-
-	db := make([]byte, newsize+1)
-	eb := make([]byte, newsize+1)
-
-	defer func() {
-		if pfbz2 != nil {
-			pfbz2.Close()
-		}
-	}()
-
-	for scan < newsize {
-		oldscore = 0
-
-		// scsc = scan += len
-		scan += ln
-		scsc = scan
-		for scan < newsize {
-			ln = search(iii, oldbin, newbin[scan:], 0, oldsize, &pos)
-
-			for scsc < scan+ln {
-				if scsc+lastoffset < oldsize && oldbin[scsc+lastoffset] == newbin[scsc] {
-					oldscore++
-				}
-				scsc++
-			}
-			if ln == oldscore && ln != 0 {
-				break
-			}
-			if ln > oldscore+8 {
-				break
-			}
-			if scan+lastoffset < oldsize && oldbin[scan+lastoffset] == newbin[scan] {
-				oldscore--
-			}
-			//
-			scan++
-		}
-
-		if ln != oldscore || scan == newsize {
-			s = 0
-			Sf = 0
-			lenf = 0
-			i := 0
-			for lastscan+i < scan && lastpos+i < oldsize {
-				if oldbin[lastpos+i] == newbin[lastscan+i] {
-					s++
-				}
-				i++
-				if s*2-i > Sf*2-lenf {
-					Sf = s
-					lenf = i
-				}
-			}
-
-			lenb = 0
-			if scan < newsize {
-				s = 0
-				Sb = 0
-				for i = 1; scan >= lastscan+i && pos >= i; i++ {
-					if oldbin[pos-i] == newbin[scan-i] {
-						s++
-					}
-					if s*2-i > Sb*2-lenb {
-						Sb = s
-						lenb = i
-					}
-				}
-			}
-
-			if lastscan+lenf > scan-lenb {
-				overlap = (lastscan + lenf) - (scan - lenb)
-				s = 0
-				Ss = 0
-				lens = 0
-				for i = 0; i < overlap; i++ {
-					if newbin[lastscan+lenf-overlap+i] == oldbin[lastpos+lenf-overlap+i] {
-						s++
-					}
-
-					if newbin[scan-lenb+i] == oldbin[pos-lenb+i] {
-						s--
-					}
-					if s > Ss {
-						Ss = s
-						lens = i + 1
-					}
-				}
-
-				lenf += lens - overlap
-				lenb -= lens
-			}
-
-			for i = 0; i < lenf; i++ {
-				db[dblen+i] = newbin[lastscan+i] - oldbin[lastpos+i]
-			}
-			for i = 0; i < (scan-lenb)-(lastscan+lenf); i++ {
-				eb[eblen+i] = newbin[lastscan+lenf+i]
-			}
-
-			dblen += lenf
-			eblen += (scan - lenb) - (lastscan + lenf)
-
-			offtout(lenf, buf)
-			if _, err = pfbz2.Write(buf); err != nil {
-				return err
-			}
-
-			offtout((scan-lenb)-(lastscan+lenf), buf)
-			if _, err = pfbz2.Write(buf); err != nil {
-				return err
-			}
-
-			offtout((pos-lenb)-(lastpos+lenf), buf)
-			if _, err = pfbz2.Write(buf); err != nil {
-				return err
-			}
-
-			lastscan = scan - lenb
-			lastpos = pos - lenb
-			lastoffset = pos - scan
-		}
+	// FormatEndsley patches are laid out as described in endsley.go
+	o.setHashes(sum(oldbin), sum(newbin))
+	oldfile, newfile := oldbin, newbin
+	expanded := false
+	if o.zip {
+		oldbin, newbin, expanded = expandZip(oldbin, newbin, o)
 	}
-	if err = pfbz2.Close(); err != nil {
-		return err
+	if o.squashfs && !expanded {
+		oldbin, newbin, expanded = expandSquashfs(oldbin, newbin, o)
 	}
-
-	// Compute size of compressed ctrl data
-	offtout(int(pfbz2.OutputOffset), header[8:])
-
-	// Write compressed diff data
-	pfbz2, err = bzip2.NewWriter(pf, bziprule)
-	if err != nil {
-		return err
+	aligned := false
+	if o.tar && !expanded {
+		oldbin, aligned = alignTar(oldbin, newbin, o)
 	}
-	if _, err = pfbz2.Write(db[:dblen]); err != nil {
-		return err
+	if o.blockAlign > 0 && !expanded && !aligned {
+		oldbin = alignBlocks(oldbin, newbin, o)
 	}
-
-	if err = pfbz2.Close(); err != nil {
-		return err
+	if o.exec && !expanded {
+		oldbin, newbin = transformExe(oldbin, newbin, o)
 	}
-	// Compute size of compressed diff data
-	offtout(int(pfbz2.OutputOffset), header[16:])
-	// Write compressed extra data
-	pfbz2, err = bzip2.NewWriter(pf, bziprule)
+	w, err := newWriter(pf, o)
 	if err != nil {
 		return err
 	}
-	if _, err = pfbz2.Write(eb[:eblen]); err != nil {
-		return err
+	defer w.release()
+	if a == nil {
+		a = &arena{}
 	}
-	if err = pfbz2.Close(); err != nil {
-		return err
+	if appended(oldbin, newbin) {
+		err = writeAppend(w, oldbin, newbin, o)
+	} else {
+		err = writeDiff(w, a.sortIndex(oldbin, o), oldbin, newbin, o, &a.db)
 	}
-	// Seek to the beginning, write the header, and close the file
-	if _, err = pf.Seek(0, io.SeekStart); err != nil {
+	if err != nil {
 		return err
 	}
-	if _, err = pf.Write(header); err != nil {
-		return err
+	if o.verify {
+		return o.verifyPatch(bytes.NewReader(oldfile), pf, sum(newfile))
 	}
-
 	return nil
 }
 
-func search(iii []int, oldbin []byte, newbin []byte, st, en int, pos *int) int {
-	var x, y int
-	oldsize := len(oldbin)
-	newsize := len(newbin)
-
-	if en-st < 2 {
-		x = matchlen(oldbin[iii[st]:], newbin)
-		y = matchlen(oldbin[iii[en]:], newbin)
-
-		if x > y {
-			*pos = iii[st]
-			return x
+// writeDiff computes the differences with the index x of oldbin, writing
+// ctrl as it goes, and closes w. db holds the diff bytes of a control.
+func writeDiff(w *Writer, x oldIndex, oldbin, newbin []byte, o *options, db *[]byte) error {
+	start := time.Now()
+	m := util.NewMeter(o.progress, StageScan, int64(len(newbin)))
+	err := x.match(oldbin, newbin, o, func(c Control) error {
+		m.Update(int64(c.NewPos))
+		*db = (*db)[:0]
+		for i := 0; i < c.Add; i++ {
+			*db = append(*db, newbin[c.NewPos+i]-oldbin[c.OldPos+i])
 		}
-		*pos = iii[en]
-		return y
-	}
-
-	x = st + (en-st)/2
-	cmpln := oldsize - iii[x]
-	if cmpln > newsize {
-		cmpln = newsize
+		return w.WriteControl(*db, newbin[c.NewPos+c.Add:c.NewPos+c.Add+c.Copy], c.Seek)
+	})
+	if err != nil {
+		return err
 	}
-	if bytes.Compare(oldbin[iii[x]:iii[x]+cmpln], newbin[:cmpln]) < 0 {
-		return search(iii, oldbin, newbin, x, en, pos)
+	m.Done()
+	if o.stats != nil {
+		o.stats.ScanTime = time.Since(start)
 	}
-	return search(iii, oldbin, newbin, st, x, pos)
+	return w.Close()
 }
 
-func matchlen(oldbin []byte, newbin []byte) int {
-	var i int
+// search returns the length and old position of the longest match of
+// newbin[scan:] in oldbin, by binary search of the suffix array iii
+func search[I suffix](iii []I, oldbin, newbin []byte, scan int) (ln, pos int) {
 	oldsize := len(oldbin)
-	newsize := len(newbin)
-	for (i < oldsize) && (i < newsize) {
-		if oldbin[i] != newbin[i] {
-			break
+	newsize := len(newbin) - scan
+	st, en := 0, oldsize
+	for en-st >= 2 {
+		x := st + (en-st)/2
+		p := int(iii[x])
+		cmpln := oldsize - p
+		if cmpln > newsize {
+			cmpln = newsize
 		}
-		i++
+		if bytes.Compare(oldbin[p:p+cmpln], newbin[scan:scan+cmpln]) < 0 {
+			st = x
+		} else {
+			en = x
+		}
+	}
+	x := util.MatchLen(oldbin[iii[st]:], newbin[scan:])
+	y := util.MatchLen(oldbin[iii[en]:], newbin[scan:])
+	if x > y {
+		return x, int(iii[st])
 	}
-	return i
+	return y, int(iii[en])
 }
 
 // offtout puts an int64 (little endian) to buf
@@ -367,145 +267,3 @@ func offtout(x int, buf []byte) {
 		buf[7] |= 0x80
 	}
 }
-
-func qsufsort(iii []int, buf []byte) {
-	buckets := make([]int, 256)
-	vvv := make([]int, len(iii))
-	var i, h, ln int
-	bufzise := len(buf)
-
-	for i = 0; i < bufzise; i++ {
-		buckets[buf[i]]++
-	}
-
-	for i = 1; i < 256; i++ {
-		buckets[i] += buckets[i-1]
-	}
-
-	for i = 255; i > 0; i-- {
-		buckets[i] = buckets[i-1]
-	}
-	buckets[0] = 0
-
-	for i = 0; i < bufzise; i++ {
-		buckets[buf[i]]++
-		iii[buckets[buf[i]]] = i
-	}
-	iii[0] = bufzise
-
-	for i = 0; i < bufzise; i++ {
-		vvv[i] = buckets[buf[i]]
-	}
-	vvv[bufzise] = 0
-
-	for i = 1; i < 256; i++ {
-		if buckets[i] == buckets[i-1]+1 {
-			iii[buckets[i]] = -1
-		}
-	}
-	iii[0] = -1
-
-	for h = 1; iii[0] != -(bufzise + 1); h += h {
-		ln = 0
-
-		i = 0
-		for i < bufzise+1 {
-			if iii[i] < 0 {
-				ln -= iii[i]
-				i -= iii[i]
-			} else {
-				if ln != 0 {
-					iii[i-ln] = -ln
-				}
-				ln = vvv[iii[i]] + 1 - i
-				split(iii, vvv, i, ln, h)
-				i += ln
-				ln = 0
-			}
-		}
-		if ln != 0 {
-			iii[i-ln] = -ln
-		}
-	}
-
-	for i = 0; i < bufzise+1; i++ {
-		iii[vvv[i]] = i
-	}
-}
-
-func split(iii, vvv []int, start, ln, h int) {
-	var i, j, k, x int
-
-	if ln < 16 {
-		for k = start; k < start+ln; k += j {
-			j = 1
-			x = vvv[iii[k]+h]
-			for i = 1; k+i < start+ln; i++ {
-				if vvv[iii[k+i]+h] < x {
-					x = vvv[iii[k+i]+h]
-					j = 0
-				}
-				if vvv[iii[k+i]+h] == x {
-					iii[k+j], iii[k+i] = iii[k+i], iii[k+j]
-					j++
-				}
-			}
-			for i = 0; i < j; i++ {
-				vvv[iii[k+i]] = k + j - 1
-			}
-			if j == 1 {
-				iii[k] = -1
-			}
-		}
-		return
-	}
-
-	x = vvv[iii[start+(ln/2)]+h]
-	var jj, kk int
-	for i = start; i < start+ln; i++ {
-		if vvv[iii[i]+h] < x {
-			jj++
-		} else if vvv[iii[i]+h] == x {
-			kk++
-		}
-	}
-	jj += start
-	kk += jj
-
-	i = start
-	j = 0
-	k = 0
-	for i < jj {
-		if vvv[iii[i]+h] < x {
-			i++
-		} else if vvv[iii[i]+h] == x {
-			iii[i], iii[jj+j] = iii[jj+j], iii[i]
-			j++
-		} else {
-			iii[i], iii[kk+k] = iii[kk+k], iii[i]
-			k++
-		}
-	}
-	for jj+j < kk {
-		if vvv[iii[jj+j]+h] == x {
-			j++
-		} else {
-			iii[jj+j], iii[kk+k] = iii[kk+k], iii[jj+j]
-			k++
-		}
-	}
-	if jj > start {
-		split(iii, vvv, start, jj-start, h)
-	}
-
-	for i = 0; i < kk-jj; i++ {
-		vvv[iii[jj+i]] = kk - 1
-	}
-	if jj == kk-1 {
-		iii[jj] = -1
-	}
-
-	if start+ln > kk {
-		split(iii, vvv, kk, start+ln-kk, h)
-	}
-}
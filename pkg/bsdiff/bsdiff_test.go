@@ -2,13 +2,23 @@ package bsdiff
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
 	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
@@ -124,3 +134,527 @@ func TestFile(t *testing.T) {
 	os.Remove(t1n)
 	os.Remove(tpp)
 }
+
+func TestFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	oldfile, newfile, patchfile := filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "patch")
+	newbs := make([]byte, 1024*64)
+	rand.Read(newbs)
+	for name, b := range map[string][]byte{oldfile: newbs[:1024], newfile: newbs, patchfile: []byte("previous")} {
+		if err := os.WriteFile(name, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A failed diff leaves the previous patch and no temporary file
+	if err := File(oldfile, newfile, patchfile, WithCompressor(failingCompressor{1000})); err == nil {
+		t.Fatal("expected the compressor error")
+	}
+	if b, _ := os.ReadFile(patchfile); string(b) != "previous" {
+		t.Fatal("patchfile was overwritten by a failed diff")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatal("temporary files were left behind:", entries)
+	}
+	if err = File(oldfile, newfile, patchfile); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(patchfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() < 32 || runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Fatal("unexpected patchfile", fi.Size(), fi.Mode())
+	}
+}
+
+func TestBzip2Config(t *testing.T) {
+	oldbs := make([]byte, 1024*32)
+	newbs := make([]byte, 1024*33)
+	rand.Read(oldbs)
+	copy(newbs, oldbs)
+	rand.Read(newbs[1024*32:])
+	for _, cfg := range []Bzip2Config{{Level: 1}, {BlockSize: 3}, {Level: 9, BlockSize: 1}} {
+		patch, err := Bytes(oldbs, newbs, WithCompressor(NewBzip2(cfg)))
+		if err != nil {
+			t.Fatal(cfg, err)
+		}
+		if string(patch[:8]) != "BSDIFF40" {
+			t.Fatal(cfg, "expected BSDIFF40 magic")
+		}
+	}
+	if _, err := Bytes(oldbs, newbs, WithCompressor(NewBzip2(Bzip2Config{Level: 10}))); err == nil {
+		t.Fatal("invalid level should fail")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	oldbs := make([]byte, 1024*8)
+	rand.Read(oldbs)
+	newbs := append([]byte("prefix"), oldbs...)
+	rand.Read(newbs[4000:4100])
+	for _, opts := range [][]Option{nil, {WithQuality(Fast)}, {WithConcurrency(4)}} {
+		var out []byte
+		err := Match(oldbs, newbs, func(c Control) error {
+			if c.NewPos != len(out) {
+				t.Fatal("control at", c.NewPos, "expected", len(out))
+			}
+			out = append(out, newbs[c.NewPos:c.NewPos+c.Add+c.Copy]...)
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, newbs) {
+			t.Fatal("controls don't cover the new file")
+		}
+	}
+}
+
+func TestFileMmap(t *testing.T) {
+	dir := t.TempDir()
+	file1 := make([]byte, 1024*32)
+	file2 := make([]byte, 1024*33)
+	rand.Read(file1)
+	copy(file2, file1)
+	rand.Read(file2[1024*32:])
+	rand.Read(file2[100:1024])
+	for _, tc := range [][2][]byte{{file1, file2}, {nil, file2}, {file1, nil}} {
+		oldf := filepath.Join(dir, "old")
+		newf := filepath.Join(dir, "new")
+		if err := os.WriteFile(oldf, tc[0], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(newf, tc[1], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := File(oldf, newf, filepath.Join(dir, "patch")); err != nil {
+			t.Fatal(err)
+		}
+		if err := FileMmap(oldf, newf, filepath.Join(dir, "patch.mmap")); err != nil {
+			t.Fatal(err)
+		}
+		p1, _ := os.ReadFile(filepath.Join(dir, "patch"))
+		p2, _ := os.ReadFile(filepath.Join(dir, "patch.mmap"))
+		if !bytes.Equal(p1, p2) {
+			t.Fatal("FileMmap and File patches differ")
+		}
+	}
+	if err := FileMmap(filepath.Join(dir, "missing"), filepath.Join(dir, "new"), filepath.Join(dir, "patch")); err == nil {
+		t.Fatal("expected an error for a missing oldfile")
+	}
+}
+
+func TestDiffer(t *testing.T) {
+	d := NewDiffer(WithMetadata("k", "v"))
+	for _, size := range []int{1024 * 32, 100, 0, 1024 * 8} {
+		oldbs := make([]byte, size)
+		newbs := make([]byte, size+100)
+		rand.Read(oldbs)
+		copy(newbs, oldbs)
+		rand.Read(newbs[size/2:])
+		want, err := Bytes(oldbs, newbs, WithMetadata("k", "v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch, err := d.Bytes(oldbs, newbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(patch, want) {
+			t.Fatal("Differ patch differs from Bytes, size", size)
+		}
+	}
+}
+
+func TestSpill(t *testing.T) {
+	oldbs := make([]byte, 1024*32)
+	newbs := make([]byte, 1024*33)
+	rand.Read(oldbs)
+	copy(newbs, oldbs)
+	rand.Read(newbs[1024*32:])
+	rand.Read(newbs[100:1024])
+	limit := spillLimit
+	defer func() { spillLimit = limit }()
+	for _, c := range []Compressor{Bzip2, Raw} {
+		spillLimit = limit
+		want, err := Bytes(oldbs, newbs, WithCompressor(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Blocks larger than 100 bytes go to temporary files
+		spillLimit = 100
+		patch, err := Bytes(oldbs, newbs, WithCompressor(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(patch, want) {
+			t.Fatal("patch differs when blocks spill", c.Magic())
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	segment := minSegment
+	defer func() { minSegment = segment }()
+	minSegment = 16 * 1024
+	oldbs := make([]byte, 1024*200)
+	newbs := make([]byte, 1024*210)
+	rand.Read(oldbs)
+	copy(newbs, oldbs[1000:])
+	rand.Read(newbs[1024*50 : 1024*51])
+	// Edits every few KiB make controls in every segment, which the serial
+	// matcher has to resynchronize with
+	var editNew []byte
+	for i := 0; i+1000 <= len(oldbs); i += 3000 {
+		insert := make([]byte, 10)
+		rand.Read(insert)
+		editNew = append(editNew, insert...)
+		editNew = append(editNew, oldbs[i:i+1000]...)
+	}
+	// Repetitive data keeps the matcher from resynchronizing quickly
+	repOld := bytes.Repeat([]byte("0123456789abcdef"), 1024*4)
+	repNew := append(bytes.Repeat([]byte("0123456789abcdeX"), 1024*2), repOld[1024*30:]...)
+	for _, pair := range [][2][]byte{{oldbs, newbs}, {oldbs, editNew}, {repOld, repNew}} {
+		want, err := Bytes(pair[0], pair[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{2, 4, 7} {
+			patch, err := Bytes(pair[0], pair[1], WithConcurrency(n), WithDeterministic())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(patch, want) {
+				t.Fatal("deterministic patch depends on the concurrency", n)
+			}
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	oldbs := make([]byte, 1024*32)
+	rand.Read(oldbs)
+	var sortStats DiffStats
+	x, err := NewIndex(oldbs, WithCompressor(Raw), WithStats(&sortStats), WithProgress(func(string, int64, int64) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		newbs := make([]byte, 1024*32+i*100)
+		copy(newbs, oldbs)
+		rand.Read(newbs[i*1000 : i*1000+500])
+		want, err := Bytes(oldbs, newbs, WithCompressor(Raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stats DiffStats
+			var stages []string
+			patch, err := x.Diff(newbs, WithStats(&stats), WithProgress(func(stage string, done, total int64) {
+				if done == 0 {
+					stages = append(stages, stage)
+				}
+			}))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(patch, want) {
+				t.Error("Index patch differs from Bytes")
+			}
+			if stats.Controls == 0 || stats.SortTime != 0 || len(stages) == 0 || stages[0] != StageScan {
+				t.Error("unexpected stats or stages of an Index diff", stats, stages)
+			}
+		}()
+	}
+	wg.Wait()
+	if sortStats.SortTime == 0 || sortStats.IndexMemory == 0 || sortStats.Controls != 0 {
+		t.Fatal("the stats of NewIndex should be those of the sort", sortStats)
+	}
+	if _, err = NewIndex(oldbs, WithExecutable()); err == nil {
+		t.Fatal("expected an error for executables")
+	}
+}
+
+func TestIndexWidth(t *testing.T) {
+	oldbs := make([]byte, 1024*32)
+	newbs := make([]byte, 1024*33)
+	rand.Read(oldbs)
+	copy(newbs[1000:], oldbs)
+	want, err := Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := maxInt32Index
+	defer func() { maxInt32Index = limit }()
+	// Suffix arrays of int elements, as for old files of 2 GiB or more
+	maxInt32Index = 0
+	patch, err := Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patch, want) {
+		t.Fatal("patch depends on the width of the suffix array")
+	}
+}
+
+// failingCompressor fails to write once limit bytes were written
+type failingCompressor struct {
+	limit int
+}
+
+func (failingCompressor) Magic() string {
+	return "BSDIFFxx"
+}
+
+func (c failingCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &failingWriter{limit: c.limit}, nil
+}
+
+type failingWriter struct {
+	limit, n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n += len(p); w.n > w.limit {
+		return 0, errors.New("compressor failed")
+	}
+	return len(p), nil
+}
+
+func (w *failingWriter) Close() error {
+	return nil
+}
+
+func TestAsyncCompressorError(t *testing.T) {
+	oldbs := make([]byte, 1024*64)
+	newbs := make([]byte, 1024*512)
+	rand.Read(newbs)
+	for _, limit := range []int{0, 1000, 1024 * 300} {
+		_, err := Bytes(oldbs, newbs, WithCompressor(failingCompressor{limit}), WithConcurrency(2))
+		if err == nil || err.Error() != "compressor failed" {
+			t.Fatal("expected the compressor error, got", err)
+		}
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	segment, threshold := minSegment, parallelThreshold
+	defer func() { minSegment, parallelThreshold = segment, threshold }()
+	minSegment, parallelThreshold = 16*1024, 16*1024
+	w := testdata.SmallEdits(1 << 18)
+	// Golden hashes of the patches. A change means patches made by earlier
+	// releases can't be reproduced anymore.
+	golden := []struct {
+		c    Compressor
+		hash string
+	}{
+		{Bzip2, "1887d489526e2d53d959c80895b23418b31020f52d7d64efb4d6546f2e38d4c1"},
+		{Zstd, "8c16f2e7a6fcf3cabebb5fcfabac59c1a9f88bed017d4bd54139f14241ae5a28"},
+		{Xz, "396ee1c42441eb01856e3a0730f498b0724215b6ba59a81e369a3c890d6044bf"},
+		{Brotli, "5345054f88f76058a24fd0693ce6da7993b56e888e77053f1109de99592f330a"},
+		{Raw, "bcdd1401e6961fa372f5e5fbcaa1f474d2065f17de402804546980a5d37a8827"},
+	}
+	for _, g := range golden {
+		for _, n := range []int{0, 3, 8} {
+			patch, err := Bytes(w.Old, w.New, WithReproducible(), WithCompressor(g.c), WithConcurrency(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash := fmt.Sprintf("%x", sha256.Sum256(patch)); hash != g.hash {
+				t.Fatalf("%v patch with concurrency %v hashes to %v, want %v", g.c.Magic(), n, hash, g.hash)
+			}
+		}
+	}
+
+	// The modification time is left out
+	dir := t.TempDir()
+	oldfile, newfile := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	if err := os.WriteFile(oldfile, w.Old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var patches [2][]byte
+	for i := range patches {
+		if err := os.WriteFile(newfile, w.New, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(newfile, time.Now(), time.Unix(int64(i)*3600, 0)); err != nil {
+			t.Fatal(err)
+		}
+		patchfile := filepath.Join(dir, "patch")
+		if err := File(oldfile, newfile, patchfile, WithReproducible(), WithFileInfo()); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(patchfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patches[i] = b
+	}
+	if !bytes.Equal(patches[0], patches[1]) {
+		t.Fatal("reproducible patch depends on the modification time")
+	}
+}
+
+// flippingCompressor stores the blocks uncompressed like Raw, but flips the
+// bits of every 1000th byte
+type flippingCompressor struct{}
+
+func (flippingCompressor) Magic() string {
+	return magicRaw
+}
+
+func (flippingCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &flippingWriter{w: w}, nil
+}
+
+type flippingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (f *flippingWriter) Write(p []byte) (int, error) {
+	b := append([]byte{}, p...)
+	for i := range b {
+		if (f.n+i)%1000 == 999 {
+			b[i] ^= 0xff
+		}
+	}
+	f.n += len(p)
+	return f.w.Write(b)
+}
+
+func (f *flippingWriter) Close() error {
+	return nil
+}
+
+// writeSeeker hides the io.ReaderAt of an os.File
+type writeSeeker struct {
+	io.WriteSeeker
+}
+
+func TestVerify(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	if _, err := Bytes(w.Old, w.New, WithVerify()); err != nil {
+		t.Fatal(err)
+	}
+	var patch util.BufWriter
+	if err := Stream(bytes.NewReader(w.Old), bytes.NewReader(w.New), &patch, WithVerify(), WithWindow(1<<14)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Bytes(w.Old, w.New, WithVerify(), WithCompressor(flippingCompressor{})); !errors.Is(err, ErrVerify) {
+		t.Fatal("expected a verification error, got", err)
+	}
+	err := Stream(bytes.NewReader(w.Old), bytes.NewReader(w.New), &util.BufWriter{}, WithVerify(), WithCompressor(flippingCompressor{}))
+	if !errors.Is(err, ErrVerify) {
+		t.Fatal("expected a verification error from Stream, got", err)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = Reader(bytes.NewReader(w.Old), bytes.NewReader(w.New), f, WithVerify()); err != nil {
+		t.Fatal(err)
+	}
+	if err = Reader(bytes.NewReader(w.Old), bytes.NewReader(w.New), writeSeeker{f}, WithVerify()); err == nil || errors.Is(err, ErrVerify) {
+		t.Fatal("expected an error reading the patch back, got", err)
+	}
+}
+
+// panicCompressor panics writing the blocks
+type panicCompressor struct{}
+
+func (panicCompressor) Magic() string {
+	return magicRaw
+}
+
+func (panicCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return panicWriter{}, nil
+}
+
+type panicWriter struct{}
+
+func (panicWriter) Write(p []byte) (int, error) {
+	panic("compressor bug")
+}
+
+func (panicWriter) Close() error {
+	return nil
+}
+
+func TestPanic(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	dir := t.TempDir()
+	oldn, newn := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	if err := os.WriteFile(oldn, w.Old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newn, w.New, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, diff := range []func() error{
+		func() error { _, err := Bytes(w.Old, w.New, WithCompressor(panicCompressor{})); return err },
+		// The blocks are compressed on their own goroutines
+		func() error {
+			_, err := Bytes(w.Old, w.New, WithCompressor(panicCompressor{}), WithConcurrency(4))
+			return err
+		},
+		func() error { return File(oldn, newn, filepath.Join(dir, "patch"), WithCompressor(panicCompressor{})) },
+	} {
+		if err := diff(); err == nil || !strings.Contains(err.Error(), "compressor bug") {
+			t.Fatal("expected a recovered panic, got", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "patch")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("File left a patch behind:", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Fatal("File left a temporary file behind:", len(files), "files")
+	}
+
+	// A panic on a worker goroutine is raised again on the caller's
+	err := func() (err error) {
+		defer util.Recover(&err)
+		forChunks(4096, 4, func(w, lo, hi int) {
+			if w == 2 {
+				panic("worker bug")
+			}
+		})
+		return nil
+	}()
+	var pe *util.PanicError
+	if !errors.As(err, &pe) || pe.Value != "worker bug" {
+		t.Fatal("expected the panic of a worker, got", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	for _, size := range []int{1000, appendChunk, appendChunk + 100} {
+		oldbs := make([]byte, size)
+		rand.Read(oldbs)
+		newbs := append(append([]byte(nil), oldbs...), "appended to the journal"...)
+		var s DiffStats
+		patch, err := Bytes(oldbs, newbs, WithCompressor(Raw), WithStats(&s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (size + appendChunk - 1) / appendChunk; s.Controls != want || s.SortTime != 0 {
+			t.Fatal(size, "bytes:", s.Controls, "controls, sorted in", s.SortTime)
+		}
+		got, err := bspatch.Bytes(oldbs, patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, newbs) {
+			t.Fatal(size, "bytes: wrong new file")
+		}
+	}
+}
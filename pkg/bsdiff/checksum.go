@@ -0,0 +1,43 @@
+package bsdiff
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+)
+
+// WithBlockChecksums records the CRC-32C of each compressed block in an
+// extended (BSDIFF4X) header. bspatch checks them before decompressing, so a
+// damaged patch fails with an error naming the block instead of producing a
+// wrong new file.
+func WithBlockChecksums() Option {
+	return func(o *options) {
+		o.checksums = true
+	}
+}
+
+// extCRC + "." + block name is the CRC-32C of a compressed block
+const extCRC = "crc32c"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newBlockCRCs reserves the checksum records in h and returns the hashes of
+// the ctrl, diff and extra blocks
+func newBlockCRCs(h *extHeader) [3]hash.Hash32 {
+	var crcs [3]hash.Hash32
+	for i := range crcs {
+		crcs[i] = crc32.New(castagnoli)
+		h.setUint32(extCRC+"."+blockNames[i], 0)
+	}
+	return crcs
+}
+
+// setBlockCRCs records the checksums of the blocks before Close rewrites the
+// header
+func (w *Writer) setBlockCRCs() {
+	for i, c := range w.crcs {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, c.Sum32())
+		w.setExt(extCRC+"."+blockNames[i], buf)
+	}
+}
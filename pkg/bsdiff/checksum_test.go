@@ -0,0 +1,47 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestBlockChecksums(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	for _, c := range []bsdiff.Compressor{bsdiff.Bzip2, bsdiff.Raw} {
+		patch := patchtest.RoundTrip(t, w.Old, w.New, []bsdiff.Option{bsdiff.WithBlockChecksums(), bsdiff.WithCompressor(c)})
+		var out bytes.Buffer
+		if err := bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(patch), &out); err != nil || !bytes.Equal(out.Bytes(), w.New) {
+			t.Fatal("stream round trip failed", err)
+		}
+
+		// Flip a byte in the middle of each block
+		ctrl := 40 + int(binary.LittleEndian.Uint64(patch[32:]))
+		diff := ctrl + int(binary.LittleEndian.Uint64(patch[8:]))
+		extra := diff + int(binary.LittleEndian.Uint64(patch[16:]))
+		for i, section := range []string{bspatch.SectionCtrl, bspatch.SectionDiff, bspatch.SectionExtra} {
+			bounds := []int{ctrl, diff, extra, len(patch)}
+			corrupt := append([]byte{}, patch...)
+			corrupt[(bounds[i]+bounds[i+1])/2] ^= 1
+			_, err := bspatch.Bytes(w.Old, corrupt)
+			var pe *bspatch.PatchError
+			if !errors.As(err, &pe) || pe.Kind != bspatch.ErrChecksum || pe.Section != section || !errors.Is(err, bspatch.ErrCorruptPatch) {
+				t.Fatal("expected a checksum error in the", section, "block, got", err)
+			}
+			err = bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(corrupt), io.Discard)
+			if c == bsdiff.Raw && (!errors.As(err, &pe) || pe.Kind != bspatch.ErrChecksum || pe.Section != section) {
+				t.Fatal("expected a checksum error in the", section, "block from ApplyStream, got", err)
+			}
+		}
+	}
+	if _, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithBlockChecksums(), bsdiff.WithFormat(bsdiff.FormatEndsley)); err == nil {
+		t.Fatal("Endsley patches can't carry block checksums")
+	}
+}
@@ -0,0 +1,205 @@
+package bsdiff
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compressor compresses the ctrl, diff and extra blocks of a patch
+type Compressor interface {
+	// Magic returns the 8 byte patch magic identifying the compression, so
+	// bspatch can pick the matching decompressor
+	Magic() string
+	// NewWriter returns a writer compressing to w. Closing it must flush
+	// the compressed stream without closing w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// Bzip2 is the compressor of the classic BSDIFF40 format
+var Bzip2 = NewBzip2(Bzip2Config{})
+
+// Bzip2Config configures a bzip2 compressor
+type Bzip2Config struct {
+	// Level is the compression level, from 1 (fastest) to 9 (best). Zero
+	// means 9.
+	Level int
+	// BlockSize is the size of the blocks, from 1 to 9 in units of 100 kB.
+	// bzip2 derives the block size from the level, so a non zero BlockSize
+	// overrides Level. Smaller blocks diff faster and use less memory, at
+	// the cost of a bigger patch.
+	BlockSize int
+}
+
+// NewBzip2 returns a bzip2 compressor producing BSDIFF40 patches
+func NewBzip2(cfg Bzip2Config) Compressor {
+	level := cfg.Level
+	if cfg.BlockSize != 0 {
+		level = cfg.BlockSize
+	}
+	if level == 0 {
+		level = bzip2.BestCompression
+	}
+	return bzip2Compressor{level}
+}
+
+type bzip2Compressor struct {
+	level int
+}
+
+func (bzip2Compressor) Magic() string {
+	return magicBSDIFF40
+}
+
+func (c bzip2Compressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return bzip2.NewWriter(w, &bzip2.WriterConfig{
+		Level: c.level,
+	})
+}
+
+// TrainZstdDict builds a zstd dictionary of up to size bytes from samples,
+// typically previous versions of the files that will be diffed. Patches of
+// similar small files compress much better with a shared dictionary.
+func TrainZstdDict(samples [][]byte, size int) ([]byte, error) {
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+	})
+}
+
+// NewZstdDict returns a zstd compressor using the dictionary d. The patch
+// records the dictionary ID, and bspatch needs bspatch.NewZstdDict with the
+// same dictionary to apply it.
+func NewZstdDict(d []byte) (Compressor, error) {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %v", err.Error())
+	}
+	return &zstdDictCompressor{d, info.ID()}, nil
+}
+
+type zstdDictCompressor struct {
+	dict []byte
+	id   uint32
+}
+
+func (*zstdDictCompressor) Magic() string {
+	return magicZstd
+}
+
+func (c *zstdDictCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1), zstd.WithEncoderDict(c.dict))
+}
+
+func (c *zstdDictCompressor) setExt(h *extHeader) {
+	h.setUint32(extZstdDict, c.id)
+}
+
+// extCompressor is implemented by compressors that need extension records
+// to be read back
+type extCompressor interface {
+	setExt(h *extHeader)
+}
+
+func isExtCompressor(c Compressor) bool {
+	_, ok := c.(extCompressor)
+	return ok
+}
+
+// Xz compresses the blocks with xz (LZMA2), like the lzma based bsdiff forks
+var Xz Compressor = xzCompressor{}
+
+type xzCompressor struct{}
+
+func (xzCompressor) Magic() string {
+	return magicXz
+}
+
+func (xzCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+// Brotli compresses the blocks with brotli. Each block is a standalone
+// brotli stream, so web clients can decode them with the platform decoder
+// as they arrive.
+var Brotli Compressor = brotliCompressor{brotli.BestCompression}
+
+// NewBrotli returns a brotli compressor at level, from 0 (fastest) to 11
+// (best, the level of Brotli)
+func NewBrotli(level int) Compressor {
+	return brotliCompressor{level}
+}
+
+type brotliCompressor struct {
+	level int
+}
+
+func (brotliCompressor) Magic() string {
+	return magicBrotli
+}
+
+func (c brotliCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return brotli.NewWriterLevel(w, c.level), nil
+}
+
+// Raw stores the blocks uncompressed. Use it when the inputs are already
+// compressed or encrypted and compressing the patch would only waste CPU.
+var Raw Compressor = rawCompressor{}
+
+type rawCompressor struct{}
+
+func (rawCompressor) Magic() string {
+	return magicRaw
+}
+
+func (rawCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// Zstd compresses the blocks with zstd at its best compression level. It's
+// much faster than bzip2 for large inputs, at a similar patch size.
+var Zstd Compressor = zstdCompressor{zstd.SpeedBestCompression}
+
+// NewZstd returns a zstd compressor at level, from 1 (fastest) to 22 (best)
+// as with the zstd command. The encoder has four speeds, which the levels
+// are mapped to.
+func NewZstd(level int) Compressor {
+	return zstdCompressor{zstd.EncoderLevelFromZstd(level)}
+}
+
+type zstdCompressor struct {
+	level zstd.EncoderLevel
+}
+
+func (zstdCompressor) Magic() string {
+	return magicZstd
+}
+
+func (c zstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(c.level), zstd.WithEncoderConcurrency(1))
+}
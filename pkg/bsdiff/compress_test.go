@@ -0,0 +1,148 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Magic() string {
+	return "BSDIFGZ0"
+}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func TestCompressor(t *testing.T) {
+	oldbs := []byte{0xFF, 0xFA, 0xB7, 0xDD}
+	newbs := []byte{0xFF, 0xFA, 0x90, 0xB7, 0xDD, 0xFE}
+	patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithCompressor(gzipCompressor{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFGZ0" {
+		t.Fatal("expected BSDIFGZ0 magic, got", string(patch[:8]))
+	}
+	if _, err = bspatch.Bytes(oldbs, patch); err == nil {
+		t.Fatal("gzip patch applied without a decompressor")
+	}
+	patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithCompressor(gzipCompressor{})}, bspatch.WithDecompressor(gzipCompressor{}))
+}
+
+func TestBuiltinCompressors(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	for _, tc := range []struct {
+		comp  bsdiff.Compressor
+		magic string
+	}{
+		{bsdiff.Bzip2, "BSDIFF40"},
+		{bsdiff.Zstd, "BSDIFZS0"},
+		{bsdiff.Xz, "BSDIFXZ0"},
+		{bsdiff.Raw, "BSDIFRW0"},
+		{bsdiff.Brotli, "BSDIFBR0"},
+		{bsdiff.NewZstd(1), "BSDIFZS0"},
+		{bsdiff.NewBrotli(1), "BSDIFBR0"},
+	} {
+		patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithCompressor(tc.comp)})
+		if string(patch[:8]) != tc.magic {
+			t.Fatal("expected", tc.magic, "magic, got", string(patch[:8]))
+		}
+	}
+}
+
+func TestCompressorFileInfo(t *testing.T) {
+	dir := t.TempDir()
+	oldn := patchtest.WriteFile(t, dir, "old", []byte{0xFF, 0xFA, 0xB7, 0xDD})
+	newn := patchtest.WriteFile(t, dir, "new", []byte{0xFF, 0xFA, 0x90, 0xB7, 0xDD, 0xFE})
+	patchn := filepath.Join(dir, "patch")
+	outn := filepath.Join(dir, "out")
+	if err := bsdiff.File(oldn, newn, patchn, bsdiff.WithFileInfo(), bsdiff.WithCompressor(bsdiff.Zstd)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bspatch.File(oldn, outn, patchn); err != nil {
+		t.Fatal(err)
+	}
+	newbs, err := os.ReadFile(outn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, []byte{0xFF, 0xFA, 0x90, 0xB7, 0xDD, 0xFE}) {
+		t.Fatal(newbs)
+	}
+}
+
+func TestZstdDict(t *testing.T) {
+	sample := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"name": "device-%d", "version": "1.%d.0", "features": ["wifi", "bluetooth", "ota"], "interval": %d, "endpoint": "https://updates.example.com/v1/devices/%d"}`, i, i%7, i*13, i))
+	}
+	var samples [][]byte
+	for i := 0; i < 64; i++ {
+		samples = append(samples, sample(i))
+	}
+	dict, err := bsdiff.TrainZstdDict(samples, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp, err := bsdiff.NewZstdDict(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldbs, newbs := sample(1000), sample(1001)
+	patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithCompressor(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bspatch.Bytes(oldbs, patch); err == nil {
+		t.Fatal("patch applied without its dictionary")
+	}
+	other, err := bsdiff.TrainZstdDict(samples[:32], 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherd, err := bspatch.NewZstdDict(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bspatch.Bytes(oldbs, patch, bspatch.WithDecompressor(otherd))
+	var pe *bspatch.PatchError
+	if !errors.Is(err, bspatch.ErrUnsupportedFormat) || !errors.As(err, &pe) || pe.Section != bspatch.SectionExtension {
+		t.Fatal("patch applied with the wrong dictionary:", err)
+	}
+	d, err := bspatch.NewZstdDict(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithCompressor(comp)}, bspatch.WithDecompressor(d))
+}
+
+func TestBlockCompressors(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithBlockCompressors(bsdiff.Bzip2, bsdiff.Zstd, bsdiff.Raw)})
+	if string(patch[:8]) != "BSDIFF4X" {
+		t.Fatal("expected BSDIFF4X magic, got", string(patch[:8]))
+	}
+	// same magic on every block keeps the plain format
+	patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithBlockCompressors(bsdiff.Bzip2, bsdiff.NewBzip2(bsdiff.Bzip2Config{Level: 1}), bsdiff.Bzip2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected BSDIFF40 magic, got", string(patch[:8]))
+	}
+}
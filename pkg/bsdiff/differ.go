@@ -0,0 +1,112 @@
+package bsdiff
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Differ diffs many file pairs with the same options, reusing its buffers
+// (the suffix array of the old file and its scratch space) from one call to
+// the next to reduce allocations. A Differ is not safe for concurrent use;
+// servers can keep one per worker or in a sync.Pool.
+type Differ struct {
+	opts []Option
+	a    arena
+}
+
+// NewDiffer returns a Differ making patches with opts
+func NewDiffer(opts ...Option) *Differ {
+	return &Differ{opts: opts}
+}
+
+// Bytes takes the old and new byte slices and outputs the diff
+func (d *Differ) Bytes(oldbs, newbs []byte) (_ []byte, err error) {
+	defer util.Recover(&err)
+	var patch util.BufWriter
+	if err := diffb(oldbs, newbs, &patch, newOptions(d.opts), &d.a); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// Diff writes the diff of the old and new byte slices to patch
+func (d *Differ) Diff(oldbs, newbs []byte, patch io.WriteSeeker) (err error) {
+	defer util.Recover(&err)
+	return diffb(oldbs, newbs, patch, newOptions(d.opts), &d.a)
+}
+
+// arena holds the buffers of a diff
+type arena struct {
+	iii, vvv     []int
+	iii32, vvv32 []int32
+	// db holds the diff bytes of a control
+	db []byte
+}
+
+// maxInt32Index is the largest old file whose suffix array has int32
+// elements, which halves its memory
+var maxInt32Index = math.MaxInt32 - 3
+
+// index returns the index of oldbin for the quality of o: its suffix array,
+// sorted on o.concurrency goroutines, or a table of its anchors
+func (a *arena) index(oldbin []byte, o *options) oldIndex {
+	if o.quality == Fast {
+		return oldIndex{anchors: newAnchorTable(oldbin)}
+	}
+	if len(oldbin) <= maxInt32Index {
+		return oldIndex{i32: sortSuffixes(o.ctx, &a.iii32, &a.vvv32, oldbin, o.concurrency)}
+	}
+	return oldIndex{i64: sortSuffixes(o.ctx, &a.iii, &a.vvv, oldbin, o.concurrency)}
+}
+
+// sortSuffixes returns the suffix array of oldbin in *iii, using *vvv as
+// scratch space, growing them as needed. The suffix array isn't sorted if ctx
+// is cancelled.
+func sortSuffixes[I suffix](ctx context.Context, iii, vvv *[]I, oldbin []byte, workers int) []I {
+	n := len(oldbin) + 1
+	if cap(*iii) < n {
+		*iii = make([]I, n)
+	}
+	sa := (*iii)[:n]
+	if workers > 1 && len(oldbin) >= parallelThreshold {
+		parallelSais(ctx, sa, oldbin, workers)
+		return sa
+	}
+	if cap(*vvv) < n {
+		*vvv = make([]I, n)
+	}
+	sais(ctx, sa, (*vvv)[:n], oldbin)
+	return sa
+}
+
+// oldIndex is what new files are matched against in an old file: its
+// anchors if anchors isn't nil, or else its suffix array, with int32
+// elements if i32 isn't nil and int ones otherwise
+type oldIndex struct {
+	anchors *anchorTable
+	i32     []int32
+	i64     []int
+}
+
+// scan is scanSegments with the index. It returns ctx.Err() if ctx is
+// cancelled, including while the index was built.
+func (x oldIndex) scan(ctx context.Context, oldbin, newbin []byte, workers int, deterministic bool, fn func(c Control) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if x.anchors != nil {
+		return x.anchors.scan(ctx, oldbin, newbin, fn)
+	}
+	if x.i32 != nil {
+		return scanSegments(ctx, x.i32, oldbin, newbin, workers, deterministic, fn)
+	}
+	return scanSegments(ctx, x.i64, oldbin, newbin, workers, deterministic, fn)
+}
+
+// match is scan with the context, concurrency and determinism of o
+func (x oldIndex) match(oldbin, newbin []byte, o *options, fn func(c Control) error) error {
+	return x.scan(o.ctx, oldbin, newbin, o.concurrency, o.deterministic || o.quality == Max, fn)
+}
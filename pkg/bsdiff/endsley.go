@@ -0,0 +1,44 @@
+package bsdiff
+
+import "fmt"
+
+// Format is the layout of a patch
+type Format string
+
+const (
+	// FormatBSDIFF40 is the classic layout: a header followed by separately
+	// compressed ctrl, diff and extra blocks. The magic depends on the
+	// compressor, and becomes BSDIFF4X when an extended header is needed.
+	FormatBSDIFF40 Format = magicBSDIFF40
+	// FormatEndsley is the layout of the mendsley/bsdiff fork: a
+	// "ENDSLEY/BSDIFF43" header followed by a single bzip2 stream
+	// interleaving the ctrl, diff and extra data of each control triple
+	FormatEndsley Format = magicEndsley
+)
+
+const magicEndsley = "ENDSLEY/BSDIFF43"
+
+// WithFormat selects the layout of the patch. FormatEndsley patches can only
+// be bzip2 compressed and carry no extended header.
+func WithFormat(f Format) Option {
+	return func(o *options) {
+		o.format = f
+	}
+}
+
+// checkEndsley validates the options of an Endsley patch
+//
+//	0	16	"ENDSLEY/BSDIFF43"
+//	16	8	length of new file
+//	24	??	bzip2 stream of (ctrl triple, diff data, extra data)...
+func checkEndsley(o *options) error {
+	for _, c := range o.compressors {
+		if c.Magic() != magicBSDIFF40 {
+			return fmt.Errorf("%v patches must be bzip2 compressed", magicEndsley)
+		}
+	}
+	if o.ext != nil {
+		return fmt.Errorf("%v patches can't carry an extended header", magicEndsley)
+	}
+	return nil
+}
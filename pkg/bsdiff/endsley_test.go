@@ -0,0 +1,28 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestEndsley(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithFormat(bsdiff.FormatEndsley)})
+	if string(patch[:16]) != "ENDSLEY/BSDIFF43" {
+		t.Fatal("expected ENDSLEY/BSDIFF43 magic, got", string(patch[:16]))
+	}
+	f, err := bspatch.Detect(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != bspatch.FormatEndsley {
+		t.Fatal(f, "!=", bspatch.FormatEndsley)
+	}
+	if _, err = bsdiff.Bytes(oldbs, newbs, bsdiff.WithFormat(bsdiff.FormatEndsley), bsdiff.WithCompressor(bsdiff.Zstd)); err == nil {
+		t.Fatal("endsley patches can only be bzip2 compressed")
+	}
+}
@@ -0,0 +1,38 @@
+package bsdiff
+
+import "github.com/gabstv/go-bsdiff/internal/exe"
+
+// WithExecutable normalizes the code of ELF, PE and Mach-O executables
+// before diffing: the relative targets of calls and jumps are rewritten as
+// absolute addresses, so code that merely moved when the program was
+// recompiled diffs as unchanged. The transform is recorded in an extended
+// (BSDIFF4X) header and bspatch reverses it. Files that aren't x86 or arm64
+// executables are diffed as usual.
+func WithExecutable() Option {
+	return func(o *options) {
+		o.exec = true
+	}
+}
+
+// transformExe returns normalized copies of oldbin and newbin, or them
+// unchanged if they aren't both executables of the same architecture
+func transformExe(oldbin, newbin []byte, o *options) ([]byte, []byte) {
+	oarch, oranges, ok := exe.Parse(oldbin)
+	if !ok {
+		return oldbin, newbin
+	}
+	narch, nranges, ok := exe.Parse(newbin)
+	if !ok || narch != oarch {
+		return oldbin, newbin
+	}
+	oldbin = append([]byte(nil), oldbin...)
+	newbin = append([]byte(nil), newbin...)
+	exe.Encode(oarch, oldbin, oranges)
+	exe.Encode(narch, newbin, nranges)
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	t := exe.Transform{Arch: oarch, Old: oranges, New: nranges}
+	o.ext.set(extExec, t.Marshal())
+	return oldbin, newbin
+}
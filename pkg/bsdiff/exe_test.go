@@ -0,0 +1,98 @@
+package bsdiff_test
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+// testELF returns a minimal x86-64 ELF executable with code as its .text
+// section, loaded at addr
+func testELF(code []byte, addr int) []byte {
+	le := binary.LittleEndian
+	shstrtab := []byte("\x00.text\x00.shstrtab\x00")
+	shoff := 64 + len(code) + len(shstrtab)
+	shoff += -shoff & 7
+	b := make([]byte, shoff+3*64)
+	copy(b, "\x7fELF\x02\x01\x01")
+	le.PutUint16(b[16:], 2)  // ET_EXEC
+	le.PutUint16(b[18:], 62) // EM_X86_64
+	le.PutUint32(b[20:], 1)
+	le.PutUint64(b[24:], uint64(addr))
+	le.PutUint64(b[40:], uint64(shoff))
+	le.PutUint16(b[52:], 64)
+	le.PutUint16(b[54:], 56)
+	le.PutUint16(b[58:], 64)
+	le.PutUint16(b[60:], 3)
+	le.PutUint16(b[62:], 2)
+	copy(b[64:], code)
+	copy(b[64+len(code):], shstrtab)
+	text := b[shoff+64:]
+	le.PutUint32(text[0:], 1)
+	le.PutUint32(text[4:], 1) // SHT_PROGBITS
+	le.PutUint64(text[8:], 6) // SHF_ALLOC|SHF_EXECINSTR
+	le.PutUint64(text[16:], uint64(addr))
+	le.PutUint64(text[24:], 64)
+	le.PutUint64(text[32:], uint64(len(code)))
+	strtab := b[shoff+128:]
+	le.PutUint32(strtab[0:], 7)
+	le.PutUint32(strtab[4:], 3) // SHT_STRTAB
+	le.PutUint64(strtab[24:], uint64(64+len(code)))
+	le.PutUint64(strtab[32:], uint64(len(shstrtab)))
+	return b
+}
+
+// testCode returns x86 code loaded at addr, made of functions that mostly
+// call the first few (the runtime helpers)
+func testCode(rng *rand.Rand, addr int, funcs []int) []byte {
+	var b []byte
+	for _, f := range funcs {
+		for len(b) < f {
+			b = append(b, 0x90)
+		}
+		for i := 0; i < 20; i++ {
+			b = append(b, byte(rng.Intn(0xE0)), 0xE8, 0, 0, 0, 0)
+			target := addr + funcs[rng.Intn(10)]
+			if rng.Intn(5) == 0 {
+				target = addr + funcs[rng.Intn(len(funcs))]
+			}
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(target-(addr+len(b))))
+		}
+	}
+	return b
+}
+
+func TestExecutable(t *testing.T) {
+	const addr = 0x401000
+	funcs := make([]int, 200)
+	for i := range funcs {
+		funcs[i] = i * 120
+	}
+	oldbs := testELF(testCode(rand.New(rand.NewSource(1)), addr, funcs), addr)
+	// recompiled with 40 more bytes in the middle: the calls after it to
+	// the helpers have different displacements
+	for i := 100; i < len(funcs); i++ {
+		funcs[i] += 40
+	}
+	newbs := testELF(testCode(rand.New(rand.NewSource(1)), addr, funcs), addr)
+
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithExecutable()})
+	if len(patch) >= len(plain) {
+		t.Fatal("normalized patch is", len(patch), "bytes, plain patch", len(plain))
+	}
+	// not executables: a regular patch
+	patch, err = bsdiff.Bytes([]byte("old text"), []byte("new text"), bsdiff.WithExecutable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected a BSDIFF40 patch, got", string(patch[:8]))
+	}
+}
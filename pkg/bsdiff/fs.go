@@ -0,0 +1,59 @@
+package bsdiff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// FS diffs the files oldname and newname of fsys, e.g. an embed.FS, a zip
+// file or an fstest.MapFS, and writes the patch to patch. With WithWindow,
+// the new file is read sequentially as by Stream.
+func FS(fsys fs.FS, oldname, newname string, patch io.WriteSeeker, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	if err := o.statFileInfo(newname, func(name string) (fs.FileInfo, error) {
+		return fs.Stat(fsys, name)
+	}); err != nil {
+		return err
+	}
+	if o.window > 0 {
+		return streamFS(fsys, oldname, newname, patch, o)
+	}
+	oldbs, err := fs.ReadFile(fsys, oldname)
+	if err != nil {
+		return fmt.Errorf("could not read oldfile '%v': %w", oldname, err)
+	}
+	newbs, err := fs.ReadFile(fsys, newname)
+	if err != nil {
+		return fmt.Errorf("could not read newfile '%v': %w", newname, err)
+	}
+	return diffb(oldbs, newbs, patch, o, nil)
+}
+
+// streamFS is FS in windowed mode. The old file is read whole unless it
+// implements io.ReaderAt.
+func streamFS(fsys fs.FS, oldname, newname string, patch io.WriteSeeker, o *options) error {
+	oldF, err := fsys.Open(oldname)
+	if err != nil {
+		return fmt.Errorf("could not open oldfile '%v': %w", oldname, err)
+	}
+	defer oldF.Close()
+	oldR, ok := oldF.(io.ReaderAt)
+	if !ok {
+		oldbs, err := io.ReadAll(oldF)
+		if err != nil {
+			return fmt.Errorf("could not read oldfile '%v': %w", oldname, err)
+		}
+		oldR = bytes.NewReader(oldbs)
+	}
+	newF, err := fsys.Open(newname)
+	if err != nil {
+		return fmt.Errorf("could not open newfile '%v': %w", newname, err)
+	}
+	defer newF.Close()
+	return streamb(oldR, newF, patch, o)
+}
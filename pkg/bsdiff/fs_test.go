@@ -0,0 +1,82 @@
+package bsdiff_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+func TestFS(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	mapfs := fstest.MapFS{
+		"v1/app": {Data: w.Old},
+		"v2/app": {Data: w.New, Mode: 0755},
+	}
+	for _, opts := range [][]bsdiff.Option{nil, {bsdiff.WithWindow(1 << 14)}, {bsdiff.WithFileInfo()}} {
+		var patch util.BufWriter
+		if err := bsdiff.FS(mapfs, "v1/app", "v2/app", &patch, opts...); err != nil {
+			t.Fatal(err)
+		}
+		newbs, err := bspatch.Bytes(w.Old, patch.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newbs, w.New) {
+			t.Fatal("round trip failed")
+		}
+		mapfs["app.patch"] = &fstest.MapFile{Data: patch.Bytes()}
+	}
+
+	// Zip entries aren't io.ReaderAts
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for name, b := range map[string][]byte{"old": w.Old, "new": w.New, "patch": mapfs["app.patch"].Data} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zfs, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch util.BufWriter
+	if err = bsdiff.FS(zfs, "old", "new", &patch, bsdiff.WithWindow(1<<14)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		fsys       fs.FS
+		old, patch string
+	}{{mapfs, "v1/app", "app.patch"}, {zfs, "old", "patch"}} {
+		var out bytes.Buffer
+		if err = bspatch.FS(c.fsys, c.old, c.patch, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), w.New) {
+			t.Fatal("FS round trip failed")
+		}
+	}
+	err = bspatch.FS(zfs, "old", "patch", io.Discard, bspatch.WithMemoryLimit(int64(len(w.Old))))
+	var me *bspatch.MemoryLimitError
+	if !errors.As(err, &me) {
+		t.Fatal("expected reading the zip entries to exceed the memory limit, got", err)
+	}
+	if err = bspatch.FS(mapfs, "v0/app", "app.patch", io.Discard); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected a missing file error, got", err)
+	}
+}
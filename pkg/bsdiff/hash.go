@@ -0,0 +1,57 @@
+package bsdiff
+
+import (
+	"crypto/sha256"
+	"io"
+)
+
+// WithHashes records the SHA-256 of the old and new files in an extended
+// (BSDIFF4X) header. bspatch checks the old file before applying the patch,
+// failing with bspatch.ErrWrongOld if it isn't the one the patch was made
+// from, and the new file afterwards. It has no effect on NewWriter.
+func WithHashes() Option {
+	return func(o *options) {
+		o.hashes = true
+	}
+}
+
+// WithDigests records oldSHA256 and newSHA256, the SHA-256 digests of the
+// old and new files, as WithHashes does, for patches written with NewWriter
+// from files bsdiff doesn't see. Both must be 32 bytes.
+func WithDigests(oldSHA256, newSHA256 []byte) Option {
+	return func(o *options) {
+		o.hashes = true
+		o.setHashes(oldSHA256, newSHA256)
+	}
+}
+
+// setHashes records the digests of the old and new files, if WithHashes is
+// set. A nil new digest reserves its record, for Writer.setExt to fill in.
+func (o *options) setHashes(old, new []byte) {
+	if !o.hashes {
+		return
+	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	if new == nil {
+		new = make([]byte, sha256.Size)
+	}
+	o.ext.set(extSHA256Old, old)
+	o.ext.set(extSHA256New, new)
+}
+
+// sum returns the SHA-256 of b
+func sum(b []byte) []byte {
+	d := sha256.Sum256(b)
+	return d[:]
+}
+
+// sumReaderAt returns the SHA-256 of r, read sequentially from its start
+func sumReaderAt(r io.ReaderAt) ([]byte, error) {
+	d := sha256.New()
+	if _, err := io.Copy(d, io.NewSectionReader(r, 0, 1<<62)); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
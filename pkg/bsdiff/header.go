@@ -0,0 +1,134 @@
+package bsdiff
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+const (
+	magicBSDIFF40 = "BSDIFF40"
+	magicZstd     = "BSDIFZS0"
+	magicXz       = "BSDIFXZ0"
+	magicRaw      = "BSDIFRW0"
+	magicBrotli   = "BSDIFBR0"
+	// magicExtended marks a patch with an extension area after the header.
+	magicExtended = "BSDIFF4X"
+)
+
+// Extended header is
+//	0	8	"BSDIFF4X"
+//	8	8	length of ctrl block
+//	16	8	length of diff block
+//	24	8	length of new file
+//	32	8	length of extension area (X)
+//	40	X	extension records
+// Each extension record is a uvarint key length, the key, a uvarint value
+// length and the value. The compressed blocks follow the extension area.
+
+// Extension record keys
+const (
+	extName  = "name"
+	extMode  = "mode"
+	extMtime = "mtime"
+	// extCodec is the magic of the compressor used for the blocks, when
+	// it isn't bzip2. extCodec + "." + block name is the magic of the
+	// compressor of a block, when it isn't the same as the ctrl block's.
+	extCodec = "codec"
+	// extZstdDict is the ID of the zstd dictionary needed to decompress
+	// the blocks
+	extZstdDict = "zdict"
+	// extExec is the executable transform applied to the old and new
+	// files (see internal/exe)
+	extExec = "exec"
+	// extTar is the permutation of the old tar archive (see
+	// internal/tarball)
+	extTar = "tar"
+	// extZip is the zip transform applied to the old and new files (see
+	// internal/zipfile)
+	extZip = "zip"
+	// extMeta prefixes the keys of user metadata
+	extMeta = "meta."
+	// extSHA256Old and extSHA256New are the SHA-256 digests of the old and
+	// new files
+	extSHA256Old = "sha256.old"
+	extSHA256New = "sha256.new"
+)
+
+// blockNames are the names of the ctrl, diff and extra blocks
+var blockNames = [3]string{"ctrl", "diff", "extra"}
+
+type extRecord struct {
+	key   string
+	value []byte
+}
+
+// extHeader holds the extension records of a BSDIFF4X patch
+type extHeader struct {
+	records []extRecord
+}
+
+func (h *extHeader) set(key string, value []byte) {
+	for i := range h.records {
+		if h.records[i].key == key {
+			h.records[i].value = value
+			return
+		}
+	}
+	h.records = append(h.records, extRecord{key, value})
+}
+
+func (h *extHeader) setUint32(key string, v uint32) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	h.set(key, buf)
+}
+
+func (h *extHeader) setUint(key string, v uint64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	h.set(key, buf)
+}
+
+// setFileInfo records the attributes bspatch.File restores, the modification
+// time only if mtime is set
+func (h *extHeader) setFileInfo(fi os.FileInfo, mtime bool) {
+	h.set(extName, []byte(filepath.Base(fi.Name())))
+	h.setUint(extMode, uint64(fi.Mode().Perm()))
+	if mtime {
+		h.setUint(extMtime, uint64(fi.ModTime().UnixNano()))
+	}
+}
+
+func (h *extHeader) marshal() []byte {
+	var out []byte
+	tmp := make([]byte, binary.MaxVarintLen64)
+	for _, r := range h.records {
+		n := binary.PutUvarint(tmp, uint64(len(r.key)))
+		out = append(out, tmp[:n]...)
+		out = append(out, r.key...)
+		n = binary.PutUvarint(tmp, uint64(len(r.value)))
+		out = append(out, tmp[:n]...)
+		out = append(out, r.value...)
+	}
+	return out
+}
+
+// header returns the fixed size patch header, followed by the extension
+// area when there is one. magic identifies the block compression.
+func (h *extHeader) header(magic string) []byte {
+	if h == nil || len(h.records) == 0 {
+		header := make([]byte, 32)
+		copy(header, magic)
+		return header
+	}
+	if magic != magicBSDIFF40 {
+		h.set(extCodec, []byte(magic))
+	}
+	ext := h.marshal()
+	header := make([]byte, 40+len(ext))
+	copy(header, magicExtended)
+	offtout(len(ext), header[32:])
+	copy(header[40:], ext)
+	return header
+}
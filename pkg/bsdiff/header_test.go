@@ -0,0 +1,66 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestFileInfo(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "js" {
+		t.Skip("unix permissions are not supported on " + runtime.GOOS)
+	}
+	dir := t.TempDir()
+	oldn := patchtest.WriteFile(t, dir, "old", []byte{0xFF, 0xFA, 0xB7, 0xDD})
+	newn := patchtest.WriteFile(t, dir, "new", []byte{0xFF, 0xFA, 0x90, 0xB7, 0xDD, 0xFE})
+	patchn := filepath.Join(dir, "patch")
+	outn := filepath.Join(dir, "out")
+	if err := os.Chmod(newn, 0751); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(newn, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	// the legacy format is kept unless asked otherwise
+	if err := bsdiff.File(oldn, newn, patchn); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := os.ReadFile(patchn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected BSDIFF40 magic, got", string(patch[:8]))
+	}
+	if err = bsdiff.File(oldn, newn, patchn, bsdiff.WithFileInfo()); err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.File(oldn, outn, patchn); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(outn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0751 {
+		t.Fatal("mode", fi.Mode().Perm(), "!=", os.FileMode(0751))
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatal("mtime", fi.ModTime(), "!=", mtime)
+	}
+	newbs, err := os.ReadFile(outn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, []byte{0xFF, 0xFA, 0x90, 0xB7, 0xDD, 0xFE}) {
+		t.Fatal(newbs)
+	}
+}
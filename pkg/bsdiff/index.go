@@ -0,0 +1,92 @@
+package bsdiff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Index is the suffix array of an old file, for diffing it against many new
+// files (e.g. every new build of a release against the previous version)
+// while suffix sorting it only once. An Index is safe for concurrent use;
+// concurrent diffs take their WithStats and WithProgress options from Diff
+// or Write, which they must not share.
+type Index struct {
+	old []byte
+	// oldSum is the SHA-256 of old
+	oldSum []byte
+	iii    oldIndex
+	opts   []Option
+}
+
+// NewIndex suffix sorts oldbs for diffing new files against it with opts.
+// oldbs must not be modified while the Index is in use. WithExecutable,
+// WithTar, WithZip, WithSquashfs and WithBlockAlign aren't supported, as the
+// old file is transformed for each new file. WithStats and WithProgress
+// report the sort only.
+func NewIndex(oldbs []byte, opts ...Option) (_ *Index, err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	if o.exec {
+		return nil, fmt.Errorf("executables can't be diffed against an index")
+	}
+	if o.tar || o.zip || o.squashfs || o.blockAlign > 0 {
+		return nil, fmt.Errorf("archives and images can't be diffed against an index")
+	}
+	a := &arena{}
+	return &Index{old: oldbs, oldSum: sum(oldbs), iii: a.sortIndex(oldbs, o), opts: opts}, nil
+}
+
+// Diff takes the new byte slice and outputs the diff from the old one. opts
+// are applied after those of NewIndex, to this diff only.
+func (x *Index) Diff(newbs []byte, opts ...Option) (_ []byte, err error) {
+	defer util.Recover(&err)
+	var patch util.BufWriter
+	if err := x.Write(newbs, &patch, opts...); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// Write writes the diff from the old byte slice to newbs to patch. opts are
+// applied after those of NewIndex, to this diff only.
+func (x *Index) Write(newbs []byte, patch io.WriteSeeker, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := x.newOptions(opts)
+	if o.exec || o.tar || o.zip || o.squashfs || o.blockAlign > 0 {
+		return fmt.Errorf("executables, archives and images can't be diffed against an index")
+	}
+	o.setHashes(x.oldSum, sum(newbs))
+	w, err := newWriter(patch, o)
+	if err != nil {
+		return err
+	}
+	defer w.release()
+	var db []byte
+	if appended(x.old, newbs) {
+		err = writeAppend(w, x.old, newbs, o)
+	} else {
+		err = writeDiff(w, x.iii, x.old, newbs, o, &db)
+	}
+	if err != nil {
+		return err
+	}
+	if o.verify {
+		return o.verifyPatch(bytes.NewReader(x.old), patch, sum(newbs))
+	}
+	return nil
+}
+
+// newOptions returns the options of a diff against x: those of NewIndex,
+// without the statistics and progress of the sort, then opts
+func (x *Index) newOptions(opts []Option) *options {
+	o := newOptions(nil)
+	for _, opt := range x.opts {
+		opt(o)
+	}
+	o.stats, o.progress = nil, nil
+	o.apply(opts)
+	return o
+}
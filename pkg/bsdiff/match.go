@@ -0,0 +1,182 @@
+package bsdiff
+
+import (
+	"context"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Control is a control triple of a bsdiff patch: the Add bytes of the new
+// file at NewPos are the old bytes at OldPos plus the diff block, followed by
+// Copy bytes taken verbatim from the extra block. The old position then moves
+// forward by Seek bytes (which may be negative).
+type Control struct {
+	OldPos int
+	NewPos int
+	Add    int
+	Copy   int
+	Seek   int
+}
+
+// Match runs the bsdiff matcher (suffix sorting of oldbs) and calls fn with
+// each control triple, in order. It's the building block for serializing the
+// differences in formats other than BSDIFF40. Of the options, those of the
+// matcher apply: WithConcurrency, WithDeterministic and WithQuality.
+func Match(oldbs, newbs []byte, fn func(c Control) error, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	a := &arena{}
+	o := newOptions(opts)
+	return a.index(oldbs, o).match(oldbs, newbs, o, fn)
+}
+
+// checkMask is how often, in positions of the new file, the matchers check
+// whether their context is cancelled
+const checkMask = 1<<16 - 1
+
+func scanb[I suffix](ctx context.Context, iii []I, oldbin, newbin []byte, fn func(c Control) error) error {
+	sc := &scanner[I]{ctx: ctx, iii: iii, oldbin: oldbin, newbin: newbin}
+	for {
+		c, ok := sc.next()
+		if !ok {
+			return ctx.Err()
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+}
+
+// scanState is the state of the matcher between two controls, from which
+// the following controls are determined
+type scanState struct {
+	scan, ln, pos                 int
+	lastscan, lastpos, lastoffset int
+}
+
+// scanner is the bsdiff matcher, returning the controls one at a time
+type scanner[I suffix] struct {
+	// ctx stops the matcher when cancelled
+	ctx            context.Context
+	iii            []I
+	oldbin, newbin []byte
+	scanState
+}
+
+// next returns the next control, or false at the end of newbin or when
+// sc.ctx is cancelled
+func (sc *scanner[I]) next() (Control, bool) {
+	iii, oldbin, newbin := sc.iii, sc.oldbin, sc.newbin
+	newsize := len(newbin)
+	oldsize := len(oldbin)
+
+	scan, ln, pos := sc.scan, sc.ln, sc.pos
+	lastscan, lastpos, lastoffset := sc.lastscan, sc.lastpos, sc.lastoffset
+
+	var oldscore, scsc int
+
+	var s, Sf, lenf, Sb, lenb int
+	var overlap, Ss, lens int
+
+	for scan < newsize {
+		oldscore = 0
+
+		// scsc = scan += len
+		scan += ln
+		scsc = scan
+		for scan < newsize {
+			ln, pos = search(iii, oldbin, newbin, scan)
+
+			for scsc < scan+ln {
+				if scsc+lastoffset < oldsize && oldbin[scsc+lastoffset] == newbin[scsc] {
+					oldscore++
+				}
+				scsc++
+			}
+			if ln == oldscore && ln != 0 {
+				break
+			}
+			if ln > oldscore+8 {
+				break
+			}
+			if scan+lastoffset < oldsize && oldbin[scan+lastoffset] == newbin[scan] {
+				oldscore--
+			}
+			//
+			scan++
+			if scan&checkMask == 0 && sc.ctx.Err() != nil {
+				return Control{}, false
+			}
+		}
+
+		if ln != oldscore || scan == newsize {
+			s = 0
+			Sf = 0
+			lenf = 0
+			i := 0
+			for lastscan+i < scan && lastpos+i < oldsize {
+				if oldbin[lastpos+i] == newbin[lastscan+i] {
+					s++
+				}
+				i++
+				if s*2-i > Sf*2-lenf {
+					Sf = s
+					lenf = i
+				}
+			}
+
+			lenb = 0
+			if scan < newsize {
+				s = 0
+				Sb = 0
+				for i = 1; scan >= lastscan+i && pos >= i; i++ {
+					if oldbin[pos-i] == newbin[scan-i] {
+						s++
+					}
+					if s*2-i > Sb*2-lenb {
+						Sb = s
+						lenb = i
+					}
+				}
+			}
+
+			if lastscan+lenf > scan-lenb {
+				overlap = (lastscan + lenf) - (scan - lenb)
+				s = 0
+				Ss = 0
+				lens = 0
+				for i = 0; i < overlap; i++ {
+					if newbin[lastscan+lenf-overlap+i] == oldbin[lastpos+lenf-overlap+i] {
+						s++
+					}
+
+					if newbin[scan-lenb+i] == oldbin[pos-lenb+i] {
+						s--
+					}
+					if s > Ss {
+						Ss = s
+						lens = i + 1
+					}
+				}
+
+				lenf += lens - overlap
+				lenb -= lens
+			}
+
+			c := Control{
+				OldPos: lastpos,
+				NewPos: lastscan,
+				Add:    lenf,
+				Copy:   (scan - lenb) - (lastscan + lenf),
+				Seek:   (pos - lenb) - (lastpos + lenf),
+			}
+
+			lastscan = scan - lenb
+			lastpos = pos - lenb
+			lastoffset = pos - scan
+			sc.scanState = scanState{scan, ln, pos, lastscan, lastpos, lastoffset}
+			return c, true
+		}
+	}
+	sc.scanState = scanState{scan, ln, pos, lastscan, lastpos, lastoffset}
+	return Control{}, false
+}
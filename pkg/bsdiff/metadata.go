@@ -0,0 +1,54 @@
+package bsdiff
+
+import (
+	"sort"
+	"strings"
+)
+
+// Well-known metadata keys. Any other key can be used as well.
+const (
+	MetaSourceVersion = "source.version"
+	MetaTargetVersion = "target.version"
+	// MetaCreated is a timestamp, preferably in RFC 3339 format
+	MetaCreated = "created"
+	MetaTool    = "tool"
+)
+
+// WithMetadata records a key/value pair in an extended (BSDIFF4X) header,
+// e.g. the versions the patch upgrades from and to. bspatch ignores it when
+// applying the patch; bspatch.Metadata reads it back.
+func WithMetadata(key, value string) Option {
+	return func(o *options) {
+		if o.ext == nil {
+			o.ext = &extHeader{}
+		}
+		o.ext.set(extMeta+key, []byte(value))
+	}
+}
+
+// WithRecords copies the extension records of another patch, as
+// bspatch.ReadHeader returns them, e.g. to write it again: its digests, file
+// info and metadata. The records describing the blocks, their codecs,
+// dictionary and checksums, are left to the options of this patch.
+func WithRecords(records map[string][]byte) Option {
+	return func(o *options) {
+		keys := make([]string, 0, len(records))
+		for k := range records {
+			if k == extCodec || strings.HasPrefix(k, extCodec+".") || k == extZstdDict || strings.HasPrefix(k, extCRC+".") {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			return
+		}
+		// Records are written in order
+		sort.Strings(keys)
+		if o.ext == nil {
+			o.ext = &extHeader{}
+		}
+		for _, k := range keys {
+			o.ext.set(k, records[k])
+		}
+	}
+}
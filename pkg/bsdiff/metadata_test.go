@@ -0,0 +1,45 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestMetadata(t *testing.T) {
+	oldbs := []byte("the old version of the file")
+	newbs := []byte("the new version of the file, a bit longer")
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{
+		bsdiff.WithMetadata(bsdiff.MetaSourceVersion, "1.0.0"),
+		bsdiff.WithMetadata(bsdiff.MetaTargetVersion, "1.1.0"),
+		bsdiff.WithMetadata("channel", "beta"),
+		bsdiff.WithCompressor(bsdiff.Zstd),
+	})
+	meta, err := bspatch.Metadata(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		bspatch.MetaSourceVersion: "1.0.0",
+		bspatch.MetaTargetVersion: "1.1.0",
+		"channel":                 "beta",
+	}
+	if fmt.Sprint(meta) != fmt.Sprint(want) {
+		t.Fatal(meta, "!=", want)
+	}
+
+	if patch, err = bsdiff.Bytes(oldbs, newbs); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err = bspatch.Metadata(bytes.NewReader(patch)); err != nil || len(meta) != 0 {
+		t.Fatal("expected no metadata, got", meta, err)
+	}
+	_, err = bsdiff.Bytes(oldbs, newbs, bsdiff.WithMetadata("k", "v"), bsdiff.WithFormat(bsdiff.FormatEndsley))
+	if err == nil {
+		t.Fatal("endsley patches can't carry metadata")
+	}
+}
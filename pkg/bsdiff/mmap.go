@@ -0,0 +1,33 @@
+package bsdiff
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/internal/mmap"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// FileMmap is File with the old and new files mapped into memory instead of
+// read into Go slices, which roughly halves the resident memory of large
+// diffs. The files must not be modified while diffing.
+func FileMmap(oldfile, newfile, patchfile string, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	if err := o.statFileInfo(newfile, os.Stat); err != nil {
+		return err
+	}
+	oldM, err := mmap.Open(oldfile)
+	if err != nil {
+		return fmt.Errorf("could not map oldfile '%v': %w", oldfile, err)
+	}
+	defer oldM.Close()
+	newM, err := mmap.Open(newfile)
+	if err != nil {
+		return fmt.Errorf("could not map newfile '%v': %w", newfile, err)
+	}
+	defer newM.Close()
+	return writePatchFile(patchfile, func(pf *os.File) error {
+		return diffb(oldM.Data, newM.Data, pf, o, nil)
+	})
+}
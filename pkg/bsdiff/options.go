@@ -0,0 +1,129 @@
+package bsdiff
+
+import (
+	"context"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Option configures how a patch is generated
+type Option func(*options)
+
+type options struct {
+	// compressors of the ctrl, diff and extra blocks
+	compressors [3]Compressor
+	fileInfo    bool
+	format      Format
+	// exec normalizes executables before diffing
+	exec bool
+	// tar aligns tar archives before diffing
+	tar bool
+	// zip inflates the entries of zip archives before diffing
+	zip bool
+	// squashfs inflates the blocks of squashfs images before diffing
+	squashfs bool
+	// blockAlign is the block size images are aligned on, see
+	// WithBlockAlign
+	blockAlign int
+	// window is the window size of a windowed diff, see WithWindow
+	window int
+	// bufSize is the size of the patch write buffer
+	bufSize int
+	// stripEmpty leaves out the streams of empty blocks, see
+	// WithStripEmptyBlocks
+	stripEmpty bool
+	// concurrency is the number of goroutines sorting suffixes and matching
+	// segments of the new file
+	concurrency int
+	// deterministic makes concurrent patches identical to serial ones
+	deterministic bool
+	// reproducible pins everything patches depend on, see WithReproducible
+	reproducible bool
+	quality      Quality
+	// hashes records the digests of the old and new files, see WithHashes
+	hashes bool
+	// checksums records the CRC-32C of the blocks, see WithBlockChecksums
+	checksums bool
+	// verify applies patches to check them, with verifyOpts, see WithVerify
+	verify     bool
+	verifyOpts []bspatch.Option
+	// ext is the extended header derived from the options, if any
+	ext *extHeader
+	// ctx cancels the diff, see BytesCtx
+	ctx      context.Context
+	progress func(stage string, done, total int64)
+	stats    *DiffStats
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		compressors: [3]Compressor{Bzip2, Bzip2, Bzip2},
+		format:      FormatBSDIFF40,
+		bufSize:     DefaultBufferSize,
+		ctx:         context.Background(),
+	}
+	o.apply(opts)
+	return o
+}
+
+// apply applies opts to o, resetting the statistics they're to fill
+func (o *options) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.stats != nil {
+		*o.stats = DiffStats{}
+	}
+}
+
+// DefaultBufferSize is the size of the buffer patches are written through,
+// unless WithBufferSize sets another
+const DefaultBufferSize = 16 * 1024
+
+// WithBufferSize sets the size of the buffer the compressed blocks are
+// written to the patch through. Larger buffers mean fewer writes, which
+// helps on network file systems; smaller ones save memory.
+func WithBufferSize(n int) Option {
+	return func(o *options) {
+		o.bufSize = n
+	}
+}
+
+// WithStripEmptyBlocks leaves out the compressed stream of a diff or extra
+// block without bytes, saving the bytes of an empty stream. bspatch, like
+// the reference implementation, doesn't decompress a block it takes no bytes
+// from, but other readers may.
+func WithStripEmptyBlocks() Option {
+	return func(o *options) {
+		o.stripEmpty = true
+	}
+}
+
+// WithFileInfo records the new file's name, permission bits and modification
+// time in an extended (BSDIFF4X) header. It only has an effect on File,
+// FileMmap and FS, and bspatch.File restores the recorded attributes after
+// writing the new file.
+func WithFileInfo() Option {
+	return func(o *options) {
+		o.fileInfo = true
+	}
+}
+
+// WithCompressor replaces the bzip2 compression of the patch blocks. The
+// patch is tagged with the compressor's magic; bspatch needs a decompressor
+// for the same magic to apply it.
+func WithCompressor(c Compressor) Option {
+	return func(o *options) {
+		o.compressors = [3]Compressor{c, c, c}
+	}
+}
+
+// WithBlockCompressors compresses the ctrl, diff and extra blocks with
+// different compressors, e.g. bzip2 for the ctrl block and Raw for an extra
+// block of already compressed data. When their magics differ, the choices
+// are recorded in an extended (BSDIFF4X) header.
+func WithBlockCompressors(ctrl, diff, extra Compressor) Option {
+	return func(o *options) {
+		o.compressors = [3]Compressor{ctrl, diff, extra}
+	}
+}
@@ -0,0 +1,72 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+func TestBufferSize(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 64)
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, 7, 1 << 20} {
+		patch2 := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithBufferSize(n)}, bspatch.WithBufferSize(n))
+		if !bytes.Equal(patch, patch2) {
+			t.Fatal("patch depends on the buffer size", n)
+		}
+	}
+	if _, err = bsdiff.Bytes(oldbs, newbs, bsdiff.WithBufferSize(0)); err == nil {
+		t.Fatal("expected an error for an empty buffer")
+	}
+	if _, err = bspatch.Bytes(oldbs, patch, bspatch.WithBufferSize(0)); err == nil {
+		t.Fatal("expected an error for an empty buffer")
+	}
+}
+
+func TestContext(t *testing.T) {
+	w := testdata.SmallEdits(1 << 20)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, opts := range [][]bsdiff.Option{nil, {bsdiff.WithConcurrency(4)}, {bsdiff.WithQuality(bsdiff.Fast)}} {
+		if _, err := bsdiff.BytesCtx(cancelled, w.Old, w.New, opts...); !errors.Is(err, context.Canceled) {
+			t.Fatal("expected the diff to be cancelled, got", err)
+		}
+	}
+	var patch util.BufWriter
+	if err := bsdiff.StreamCtx(cancelled, bytes.NewReader(w.Old), bytes.NewReader(w.New), &patch); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected the stream diff to be cancelled, got", err)
+	}
+	// A deadline passing during the suffix sort or the matching
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	big := testdata.Unrelated(8 << 20)
+	if _, err := bsdiff.BytesCtx(ctx, big.Old, big.New); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the diff to time out, got", err)
+	}
+
+	p, err := bsdiff.BytesCtx(context.Background(), w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out util.BufWriter
+	if err = bspatch.ReaderCtx(cancelled, bytes.NewReader(w.Old), &out, bytes.NewReader(p)); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected the patching to be cancelled, got", err)
+	}
+	if err = bspatch.ReaderCtx(context.Background(), bytes.NewReader(w.Old), &out, bytes.NewReader(p)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), w.New) {
+		t.Fatal("round trip failed")
+	}
+}
@@ -0,0 +1,356 @@
+package bsdiff
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// WithConcurrency sorts the suffixes of the old file on n goroutines,
+// matches segments of the new file of 256 KiB or more against it on n
+// goroutines, and compresses the ctrl, diff and extra blocks concurrently,
+// which speeds up diffing large files on multi-core machines.
+// Matching segments on their own loses a few matches at their boundaries, so
+// patches depend on n unless WithDeterministic is given. The parallel sort
+// (the DC3 algorithm of Kärkkäinen and Sanders, with parallel radix sorts and
+// merge) does about twice the work of the serial one, and more on highly
+// repetitive data, which the serial sort handles fastest; it pays off from
+// about 4 cores. It needs about 20 more bytes of memory per old byte (twice
+// that for old files of 2 GiB or more), and is only used for old files of
+// 64 KiB or more.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// parallelThreshold is the input size below which suffixes are sorted with
+// the serial SA-IS
+var parallelThreshold = 64 * 1024
+
+// radixBits is the size of the digits of the parallel radix sort
+const radixBits = 16
+
+// parallelSais is sais on workers goroutines
+func parallelSais[I suffix](ctx context.Context, iii []I, buf []byte, workers int) {
+	n := len(buf)
+	iii[0] = I(n)
+	// dc3 needs the symbols to be positive and the input padded with zeros
+	s := make([]I, n+3)
+	parallelFor(n, workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			s[i] = I(buf[i]) + 1
+		}
+	})
+	dc3(ctx, s, iii[1:], n, 256, workers)
+}
+
+// dc3 writes the suffix array of s[:n], whose symbols are in [1, k], to sa.
+// s[n:n+3] must be zero. It stops early if ctx is cancelled.
+func dc3[I suffix](ctx context.Context, s, sa []I, n, k, workers int) {
+	if n < parallelThreshold {
+		saisRec(ctx, s[:n], k, sa[:n], make([]I, n+1))
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	n0, n1, n2 := (n+2)/3, (n+1)/3, n/3
+	n02 := n0 + n2
+
+	// Sort the suffixes at positions i%3 != 0 by their first three symbols.
+	// When n%3 == 1 a dummy suffix at n is included, which sorts first.
+	s12 := make([]I, n02+3)
+	sa12 := make([]I, n02+3)
+	parallelFor(n02, workers, func(lo, hi int) {
+		for j := lo; j < hi; j++ {
+			s12[j] = I(3*(j/2) + 1 + j%2)
+		}
+	})
+	radixPass(s12[:n02], sa12[:n02], s, 2, k, workers)
+	radixPass(sa12[:n02], s12[:n02], s, 1, k, workers)
+	radixPass(s12[:n02], sa12[:n02], s, 0, k, workers)
+
+	// Name the triples, the names of positions i%3 == 1 before those of
+	// i%3 == 2, and sort the suffixes recursively if names repeat
+	names := make([]I, n02)
+	parallelFor(n02, workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			a := sa12[i]
+			if i == 0 {
+				names[i] = 1
+				continue
+			}
+			b := sa12[i-1]
+			if s[a] != s[b] || s[a+1] != s[b+1] || s[a+2] != s[b+2] {
+				names[i] = 1
+			}
+		}
+	})
+	prefixSum(names, workers)
+	parallelFor(n02, workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			if a := int(sa12[i]); a%3 == 1 {
+				s12[a/3] = names[i]
+			} else {
+				s12[a/3+n0] = names[i]
+			}
+		}
+	})
+	name := int(names[n02-1])
+	names = nil
+	if name < n02 {
+		dc3(ctx, s12, sa12, n02, name, workers)
+		if ctx.Err() != nil {
+			return
+		}
+		parallelFor(n02, workers, func(lo, hi int) {
+			for i := lo; i < hi; i++ {
+				s12[sa12[i]] = I(i + 1)
+			}
+		})
+	} else {
+		parallelFor(n02, workers, func(lo, hi int) {
+			for i := lo; i < hi; i++ {
+				sa12[s12[i]-1] = I(i)
+			}
+		})
+	}
+
+	// Sort the suffixes at positions i%3 == 0 by their first symbol and the
+	// rank of the suffix after it
+	s0 := make([]I, n0)
+	sa0 := make([]I, n0)
+	parallelFilter(sa12[:n02], s0, workers, func(x I) (I, bool) { return 3 * x, int(x) < n0 })
+	radixPass(s0, sa0, s, 0, k, workers)
+
+	// Merge both, skipping the dummy suffix
+	a := sa12[n0-n1 : n02]
+	pos := func(x I) I {
+		if int(x) < n0 {
+			return 3*x + 1
+		}
+		return 3*(x-I(n0)) + 2
+	}
+	less := func(x, y I) bool {
+		i, j, k := int(pos(x)), int(y), int(x)
+		if k < n0 {
+			return leq2(s[i], s12[k+n0], s[j], s12[j/3])
+		}
+		return leq3(s[i], s[i+1], s12[k-n0+1], s[j], s[j+1], s12[j/3+n0])
+	}
+	parallelMerge(a, sa0, sa[:n], workers, less, pos)
+}
+
+// leq2 reports whether the pair (a1, a2) is at most (b1, b2)
+func leq2[I suffix](a1, a2, b1, b2 I) bool {
+	return a1 < b1 || a1 == b1 && a2 <= b2
+}
+
+// leq3 reports whether the triple (a1, a2, a3) is at most (b1, b2, b3)
+func leq3[I suffix](a1, a2, a3, b1, b2, b3 I) bool {
+	return a1 < b1 || a1 == b1 && leq2(a2, a3, b2, b3)
+}
+
+// chunks returns the number of ranges to split n elements in for workers
+// goroutines, so that ranges aren't too small to be worth a goroutine
+func chunks(n, workers int) int {
+	if workers > n/1024 {
+		workers = n/1024 + 1
+	}
+	if workers < 1 {
+		return 1
+	}
+	return workers
+}
+
+// forChunks calls fn concurrently with c ranges covering [0, n) and their
+// index w
+func forChunks(n, c int, fn func(w, lo, hi int)) {
+	if c <= 1 {
+		fn(0, 0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	// A panic on a goroutine is raised again on the caller's, where the
+	// exported functions recover it
+	var mu sync.Mutex
+	var pe *util.PanicError
+	for w := 0; w < c; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			defer func() {
+				if v := recover(); v != nil {
+					mu.Lock()
+					pe = util.NewPanicError(v)
+					mu.Unlock()
+				}
+			}()
+			fn(w, n*w/c, n*(w+1)/c)
+		}(w)
+	}
+	wg.Wait()
+	if pe != nil {
+		panic(pe)
+	}
+}
+
+// parallelFor calls fn with ranges covering [0, n) on up to workers
+// goroutines
+func parallelFor(n, workers int, fn func(lo, hi int)) {
+	forChunks(n, chunks(n, workers), func(_, lo, hi int) { fn(lo, hi) })
+}
+
+// prefixSum replaces a with its inclusive prefix sums
+func prefixSum[I suffix](a []I, workers int) {
+	c := chunks(len(a), workers)
+	sums := make([]I, c)
+	forChunks(len(a), c, func(w, lo, hi int) {
+		var sum I
+		for _, v := range a[lo:hi] {
+			sum += v
+		}
+		sums[w] = sum
+	})
+	// offsets[w] is the sum of the ranges before w
+	offsets := make([]I, c)
+	for w := 1; w < c; w++ {
+		offsets[w] = offsets[w-1] + sums[w-1]
+	}
+	forChunks(len(a), c, func(w, lo, hi int) {
+		sum := offsets[w]
+		for i := lo; i < hi; i++ {
+			sum += a[i]
+			a[i] = sum
+		}
+	})
+}
+
+// parallelFilter writes fn(x) of the elements x of src for which it returns
+// true to dst, in order
+func parallelFilter[I suffix](src, dst []I, workers int, fn func(x I) (I, bool)) {
+	c := chunks(len(src), workers)
+	counts := make([]int, c)
+	forChunks(len(src), c, func(w, lo, hi int) {
+		k := 0
+		for _, x := range src[lo:hi] {
+			if _, ok := fn(x); ok {
+				k++
+			}
+		}
+		counts[w] = k
+	})
+	offsets := make([]int, c)
+	for w := 1; w < c; w++ {
+		offsets[w] = offsets[w-1] + counts[w-1]
+	}
+	forChunks(len(src), c, func(w, lo, hi int) {
+		j := offsets[w]
+		for _, x := range src[lo:hi] {
+			if v, ok := fn(x); ok {
+				dst[j] = v
+				j++
+			}
+		}
+	})
+}
+
+// radixPass stably sorts src into dst by s[x+off] for each element x, where
+// s[x+off] is at most k, with a parallel LSD radix sort
+func radixPass[I suffix](src, dst, s []I, off, k, workers int) {
+	passes := 1
+	for k>>(passes*radixBits) > 0 {
+		passes++
+	}
+	var tmp []I
+	if passes > 1 {
+		tmp = make([]I, len(src))
+	}
+	in := src
+	for p := 0; p < passes; p++ {
+		// The passes alternate between dst and tmp, ending in dst
+		out := dst
+		if (passes-1-p)%2 == 1 {
+			out = tmp
+		}
+		buckets := k>>(p*radixBits) + 1
+		if buckets > 1<<radixBits {
+			buckets = 1 << radixBits
+		}
+		countingPass(in, out, s, off, p*radixBits, buckets, workers)
+		in = out
+	}
+}
+
+// countingPass stably sorts src into dst by the digit of s[x+off] at shift,
+// counting the digits of each range of src on its own goroutine
+func countingPass[I suffix](src, dst, s []I, off, shift, buckets, workers int) {
+	c := chunks(len(src), workers)
+	mask := 1<<radixBits - 1
+	counts := make([][]int, c)
+	forChunks(len(src), c, func(w, lo, hi int) {
+		cnt := make([]int, buckets)
+		for _, x := range src[lo:hi] {
+			cnt[int(s[int(x)+off])>>shift&mask]++
+		}
+		counts[w] = cnt
+	})
+	// Turn the counts into the positions each range writes its digits at
+	sum := 0
+	for b := 0; b < buckets; b++ {
+		for w := 0; w < c; w++ {
+			v := counts[w][b]
+			counts[w][b] = sum
+			sum += v
+		}
+	}
+	forChunks(len(src), c, func(w, lo, hi int) {
+		next := counts[w]
+		for _, x := range src[lo:hi] {
+			d := int(s[int(x)+off]) >> shift & mask
+			dst[next[d]] = x
+			next[d]++
+		}
+	})
+}
+
+// parallelMerge merges the sorted a and b into out, mapping the elements of
+// a with pos. less(x, y) reports whether x of a sorts before y of b. The
+// output is split in ranges merged concurrently, with the split of each
+// range between a and b found by binary search.
+func parallelMerge[I suffix](a, b, out []I, workers int, less func(x, y I) bool, pos func(x I) I) {
+	// split returns how many elements of a are among the first k of out
+	split := func(k int) int {
+		lo, hi := 0, k
+		if k > len(b) {
+			lo = k - len(b)
+		}
+		if hi > len(a) {
+			hi = len(a)
+		}
+		for lo < hi {
+			i := int(uint(lo+hi) >> 1)
+			if less(a[i], b[k-i-1]) {
+				lo = i + 1
+			} else {
+				hi = i
+			}
+		}
+		return lo
+	}
+	parallelFor(len(out), workers, func(lo, hi int) {
+		i, ie := split(lo), split(hi)
+		j := lo - i
+		for k := lo; k < hi; k++ {
+			if i < ie && (j == hi-ie || less(a[i], b[j])) {
+				out[k] = pos(a[i])
+				i++
+			} else {
+				out[k] = b[j]
+				j++
+			}
+		}
+	})
+}
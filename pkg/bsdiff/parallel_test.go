@@ -0,0 +1,29 @@
+package bsdiff_test
+
+import (
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+func TestConcurrentSize(t *testing.T) {
+	oldbs := testdata.Random(1, 1024*1024)
+	// Moves and edits spanning the segment boundaries
+	newbs := append([]byte{}, oldbs[300*1024:]...)
+	newbs = append(newbs, oldbs[:300*1024]...)
+	for i := 0; i < len(newbs); i += 100 * 1024 {
+		copy(newbs[i:i+500], testdata.Random(int64(i), 500))
+	}
+	serial, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{2, 4} {
+		patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithConcurrency(n)})
+		if len(patch) > len(serial)+len(serial)/10 {
+			t.Fatalf("concurrent patch is %v bytes, serial %v", len(patch), len(serial))
+		}
+	}
+}
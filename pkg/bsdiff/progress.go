@@ -0,0 +1,44 @@
+package bsdiff
+
+import (
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Stages reported to WithProgress
+const (
+	// StageSort is the suffix sorting (or anchor hashing, with Fast) of the
+	// old file, in bytes. It isn't reported by Stream, nor when the new file
+	// only appends to the old one.
+	StageSort = "sort"
+	// StageScan is the matching of the new file, in bytes. The total is -1
+	// for Stream, which doesn't know the size of the new file.
+	StageScan = "scan"
+	// StageCompress is the completion of the compressed blocks, which are
+	// compressed while the new file is matched, in blocks
+	StageCompress = "compress"
+)
+
+// WithProgress calls fn as each stage of the diff advances, about every
+// percent of it: done of total units are complete. fn is called with done 0
+// when a stage starts and done == total when it ends, on the goroutine the
+// diff runs on.
+func WithProgress(fn func(stage string, done, total int64)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// sortIndex is index, reporting StageSort and its statistics
+func (a *arena) sortIndex(oldbin []byte, o *options) oldIndex {
+	start := time.Now()
+	m := util.NewMeter(o.progress, StageSort, int64(len(oldbin)))
+	x := a.index(oldbin, o)
+	m.Done()
+	if s := o.stats; s != nil {
+		s.SortTime += time.Since(start)
+		s.IndexMemory = a.memory(x)
+	}
+	return x
+}
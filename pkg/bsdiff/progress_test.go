@@ -0,0 +1,81 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestProgress(t *testing.T) {
+	w := testdata.SmallEdits(1 << 20)
+	type report struct {
+		stage       string
+		done, total int64
+	}
+	var reports []report
+	fn := func(stage string, done, total int64) {
+		reports = append(reports, report{stage, done, total})
+	}
+	// check returns the reports of each stage, checking they advance from 0
+	// to their total
+	check := func() map[string][]report {
+		stages := map[string][]report{}
+		for _, r := range reports {
+			rs := stages[r.stage]
+			if len(rs) == 0 && r.done != 0 || len(rs) > 0 && r.done <= rs[len(rs)-1].done {
+				t.Fatal("progress of", r.stage, "doesn't advance from 0:", r)
+			}
+			stages[r.stage] = append(rs, r)
+		}
+		for stage, rs := range stages {
+			if last := rs[len(rs)-1]; last.done != last.total {
+				t.Fatal("stage", stage, "didn't end:", last)
+			}
+		}
+		reports = nil
+		return stages
+	}
+
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithProgress(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := check()
+	if len(stages) != 3 || len(stages[bsdiff.StageScan]) < 50 || stages[bsdiff.StageCompress][0].total != 3 {
+		t.Fatal("unexpected diff progress", stages)
+	}
+	var sp util.BufWriter
+	if err = bsdiff.Stream(bytes.NewReader(w.Old), bytes.NewReader(w.New), &sp, bsdiff.WithProgress(fn)); err != nil {
+		t.Fatal(err)
+	}
+	if stages = check(); stages[bsdiff.StageScan][0].total != -1 || stages[bsdiff.StageSort] != nil {
+		t.Fatal("unexpected stream progress", stages)
+	}
+
+	newbs, err := bspatch.Bytes(w.Old, patch, bspatch.WithProgress(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, w.New) {
+		t.Fatal("round trip failed")
+	}
+	stages = check()
+	if len(stages) != 1 || len(stages[bspatch.StageApply]) < 50 || stages[bspatch.StageApply][0].total != int64(len(w.New)) {
+		t.Fatal("unexpected patch progress", stages)
+	}
+	delta, err := vcdiff.Diff(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bspatch.Bytes(w.Old, delta, bspatch.WithProgress(fn)); err != nil {
+		t.Fatal(err)
+	}
+	if stages = check(); stages[bspatch.StageApply][0].total != -1 {
+		t.Fatal("unexpected VCDIFF progress", stages)
+	}
+}
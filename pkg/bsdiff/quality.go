@@ -0,0 +1,151 @@
+package bsdiff
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Quality trades the time and memory a diff takes for the size of the patch
+type Quality int
+
+const (
+	// Balanced suffix sorts the old file, the classic bsdiff matcher. It's
+	// the default.
+	Balanced Quality = iota
+	// Fast looks matches up in a hash table of anchors sampled from the old
+	// file instead of a suffix array. Diffing takes an order of magnitude
+	// less time and memory (about 1 byte per old byte), but misses matches
+	// shorter than about 48 bytes, so patches are larger.
+	Fast
+	// Max never trades patch size for speed: with WithConcurrency, the
+	// segments of the new file are matched deterministically, as with
+	// WithDeterministic.
+	Max
+)
+
+// WithQuality sets the trade-off between diff speed and patch size
+func WithQuality(q Quality) Option {
+	return func(o *options) {
+		o.quality = q
+	}
+}
+
+const (
+	// anchorLen is the length of the anchors, and anchorStep the distance
+	// between two anchors of the old file
+	anchorLen  = 32
+	anchorStep = 16
+	// anchorMul is the multiplier of the rolling hash
+	anchorMul = 0x01000193
+)
+
+// anchorTable maps the hashes of anchors of the old file to their position
+// plus one
+type anchorTable struct {
+	slots []int
+	shift uint
+	// mulOut is anchorMul to the power of anchorLen-1, to roll bytes out
+	mulOut uint32
+}
+
+func newAnchorTable(oldbin []byte) *anchorTable {
+	bits := uint(1)
+	for 1<<bits < 2*len(oldbin)/anchorStep {
+		bits++
+	}
+	t := &anchorTable{slots: make([]int, 1<<bits), shift: 32 - bits, mulOut: 1}
+	for i := 0; i < anchorLen-1; i++ {
+		t.mulOut *= anchorMul
+	}
+	for p := 0; p+anchorLen <= len(oldbin); p += anchorStep {
+		t.slots[t.slot(anchorHash(oldbin[p:p+anchorLen]))] = p + 1
+	}
+	return t
+}
+
+// anchorHash is the rolling hash of b
+func anchorHash(b []byte) uint32 {
+	var h uint32
+	for _, c := range b {
+		h = h*anchorMul + uint32(c)
+	}
+	return h
+}
+
+func (t *anchorTable) slot(h uint32) int {
+	return int((h * 2654435761) >> t.shift)
+}
+
+// scan calls fn with the controls from oldbin to newbin, like scanb. Every
+// position of newbin is looked up in the table; an anchor found at another
+// offset than the current one starts a new control, whose diff bytes extend
+// forward from the previous one for as long as more than half of the bytes
+// match.
+func (t *anchorTable) scan(ctx context.Context, oldbin, newbin []byte, fn func(c Control) error) error {
+	oldsize, newsize := len(oldbin), len(newbin)
+	var lastscan, lastpos int
+	// emit writes the control up to the match at scan and pos
+	emit := func(scan, pos int) error {
+		var s, Sf, lenf int
+		for i := 0; lastscan+i < scan && lastpos+i < oldsize; {
+			if oldbin[lastpos+i] == newbin[lastscan+i] {
+				s++
+			}
+			i++
+			if s*2-i > Sf*2-lenf {
+				Sf = s
+				lenf = i
+			}
+		}
+		return fn(Control{
+			OldPos: lastpos,
+			NewPos: lastscan,
+			Add:    lenf,
+			Copy:   scan - (lastscan + lenf),
+			Seek:   pos - (lastpos + lenf),
+		})
+	}
+
+	scan := 0
+	var h uint32
+	if newsize >= anchorLen {
+		h = anchorHash(newbin[:anchorLen])
+	}
+	for scan+anchorLen <= newsize {
+		p := t.slots[t.slot(h)] - 1
+		if p >= 0 && p-scan != lastpos-lastscan && bytes.Equal(oldbin[p:p+anchorLen], newbin[scan:scan+anchorLen]) {
+			// Extend the match backwards, then skip over it
+			start := scan
+			for scan > lastscan && p > 0 && oldbin[p-1] == newbin[scan-1] {
+				scan--
+				p--
+			}
+			if err := emit(scan, p); err != nil {
+				return err
+			}
+			lastscan, lastpos = scan, p
+			next := start + anchorLen + util.MatchLen(oldbin[p+start-scan+anchorLen:], newbin[start+anchorLen:])
+			if next+anchorLen > newsize {
+				break
+			}
+			scan = next
+			h = anchorHash(newbin[scan : scan+anchorLen])
+			continue
+		}
+		if scan+anchorLen < newsize {
+			h = (h-uint32(newbin[scan])*t.mulOut)*anchorMul + uint32(newbin[scan+anchorLen])
+		}
+		scan++
+		if scan&checkMask == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	if newsize == 0 {
+		return nil
+	}
+	return emit(newsize, lastpos)
+}
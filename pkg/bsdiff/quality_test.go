@@ -0,0 +1,35 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+func TestQuality(t *testing.T) {
+	for _, w := range testdata.Workloads(1 << 16) {
+		serial, err := bsdiff.Bytes(w.Old, w.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, q := range []bsdiff.Quality{bsdiff.Fast, bsdiff.Balanced, bsdiff.Max} {
+			patch := patchtest.RoundTrip(t, w.Old, w.New, []bsdiff.Option{bsdiff.WithQuality(q), bsdiff.WithConcurrency(4)})
+			if q == bsdiff.Max && !bytes.Equal(patch, serial) {
+				t.Fatal("patch with quality Max differs from the serial one for", w.Name)
+			}
+		}
+	}
+	// Fast still finds moved blocks
+	w := testdata.SmallEdits(1 << 16)
+	moved := append(append([]byte{}, w.New[1<<15:]...), w.New[:1<<15]...)
+	patch, err := bsdiff.Bytes(w.Old, moved, bsdiff.WithQuality(bsdiff.Fast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) > len(moved)/10 {
+		t.Fatalf("fast patch of %v bytes for %v mostly moved bytes", len(patch), len(moved))
+	}
+}
@@ -0,0 +1,17 @@
+package bsdiff
+
+// WithReproducible guarantees byte-identical patches of identical inputs, so
+// patches can be content-addressed and rebuilt in CI. It implies
+// WithDeterministic, so the goroutine count of WithConcurrency doesn't
+// matter, and leaves the modification time out of the WithFileInfo record,
+// as checkouts don't preserve it.
+// The built-in compressors use pinned settings, and the patches they make
+// are checked against golden hashes, so a change of the output across
+// versions of this package or its compression libraries is caught before a
+// release. Custom compressors must be deterministic themselves.
+func WithReproducible() Option {
+	return func(o *options) {
+		o.reproducible = true
+		o.deterministic = true
+	}
+}
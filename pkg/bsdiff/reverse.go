@@ -0,0 +1,49 @@
+package bsdiff
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Reverse returns the undo patch of the diff from oldbs to newbs: the patch
+// from newbs back to oldbs, which rolls an update back without keeping the
+// previous version
+func Reverse(oldbs, newbs []byte, opts ...Option) ([]byte, error) {
+	return BytesCtx(context.Background(), newbs, oldbs, opts...)
+}
+
+// BothWays returns the patch from oldbs to newbs and its undo patch (see
+// Reverse), made concurrently
+func BothWays(oldbs, newbs []byte, opts ...Option) (forward, reverse []byte, err error) {
+	return BothWaysCtx(context.Background(), oldbs, newbs, opts...)
+}
+
+// BothWaysCtx is BothWays, stopping with ctx.Err() when ctx is cancelled
+func BothWaysCtx(parent context.Context, oldbs, newbs []byte, opts ...Option) (forward, reverse []byte, err error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { done <- err }()
+		defer util.Recover(&err)
+		if reverse, err = BytesCtx(ctx, newbs, oldbs, opts...); err != nil {
+			cancel()
+		}
+	}()
+	forward, err = BytesCtx(ctx, oldbs, newbs, opts...)
+	if err != nil {
+		cancel()
+	}
+	// A diff stopped because the other failed reports the other's error
+	rerr := <-done
+	if err == nil || rerr != nil && parent.Err() == nil && errors.Is(err, context.Canceled) {
+		err = rerr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return forward, reverse, nil
+}
@@ -0,0 +1,170 @@
+package bsdiff
+
+import "context"
+
+// suffix is the element type of suffix arrays: int32 halves their memory
+// for inputs under 2 GiB
+type suffix interface {
+	int32 | int
+}
+
+// sais sorts the suffixes of buf into iii (of length len(buf)+1) with the
+// SA-IS induced sorting algorithm (Nong, Zhang and Chan), in linear time.
+// iii[0] is the empty suffix. vvv, of the same length as iii, is used as
+// scratch space. If ctx is cancelled, the sort stops early, leaving iii
+// unsorted.
+func sais[I suffix](ctx context.Context, iii, vvv []I, buf []byte) {
+	n := len(buf)
+	iii[0] = I(n)
+	saisRec(ctx, buf, 255, iii[1:], vvv)
+}
+
+// saisRec writes the suffix array of s, whose values are at most upper, to
+// sa. lmsMap needs len(s)+1 entries.
+func saisRec[T byte | int32 | int, I suffix](ctx context.Context, s []T, upper int, sa, lmsMap []I) {
+	n := len(s)
+	switch n {
+	case 0:
+		return
+	case 1:
+		sa[0] = 0
+		return
+	case 2:
+		if s[0] < s[1] {
+			sa[0], sa[1] = 0, 1
+		} else {
+			sa[0], sa[1] = 1, 0
+		}
+		return
+	}
+
+	// ls[i] is whether suffix i is S-type (smaller than suffix i+1)
+	ls := make([]bool, n)
+	for i := n - 2; i >= 0; i-- {
+		if s[i] == s[i+1] {
+			ls[i] = ls[i+1]
+		} else {
+			ls[i] = s[i] < s[i+1]
+		}
+	}
+	// sumL and sumS are the starts of the L and S parts of each bucket
+	sumL := make([]int, upper+2)
+	sumS := make([]int, upper+2)
+	for i := 0; i < n; i++ {
+		if !ls[i] {
+			sumS[s[i]]++
+		} else {
+			sumL[int(s[i])+1]++
+		}
+	}
+	for i := 0; i <= upper; i++ {
+		sumS[i] += sumL[i]
+		if i < upper {
+			sumL[i+1] += sumS[i]
+		}
+	}
+	bkt := make([]int, upper+2)
+	induce := func(lms []I) {
+		for i := range sa {
+			sa[i] = -1
+		}
+		copy(bkt, sumS)
+		for _, d := range lms {
+			if int(d) == n {
+				continue
+			}
+			sa[bkt[s[d]]] = d
+			bkt[s[d]]++
+		}
+		copy(bkt, sumL)
+		sa[bkt[s[n-1]]] = I(n - 1)
+		bkt[s[n-1]]++
+		for i := 0; i < n; i++ {
+			v := sa[i]
+			if v >= 1 && !ls[v-1] {
+				sa[bkt[s[v-1]]] = v - 1
+				bkt[s[v-1]]++
+			}
+		}
+		copy(bkt, sumL)
+		for i := n - 1; i >= 0; i-- {
+			v := sa[i]
+			if v >= 1 && ls[v-1] {
+				bkt[int(s[v-1])+1]--
+				sa[bkt[int(s[v-1])+1]] = v - 1
+			}
+		}
+	}
+
+	// Sort the LMS suffixes (S-type after L-type) by their LMS substrings
+	lmsMap = lmsMap[:n+1]
+	m := 0
+	for i := range lmsMap {
+		lmsMap[i] = -1
+	}
+	for i := 1; i < n; i++ {
+		if !ls[i-1] && ls[i] {
+			lmsMap[i] = I(m)
+			m++
+		}
+	}
+	lms := make([]I, 0, m)
+	for i := 1; i < n; i++ {
+		if lmsMap[i] != -1 {
+			lms = append(lms, I(i))
+		}
+	}
+	induce(lms)
+	if m == 0 || ctx.Err() != nil {
+		return
+	}
+
+	// Name the LMS substrings and sort the LMS suffixes recursively if
+	// names repeat. There are at most n/2 LMS suffixes, so the sorted ones
+	// and their names fit in sa, and the recursion's suffix array and
+	// scratch space in lmsMap once the names are known.
+	sorted := sa[:0]
+	for _, v := range sa {
+		if lmsMap[v] != -1 {
+			sorted = append(sorted, v)
+		}
+	}
+	rec := sa[m : 2*m]
+	recUpper := 0
+	rec[lmsMap[sorted[0]]] = 0
+	for i := 1; i < m; i++ {
+		l, r := int(sorted[i-1]), int(sorted[i])
+		endL, endR := n, n
+		if k := int(lmsMap[l]) + 1; k < m {
+			endL = int(lms[k])
+		}
+		if k := int(lmsMap[r]) + 1; k < m {
+			endR = int(lms[k])
+		}
+		same := true
+		if endL-l != endR-r {
+			same = false
+		} else {
+			for l < endL && s[l] == s[r] {
+				l++
+				r++
+			}
+			if l == n || r == n || s[l] != s[r] {
+				same = false
+			}
+		}
+		if !same {
+			recUpper++
+		}
+		rec[lmsMap[sorted[i]]] = I(recUpper)
+	}
+	recSA := lmsMap[:m]
+	saisRec(ctx, rec, recUpper, recSA, lmsMap[m:])
+	if ctx.Err() != nil {
+		return
+	}
+	for i, j := range recSA {
+		recSA[i] = lms[j]
+	}
+	induce(recSA)
+}
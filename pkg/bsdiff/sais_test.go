@@ -0,0 +1,84 @@
+package bsdiff
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSais(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("ab"),
+		[]byte("ba"),
+		[]byte("banana"),
+		[]byte("mississippi"),
+		bytes.Repeat([]byte("abc"), 1000),
+		bytes.Repeat([]byte{0}, 500),
+		bytes.Repeat([]byte{255, 0}, 300),
+	}
+	for i := 0; i < 50; i++ {
+		b := make([]byte, rand.Intn(3000))
+		// small alphabets make long repeats and deep recursion
+		alphabet := 1 + rand.Intn(4)
+		if i%2 == 0 {
+			alphabet = 256
+		}
+		for j := range b {
+			b[j] = byte(rand.Intn(alphabet))
+		}
+		inputs = append(inputs, b)
+	}
+	for _, buf := range inputs {
+		iii := make([]int, len(buf)+1)
+		sais(context.Background(), iii, make([]int, len(buf)+1), buf)
+		iii32 := make([]int32, len(buf)+1)
+		sais(context.Background(), iii32, make([]int32, len(buf)+1), buf)
+		want := make([]int, len(buf)+1)
+		for i := range want {
+			want[i] = i
+		}
+		sort.Slice(want, func(a, b int) bool { return bytes.Compare(buf[want[a]:], buf[want[b]:]) < 0 })
+		for i := range want {
+			if iii[i] != want[i] || int(iii32[i]) != want[i] {
+				t.Fatalf("suffix array of %q differs at %v: %v, %v != %v", buf, i, iii[i], iii32[i], want[i])
+			}
+		}
+	}
+}
+
+func TestParallelSais(t *testing.T) {
+	threshold := parallelThreshold
+	defer func() { parallelThreshold = threshold }()
+	// A low threshold makes dc3 recurse a few levels before falling back
+	parallelThreshold = 16
+	for i := 0; i < 40; i++ {
+		b := make([]byte, 16+rand.Intn(20000))
+		alphabet := 1 + rand.Intn(4)
+		if i%2 == 0 {
+			alphabet = 256
+		}
+		for j := range b {
+			b[j] = byte(rand.Intn(alphabet))
+		}
+		if i%5 == 0 {
+			copy(b[len(b)/2:], b)
+		}
+		want := make([]int, len(b)+1)
+		sais(context.Background(), want, make([]int, len(b)+1), b)
+		for _, workers := range []int{2, 3, 8} {
+			iii := make([]int, len(b)+1)
+			parallelSais(context.Background(), iii, b, workers)
+			iii32 := make([]int32, len(b)+1)
+			parallelSais(context.Background(), iii32, b, workers)
+			for k := range want {
+				if iii[k] != want[k] || int(iii32[k]) != want[k] {
+					t.Fatalf("parallel suffix array of %v bytes on %v workers differs at %v: %v, %v != %v", len(b), workers, k, iii[k], iii32[k], want[k])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package bsdiff
+
+import "context"
+
+// WithDeterministic makes patches made with WithConcurrency byte-identical to
+// serial ones. The segments of the new file are still matched in parallel,
+// but at each segment boundary the serial matcher runs until it reaches a
+// state the segment's matcher went through, which usually takes a few
+// controls.
+func WithDeterministic() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
+
+// minSegment is the smallest segment of the new file matched on its own
+// goroutine
+var minSegment = 256 * 1024
+
+// segmentRun is the controls matched from the start of a segment, and the
+// matcher state before the first and after each of them
+type segmentRun struct {
+	start    scanState
+	controls []Control
+	states   []scanState
+}
+
+// scanSegments is scanb on workers goroutines, each matching a segment of
+// newbin. Segments are matched on their own and joined by fixing the seek of
+// the last control of each, unless deterministic: then a segment's controls
+// are matched speculatively past its end, and only used once the serial
+// matcher, resumed at the end of the previous segment, reaches a state they
+// went through.
+func scanSegments[I suffix](ctx context.Context, iii []I, oldbin, newbin []byte, workers int, deterministic bool, fn func(c Control) error) error {
+	segs := workers
+	if k := len(newbin) / minSegment; segs > k {
+		segs = k
+	}
+	if segs <= 1 {
+		return scanb(ctx, iii, oldbin, newbin, fn)
+	}
+	bound := func(k int) int {
+		return len(newbin) * k / segs
+	}
+
+	runs := make([]segmentRun, segs)
+	forChunks(segs, segs, func(k, _, _ int) {
+		start, end := bound(k), bound(k+1)
+		sc := &scanner[I]{ctx: ctx, iii: iii, oldbin: oldbin, newbin: newbin[:end]}
+		if deterministic {
+			sc.newbin = newbin
+		}
+		if k > 0 {
+			// Guess that the segment starts aligned with the old file
+			lastpos := start
+			if lastpos > len(oldbin) {
+				lastpos = len(oldbin)
+			}
+			sc.scanState = scanState{scan: start, lastscan: start, lastpos: lastpos, lastoffset: lastpos - start}
+		}
+		r := &runs[k]
+		r.start = sc.scanState
+		for sc.lastscan < end {
+			c, ok := sc.next()
+			if !ok {
+				break
+			}
+			r.controls = append(r.controls, c)
+			r.states = append(r.states, sc.scanState)
+		}
+	})
+	// A cancelled segment may have no controls to join
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !deterministic {
+		for k, r := range runs {
+			for i, c := range r.controls {
+				if i == len(r.controls)-1 && k+1 < segs {
+					c.Seek = runs[k+1].controls[0].OldPos - (c.OldPos + c.Add)
+				}
+				if err := fn(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	sc := &scanner[I]{ctx: ctx, iii: iii, oldbin: oldbin, newbin: newbin}
+	for k, r := range runs {
+		seen := make(map[scanState]int, len(r.states)+1)
+		seen[r.start] = -1
+		for i, st := range r.states {
+			seen[st] = i
+		}
+		end := bound(k + 1)
+		for sc.lastscan < end {
+			if i, ok := seen[sc.scanState]; ok {
+				for _, c := range r.controls[i+1:] {
+					if err := fn(c); err != nil {
+						return err
+					}
+				}
+				sc.scanState = r.states[len(r.states)-1]
+				break
+			}
+			c, ok := sc.next()
+			if !ok {
+				break
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,99 @@
+package bsdiff
+
+import (
+	"github.com/gabstv/go-bsdiff/internal/squashfs"
+	"github.com/gabstv/go-bsdiff/internal/tarball"
+	"github.com/gabstv/go-bsdiff/internal/zipfile"
+)
+
+// maxSquashfsBlock is the most a squashfs block inflates to
+const maxSquashfsBlock = 1 << 20
+
+// WithSquashfs diffs gzip compressed squashfs images, the root filesystems
+// of embedded Linux firmware, with their data, fragment and inode table
+// blocks inflated, so a small edit to a file makes a small patch for OTA
+// updates, and bspatch deflates them again to rebuild the new image byte for
+// byte. As with WithZip, only new blocks that compress/flate deflates back to
+// the same bytes are inflated, which mksquashfs's zlib mostly doesn't do:
+// images built by Go tools, or by pipelines that recompress with them, benefit
+// the most. The transform is recorded like that of WithZip. Files that aren't
+// both such images are diffed as usual.
+func WithSquashfs() Option {
+	return func(o *options) {
+		o.squashfs = true
+	}
+}
+
+// WithBlockAlign diffs raw firmware and filesystem images block by block:
+// the blocks of n bytes of the old image are reordered to line up with
+// those of the same contents in the new one, or that continue the run
+// before, so blocks that moved, as files do in rebuilt filesystems, are
+// diffed against their previous versions. n is usually the erase block or
+// filesystem block size. The permutation is recorded like that of WithTar,
+// and images that already line up are diffed as usual.
+func WithBlockAlign(n int) Option {
+	return func(o *options) {
+		o.blockAlign = n
+	}
+}
+
+// expandSquashfs returns oldbin and newbin with their compressed blocks
+// inflated, or them unchanged and false if they aren't both squashfs images
+// or no new block can be deflated again
+func expandSquashfs(oldbin, newbin []byte, o *options) ([]byte, []byte, bool) {
+	oldBlocks, ok := squashfs.Blocks(oldbin)
+	if !ok {
+		return oldbin, newbin, false
+	}
+	newBlocks, ok := squashfs.Blocks(newbin)
+	if !ok {
+		return oldbin, newbin, false
+	}
+	t := &zipfile.Transform{}
+	for _, blk := range newBlocks {
+		stream, data, ok := squashfs.Inflate(newbin, blk, maxSquashfsBlock)
+		if !ok {
+			continue
+		}
+		level, ok := zipfile.Level(data, newbin[stream.Off:stream.Off+stream.Len])
+		if !ok {
+			continue
+		}
+		t.New = append(t.New, zipfile.Entry{Off: stream.Off, Len: stream.Len, Size: len(data), Level: level})
+	}
+	if len(t.New) == 0 {
+		return oldbin, newbin, false
+	}
+	for _, blk := range oldBlocks {
+		if stream, data, ok := squashfs.Inflate(oldbin, blk, maxSquashfsBlock); ok {
+			t.Old = append(t.Old, zipfile.Entry{Off: stream.Off, Len: stream.Len, Size: len(data)})
+		}
+	}
+	oldx, err := zipfile.Expand(oldbin, t.Old)
+	if err != nil {
+		return oldbin, newbin, false
+	}
+	newx, err := zipfile.Expand(newbin, t.New)
+	if err != nil {
+		return oldbin, newbin, false
+	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	o.ext.set(extZip, t.Marshal())
+	return oldx, newx, true
+}
+
+// alignBlocks returns the permutation of oldbin lining its blocks up with
+// newbin, or oldbin unchanged if they already line up
+func alignBlocks(oldbin, newbin []byte, o *options) []byte {
+	ranges, ok := tarball.AlignBlocks(oldbin, newbin, o.blockAlign)
+	if !ok {
+		return oldbin
+	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	o.ext.set(extTar, tarball.Marshal(ranges))
+	return tarball.Permute(oldbin, ranges)
+}
@@ -0,0 +1,75 @@
+package bsdiff_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+func testSquashfs(n int, changed map[int]bool) []byte {
+	words := strings.Fields("busybox init mount proc sysfs tmpfs eth0 wlan0 dhcp ntp")
+	var files [][]byte
+	for i := 0; i < n; i++ {
+		rng := rand.New(rand.NewSource(int64(i)))
+		var data []byte
+		for len(data) < 5000+rng.Intn(10000) {
+			data = append(data, words[rng.Intn(len(words))]...)
+			data = append(data, " \n"[rng.Intn(2)])
+		}
+		if changed[i] {
+			copy(data[100:], "edited")
+		}
+		files = append(files, data)
+	}
+	return testdata.Squashfs(files)
+}
+
+func TestSquashfs(t *testing.T) {
+	oldbs := testSquashfs(40, nil)
+	newbs := testSquashfs(40, map[int]bool{2: true, 30: true})
+
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithSquashfs()})
+	if len(patch) >= len(plain)/2 {
+		t.Fatal("squashfs patch is", len(patch), "bytes, plain patch", len(plain))
+	}
+	if _, err = bsdiff.NewIndex(oldbs, bsdiff.WithSquashfs()); err == nil {
+		t.Fatal("expected an error indexing with WithSquashfs")
+	}
+}
+
+func TestBlockAlign(t *testing.T) {
+	const n = 4096
+	oldbs := testdata.Random(1, 64*n+100)
+	// The second half of the blocks moved first, with the first block of
+	// each half rewritten in many places
+	newbs := append(append([]byte(nil), oldbs[32*n:64*n]...), oldbs[:32*n]...)
+	for _, i := range []int{0, 32} {
+		for j := 0; j < n; j += 8 {
+			newbs[i*n+j]++
+		}
+	}
+	newbs = append(newbs, "a new tail"...)
+
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithBlockAlign(n)})
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bsdiff follows runs of blocks on its own; the permutation costs a small
+	// extended header
+	if string(patch[:8]) != "BSDIFF4X" || len(patch) > len(plain)+64 {
+		t.Fatal("aligned patch is", len(patch), "bytes, plain patch", len(plain), string(patch[:8]))
+	}
+	// Already aligned: a regular patch
+	if patch, err = bsdiff.Bytes(oldbs, oldbs, bsdiff.WithBlockAlign(n)); err != nil || string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected a BSDIFF40 patch", err)
+	}
+}
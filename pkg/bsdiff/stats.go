@@ -0,0 +1,41 @@
+package bsdiff
+
+import "time"
+
+// DiffStats are statistics of a diff, for tuning and monitoring
+type DiffStats struct {
+	// Controls is the number of control triples
+	Controls int
+	// Matched is the number of bytes of the new file from the diff block,
+	// Extra those from the extra block
+	Matched, Extra int64
+	// CtrlSize, DiffSize and ExtraSize are the compressed sizes of the
+	// blocks. Endsley patches have a single block, counted in CtrlSize.
+	CtrlSize, DiffSize, ExtraSize int64
+	// SortTime is the time taken to index the old file, ScanTime to match
+	// the new file against it (including compressing the blocks as they're
+	// written) and CompressTime to finish the compressed blocks
+	SortTime, ScanTime, CompressTime time.Duration
+	// IndexMemory is the size of the index of the old file (its suffix
+	// array and scratch space, or anchor table), which is most of the memory
+	// of a diff. For Stream it's that of one window.
+	IndexMemory int64
+}
+
+// WithStats fills s with the statistics of the diff. s is reset by every
+// call the option is passed to, so it must not be shared by concurrent
+// diffs. With an Index, the sort is done (and measured) by NewIndex and the
+// rest by the Diff or Write s is passed to.
+func WithStats(s *DiffStats) Option {
+	return func(o *options) {
+		o.stats = s
+	}
+}
+
+// memory returns the bytes of the index x, whose buffers a holds
+func (a *arena) memory(x oldIndex) int64 {
+	if x.anchors != nil {
+		return int64(len(x.anchors.slots)) * 8
+	}
+	return int64(cap(a.iii)+cap(a.vvv))*8 + int64(cap(a.iii32)+cap(a.vvv32))*4
+}
@@ -0,0 +1,40 @@
+package bsdiff_test
+
+import (
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestStats(t *testing.T) {
+	w := testdata.SmallEdits(1 << 20)
+	var ds bsdiff.DiffStats
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithStats(&ds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Controls == 0 || ds.Matched+ds.Extra != int64(len(w.New)) || ds.SortTime == 0 || ds.ScanTime == 0 {
+		t.Fatalf("unexpected diff stats %+v", ds)
+	}
+	if 32+ds.CtrlSize+ds.DiffSize+ds.ExtraSize != int64(len(patch)) {
+		t.Fatalf("block sizes %+v don't add up to the patch size %v", ds, len(patch))
+	}
+	// The suffix array and its scratch space are two int32s per byte
+	if ds.IndexMemory < int64(len(w.Old))*8 {
+		t.Fatalf("index memory %v for %v old bytes", ds.IndexMemory, len(w.Old))
+	}
+
+	var ps bspatch.PatchStats
+	if _, err = bspatch.Bytes(w.Old, patch, bspatch.WithStats(&ps)); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Controls != ds.Controls || ps.Matched != ds.Matched || ps.Extra != ds.Extra || ps.ApplyTime == 0 {
+		t.Fatalf("patch stats %+v don't match diff stats %+v", ps, ds)
+	}
+	// The new file and the read buffers
+	if ps.PeakMemory != int64(len(w.New))+2*bspatch.DefaultBufferSize {
+		t.Fatalf("unexpected peak memory %v", ps.PeakMemory)
+	}
+}
@@ -0,0 +1,32 @@
+package bsdiff
+
+import "github.com/gabstv/go-bsdiff/internal/tarball"
+
+// WithTar diffs tar archives entry by entry: the entries of the old archive
+// are reordered to line up with their namesakes in the new one, so each
+// entry is diffed against its previous version wherever either moved in the
+// archive. Entries match by name, or by name less its top directory, which
+// release tarballs usually version. The permutation is recorded in an
+// extended (BSDIFF4X) header and bspatch repeats it. Files that aren't both
+// uncompressed tar archives, or whose entries already line up, are diffed as
+// usual.
+func WithTar() Option {
+	return func(o *options) {
+		o.tar = true
+	}
+}
+
+// alignTar returns the permutation of oldbin lining up with newbin, or
+// oldbin unchanged and false if they aren't both tar archives or already
+// line up
+func alignTar(oldbin, newbin []byte, o *options) ([]byte, bool) {
+	ranges, ok := tarball.Align(oldbin, newbin)
+	if !ok || len(ranges) == 1 && ranges[0] == (tarball.Range{Off: 0, Len: len(oldbin)}) {
+		return oldbin, false
+	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	o.ext.set(extTar, tarball.Marshal(ranges))
+	return tarball.Permute(oldbin, ranges), true
+}
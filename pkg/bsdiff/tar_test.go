@@ -0,0 +1,74 @@
+package bsdiff_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// testTar returns a tar archive of files of random words under dir, in
+// order, with the files in changed edited
+func testTar(t *testing.T, dir string, order []int, changed map[int]bool) []byte {
+	words := strings.Fields("func return if else for range var const type struct err nil")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, i := range order {
+		rng := rand.New(rand.NewSource(int64(i)))
+		var data []byte
+		for len(data) < 4096+i*97 {
+			data = append(data, words[rng.Intn(len(words))]...)
+			data = append(data, " \n"[rng.Intn(2)])
+		}
+		if changed[i] {
+			copy(data[len(data)/2:], "edited")
+		}
+		hdr := &tar.Header{Name: fmt.Sprintf("%v/file%v.go", dir, i), Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTar(t *testing.T) {
+	order := make([]int, 200)
+	for i := range order {
+		order[i] = i
+	}
+	oldbs := testTar(t, "app-1.0", order, nil)
+	rand.New(rand.NewSource(1)).Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	newbs := testTar(t, "app-1.1", order[10:], map[int]bool{3: true, 50: true, 120: true})
+
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithTar()})
+	if len(patch) >= len(plain) {
+		t.Fatal("tar patch is", len(patch), "bytes, plain patch", len(plain))
+	}
+	var out bytes.Buffer
+	if err = bspatch.ApplyStream(bytes.NewReader(oldbs), iotest.OneByteReader(bytes.NewReader(patch)), &out); err != nil || !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("stream round trip failed", err)
+	}
+	// not archives: a regular patch
+	patch, err = bsdiff.Bytes([]byte("old text"), []byte("new text"), bsdiff.WithTar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected a BSDIFF40 patch, got", string(patch[:8]))
+	}
+}
@@ -0,0 +1,51 @@
+package bsdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// ErrVerify is returned by patches made with WithVerify that don't
+// reproduce the new file
+var ErrVerify = errors.New("patch doesn't reproduce the new file")
+
+// WithVerify applies each patch to the old file once it's written and
+// compares the result with the new file, as insurance against bugs in the
+// matcher or a compressor. opts are passed to bspatch, e.g. the decompressor
+// of a custom compressor. The patch is read back from where it was written:
+// Stream, Reader and Index.Write need a patch that is also an io.ReaderAt,
+// like an *os.File opened for reading and writing.
+func WithVerify(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.verify = true
+		o.verifyOpts = opts
+	}
+}
+
+// verifyPatch applies the patch written to pf to oldfile and compares the
+// SHA-256 of the result with want
+func (o *options) verifyPatch(oldfile io.ReaderAt, pf io.WriteSeeker, want []byte) error {
+	var patch io.ReaderAt
+	switch p := pf.(type) {
+	case *util.BufWriter:
+		patch = bytes.NewReader(p.Bytes())
+	case io.ReaderAt:
+		patch = p
+	default:
+		return fmt.Errorf("can't verify a patch written to a %T, which isn't an io.ReaderAt", pf)
+	}
+	d := sha256.New()
+	if err := bspatch.Apply(oldfile, patch, d, o.verifyOpts...); err != nil {
+		return fmt.Errorf("%w: %v", ErrVerify, err.Error())
+	}
+	if got := d.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%w (SHA-256 %x, expected %x)", ErrVerify, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package bsdiff
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// DefaultWindow is the window size of Stream, unless WithWindow sets another
+const DefaultWindow = 8 << 20
+
+// WithWindow diffs the new file in windows of n bytes, each against a region
+// of 2n bytes of the old file around the position the previous window ended
+// at, instead of suffix sorting the whole old file. Memory use is bounded by
+// about 25n bytes whatever the size of the inputs, but data that moved
+// farther than n/2 bytes isn't matched. It has an effect on Stream and File.
+func WithWindow(n int) Option {
+	return func(o *options) {
+		o.window = n
+	}
+}
+
+// Stream diffs newfile, read sequentially, against oldfile in windows (see
+// WithWindow), so inputs of any size can be diffed with bounded memory. The
+// compressed diff and extra blocks are buffered until the patch is complete,
+// in temporary files once they grow large. WithExecutable isn't supported.
+func Stream(oldfile io.ReaderAt, newfile io.Reader, patch io.WriteSeeker, opts ...Option) error {
+	return StreamCtx(context.Background(), oldfile, newfile, patch, opts...)
+}
+
+// StreamCtx is Stream, stopping with ctx.Err() when ctx is cancelled
+func StreamCtx(ctx context.Context, oldfile io.ReaderAt, newfile io.Reader, patch io.WriteSeeker, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	o.ctx = ctx
+	if o.window == 0 {
+		o.window = DefaultWindow
+	}
+	return streamb(oldfile, newfile, patch, o)
+}
+
+// streamFile is File in windowed mode
+func streamFile(oldfile, newfile, patchfile string, o *options) error {
+	oldF, err := os.Open(oldfile)
+	if err != nil {
+		return fmt.Errorf("could not open oldfile '%v': %w", oldfile, err)
+	}
+	defer oldF.Close()
+	newF, err := os.Open(newfile)
+	if err != nil {
+		return fmt.Errorf("could not open newfile '%v': %w", newfile, err)
+	}
+	defer newF.Close()
+	return writePatchFile(patchfile, func(pf *os.File) error {
+		return streamb(oldF, newF, pf, o)
+	})
+}
+
+// pendingControl is a control whose seek depends on the next one
+type pendingControl struct {
+	oldpos      int
+	diff, extra []byte
+}
+
+func streamb(oldfile io.ReaderAt, newfile io.Reader, pf io.WriteSeeker, o *options) error {
+	n := o.window
+	if n <= 0 {
+		return fmt.Errorf("invalid window size %v", n)
+	}
+	if o.exec {
+		return fmt.Errorf("executables can't be diffed in windows")
+	}
+	if o.tar || o.zip || o.squashfs || o.blockAlign > 0 {
+		return fmt.Errorf("archives and images can't be diffed in windows")
+	}
+	// The new file is hashed as it's read
+	var digest hash.Hash
+	if o.hashes {
+		old, err := sumReaderAt(oldfile)
+		if err != nil {
+			return err
+		}
+		o.setHashes(old, nil)
+	}
+	if o.hashes || o.verify {
+		digest = sha256.New()
+		newfile = io.TeeReader(newfile, digest)
+	}
+	w, err := newWriter(pf, o)
+	if err != nil {
+		return err
+	}
+	defer w.release()
+	newbin := make([]byte, n)
+	oldbuf := make([]byte, 2*n)
+	a := &arena{}
+
+	// The seek of a control is only known once the next control is, as it
+	// may come from the next window
+	var p pendingControl
+	emit := func(oldpos int) error {
+		seek := oldpos - (p.oldpos + len(p.diff))
+		if len(p.diff) == 0 && len(p.extra) == 0 && seek == 0 {
+			return nil
+		}
+		return w.WriteControl(p.diff, p.extra, seek)
+	}
+
+	m := util.NewMeter(o.progress, StageScan, -1)
+	// drift is the old position minus the new position where the last
+	// window left off
+	var newpos, drift int
+	for {
+		nn, err := io.ReadFull(newfile, newbin)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		start := newpos + drift - n/2
+		if start < 0 {
+			start = 0
+		}
+		on, err := oldfile.ReadAt(oldbuf, int64(start))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		oldbin := oldbuf[:on]
+		began := time.Now()
+		x := a.index(oldbin, o)
+		if s := o.stats; s != nil {
+			s.SortTime += time.Since(began)
+			s.IndexMemory = a.memory(x)
+			began = time.Now()
+		}
+		err = x.scan(o.ctx, oldbin, newbin[:nn], 1, false, func(c Control) error {
+			m.Update(int64(newpos + c.NewPos))
+			if err := emit(start + c.OldPos); err != nil {
+				return err
+			}
+			p.oldpos = start + c.OldPos
+			p.diff = p.diff[:0]
+			for i := 0; i < c.Add; i++ {
+				p.diff = append(p.diff, newbin[c.NewPos+i]-oldbin[c.OldPos+i])
+			}
+			p.extra = append(p.extra[:0], newbin[c.NewPos+c.Add:c.NewPos+c.Add+c.Copy]...)
+			if c.Add > 0 {
+				drift = p.oldpos + c.Add - (newpos + c.NewPos + c.Add)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if o.stats != nil {
+			o.stats.ScanTime += time.Since(began)
+		}
+		newpos += nn
+		if nn < n {
+			break
+		}
+	}
+	if err = emit(p.oldpos + len(p.diff)); err != nil {
+		return err
+	}
+	m.Update(int64(newpos))
+	m.Done()
+	if o.hashes {
+		w.setExt(extSHA256New, digest.Sum(nil))
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if o.verify {
+		return o.verifyPatch(oldfile, pf, digest.Sum(nil))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+func TestStream(t *testing.T) {
+	oldbs := testdata.Random(1, 1024*64)
+	// The new file has changed bytes, an insertion and a deletion
+	newbs := append([]byte(nil), oldbs[:20000]...)
+	newbs = append(newbs, make([]byte, 1000)...)
+	newbs = append(newbs, oldbs[20000:40000]...)
+	newbs = append(newbs, oldbs[41000:]...)
+	copy(newbs[5000:5100], testdata.Random(2, 100))
+	copy(newbs[50000:50010], testdata.Random(3, 10))
+	for _, window := range []int{1000, 4096, 1 << 20} {
+		var patch util.BufWriter
+		err := bsdiff.Stream(bytes.NewReader(oldbs), iotest.OneByteReader(bytes.NewReader(newbs)), &patch, bsdiff.WithWindow(window))
+		if err != nil {
+			t.Fatal(err)
+		}
+		newbs2, err := bspatch.Bytes(oldbs, patch.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newbs, newbs2) {
+			t.Fatal("round trip failed with window", window)
+		}
+		if len(patch.Bytes()) > 4096 {
+			t.Fatal("patch too large with window", window, len(patch.Bytes()))
+		}
+	}
+
+	// Windows also work on empty and unrelated files
+	for _, tc := range [][2][]byte{{nil, newbs}, {oldbs, nil}, {oldbs[:10], newbs}} {
+		var patch util.BufWriter
+		if err := bsdiff.Stream(bytes.NewReader(tc[0]), bytes.NewReader(tc[1]), &patch, bsdiff.WithWindow(4096)); err != nil {
+			t.Fatal(err)
+		}
+		newbs2, err := bspatch.Bytes(tc[0], patch.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tc[1], newbs2) {
+			t.Fatal("round trip failed")
+		}
+	}
+}
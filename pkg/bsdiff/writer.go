@@ -0,0 +1,301 @@
+package bsdiff
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// spillLimit is how much of the compressed diff and extra blocks is kept in
+// memory; the rest goes to temporary files
+var spillLimit = 32 << 20
+
+// Writer serializes control triples into a patch. It's used to write
+// patches whose differences don't come from the bsdiff matcher, e.g. when
+// converting a patch from another format.
+type Writer struct {
+	pf io.WriteSeeker
+	// bw buffers the writes to pf
+	bw     *bufio.Writer
+	format Format
+	comps  [3]Compressor
+	header []byte
+	// ext holds the extension records of header, if any
+	ext *extHeader
+	// crcs hash the compressed blocks, with WithBlockChecksums
+	crcs []hash.Hash32
+	// cw counts the bytes of the ctrl block, ctrl compresses it (or the
+	// single stream of an Endsley patch)
+	cw   *countWriter
+	ctrl io.WriteCloser
+	// diff and extra compress the diff and extra blocks into db and eb as
+	// the controls are written, so only the compressed blocks are held
+	diff, extra io.WriteCloser
+	db, eb      *util.SpillWriter
+	newsize     int
+	buf         [24]byte
+	progress    func(stage string, done, total int64)
+	stats       *DiffStats
+
+	// difflen and extralen count the bytes of the diff and extra blocks,
+	// for stripEmpty
+	difflen, extralen int
+	stripEmpty        bool
+}
+
+// NewWriter writes the patch header to pf and returns a Writer for the
+// control triples. The header is completed by Close, so pf must not be
+// written to in between.
+func NewWriter(pf io.WriteSeeker, opts ...Option) (_ *Writer, err error) {
+	defer util.Recover(&err)
+	return newWriter(pf, newOptions(opts))
+}
+
+func newWriter(pf io.WriteSeeker, o *options) (*Writer, error) {
+	comps := o.compressors
+	for _, c := range comps {
+		if len(c.Magic()) != 8 {
+			return nil, fmt.Errorf("invalid compressor magic %q", c.Magic())
+		}
+	}
+	if o.bufSize <= 0 {
+		return nil, fmt.Errorf("invalid buffer size %v", o.bufSize)
+	}
+	if o.checksums && o.format != FormatBSDIFF40 {
+		return nil, fmt.Errorf("%v patches can't carry block checksums", o.format)
+	}
+	w := &Writer{pf: pf, bw: bufio.NewWriterSize(pf, o.bufSize), format: o.format, comps: comps, progress: o.progress, stats: o.stats, stripEmpty: o.stripEmpty}
+	switch o.format {
+	case FormatBSDIFF40:
+		ext := o.ext
+		if o.checksums {
+			if ext == nil {
+				ext = &extHeader{}
+			}
+			crcs := newBlockCRCs(ext)
+			w.crcs = crcs[:]
+		}
+		for i, c := range comps {
+			if ext == nil && (c.Magic() != comps[0].Magic() || isExtCompressor(c)) {
+				ext = &extHeader{}
+			}
+			if c.Magic() != comps[0].Magic() {
+				ext.set(extCodec+"."+blockNames[i], []byte(c.Magic()))
+			}
+			if e, ok := c.(extCompressor); ok {
+				e.setExt(ext)
+			}
+		}
+		w.header = ext.header(comps[0].Magic())
+		w.ext = ext
+	case FormatBSDF2:
+		var err error
+		if w.header, err = bsdf2Header(o); err != nil {
+			return nil, err
+		}
+	case FormatEndsley:
+		if err := checkEndsley(o); err != nil {
+			return nil, err
+		}
+		w.header = make([]byte, 24)
+		copy(w.header, magicEndsley)
+	default:
+		return nil, fmt.Errorf("unknown patch format %q", o.format)
+	}
+	if _, err := w.bw.Write(w.header); err != nil {
+		return nil, err
+	}
+	w.cw = &countWriter{w: w.bw}
+	var err error
+	if w.ctrl, err = comps[0].NewWriter(w.blockWriter(0, w.cw)); err != nil {
+		return nil, err
+	}
+	if o.format == FormatEndsley {
+		return w, nil
+	}
+	w.db, w.eb = util.NewSpillWriter(spillLimit), util.NewSpillWriter(spillLimit)
+	if w.diff, err = comps[1].NewWriter(w.blockWriter(1, w.db)); err != nil {
+		w.release()
+		return nil, err
+	}
+	if w.extra, err = comps[2].NewWriter(w.blockWriter(2, w.eb)); err != nil {
+		w.release()
+		return nil, err
+	}
+	if o.concurrency > 1 {
+		w.ctrl = newAsyncWriter(w.ctrl)
+		w.diff = newAsyncWriter(w.diff)
+		w.extra = newAsyncWriter(w.extra)
+	}
+	return w, nil
+}
+
+// blockWriter returns bw, hashing the bytes of block i if the blocks are
+// checksummed
+func (w *Writer) blockWriter(i int, bw io.Writer) io.Writer {
+	if w.crcs == nil {
+		return bw
+	}
+	return io.MultiWriter(bw, w.crcs[i])
+}
+
+// WriteControl writes a control triple: the new file continues with the
+// diff bytes added to the old file, then the extra bytes, and the old
+// position moves forward by len(diff)+seek
+func (w *Writer) WriteControl(diff, extra []byte, seek int) (err error) {
+	defer util.Recover(&err)
+	offtout(len(diff), w.buf[:])
+	offtout(len(extra), w.buf[8:])
+	offtout(seek, w.buf[16:])
+	if _, err := w.ctrl.Write(w.buf[:]); err != nil {
+		return err
+	}
+	w.newsize += len(diff) + len(extra)
+	w.difflen += len(diff)
+	w.extralen += len(extra)
+	if s := w.stats; s != nil {
+		s.Controls++
+		s.Matched += int64(len(diff))
+		s.Extra += int64(len(extra))
+	}
+	if w.format == FormatEndsley {
+		if _, err := w.ctrl.Write(diff); err != nil {
+			return err
+		}
+		_, err := w.ctrl.Write(extra)
+		return err
+	}
+	if _, err := w.diff.Write(diff); err != nil {
+		return err
+	}
+	_, err = w.extra.Write(extra)
+	return err
+}
+
+// Close writes the diff and extra blocks and completes the header. It
+// doesn't close the underlying writer, but removes the temporary files of
+// large blocks.
+func (w *Writer) Close() (err error) {
+	defer util.Recover(&err)
+	defer w.release()
+	// Let concurrent compressors finish their blocks together
+	for _, c := range []io.WriteCloser{w.ctrl, w.diff, w.extra} {
+		if a, ok := c.(*asyncWriter); ok {
+			a.finish()
+		}
+	}
+	start := time.Now()
+	blocks := int64(3)
+	if w.format == FormatEndsley {
+		blocks = 1
+	}
+	m := util.NewMeter(w.progress, StageCompress, blocks)
+	if err := w.ctrl.Close(); err != nil {
+		return err
+	}
+	m.Update(1)
+	if w.format == FormatEndsley {
+		w.finishStats(start)
+		offtout(w.newsize, w.header[16:])
+		return w.writeHeader(w.header)
+	}
+
+	// Compute size of compressed ctrl data
+	offtout(w.cw.n, w.header[8:])
+
+	// Write compressed diff data
+	if err := w.diff.Close(); err != nil {
+		return err
+	}
+	dlen, err := w.writeBlock(1, w.db, w.difflen)
+	if err != nil {
+		return err
+	}
+	m.Update(2)
+	// Compute size of compressed diff data
+	offtout(int(dlen), w.header[16:])
+	// Write compressed extra data
+	if err := w.extra.Close(); err != nil {
+		return err
+	}
+	if _, err := w.writeBlock(2, w.eb, w.extralen); err != nil {
+		return err
+	}
+	m.Update(3)
+	w.finishStats(start)
+	if w.crcs != nil {
+		w.setBlockCRCs()
+	}
+	offtout(w.newsize, w.header[24:])
+	return w.writeHeader(w.header)
+}
+
+// writeBlock writes b, block i compressed from n bytes, unless it's empty
+// and stripped, and returns the size written
+func (w *Writer) writeBlock(i int, b *util.SpillWriter, n int) (int64, error) {
+	if w.stripEmpty && n == 0 {
+		if w.crcs != nil {
+			w.crcs[i].Reset()
+		}
+		return 0, nil
+	}
+	return b.WriteTo(w.bw)
+}
+
+// setExt replaces the value of an extension record with one of the same
+// length, before Close rewrites the header
+func (w *Writer) setExt(key string, value []byte) {
+	w.ext.set(key, value)
+	ext := w.ext.header(w.comps[0].Magic())
+	copy(w.header[32:], ext[32:])
+}
+
+// finishStats records the block sizes and the time spent finishing them
+// since start
+func (w *Writer) finishStats(start time.Time) {
+	s := w.stats
+	if s == nil {
+		return
+	}
+	s.CompressTime = time.Since(start)
+	s.CtrlSize = int64(w.cw.n)
+	if w.db != nil {
+		s.DiffSize, s.ExtraSize = w.db.Len(), w.eb.Len()
+		if w.stripEmpty && w.difflen == 0 {
+			s.DiffSize = 0
+		}
+		if w.stripEmpty && w.extralen == 0 {
+			s.ExtraSize = 0
+		}
+	}
+}
+
+// release stops the concurrent compressors and removes the temporary files
+// of the diff and extra blocks, if any
+func (w *Writer) release() {
+	for _, c := range []io.WriteCloser{w.ctrl, w.diff, w.extra} {
+		if a, ok := c.(*asyncWriter); ok {
+			a.Close()
+		}
+	}
+	if w.db != nil {
+		w.db.Close()
+		w.eb.Close()
+	}
+}
+
+// writeHeader seeks to the beginning and rewrites the header
+func (w *Writer) writeHeader(header []byte) error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.pf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.pf.Write(header)
+	return err
+}
@@ -0,0 +1,39 @@
+package bsdiff
+
+import "github.com/gabstv/go-bsdiff/internal/zipfile"
+
+// WithZip diffs zip archives (and JAR and APK files) entry by entry: the
+// deflated entries the old and new archives share are inflated before
+// diffing, so a small edit to an entry makes a small patch, and bspatch
+// deflates them again to rebuild the new archive byte for byte. Only new
+// entries that compress/flate deflates back to the same bytes are inflated,
+// as it records the level; in practice those of archives written by Go.
+// The transform is recorded in an extended (BSDIFF4X) header. Files that
+// aren't both zip archives are diffed as usual.
+func WithZip() Option {
+	return func(o *options) {
+		o.zip = true
+	}
+}
+
+// expandZip returns oldbin and newbin with their shared entries inflated,
+// or them unchanged and false if there are none
+func expandZip(oldbin, newbin []byte, o *options) ([]byte, []byte, bool) {
+	t, ok := zipfile.Parse(oldbin, newbin)
+	if !ok {
+		return oldbin, newbin, false
+	}
+	oldx, err := zipfile.Expand(oldbin, t.Old)
+	if err != nil {
+		return oldbin, newbin, false
+	}
+	newx, err := zipfile.Expand(newbin, t.New)
+	if err != nil {
+		return oldbin, newbin, false
+	}
+	if o.ext == nil {
+		o.ext = &extHeader{}
+	}
+	o.ext.set(extZip, t.Marshal())
+	return oldx, newx, true
+}
@@ -0,0 +1,81 @@
+package bsdiff_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// testZip returns a zip archive of files of random words, with the files in
+// changed edited
+func testZip(t *testing.T, n int, changed map[int]bool) []byte {
+	words := strings.Fields("public static void class return if else for new null this")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		rng := rand.New(rand.NewSource(int64(i)))
+		var data []byte
+		for len(data) < 8192 {
+			data = append(data, words[rng.Intn(len(words))]...)
+			data = append(data, " \n"[rng.Intn(2)])
+		}
+		if changed[i] {
+			copy(data[100:], "edited")
+		}
+		w, err := zw.Create(fmt.Sprintf("com/example/Class%v.java", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZip(t *testing.T) {
+	oldbs := testZip(t, 40, nil)
+	newbs := testZip(t, 40, map[int]bool{2: true, 30: true})
+
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithZip(), bsdiff.WithHashes()})
+	if len(patch) >= len(plain)/2 {
+		t.Fatal("zip patch is", len(patch), "bytes, plain patch", len(plain))
+	}
+	var out bytes.Buffer
+	if err = bspatch.ApplyStream(bytes.NewReader(oldbs), iotest.OneByteReader(bytes.NewReader(patch)), &out); err != nil || !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("stream round trip failed", err)
+	}
+	dir := t.TempDir()
+	oldfile := patchtest.WriteFile(t, dir, "old.jar", oldbs)
+	patchfile := patchtest.WriteFile(t, dir, "patch", patch)
+	newfile := filepath.Join(dir, "new.jar")
+	if err = bspatch.File(oldfile, newfile, patchfile); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(newfile); err != nil || !bytes.Equal(b, newbs) {
+		t.Fatal("file round trip failed", len(b), len(newbs), err)
+	}
+	// not archives: a regular patch
+	patch, err = bsdiff.Bytes([]byte("old text"), []byte("new text"), bsdiff.WithZip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch[:8]) != "BSDIFF40" {
+		t.Fatal("expected a BSDIFF40 patch, got", string(patch[:8]))
+	}
+}
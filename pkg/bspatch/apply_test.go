@@ -0,0 +1,84 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestApply(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	vpatch, err := vcdiff.Diff(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches := [][]byte{vpatch}
+	for _, opts := range [][]bsdiff.Option{
+		nil,
+		{bsdiff.WithFormat(bsdiff.FormatEndsley)},
+		{bsdiff.WithExecutable()},
+	} {
+		patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patches = append(patches, patch)
+	}
+	for _, patch := range patches {
+		// The new file is written through a gzip.Writer, which can't seek
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if err := bspatch.Apply(bytes.NewReader(oldbs), bytes.NewReader(patch), zw); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newbs2, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newbs, newbs2) {
+			t.Fatal("round trip failed", string(patch[:8]))
+		}
+	}
+}
+
+func TestBytesInto(t *testing.T) {
+	oldbs := testdata.Random(1, 1<<16)
+	newbs := append([]byte("header"), oldbs...)
+	copy(newbs[5000:], "changed")
+	patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithCompressor(bsdiff.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, len(newbs)+10)
+	buf := make([]byte, 8<<10)
+	n, err := bspatch.BytesInto(dst, oldbs, patch, bspatch.WithBuffer(buf))
+	if err != nil || !bytes.Equal(dst[:n], newbs) {
+		t.Fatal("patched the wrong file", err)
+	}
+	// Beyond parsing the header, raw patches need no allocations that grow
+	// with the files
+	allocs := testing.AllocsPerRun(10, func() {
+		bspatch.BytesInto(dst, oldbs, patch, bspatch.WithBuffer(buf))
+	})
+	if allocs > 30 {
+		t.Error("allocations:", allocs)
+	}
+	if _, err = bspatch.BytesInto(dst[:len(newbs)-1], oldbs, patch); !errors.Is(err, io.ErrShortBuffer) {
+		t.Error("short buffer:", err)
+	}
+}
@@ -0,0 +1,72 @@
+package bspatch
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WithBackup makes File and InPlace copy the file they overwrite to backup
+// before writing it: the file patched in place, or the new file if it
+// exists. Should patching fail, the file is restored from backup; Rollback
+// restores it later.
+func WithBackup(backup string) Option {
+	return func(o *options) {
+		o.backup = backup
+	}
+}
+
+// Backup copies file to backup, keeping its mode and modification time,
+// through a temporary file renamed once complete
+func Backup(file, backup string) (err error) {
+	defer recoverPanic(&err)
+	src, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("could not open file '%v': %w", file, err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat file '%v': %w", file, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(backup), "."+filepath.Base(backup)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not create backup '%v': %w", backup, err)
+	}
+	name := tmp.Name()
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(name, fi.ModTime(), fi.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(name, backup)
+	}
+	if err != nil {
+		os.Remove(name)
+		return fmt.Errorf("could not write backup '%v': %w", backup, err)
+	}
+	return nil
+}
+
+// Rollback replaces file with backup, made by Backup or WithBackup,
+// undoing a patch
+func Rollback(file, backup string) (err error) {
+	defer recoverPanic(&err)
+	if _, err = os.Stat(backup); err != nil {
+		return fmt.Errorf("could not find backup '%v': %w", backup, err)
+	}
+	if err = os.Rename(backup, file); err != nil {
+		return fmt.Errorf("could not restore file '%v': %w", file, err)
+	}
+	return nil
+}
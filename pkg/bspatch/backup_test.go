@@ -0,0 +1,75 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestBackup(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	patch, err := bsdiff.Bytes(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	name, backup := filepath.Join(dir, "file"), filepath.Join(dir, "file.bak")
+	check := func(name string, want []byte) {
+		t.Helper()
+		if b, err := os.ReadFile(name); err != nil || !bytes.Equal(b, want) {
+			t.Fatalf("unexpected %v (%v)", name, err)
+		}
+	}
+	if err = os.WriteFile(name, w.Old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.InPlace(name, bytes.NewReader(patch), bspatch.WithBackup(backup)); err != nil {
+		t.Fatal(err)
+	}
+	check(name, w.New)
+	check(backup, w.Old)
+	if fi, err := os.Stat(backup); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatal("expected the mode of the file to be kept", fi, err)
+	}
+	if err = bspatch.Rollback(name, backup); err != nil {
+		t.Fatal(err)
+	}
+	check(name, w.Old)
+	if err = bspatch.Rollback(name, backup); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected a missing backup error, got", err)
+	}
+
+	// A patch failing midway restores the file
+	h, err := bspatch.ReadHeader(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, patch...)
+	corrupt[h.BlockOffset+h.CtrlSize+h.DiffSize/2] ^= 0xff
+	if err = bspatch.InPlace(name, bytes.NewReader(corrupt), bspatch.WithBackup(backup)); err == nil {
+		t.Fatal("expected an error applying a corrupt patch")
+	}
+	check(name, w.Old)
+
+	// File backs up an existing new file
+	oldfile := patchtest.WriteFile(t, dir, "old", w.Old)
+	newfile := patchtest.WriteFile(t, dir, "new", []byte("previous"))
+	patchfile := patchtest.WriteFile(t, dir, "patch", corrupt)
+	if err = bspatch.File(oldfile, newfile, patchfile, bspatch.WithBackup(backup)); err == nil {
+		t.Fatal("expected an error applying a corrupt patch")
+	}
+	check(newfile, []byte("previous"))
+	patchtest.WriteFile(t, dir, "patch", patch)
+	if err = bspatch.File(oldfile, newfile, patchfile, bspatch.WithBackup(backup)); err != nil {
+		t.Fatal(err)
+	}
+	check(newfile, w.New)
+	check(backup, []byte("previous"))
+}
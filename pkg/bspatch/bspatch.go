@@ -27,63 +27,151 @@ package bspatch
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
-	"github.com/dsnet/compress/bzip2"
 	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
 // Bytes applies a patch with the oldfile to create the newfile
-func Bytes(oldfile, patch []byte) (newfile []byte, err error) {
-	var buf util.BufWriter
-	err = patchb(bytes.NewReader(oldfile), bytes.NewReader(patch), &buf)
+func Bytes(oldfile, patch []byte, opts ...Option) (newfile []byte, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(bytes.NewReader(patch), newOptions(opts))
 	if err != nil {
 		return nil, err
 	}
+	var buf util.BufWriter
+	if err = h.patchb(bytes.NewReader(oldfile), bytes.NewReader(patch), &buf); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
+// BytesInto applies a patch with the oldfile and writes the newfile to the
+// start of dst, returning its size, without allocating it. It fails with
+// io.ErrShortBuffer if the newfile doesn't fit.
+func BytesInto(dst, oldfile, patch []byte, opts ...Option) (n int, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(bytes.NewReader(patch), newOptions(opts))
+	if err != nil {
+		return 0, err
+	}
+	if h.magic != magicVCDIFF && h.ext[extZip] == nil && h.newsize > len(dst) {
+		return 0, fmt.Errorf("new file of %v bytes: %w", h.newsize, io.ErrShortBuffer)
+	}
+	w := &sliceWriter{b: dst}
+	if err = h.patch(bytes.NewReader(oldfile), bytes.NewReader(patch), w); err != nil {
+		return 0, err
+	}
+	return w.n, nil
+}
+
+// sliceWriter writes to a slice it doesn't grow
+type sliceWriter struct {
+	b []byte
+	n int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	if len(p) > len(w.b)-w.n {
+		return 0, io.ErrShortBuffer
+	}
+	w.n += copy(w.b[w.n:], p)
+	return len(p), nil
+}
+
 // Reader applies a BSDIFF4 patch (using oldbin and patchf) to create the newbin
-func Reader(oldfile io.ReaderAt, newfile io.WriterAt, patch io.ReaderAt) error {
-	return patchb(oldfile, patch, newfile)
+func Reader(oldfile io.ReaderAt, newfile io.WriterAt, patch io.ReaderAt, opts ...Option) error {
+	return ReaderCtx(context.Background(), oldfile, newfile, patch, opts...)
 }
 
-// File applies a BSDIFF4 patch (using oldfile and patchfile) to create the newfile
-func File(oldfile, newfile, patchfile string) error {
+// ReaderCtx is Reader, stopping with ctx.Err() when ctx is cancelled. BSDIFF
+// patches check ctx between the buffers they apply; VCDIFF deltas aren't
+// cancellable.
+func ReaderCtx(ctx context.Context, oldfile io.ReaderAt, newfile io.WriterAt, patch io.ReaderAt, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	o := newOptions(opts)
+	o.ctx = ctx
+	h, err := readHeader(patch, o)
+	if err != nil {
+		return err
+	}
+	return h.patchb(oldfile, patch, newfile)
+}
+
+// Apply applies a patch (using oldfile and patch) and writes the new file to
+// out in order, so it can be piped to stdout, a socket or a compressor.
+// Reader is faster when the new file can be written at any offset.
+func Apply(oldfile io.ReaderAt, patch io.ReaderAt, out io.Writer, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return err
+	}
+	return h.patch(oldfile, patch, out)
+}
+
+// File applies a BSDIFF4 patch (using oldfile and patchfile) to create the newfile.
+// It fails with ErrAlreadyApplied, leaving newfile alone, if newfile
+// already is the new file.
+func File(oldfile, newfile, patchfile string, opts ...Option) (err error) {
+	defer recoverPanic(&err)
 	oldF, err := os.Open(oldfile)
 	if err != nil {
-		return fmt.Errorf("could not open oldfile '%v': %v", oldfile, err.Error())
+		return fmt.Errorf("could not open oldfile '%v': %w", oldfile, err)
 	}
 	defer oldF.Close()
 	patchF, err := os.Open(patchfile)
 	if err != nil {
-		return fmt.Errorf("could not open patchfile '%v': %v", patchfile, err.Error())
+		return fmt.Errorf("could not open patchfile '%v': %w", patchfile, err)
 	}
 	defer patchF.Close()
+	o := newOptions(opts)
+	h, err := readHeader(patchF, o)
+	if err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if applied, err := h.appliedFile(newfile); err != nil || applied {
+		if applied {
+			err = ErrAlreadyApplied
+		}
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	backedUp := false
+	if o.backup != "" {
+		if _, err := os.Stat(newfile); err == nil {
+			if err = Backup(newfile, o.backup); err != nil {
+				return err
+			}
+			backedUp = true
+		}
+	}
 	newF, err := os.OpenFile(newfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("could not create newfile '%v': %v", newfile, err.Error())
+		return fmt.Errorf("could not create newfile '%v': %w", newfile, err)
 	}
-	err = patchb(oldF, patchF, newF)
+	err = h.patchb(oldF, patchF, newF)
 	_ = newF.Close()
 	if err != nil {
-		os.Remove(newfile)
-		return fmt.Errorf("bspatch: %v", err.Error())
+		if backedUp {
+			os.Rename(o.backup, newfile)
+		} else {
+			os.Remove(newfile)
+		}
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = h.restoreFileInfo(newfile); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
 	}
 	return nil
 }
 
-func patchb(oldfile io.ReaderAt, patch io.ReaderAt, res io.WriterAt) error {
-	var newsize int
-	header := make([]byte, 32)
-	buf := make([]byte, 8)
-	var i int
-	ctrl := make([]int, 3)
-
-	f := io.NewSectionReader(patch, 0, int64(len(header)))
-
+func (h *header) patchb(oldfile io.ReaderAt, patch io.ReaderAt, res io.WriterAt) (err error) {
+	// Recovered here too, so File removes the new file after a panic
+	defer recoverPanic(&err)
 	//	File format:
 	//		0	8	"BSDIFF40"
 	//		8	8	X
@@ -95,143 +183,328 @@ func patchb(oldfile io.ReaderAt, patch io.ReaderAt, res io.WriterAt) error {
 	//	with control block a set of triples (x,y,z) meaning "add x bytes
 	//	from oldfile to x bytes from the diff block; copy y bytes from the
 	//	extra block; seek forwards in oldfile by z bytes".
+	//	BSDIFF4X patches carry an extension area between the header and
+	//	the control block (see header.go). Other magics identify patches
+	//	whose blocks use a different Decompressor. ENDSLEY/BSDIFF43
+	//	patches interleave the three blocks in a single stream. VCDIFF
+	//	deltas (xdelta3) are handed to the VCDIFF decoder.
 
-	// Read header
-	if n, err := f.Read(header); err != nil || n < 32 {
-		if err != nil {
-			return fmt.Errorf("corrupt patch %v", err.Error())
+	if _, ok := res.(*util.BufWriter); ok {
+		// The new file is held in memory
+		if err = h.o.alloc("new file", h.newsize); err != nil {
+			return err
 		}
-		return fmt.Errorf("corrupt patch (n %v < 32)", n)
 	}
-	// Check for appropriate magic
-	if bytes.Compare(header[:8], []byte("BSDIFF40")) != 0 {
-		return fmt.Errorf("corrupt patch (header BSDIFF40)")
+	// Preallocate required space, the rest is written in order. The new
+	// size of zip patches is that of the inflated archive.
+	if h.magic != magicVCDIFF && h.ext[extZip] == nil && h.newsize > 0 {
+		if _, err = res.WriteAt([]byte{0}, int64(h.newsize-1)); err != nil {
+			return err
+		}
 	}
+	return h.patch(oldfile, patch, &offsetWriter{w: res})
+}
 
-	// Read lengths from header
-	bzctrllen := offtin(header[8:])
-	bzdatalen := offtin(header[16:])
-	newsize = offtin(header[24:])
-
-	if bzctrllen < 0 || bzdatalen < 0 || newsize < 0 {
-		return fmt.Errorf("corrupt patch (bzctrllen %v bzdatalen %v newsize %v)", bzctrllen, bzdatalen, newsize)
+// patch writes the new file to w, in order
+func (h *header) patch(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	if err := h.checkOld(oldfile); err != nil {
+		return err
 	}
+	return h.checkNew(w, func(w io.Writer) error {
+		switch {
+		case h.magic == magicVCDIFF:
+			return h.patchVCDIFF(oldfile, patch, w)
+		case h.ext[extZip] != nil:
+			return h.applyZip(oldfile, patch, w)
+		case h.ext[extTar] != nil:
+			return h.applyTar(oldfile, patch, w)
+		case h.ext[extExec] != nil:
+			return h.applyExe(oldfile, patch, w)
+		}
+		return h.apply(oldfile, patch, w)
+	})
+}
 
-	// Close patch file and re-open it via libbzip2 at the right places
-	f = nil
-	cpfbz2, err := bzip2.NewReader(io.NewSectionReader(patch, 32, int64(bzctrllen)), nil)
+func (h *header) apply(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	// Close patch file and re-open it via the decompressor at the right places
+	cpfbz2, dpfbz2, epfbz2, err := h.openBlocks(patch)
 	if err != nil {
 		return err
 	}
-	dpfbz2, err := bzip2.NewReader(io.NewSectionReader(patch, int64(32+bzctrllen), int64(bzdatalen)), nil)
-	if err != nil {
+
+	if err = h.applyBlocks(oldfile, cpfbz2, dpfbz2, epfbz2, w); err != nil {
 		return err
 	}
-	epfbz2, err := bzip2.NewReader(io.NewSectionReader(patch, int64(32+bzctrllen+bzdatalen), 1<<31), nil)
-	if err != nil {
+
+	// Clean up the bzip2 reads
+	if err = cpfbz2.Close(); err != nil {
 		return err
 	}
-
-	// Preallocate required space
-	if _, err = res.WriteAt([]byte{0}, int64(newsize-1)); err != nil {
+	if err = dpfbz2.Close(); err != nil {
 		return err
 	}
+	return epfbz2.Close()
+}
 
-	const readBufSize = 64 * 1024
-	var readBuf, readBufPatch [readBufSize]byte
+// applyBlocks reconstructs the new file from the decompressed ctrl, diff and
+// extra streams. The new file is written strictly in order.
+func (h *header) applyBlocks(oldfile io.ReaderAt, cpfbz2, dpfbz2, epfbz2 io.Reader, w io.Writer) error {
+	buf := make([]byte, 8)
+	var i int
+	var err error
+	ctrl := make([]int, 3)
+	newsize := h.newsize
+
+	readBufSize := h.o.bufSize
+	var readBuf, readBufPatch []byte
+	if len(h.o.buf) >= 2 {
+		// The caller's buffer, see WithBuffer
+		readBufSize = len(h.o.buf) / 2
+		readBuf, readBufPatch = h.o.buf[:readBufSize], h.o.buf[readBufSize:2*readBufSize]
+	} else {
+		if readBufSize <= 0 {
+			return fmt.Errorf("invalid buffer size %v", readBufSize)
+		}
+		if err = h.o.alloc("read buffers", 2*readBufSize); err != nil {
+			return err
+		}
+		defer h.o.free(2 * readBufSize)
+		readBuf, readBufPatch = make([]byte, readBufSize), make([]byte, readBufSize)
+	}
 	newpos := 0
 	oldpos := 0
+	pw := h.o.progressWriter(w, int64(newsize))
+	w = pw
+	start := time.Now()
+	// The number of controls, and the bytes of the diff and extra blocks
+	// read
+	var ctrls int
+	var diffpos, extrapos int64
+	// Resume checkpoints the state of plain patches, and fast-forwards to
+	// it: done is what the control it was taken in had applied
+	done, resumed := 0, false
+	r := h.o.resume
+	if r != nil && !r.plain {
+		r = nil
+	}
+	if r != nil && r.st.newpos > 0 {
+		if err = r.skip(cpfbz2, dpfbz2, epfbz2); err != nil {
+			return err
+		}
+		st := r.st
+		ctrls, newpos, oldpos = int(st.ctrls), int(st.newpos), int(st.oldpos)
+		diffpos, extrapos = st.diffpos, st.extrapos
+		for i = range ctrl {
+			ctrl[i] = int(st.ctrl[i])
+		}
+		done, resumed = int(st.ctrlDone), true
+	}
+	// save records the state after a chunk, before it's written
+	save := func(ctrlDone int) {
+		if r != nil {
+			r.st = applyState{
+				ctrls:    int64(ctrls),
+				ctrl:     [3]int64{int64(ctrl[0]), int64(ctrl[1]), int64(ctrl[2])},
+				ctrlDone: int64(ctrlDone),
+				newpos:   int64(newpos),
+				oldpos:   int64(oldpos),
+				diffpos:  diffpos,
+				extrapos: extrapos,
+			}
+		}
+	}
 
 	for newpos < newsize {
-		// Read control data
-		for i = 0; i <= 2; i++ {
-			lenread, err := io.ReadFull(cpfbz2, buf)
-			if err != nil && err != io.EOF {
-				e0 := ""
+		if err = h.o.ctx.Err(); err != nil {
+			return err
+		}
+		if resumed {
+			// The control the checkpoint was taken in
+			resumed = false
+		} else {
+			done = 0
+			// Read control data
+			for i = 0; i <= 2; i++ {
+				lenread, err := io.ReadFull(cpfbz2, buf)
 				if err != nil {
-					e0 = err.Error()
+					e0 := ""
+					if err != nil {
+						e0 = err.Error()
+					}
+					return patchErrorf(ErrCorruptPatch, SectionCtrl, int64(24*ctrls+8*i+lenread), err, "corrupt patch or bzstream ended: %s (read: %v/8)", e0, lenread)
 				}
-				return fmt.Errorf("corrupt patch or bzstream ended: %s (read: %v/8)", e0, lenread)
+				ctrl[i] = offtin(buf)
 			}
-			ctrl[i] = offtin(buf)
-		}
-		// Sanity-check
-		if newpos+ctrl[0] > newsize {
-			return fmt.Errorf("corrupt patch (sanity check)")
+			// Sanity-check
+			if ctrl[0] < 0 || ctrl[1] < 0 || ctrl[2] < -maxOffset || ctrl[2] > maxOffset {
+				return patchErrorf(ErrCorruptPatch, SectionCtrl, int64(24*ctrls), nil, "corrupt patch (sanity check)")
+			}
+			if newpos+ctrl[0] > newsize {
+				return patchErrorf(ErrSizeMismatch, SectionCtrl, int64(24*ctrls), nil, "corrupt patch (sanity check)")
+			}
+			ctrls++
 		}
 
-		for i = 0; i < ctrl[0]; i += readBufSize {
+		for i = done; i < ctrl[0]; i += readBufSize {
 			readSize := ctrl[0] - i
 			if readSize > readBufSize {
 				readSize = readBufSize
 			}
+			if err = h.o.ctx.Err(); err != nil {
+				return err
+			}
 
 			// Read diff string
 			// lenread, err = dpfbz2.Read(pnew[newpos : newpos+ctrl[0]])
 			_, err = io.ReadFull(dpfbz2, readBufPatch[:readSize])
-			if err != nil && err != io.EOF {
+			if err != nil {
 				e0 := ""
 				if err != nil {
 					e0 = err.Error()
 				}
-				return fmt.Errorf("corrupt patch or bzstream ended (2): %s", e0)
+				return patchErrorf(ErrCorruptPatch, SectionDiff, diffpos, err, "corrupt patch or bzstream ended (2): %s", e0)
 			}
 
 			// Add pold data to diff string
 			n, _ := oldfile.ReadAt(readBuf[:readSize], int64(oldpos))
-			for j := 0; j < n; j++ {
-				readBufPatch[j] += readBuf[j]
-			}
+			util.AddBytes(readBufPatch, readBuf[:n])
 
-			if _, err = res.WriteAt(readBufPatch[:readSize], int64(newpos)); err != nil {
-				return err
-			}
 			newpos += readSize
 			oldpos += readSize
+			diffpos += int64(readSize)
+			save(i + readSize)
+			if _, err = w.Write(readBufPatch[:readSize]); err != nil {
+				return err
+			}
 		}
 
 		// Sanity-check
 		if newpos+ctrl[1] > newsize {
-			return fmt.Errorf("corrupt patch newpos+ctrl[1] newsize")
+			return patchErrorf(ErrSizeMismatch, SectionCtrl, int64(24*ctrls-16), nil, "corrupt patch newpos+ctrl[1] newsize")
 		}
 
 		// Read extra string
 		// epfbz2.Read was not reading all the requested bytes, probably an internal buffer limitation ?
 		// it was encapsulated by zreadall to work around the issue
-		for i = 0; i < ctrl[1]; i += readBufSize {
+		i = 0
+		if done > ctrl[0] {
+			i = done - ctrl[0]
+		}
+		for ; i < ctrl[1]; i += readBufSize {
 			readSize := ctrl[1] - i
 			if readSize > readBufSize {
 				readSize = readBufSize
 			}
-			if _, err = io.ReadFull(epfbz2, readBuf[:readSize]); err != nil && err != io.EOF {
+			if err = h.o.ctx.Err(); err != nil {
+				return err
+			}
+			if _, err = io.ReadFull(epfbz2, readBuf[:readSize]); err != nil {
 				e0 := ""
 				if err != nil {
 					e0 = err.Error()
 				}
-				return fmt.Errorf("corrupt patch or bzstream ended (3): %s", e0)
-			}
-			if _, err = res.WriteAt(readBuf[:readSize], int64(newpos)); err != nil {
-				return err
+				return patchErrorf(ErrCorruptPatch, SectionExtra, extrapos, err, "corrupt patch or bzstream ended (3): %s", e0)
 			}
 			newpos += readSize
 			oldpos += readSize
+			extrapos += int64(readSize)
+			save(ctrl[0] + i + readSize)
+			if _, err = w.Write(readBuf[:readSize]); err != nil {
+				return err
+			}
 		}
 		// Adjust pointers
 		oldpos += ctrl[2] - ctrl[1]
+		if oldpos < -maxOffset || oldpos > maxOffset {
+			return patchErrorf(ErrCorruptPatch, SectionCtrl, int64(24*ctrls-8), nil, "corrupt patch (old position %v)", oldpos)
+		}
 	}
-
-	// Clean up the bzip2 reads
-	if err = cpfbz2.Close(); err != nil {
+	if err = h.o.checkTrailing([3]io.Reader{cpfbz2, dpfbz2, epfbz2}, [3]int64{int64(24 * ctrls), diffpos, extrapos}); err != nil {
 		return err
 	}
-	if err = dpfbz2.Close(); err != nil {
-		return err
+	pw.done()
+	if s := h.o.stats; s != nil {
+		s.Controls += ctrls
+		s.Matched += diffpos
+		s.Extra += extrapos
+		s.ApplyTime += time.Since(start)
 	}
-	if err = epfbz2.Close(); err != nil {
-		return err
+	return nil
+}
+
+// openBlocks returns readers of the decompressed ctrl, diff and extra
+// blocks. The three are the same stream in an Endsley patch.
+func (h *header) openBlocks(patch io.ReaderAt) (ctrl, diff, extra io.ReadCloser, err error) {
+	off := h.blockoff
+	if h.magic == magicEndsley {
+		if ctrl, err = h.newReader(0, io.NewSectionReader(patch, int64(off), 1<<62)); err != nil {
+			return nil, nil, nil, err
+		}
+		diff = io.NopCloser(ctrl)
+		return ctrl, diff, diff, nil
+	}
+	extralen, err := h.checkBlocks(patch)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var blocks [3]io.ReadCloser
+	starts := [3]int64{int64(off), int64(off + h.ctrllen), int64(off + h.ctrllen + h.datalen)}
+	lens := [3]int64{int64(h.ctrllen), int64(h.datalen), extralen}
+	for i := range blocks {
+		// Checksums are checked before anything is decompressed
+		if err = h.checkBlock(i, io.NewSectionReader(patch, starts[i], lens[i])); err != nil {
+			return nil, nil, nil, err
+		}
 	}
+	for i := range blocks {
+		if lens[i] == 0 {
+			// A stripped empty block, see bsdiff.WithStripEmptyBlocks
+			blocks[i] = io.NopCloser(bytes.NewReader(nil))
+			continue
+		}
+		if blocks[i], err = h.newReader(i, io.NewSectionReader(patch, starts[i], lens[i])); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return blocks[0], blocks[1], blocks[2], nil
+}
 
-	return nil
+// checkBlocks checks that the ctrl and diff blocks lie within patch, and
+// returns the length of the extra block, the rest of the patch. When the
+// size of patch isn't known, the last byte of each block is read instead and
+// the extra block extends to the end of patch.
+func (h *header) checkBlocks(patch io.ReaderAt) (int64, error) {
+	ctrlend := int64(h.blockoff + h.ctrllen)
+	diffend := ctrlend + int64(h.datalen)
+	if size := patchSize(patch); size >= 0 {
+		if ctrlend > size {
+			return 0, patchErrorf(ErrCorruptPatch, SectionCtrl, size-int64(h.blockoff), nil, "corrupt patch (ctrl block ends at %v, past the end of the patch at %v)", ctrlend, size)
+		}
+		if diffend > size {
+			return 0, patchErrorf(ErrCorruptPatch, SectionDiff, size-ctrlend, nil, "corrupt patch (diff block ends at %v, past the end of the patch at %v)", diffend, size)
+		}
+		return size - diffend, nil
+	}
+	b := make([]byte, 1)
+	for i, end := range []int64{ctrlend, diffend} {
+		if n, _ := patch.ReadAt(b, end-1); n == 0 && end > int64(h.blockoff) {
+			return 0, patchErrorf(ErrCorruptPatch, []string{SectionCtrl, SectionDiff}[i], -1, nil, "corrupt patch (%v block ends at %v, past the end of the patch)", blockNames[i], end)
+		}
+	}
+	return 1 << 62, nil
+}
+
+// patchSize returns the size of patch, or -1 if it isn't known
+func patchSize(patch io.ReaderAt) int64 {
+	switch p := patch.(type) {
+	case interface{ Size() int64 }:
+		return p.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := p.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
 }
 
 // offtin reads an int64 (little endian)
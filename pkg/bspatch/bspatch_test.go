@@ -3,11 +3,18 @@ package bspatch
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/adler32"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/gabstv/go-bsdiff/internal/testdata"
 	"github.com/gabstv/go-bsdiff/pkg/util"
 )
 
@@ -254,3 +261,521 @@ func TestCorruptHeader(t *testing.T) {
 		t.Fatal("header should be corrupt (6)")
 	}
 }
+
+// extPatch converts a BSDIFF40 patch into a BSDIFF4X patch carrying ext
+func extPatch(patch, ext []byte) []byte {
+	out := make([]byte, 40, 40+len(ext)+len(patch))
+	copy(out, patch[:32])
+	copy(out, magicExtended)
+	binary.LittleEndian.PutUint64(out[32:], uint64(len(ext)))
+	out = append(out, ext...)
+	return append(out, patch[32:]...)
+}
+
+func TestFileInfo(t *testing.T) {
+	oldfile := []byte{
+		0x66, 0xFF, 0xD1, 0x55, 0x56, 0x10, 0x30, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xD1,
+	}
+	patchfile := []byte{
+		0x42, 0x53, 0x44, 0x49, 0x46, 0x46, 0x34, 0x30,
+		0x29, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x2A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x42, 0x5A, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26,
+		0x53, 0x59, 0xDA, 0xE4, 0x46, 0xF2, 0x00, 0x00,
+		0x05, 0xC0, 0x00, 0x4A, 0x09, 0x20, 0x00, 0x22,
+		0x34, 0xD9, 0x06, 0x06, 0x4B, 0x21, 0xEE, 0x17,
+		0x72, 0x45, 0x38, 0x50, 0x90, 0xDA, 0xE4, 0x46,
+		0xF2, 0x42, 0x5A, 0x68, 0x39, 0x31, 0x41, 0x59,
+		0x26, 0x53, 0x59, 0x30, 0x88, 0x1C, 0x89, 0x00,
+		0x00, 0x02, 0xC4, 0x00, 0x44, 0x00, 0x06, 0x00,
+		0x20, 0x00, 0x21, 0x21, 0xA0, 0xC3, 0x1B, 0x03,
+		0x3C, 0x5D, 0xC9, 0x14, 0xE1, 0x42, 0x40, 0xC2,
+		0x20, 0x72, 0x24, 0x42, 0x5A, 0x68, 0x39, 0x31,
+		0x41, 0x59, 0x26, 0x53, 0x59, 0x65, 0x25, 0x30,
+		0x43, 0x00, 0x00, 0x00, 0x40, 0x02, 0xC0, 0x00,
+		0x20, 0x00, 0x00, 0x00, 0xA0, 0x00, 0x22, 0x1F,
+		0xA4, 0x19, 0x82, 0x58, 0x5D, 0xC9, 0x14, 0xE1,
+		0x42, 0x41, 0x94, 0x94, 0xC1, 0x0C,
+	}
+	mtime := time.Date(2017, 5, 3, 12, 0, 0, 0, time.UTC)
+	var ext []byte
+	ext = append(ext, 4)
+	ext = append(ext, extMode...)
+	ext = append(ext, 8, 0x80, 0x01, 0, 0, 0, 0, 0, 0) // 0600
+	ext = append(ext, 5)
+	ext = append(ext, extMtime...)
+	ext = append(ext, 8)
+	ext = append(ext, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(ext[len(ext)-8:], uint64(mtime.UnixNano()))
+	patchfile = extPatch(patchfile, ext)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldn := filepath.Join(dir, "old")
+	newn := filepath.Join(dir, "new")
+	patchn := filepath.Join(dir, "patch")
+	if err = ioutil.WriteFile(oldn, oldfile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(patchn, patchfile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fileInfoSupported {
+		if err = File(oldn, newn, patchn); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(newn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Fatal("mode", fi.Mode().Perm(), "!=", os.FileMode(0600))
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Fatal("mtime", fi.ModTime(), "!=", mtime)
+		}
+		os.Remove(newn)
+	}
+	// unsupported platforms ignore the recorded attributes
+	supported := fileInfoSupported
+	fileInfoSupported = false
+	defer func() {
+		fileInfoSupported = supported
+	}()
+	if err = File(oldn, newn, patchn); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(newn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ModTime().Equal(mtime) {
+		t.Fatal("mtime should not be restored")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	patch := []byte{
+		0x42, 0x53, 0x44, 0x49, 0x46, 0x46, 0x34, 0x30,
+		0x29, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x2A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	f, err := Detect(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != FormatBzip2 {
+		t.Fatal(f, "!=", FormatBzip2)
+	}
+	for _, magic := range []Format{FormatZstd, FormatXz, FormatRaw, FormatBrotli} {
+		copy(patch, magic)
+		if f, err = Detect(bytes.NewReader(patch)); err != nil {
+			t.Fatal(err)
+		}
+		if f != magic {
+			t.Fatal(f, "!=", magic)
+		}
+	}
+	ext := append([]byte{5}, extCodec...)
+	ext = append(ext, 8)
+	ext = append(ext, FormatZstd...)
+	copy(patch, magicBSDIFF40)
+	if f, err = Detect(bytes.NewReader(extPatch(patch, ext))); err != nil {
+		t.Fatal(err)
+	}
+	if f != FormatZstd {
+		t.Fatal(f, "!=", FormatZstd)
+	}
+	copy(patch, "BSDIFF99")
+	if _, err = Detect(bytes.NewReader(patch)); err == nil {
+		t.Fatal("unknown magic should fail")
+	}
+}
+
+func TestStdBzip2(t *testing.T) {
+	oldfile := []byte{
+		0x66, 0xFF, 0xD1, 0x55, 0x56, 0x10, 0x30, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xD1,
+	}
+	newfilecomp := []byte{
+		0x66, 0xFF, 0xD1, 0x55, 0x56, 0x10, 0x30, 0x00,
+		0x44, 0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xD1, 0xFF, 0xD1,
+	}
+	patchfile := []byte{
+		0x42, 0x53, 0x44, 0x49, 0x46, 0x46, 0x34, 0x30,
+		0x29, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x2A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x42, 0x5A, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26,
+		0x53, 0x59, 0xDA, 0xE4, 0x46, 0xF2, 0x00, 0x00,
+		0x05, 0xC0, 0x00, 0x4A, 0x09, 0x20, 0x00, 0x22,
+		0x34, 0xD9, 0x06, 0x06, 0x4B, 0x21, 0xEE, 0x17,
+		0x72, 0x45, 0x38, 0x50, 0x90, 0xDA, 0xE4, 0x46,
+		0xF2, 0x42, 0x5A, 0x68, 0x39, 0x31, 0x41, 0x59,
+		0x26, 0x53, 0x59, 0x30, 0x88, 0x1C, 0x89, 0x00,
+		0x00, 0x02, 0xC4, 0x00, 0x44, 0x00, 0x06, 0x00,
+		0x20, 0x00, 0x21, 0x21, 0xA0, 0xC3, 0x1B, 0x03,
+		0x3C, 0x5D, 0xC9, 0x14, 0xE1, 0x42, 0x40, 0xC2,
+		0x20, 0x72, 0x24, 0x42, 0x5A, 0x68, 0x39, 0x31,
+		0x41, 0x59, 0x26, 0x53, 0x59, 0x65, 0x25, 0x30,
+		0x43, 0x00, 0x00, 0x00, 0x40, 0x02, 0xC0, 0x00,
+		0x20, 0x00, 0x00, 0x00, 0xA0, 0x00, 0x22, 0x1F,
+		0xA4, 0x19, 0x82, 0x58, 0x5D, 0xC9, 0x14, 0xE1,
+		0x42, 0x41, 0x94, 0x94, 0xC1, 0x0C,
+	}
+	newfile, err := Bytes(oldfile, patchfile, WithDecompressor(StdBzip2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newfile, newfilecomp) {
+		t.Fatal("expected:", newfilecomp, "got:", newfile)
+	}
+}
+
+func TestVCDIFF(t *testing.T) {
+	// a hand-assembled delta with the xdelta3 extensions: application
+	// header and window checksum.
+	// source "abcdefgh", target "abcdXXabcdabcdZ"
+	target := []byte("abcdXXabcdabcdZ")
+	sum := adler32.Checksum(target)
+	patch := []byte{
+		0xD6, 0xC3, 0xC4, 0x00, 0x04, // header, VCD_APPHEADER
+		3, 'o', '/', 'n', // application header
+		0x05, 8, 0, // VCD_SOURCE|VCD_ADLER32, source segment
+		18,      // delta encoding length
+		15,      // target window length
+		0,       // delta indicator
+		3, 3, 3, // data, instructions and addresses lengths
+		byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum),
+		'X', 'X', 'Z', // data
+		20, 163 + 3, 247 + 1, // instructions
+		0, 0, 4, // addresses
+	}
+	f, err := Detect(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != FormatVCDIFF {
+		t.Fatal(f, "!=", FormatVCDIFF)
+	}
+	got, err := Bytes([]byte("abcdefgh"), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Fatal(string(got))
+	}
+	patch[17]++ // checksum
+	if _, err = Bytes([]byte("abcdefgh"), patch); err == nil {
+		t.Fatal("expected a checksum error")
+	}
+}
+
+func TestXdelta3(t *testing.T) {
+	// made by xdelta3 3.1.0 from testdata.Executable(1 << 16):
+	//
+	//	xdelta3 -e -s old new default.vcdiff
+	//	xdelta3 -e -W 16384 -s old new windows.vcdiff
+	//	xdelta3 -e -S djw -W 16384 -s old new djw.vcdiff
+	w := testdata.Executable(1 << 16)
+	for _, name := range []string{"default", "windows", "djw"} {
+		patch, err := ioutil.ReadFile(filepath.Join("testdata", "xdelta3", name+".vcdiff"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Bytes(w.Old, patch)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(got, w.New) {
+			t.Fatal(name, "new file mismatch")
+		}
+	}
+}
+
+func TestBSDF2Header(t *testing.T) {
+	patch := make([]byte, 32)
+	copy(patch, "BSDF2\x01\x02\x00")
+	h, err := readHeader(bytes.NewReader(patch), newOptions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{magicBSDIFF40, magicBrotli, magicRaw} {
+		if h.codecs[i].Magic() != want {
+			t.Fatal(blockNames[i], h.codecs[i].Magic(), "!=", want)
+		}
+	}
+	patch[6] = 3
+	if _, err = readHeader(bytes.NewReader(patch), newOptions(nil)); err == nil {
+		t.Fatal("expected an unsupported compression type error")
+	}
+}
+
+func TestHDiffPatch(t *testing.T) {
+	patch := make([]byte, 32)
+	copy(patch, "HDIFFSF20&zlib\x00")
+	_, err := Bytes(nil, patch)
+	if err == nil || err.Error() != `unsupported patch format "HDIFFSF20" (HDiffPatch)` {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// A BSDIFF40 header declaring a 1 TiB new file
+	huge := make([]byte, 32)
+	copy(huge, "BSDIFF40")
+	huge[24+5] = 1
+	_, err := Bytes(nil, huge, WithMemoryLimit(1<<20))
+	var me *MemoryLimitError
+	if !errors.As(err, &me) || me.What != "new file" || me.Need != 1<<40 {
+		t.Fatal("expected a memory limit error for the new file, got", err)
+	}
+
+	// ApplyStream stages a 1 TiB ctrl block
+	copy(huge[8:], []byte{0, 0, 0, 0, 0, 1, 0, 0})
+	err = ApplyStream(bytes.NewReader(nil), bytes.NewReader(huge), io.Discard, WithMemoryLimit(1<<20))
+	if !errors.As(err, &me) || me.What != "ctrl block" {
+		t.Fatal("expected a memory limit error for the ctrl block, got", err)
+	}
+
+	// A 1 TiB extension area
+	ext := make([]byte, 40)
+	copy(ext, "BSDIFF4X")
+	ext[32+5] = 1
+	if _, err = Metadata(bytes.NewReader(ext), WithMemoryLimit(1<<20)); !errors.As(err, &me) {
+		t.Fatal("expected a memory limit error for the extension area, got", err)
+	}
+	err = ApplyStream(bytes.NewReader(nil), bytes.NewReader(ext), io.Discard, WithMemoryLimit(1<<20))
+	if !errors.As(err, &me) {
+		t.Fatal("expected a memory limit error for the extension area, got", err)
+	}
+
+	// A VCDIFF window of 64 MiB
+	var delta bytes.Buffer
+	// magic, header indicator, window indicator
+	delta.Write([]byte{0xd6, 0xc3, 0xc4, 0x00, 0x00, 0x00})
+	// delta encoding length
+	delta.Write([]byte{0x9f, 0xff, 0xff, 0x7f})
+	err = Apply(bytes.NewReader(nil), bytes.NewReader(delta.Bytes()), io.Discard, WithMemoryLimit(1<<20))
+	if !errors.As(err, &me) || me.What != "VCDIFF window" {
+		t.Fatal("expected a memory limit error for the VCDIFF window, got", err)
+	}
+}
+
+// offtout writes x in the sign-magnitude encoding of offtin
+func offtout(x int, buf []byte) {
+	u := uint64(x)
+	if x < 0 {
+		u = uint64(-x) | 1<<63
+	}
+	binary.LittleEndian.PutUint64(buf, u)
+}
+
+// rawPatch returns a BSDIFRW0 patch of the controls, whose diff and extra
+// bytes are zeros
+func rawPatch(newsize int, ctrls ...[3]int) []byte {
+	var ctrl, diff, extra []byte
+	for _, c := range ctrls {
+		buf := make([]byte, 24)
+		for i, x := range c {
+			offtout(x, buf[8*i:])
+		}
+		ctrl = append(ctrl, buf...)
+		if c[0] > 0 {
+			diff = append(diff, make([]byte, c[0])...)
+		}
+		if c[1] > 0 {
+			extra = append(extra, make([]byte, c[1])...)
+		}
+	}
+	patch := make([]byte, 32)
+	copy(patch, magicRaw)
+	offtout(len(ctrl), patch[8:])
+	offtout(len(diff), patch[16:])
+	offtout(newsize, patch[24:])
+	patch = append(patch, ctrl...)
+	patch = append(patch, diff...)
+	return append(patch, extra...)
+}
+
+// sizelessReader hides the Size method of a bytes.Reader
+type sizelessReader struct {
+	r io.ReaderAt
+}
+
+func (s sizelessReader) ReadAt(p []byte, off int64) (int, error) {
+	return s.r.ReadAt(p, off)
+}
+
+func TestHardening(t *testing.T) {
+	old := make([]byte, 16)
+	if _, err := Bytes(old, rawPatch(10, [3]int{4, 6, 0})); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := rawPatch(1<<40, [3]int{4, 6, 0})
+	var out util.BufWriter
+	if err := Reader(bytes.NewReader(old), &out, bytes.NewReader(patch), WithMaxNewSize(1<<20)); !errors.Is(err, ErrTooLarge) || out.Len() != 0 {
+		t.Fatal("expected a too large error before writing, got", err, out.Len())
+	}
+
+	cut := rawPatch(10, [3]int{4, 6, 0})
+	cut = cut[:32+24+2]
+	for _, r := range []io.ReaderAt{bytes.NewReader(cut), sizelessReader{bytes.NewReader(cut)}} {
+		err := Apply(bytes.NewReader(old), r, io.Discard)
+		var pe *PatchError
+		if !errors.As(err, &pe) || pe.Kind != ErrCorruptPatch || pe.Section != SectionDiff {
+			t.Fatal("expected a corrupt diff block error, got", err)
+		}
+	}
+
+	for _, c := range []struct {
+		name  string
+		patch []byte
+	}{
+		{"huge new size", rawPatch(1<<61, [3]int{4, 6, 0})},
+		{"negative diff length", rawPatch(10, [3]int{-1, 0, 0})},
+		{"negative extra length", rawPatch(10, [3]int{0, -1, 0})},
+		{"huge seek", rawPatch(10, [3]int{4, 0, 1 << 62}, [3]int{6, 0, 0})},
+		{"seek overflow", rawPatch(10, [3]int{1, 0, 1 << 60}, [3]int{1, 0, 1 << 60}, [3]int{8, 0, 0})},
+		{"missing controls", rawPatch(10, [3]int{4, 0, 0})},
+	} {
+		if _, err := Bytes(old, c.patch); !errors.Is(err, ErrCorruptPatch) {
+			t.Fatal(c.name, ": expected a corrupt patch error, got", err)
+		}
+	}
+
+	// Lengths the patch doesn't hold aren't allocated up front
+	huge := rawPatch(1<<40, [3]int{1, 0, 0})
+	offtout(1<<39, huge[32:])
+	if err := Scan(bytes.NewReader(huge), func(Control) error { return nil }); !errors.Is(err, ErrCorruptPatch) {
+		t.Fatal("expected a corrupt diff block error from Scan, got", err)
+	}
+	ext := make([]byte, 40)
+	copy(ext, magicExtended)
+	offtout(1<<50, ext[32:])
+	if _, err := Metadata(bytes.NewReader(ext)); !errors.Is(err, ErrCorruptPatch) {
+		t.Fatal("expected a corrupt extension area error, got", err)
+	}
+}
+
+func TestScanTransforms(t *testing.T) {
+	patch := rawPatch(10, [3]int{10, 0, 0})
+	for _, key := range []string{extExec, extTar, extZip} {
+		ext := append([]byte{byte(len(key))}, key...)
+		ext = append(ext, 1, 0)
+		ext = append(ext, byte(len(extCodec)))
+		ext = append(ext, extCodec...)
+		ext = append(ext, byte(len(FormatRaw)))
+		ext = append(ext, FormatRaw...)
+		err := Scan(bytes.NewReader(extPatch(patch, ext)), func(Control) error { return nil })
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Fatal(key, ": expected an unsupported format error, got", err)
+		}
+		var pe *PatchError
+		if !errors.As(err, &pe) || pe.Section != SectionExtension {
+			t.Fatal(key, ": expected an extension error, got", err)
+		}
+	}
+}
+
+// panicDecompressor panics reading the blocks of BSDIFPNC patches
+type panicDecompressor struct{}
+
+func (panicDecompressor) Magic() string {
+	return "BSDIFPNC"
+}
+
+func (panicDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	var b []byte
+	_ = b[len(b)]
+	return nil, nil
+}
+
+func TestPanic(t *testing.T) {
+	old := make([]byte, 16)
+	patch := rawPatch(10, [3]int{4, 6, 0})
+	copy(patch, panicDecompressor{}.Magic())
+	dir := t.TempDir()
+	oldn, patchn, newn := filepath.Join(dir, "old"), filepath.Join(dir, "patch"), filepath.Join(dir, "new")
+	if err := os.WriteFile(oldn, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(patchn, patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, apply := range []func() error{
+		func() error { _, err := Bytes(old, patch, WithDecompressor(panicDecompressor{})); return err },
+		func() error {
+			return ApplyStream(bytes.NewReader(old), bytes.NewReader(patch), io.Discard, WithDecompressor(panicDecompressor{}))
+		},
+		func() error {
+			return Scan(bytes.NewReader(patch), func(Control) error { return nil }, WithDecompressor(panicDecompressor{}))
+		},
+		func() error { return File(oldn, newn, patchn, WithDecompressor(panicDecompressor{})) },
+	} {
+		err := apply()
+		var pe *util.PanicError
+		var re runtime.Error
+		if !errors.Is(err, ErrCorruptPatch) || !errors.As(err, &pe) || !errors.As(err, &re) {
+			t.Fatal("expected a recovered panic, got", err)
+		}
+	}
+	if _, err := os.Stat(newn); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("File left the new file behind:", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	old := make([]byte, 16)
+	trailingExtra := append(rawPatch(10, [3]int{4, 6, 0}), 1, 2, 3)
+	// A control past the end of the new file
+	trailingCtrl := rawPatch(10, [3]int{4, 6, 0}, [3]int{0, 0, 0})
+	for _, c := range []struct {
+		patch   []byte
+		section string
+	}{{trailingExtra, SectionExtra}, {trailingCtrl, SectionCtrl}} {
+		if _, err := Bytes(old, c.patch); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyStream(bytes.NewReader(old), bytes.NewReader(c.patch), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Bytes(old, c.patch, WithStrict())
+		var pe *PatchError
+		if !errors.As(err, &pe) || pe.Kind != ErrCorruptPatch || pe.Section != c.section {
+			t.Fatal("expected trailing data in the", c.section, "block, got", err)
+		}
+		err = ApplyStream(bytes.NewReader(old), bytes.NewReader(c.patch), io.Discard, WithStrict())
+		if !errors.As(err, &pe) || pe.Section != c.section {
+			t.Fatal("expected trailing data in the", c.section, "block from ApplyStream, got", err)
+		}
+		if err = Scan(bytes.NewReader(c.patch), func(Control) error { return nil }, WithStrict()); !errors.Is(err, ErrCorruptPatch) {
+			t.Fatal("expected trailing data from Scan, got", err)
+		}
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	patch := rawPatch(10, [3]int{4, 6, 0})
+	h, err := ReadHeader(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Format != FormatRaw || h.NewSize != 10 || h.BlockOffset != 32 || h.CtrlSize != 24 || h.DiffSize != 4 || h.ExtraSize != 6 || h.Codecs[2] != FormatRaw {
+		t.Fatalf("unexpected header %+v", h)
+	}
+	if h, err = ReadHeader(sizelessReader{bytes.NewReader(patch)}); err != nil || h.ExtraSize != -1 {
+		t.Fatal("expected an unknown extra block size, got", h, err)
+	}
+	if _, err = ReadHeader(bytes.NewReader(patch[:40])); !errors.Is(err, ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error, got", err)
+	}
+}
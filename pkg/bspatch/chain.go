@@ -0,0 +1,60 @@
+package bspatch
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// chainSpillLimit is how much of each intermediate file of Chain is kept in
+// memory before spilling to a temporary file
+const chainSpillLimit = 64 << 20
+
+// Chain applies patches in order, the first to oldfile and each next one to
+// the result of the previous one, and writes the last result to out, so a
+// client several versions behind catches up at once. The intermediate files
+// are buffered in memory up to 64 MiB, in temporary files beyond.
+//
+// opts apply to every patch, except that WithOldSHA256 checks oldfile only
+// and WithNewSHA256 the last result only. Progress and statistics are
+// reported for each patch in turn.
+func Chain(oldfile io.ReaderAt, out io.Writer, patches []io.ReaderAt, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	if len(patches) == 0 {
+		return fmt.Errorf("bspatch: no patches to chain")
+	}
+	cur := oldfile
+	var prev *util.SpillWriter
+	defer func() {
+		if prev != nil {
+			prev.Close()
+		}
+	}()
+	last := len(patches) - 1
+	for i, patch := range patches {
+		popts := opts[:len(opts):len(opts)]
+		if i > 0 {
+			popts = append(popts, WithOldSHA256(nil))
+		}
+		if i < last {
+			popts = append(popts, WithNewSHA256(nil))
+		}
+		if i == last {
+			if err = Apply(cur, patch, out, popts...); err != nil {
+				return fmt.Errorf("patch %v of %v: %w", i+1, len(patches), err)
+			}
+			break
+		}
+		next := util.NewSpillWriter(chainSpillLimit)
+		if err = Apply(cur, patch, next, popts...); err != nil {
+			next.Close()
+			return fmt.Errorf("patch %v of %v: %w", i+1, len(patches), err)
+		}
+		if prev != nil {
+			prev.Close()
+		}
+		prev, cur = next, next
+	}
+	return nil
+}
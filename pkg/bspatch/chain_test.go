@@ -0,0 +1,57 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestChain(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	v1, v2 := w.Old, w.New
+	v3 := append(append([]byte{}, v2[1000:]...), v2[:1000]...)
+	v4 := append([]byte("v4"), v3...)
+	var patches []io.ReaderAt
+	for _, v := range [][2][]byte{{v1, v2}, {v2, v3}, {v3, v4}} {
+		patch, err := bsdiff.Bytes(v[0], v[1], bsdiff.WithHashes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		patches = append(patches, bytes.NewReader(patch))
+	}
+	sum1, sum4 := sha256.Sum256(v1), sha256.Sum256(v4)
+	var out bytes.Buffer
+	if err := bspatch.Chain(bytes.NewReader(v1), &out, patches, bspatch.WithOldSHA256(sum1[:]), bspatch.WithNewSHA256(sum4[:])); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), v4) {
+		t.Fatal("chained patches made the wrong file")
+	}
+	out.Reset()
+	if err := bspatch.Chain(bytes.NewReader(v1), &out, patches[:1]); err != nil || !bytes.Equal(out.Bytes(), v2) {
+		t.Fatal("a single patch made the wrong file", err)
+	}
+
+	// The digests are those of the first and last files
+	if err := bspatch.Chain(bytes.NewReader(v1), io.Discard, patches, bspatch.WithOldSHA256(sum4[:])); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error, got", err)
+	}
+	if err := bspatch.Chain(bytes.NewReader(v1), io.Discard, patches, bspatch.WithNewSHA256(sum1[:])); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error, got", err)
+	}
+	// Out of order, the patches' recorded digests don't match
+	err := bspatch.Chain(bytes.NewReader(v1), io.Discard, []io.ReaderAt{patches[0], patches[2], patches[1]})
+	if !errors.Is(err, bspatch.ErrWrongOld) || !strings.Contains(err.Error(), "patch 2 of 3") {
+		t.Fatal("expected the second patch to fail, got", err)
+	}
+	if err = bspatch.Chain(bytes.NewReader(v1), io.Discard, nil); err == nil {
+		t.Fatal("expected an error without patches")
+	}
+}
@@ -0,0 +1,79 @@
+package bspatch
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// extCRC + "." + block name is the CRC-32C of a compressed block
+const extCRC = "crc32c"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// blockCRC returns the CRC-32C recorded for block i, if any
+func (h *header) blockCRC(i int) (uint32, bool, error) {
+	v, ok := h.ext[extCRC+"."+blockNames[i]]
+	if !ok {
+		return 0, false, nil
+	}
+	if len(v) != 4 {
+		return 0, false, patchErrorf(ErrCorruptPatch, SectionExtension, 40, nil, "corrupt patch (%v block checksum is %v bytes)", blockNames[i], len(v))
+	}
+	return binary.LittleEndian.Uint32(v), true, nil
+}
+
+// checkBlock compares the CRC-32C of block i, read from r, with the one the
+// patch records, if any
+func (h *header) checkBlock(i int, r io.Reader) error {
+	want, ok, err := h.blockCRC(i)
+	if err != nil || !ok {
+		return err
+	}
+	c := crc32.New(castagnoli)
+	if _, err = io.Copy(c, r); err != nil {
+		return err
+	}
+	return checkCRC(i, c, want)
+}
+
+func checkCRC(i int, c hash.Hash32, want uint32) error {
+	if got := c.Sum32(); got != want {
+		return patchErrorf(ErrChecksum, blockSections[i], -1, nil, "corrupt patch (%v block CRC-32C %08x, expected %08x)", blockNames[i], got, want)
+	}
+	return nil
+}
+
+// blockSections are the sections of the ctrl, diff and extra blocks
+var blockSections = [3]string{SectionCtrl, SectionDiff, SectionExtra}
+
+// crcReader hashes what's read from r, and checks the CRC-32C of block i
+// once r is exhausted
+type crcReader struct {
+	r    io.Reader
+	c    hash.Hash32
+	i    int
+	want uint32
+}
+
+// checkedBlock returns r, checking the CRC-32C of block i as it's read if the
+// patch records one
+func (h *header) checkedBlock(i int, r io.Reader) (io.Reader, error) {
+	want, ok, err := h.blockCRC(i)
+	if err != nil || !ok {
+		return r, err
+	}
+	return &crcReader{r: r, c: crc32.New(castagnoli), i: i, want: want}, nil
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.c.Write(p[:n])
+	if err == io.EOF {
+		if cerr := checkCRC(cr.i, cr.c, cr.want); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
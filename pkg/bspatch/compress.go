@@ -0,0 +1,57 @@
+package bspatch
+
+import (
+	"compress/bzip2"
+	"io"
+)
+
+// Decompressor decompresses the ctrl, diff and extra blocks of a patch
+type Decompressor interface {
+	// Magic returns the 8 byte patch magic of the patches it can read
+	Magic() string
+	// NewReader returns a reader decompressing from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// StdBzip2 decompresses BSDIFF40 patches with the standard library's
+// compress/bzip2. It's slower than Bzip2, but is what Bzip2 uses when
+// building with the bspatch_stdlib tag.
+var StdBzip2 Decompressor = stdBzip2Decompressor{}
+
+type stdBzip2Decompressor struct{}
+
+func (stdBzip2Decompressor) Magic() string {
+	return magicBSDIFF40
+}
+
+func (stdBzip2Decompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+// Raw is the decompressor of BSDIFRW0 patches (bsdiff.Raw), which store the
+// blocks uncompressed
+var Raw Decompressor = rawDecompressor{}
+
+type rawDecompressor struct{}
+
+func (rawDecompressor) Magic() string {
+	return magicRaw
+}
+
+func (rawDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func (o *options) decompressor(magic string) Decompressor {
+	for _, d := range o.decompressors {
+		if d.Magic() == magic {
+			return d
+		}
+	}
+	for _, d := range builtinDecompressors {
+		if d.Magic() == magic {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+//go:build !bspatch_stdlib && !bspatch_tiny
+
+package bspatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// builtinDecompressors are always available, in addition to the ones passed
+// with WithDecompressor
+var builtinDecompressors = []Decompressor{
+	Bzip2,
+	Zstd,
+	Xz,
+	Raw,
+	Brotli,
+}
+
+// Bzip2 is the decompressor of the classic BSDIFF40 format
+var Bzip2 Decompressor = bzip2Decompressor{}
+
+type bzip2Decompressor struct{}
+
+func (bzip2Decompressor) Magic() string {
+	return magicBSDIFF40
+}
+
+func (bzip2Decompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return bzip2.NewReader(r, nil)
+}
+
+// Zstd is the decompressor of BSDIFZS0 patches (bsdiff.Zstd)
+var Zstd Decompressor = zstdDecompressor{}
+
+type zstdDecompressor struct {
+	// maxMem bounds the window and memory of the decoder if not 0
+	maxMem uint64
+}
+
+func (zstdDecompressor) Magic() string {
+	return magicZstd
+}
+
+func (d zstdDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return newZstdReader(r, d.maxMem, zstd.WithDecoderConcurrency(1))
+}
+
+func (zstdDecompressor) limitMemory(n int64) Decompressor {
+	return zstdDecompressor{maxMem: zstdMaxMem(n)}
+}
+
+// zstdMaxMem returns n as a zstd memory limit
+func zstdMaxMem(n int64) uint64 {
+	if n < zstd.MinWindowSize {
+		return zstd.MinWindowSize
+	}
+	return uint64(n)
+}
+
+// newZstdReader returns a zstd reader, limited to maxMem bytes if it isn't 0
+func newZstdReader(r io.Reader, maxMem uint64, opts ...zstd.DOption) (io.ReadCloser, error) {
+	if maxMem > 0 {
+		window := maxMem
+		if window > zstd.MaxWindowSize {
+			window = zstd.MaxWindowSize
+		}
+		opts = append(opts, zstd.WithDecoderMaxMemory(maxMem), zstd.WithDecoderMaxWindow(window))
+	}
+	d, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rc := d.IOReadCloser()
+	if maxMem == 0 {
+		return rc, nil
+	}
+	return &zstdLimitReader{rc, int64(maxMem)}, nil
+}
+
+// zstdLimitReader reports the decoder exceeding its memory as a
+// *MemoryLimitError
+type zstdLimitReader struct {
+	io.ReadCloser
+	limit int64
+}
+
+func (z *zstdLimitReader) Read(p []byte) (int, error) {
+	n, err := z.ReadCloser.Read(p)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		err = &MemoryLimitError{What: "zstd window", Limit: z.limit}
+	}
+	return n, err
+}
+
+// NewZstdDict returns a decompressor for BSDIFZS0 patches made with
+// bsdiff.NewZstdDict and the same dictionary d
+func NewZstdDict(d []byte) (Decompressor, error) {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %v", err.Error())
+	}
+	return &zstdDictDecompressor{dict: d, id: info.ID()}, nil
+}
+
+type zstdDictDecompressor struct {
+	dict   []byte
+	id     uint32
+	maxMem uint64
+}
+
+func (*zstdDictDecompressor) Magic() string {
+	return magicZstd
+}
+
+func (d *zstdDictDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return newZstdReader(r, d.maxMem, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(d.dict))
+}
+
+func (d *zstdDictDecompressor) limitMemory(n int64) Decompressor {
+	return &zstdDictDecompressor{d.dict, d.id, zstdMaxMem(n)}
+}
+
+func (d *zstdDictDecompressor) dictID() uint32 {
+	return d.id
+}
+
+// Xz is the decompressor of BSDIFXZ0 patches (bsdiff.Xz)
+var Xz Decompressor = xzDecompressor{}
+
+type xzDecompressor struct{}
+
+func (xzDecompressor) Magic() string {
+	return magicXz
+}
+
+func (xzDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
+
+// Brotli is the decompressor of BSDIFBR0 patches (bsdiff.Brotli)
+var Brotli Decompressor = brotliDecompressor{}
+
+type brotliDecompressor struct{}
+
+func (brotliDecompressor) Magic() string {
+	return magicBrotli
+}
+
+func (brotliDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
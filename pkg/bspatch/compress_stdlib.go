@@ -0,0 +1,18 @@
+//go:build bspatch_stdlib || bspatch_tiny
+
+package bspatch
+
+// Building with the bspatch_stdlib tag, or bspatch_tiny (see tiny.go), drops
+// the dependencies on third party compression packages. Only BSDIFF40 (using compress/bzip2) and BSDIFRW0
+// patches can be applied, unless other decompressors are passed with
+// WithDecompressor.
+
+// builtinDecompressors are always available, in addition to the ones passed
+// with WithDecompressor
+var builtinDecompressors = []Decompressor{
+	Bzip2,
+	Raw,
+}
+
+// Bzip2 is the decompressor of the classic BSDIFF40 format
+var Bzip2 = StdBzip2
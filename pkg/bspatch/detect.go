@@ -0,0 +1,111 @@
+package bspatch
+
+import "io"
+
+// Format identifies how the blocks of a patch are compressed. Its value is
+// the magic of the matching Decompressor.
+type Format string
+
+// Formats of the builtin decompressors
+const (
+	FormatBzip2  Format = magicBSDIFF40
+	FormatZstd   Format = magicZstd
+	FormatXz     Format = magicXz
+	FormatRaw    Format = magicRaw
+	FormatBrotli Format = magicBrotli
+	// FormatEndsley is the single stream layout of the mendsley/bsdiff fork
+	FormatEndsley Format = magicEndsley
+	// FormatBSDF2 is the layout of the ChromiumOS/Android bsdiff, with a
+	// compression type per block
+	FormatBSDF2 Format = magicBSDF2
+	// FormatVCDIFF is an RFC 3284 delta, as written by xdelta3
+	FormatVCDIFF Format = "VCDIFF"
+)
+
+// Detect reads the patch header and returns its format. Extended (BSDIFF4X)
+// patches report the format of their blocks. It fails if no decompressor is
+// available for the patch.
+func Detect(patch io.ReaderAt, opts ...Option) (_ Format, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	return h.format(), nil
+}
+
+func (h *header) format() Format {
+	switch h.magic {
+	case magicEndsley:
+		return FormatEndsley
+	case magicBSDF2:
+		return FormatBSDF2
+	case magicVCDIFF:
+		return FormatVCDIFF
+	}
+	return Format(h.codec.Magic())
+}
+
+// Header is the header of a patch, as read by ReadHeader
+type Header struct {
+	// Format is the format of the patch, as returned by Detect
+	Format Format
+	// Magic is the magic the patch starts with
+	Magic string
+	// NewSize is the size of the new file, -1 for VCDIFF deltas, which
+	// don't declare it up front
+	NewSize int64
+	// BlockOffset is where the blocks start, after the header and the
+	// extension area
+	BlockOffset int64
+	// CtrlSize, DiffSize and ExtraSize are the compressed sizes of the
+	// blocks. Endsley patches have a single block, counted in CtrlSize. The
+	// last block's size is -1 when the size of the patch isn't known. They're
+	// 0 for VCDIFF deltas.
+	CtrlSize, DiffSize, ExtraSize int64
+	// Codecs are the formats of the ctrl, diff and extra blocks
+	Codecs [3]Format
+	// Records are the extension records of a BSDIFF4X patch, nil for other
+	// patches
+	Records map[string][]byte
+}
+
+// ReadHeader reads the header of a patch, without decompressing its blocks.
+// Like Detect, it fails if no decompressor is available for the patch.
+func ReadHeader(patch io.ReaderAt, opts ...Option) (_ *Header, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	hd := &Header{
+		Format:      h.format(),
+		Magic:       h.magic,
+		NewSize:     int64(h.newsize),
+		BlockOffset: int64(h.blockoff),
+		Records:     h.ext,
+	}
+	if h.magic == magicVCDIFF {
+		hd.NewSize = -1
+		return hd, nil
+	}
+	for i, c := range h.codecs {
+		hd.Codecs[i] = Format(c.Magic())
+	}
+	if h.magic == magicEndsley {
+		hd.CtrlSize = -1
+		if size := patchSize(patch); size >= 0 {
+			hd.CtrlSize = size - hd.BlockOffset
+		}
+		return hd, nil
+	}
+	extralen, err := h.checkBlocks(patch)
+	if err != nil {
+		return nil, err
+	}
+	if patchSize(patch) < 0 {
+		extralen = -1
+	}
+	hd.CtrlSize, hd.DiffSize, hd.ExtraSize = int64(h.ctrllen), int64(h.datalen), extralen
+	return hd, nil
+}
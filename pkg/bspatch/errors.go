@@ -0,0 +1,93 @@
+package bspatch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Kinds of PatchError, for errors.Is
+var (
+	// ErrCorruptPatch is a malformed or truncated patch. Patches with a bad
+	// magic, a size mismatch or a checksum mismatch are corrupt too.
+	ErrCorruptPatch = errors.New("corrupt patch")
+	// ErrBadMagic is a patch whose magic no format or decompressor matches
+	ErrBadMagic = errors.New("bad patch magic")
+	// ErrUnsupportedFormat is a patch in a format, or with a compression,
+	// that bspatch recognizes but can't apply
+	ErrUnsupportedFormat = errors.New("unsupported patch format")
+	// ErrSizeMismatch is a patch whose controls don't add up to the size of
+	// the new file it declares
+	ErrSizeMismatch = errors.New("patch size mismatch")
+	// ErrChecksum is a block whose checksum doesn't match the one recorded
+	// by bsdiff.WithBlockChecksums. It's corrupt too.
+	ErrChecksum = errors.New("block checksum mismatch")
+	// ErrTooLarge is a patch making a new file larger than WithMaxNewSize
+	// allows
+	ErrTooLarge = errors.New("new file too large")
+)
+
+// Sections of a patch, as reported by PatchError
+const (
+	SectionHeader    = "header"
+	SectionExtension = "extension"
+	SectionCtrl      = "ctrl"
+	SectionDiff      = "diff"
+	SectionExtra     = "extra"
+	SectionVCDIFF    = "vcdiff"
+)
+
+// PatchError describes what's wrong with a patch and where. Use errors.Is
+// with its Kind, and errors.As to get the details.
+type PatchError struct {
+	// Kind is ErrCorruptPatch, ErrBadMagic, ErrUnsupportedFormat,
+	// ErrSizeMismatch, ErrChecksum or ErrTooLarge
+	Kind error
+	// Section is the part of the patch the error is in, empty when the new
+	// file doesn't match its recorded SHA-256
+	Section string
+	// Offset is where in the section the error was found, in bytes: from
+	// the start of the patch for the header and extension area, and of the
+	// decompressed block for the ctrl, diff and extra blocks (of the
+	// compressed block when it's cut short). It's -1 when unknown.
+	Offset int64
+	// Err is the error reading or decompressing the section, if any
+	Err error
+	msg string
+}
+
+func (e *PatchError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the kind of e and the error it wraps
+func (e *PatchError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Is reports whether target is ErrCorruptPatch and e a bad magic, a size
+// mismatch or a checksum mismatch
+func (e *PatchError) Is(target error) bool {
+	return target == ErrCorruptPatch && (e.Kind == ErrBadMagic || e.Kind == ErrSizeMismatch || e.Kind == ErrChecksum)
+}
+
+// patchErrorf returns a PatchError of kind in section at off, wrapping err,
+// with a formatted message
+func patchErrorf(kind error, section string, off int64, err error, format string, args ...interface{}) error {
+	return &PatchError{Kind: kind, Section: section, Offset: off, Err: err, msg: fmt.Sprintf(format, args...)}
+}
+
+// recoverPanic stores a panic of the calling function in *err as an
+// ErrCorruptPatch wrapping a *util.PanicError: the patch is the untrusted
+// input, so a bounds check it got past is a malformed patch. It must be
+// deferred directly.
+func recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		pe := util.NewPanicError(v)
+		*err = patchErrorf(ErrCorruptPatch, "", -1, pe, "corrupt patch (%v)", pe)
+	}
+}
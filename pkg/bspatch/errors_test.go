@@ -0,0 +1,69 @@
+package bspatch_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestPatchErrors(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	patch, err := bsdiff.Bytes(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(off int, b ...byte) []byte {
+		p := append([]byte{}, patch...)
+		copy(p[off:], b)
+		return p
+	}
+	hdiff := make([]byte, 32)
+	copy(hdiff, "HDIFF13")
+	for _, c := range []struct {
+		name    string
+		patch   []byte
+		kind    error
+		section string
+		corrupt bool
+	}{
+		{"bad magic", modified(0, 'X'), bspatch.ErrBadMagic, bspatch.SectionHeader, true},
+		{"short header", patch[:20], bspatch.ErrCorruptPatch, bspatch.SectionHeader, true},
+		{"negative length", modified(15, 0x80), bspatch.ErrCorruptPatch, bspatch.SectionHeader, true},
+		{"unsupported", hdiff, bspatch.ErrUnsupportedFormat, bspatch.SectionHeader, false},
+		// The new file is declared a byte short
+		{"size mismatch", modified(24, byte(len(w.New)-1), byte((len(w.New)-1)>>8), byte((len(w.New)-1)>>16)), bspatch.ErrSizeMismatch, bspatch.SectionCtrl, true},
+		{"truncated", patch[:len(patch)-20], bspatch.ErrCorruptPatch, bspatch.SectionExtra, true},
+	} {
+		_, err := bspatch.Bytes(w.Old, c.patch)
+		var pe *bspatch.PatchError
+		if !errors.As(err, &pe) || !errors.Is(err, c.kind) || pe.Section != c.section {
+			t.Fatalf("%v: unexpected error %v (%+v)", c.name, err, pe)
+		}
+		if errors.Is(err, bspatch.ErrCorruptPatch) != c.corrupt {
+			t.Fatalf("%v: %v is corrupt: %v", c.name, err, !c.corrupt)
+		}
+	}
+
+	// I/O errors aren't corruption
+	dir := t.TempDir()
+	patchfile := patchtest.WriteFile(t, dir, "patch", modified(0, 'X'))
+	err = bspatch.File(filepath.Join(dir, "missing"), filepath.Join(dir, "new"), patchfile)
+	if !errors.Is(err, os.ErrNotExist) || errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a missing file error, got", err)
+	}
+	oldfile := patchtest.WriteFile(t, dir, "old", w.Old)
+	err = bspatch.File(oldfile, filepath.Join(dir, "new"), patchfile)
+	if !errors.Is(err, bspatch.ErrBadMagic) {
+		t.Fatal("expected a bad magic error, got", err)
+	}
+	err = bsdiff.File(oldfile, filepath.Join(dir, "missing"), patchfile)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected a missing file error, got", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !bspatch_tiny
+
+package bspatch
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/exe"
+)
+
+// applyExe applies a patch made with bsdiff.WithExecutable: the old file is
+// normalized the same way as when diffing, and the normalization of the
+// result is reversed. Both files are held in memory.
+func (h *header) applyExe(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	t, err := exe.Unmarshal(h.ext[extExec])
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (%v)", err.Error())
+	}
+	oldbs, err := h.o.readAll(io.NewSectionReader(oldfile, 0, 1<<62), "old file")
+	if err != nil {
+		return err
+	}
+	if err = h.o.alloc("new file", h.newsize); err != nil {
+		return err
+	}
+	if !exe.Check(t.Old, len(oldbs)) || !exe.Check(t.New, h.newsize) {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, nil, "corrupt patch (executable sections out of bounds)")
+	}
+	exe.Encode(t.Arch, oldbs, t.Old)
+	var buf bytes.Buffer
+	if err = h.apply(bytes.NewReader(oldbs), patch, &buf); err != nil {
+		return err
+	}
+	newbs := buf.Bytes()
+	if !exe.Check(t.New, len(newbs)) {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, nil, "corrupt patch (executable sections out of bounds)")
+	}
+	exe.Decode(t.Arch, newbs, t.New)
+	_, err = w.Write(newbs)
+	return err
+}
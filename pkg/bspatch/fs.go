@@ -0,0 +1,53 @@
+package bspatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// FS applies the patch patchname to the file oldname of fsys, e.g. an
+// embed.FS, a zip file or an fstest.MapFS, and writes the new file to out in
+// order. Files that don't implement io.ReaderAt (zip entries) are read into
+// memory, which counts against WithMemoryLimit.
+func FS(fsys fs.FS, oldname, patchname string, out io.Writer, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	o := newOptions(opts)
+	oldF, err := fsys.Open(oldname)
+	if err != nil {
+		return fmt.Errorf("could not open oldfile '%v': %w", oldname, err)
+	}
+	defer oldF.Close()
+	patchF, err := fsys.Open(patchname)
+	if err != nil {
+		return fmt.Errorf("could not open patchfile '%v': %w", patchname, err)
+	}
+	defer patchF.Close()
+	patch, err := o.readerAt(patchF, "patch")
+	if err != nil {
+		return fmt.Errorf("could not read patchfile '%v': %w", patchname, err)
+	}
+	h, err := readHeader(patch, o)
+	if err != nil {
+		return err
+	}
+	old, err := o.readerAt(oldF, "old file")
+	if err != nil {
+		return fmt.Errorf("could not read oldfile '%v': %w", oldname, err)
+	}
+	return h.patch(old, patch, out)
+}
+
+// readerAt returns f as an io.ReaderAt, reading it into memory, accounted
+// as what, if it isn't one
+func (o *options) readerAt(f fs.File, what string) (io.ReaderAt, error) {
+	if r, ok := f.(io.ReaderAt); ok {
+		return r, nil
+	}
+	b, err := o.readAll(f, what)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
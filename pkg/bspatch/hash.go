@@ -0,0 +1,195 @@
+package bspatch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Extension record keys of the SHA-256 digests of the old and new files
+const (
+	extSHA256Old = "sha256.old"
+	extSHA256New = "sha256.new"
+)
+
+// ErrWrongOld is returned before anything is written when the patch records
+// the SHA-256 of the old file it was made from, or WithOldSHA256 gives one,
+// and the old file given is another one. A new file not matching its recorded SHA-256 is an
+// ErrCorruptPatch instead.
+var ErrWrongOld = errors.New("wrong old file")
+
+// ErrNoNewSHA256 is returned with WithRequireNewSHA256 for a patch that
+// doesn't record the new file's SHA-256
+var ErrNoNewSHA256 = errors.New("patch doesn't record the new file's SHA-256")
+
+// ErrAlreadyApplied is returned by File, InPlace and Resume, before
+// anything is written, when the file they'd write already is the new file:
+// its SHA-256 is the one the patch records or WithNewSHA256 gives. A retry
+// of a patch that succeeded can take it as success.
+var ErrAlreadyApplied = errors.New("patch already applied")
+
+// Verify applies patch to oldfile without writing the new file anywhere, to
+// check a patch before committing to disk changes. Patches made with
+// bsdiff.WithHashes are checked against the SHA-256 of the old and new files
+// they record; others only have to apply cleanly.
+func Verify(oldfile, patch io.ReaderAt, opts ...Option) error {
+	return Apply(oldfile, patch, io.Discard, opts...)
+}
+
+// ApplyWithPrecondition is Apply, refusing with ErrWrongOld an old file
+// whose SHA-256 isn't wantOldSHA256, for patches made without
+// bsdiff.WithHashes or old files that may have drifted since the patch was
+// made. The old file is hashed before anything is written to out.
+func ApplyWithPrecondition(oldfile, patch io.ReaderAt, out io.Writer, wantOldSHA256 []byte, opts ...Option) error {
+	return Apply(oldfile, patch, out, append(opts, WithOldSHA256(wantOldSHA256))...)
+}
+
+// WithOldSHA256 refuses with ErrWrongOld, before anything is written, an old
+// file whose SHA-256 isn't sum. It works with every function applying a
+// patch, including File and InPlace, which then leave the files untouched.
+func WithOldSHA256(sum []byte) Option {
+	return func(o *options) {
+		o.oldSHA256 = sum
+	}
+}
+
+// WithNewSHA256 fails with an ErrCorruptPatch when the new file's SHA-256
+// isn't sum, as when it doesn't match the digest recorded by
+// bsdiff.WithHashes. The new file is only known to be wrong once written, so
+// File removes it; with Apply, out has to be discarded.
+func WithNewSHA256(sum []byte) Option {
+	return func(o *options) {
+		o.newSHA256 = sum
+	}
+}
+
+// WithRequireNewSHA256 refuses with ErrNoNewSHA256, before anything is
+// written, a patch that doesn't record the new file's SHA-256
+// (bsdiff.WithHashes) when WithNewSHA256 doesn't give it either, so a
+// downloaded patch is never applied unchecked.
+func WithRequireNewSHA256() Option {
+	return func(o *options) {
+		o.requireNewSHA256 = true
+	}
+}
+
+// checkNew calls fn to write the new file to w and compares its SHA-256
+// with the one the patch records and the one WithNewSHA256 expects, if any
+func (h *header) checkNew(w io.Writer, fn func(w io.Writer) error) error {
+	want, err := h.digest(extSHA256New)
+	if err != nil {
+		return err
+	}
+	if want == nil && h.o.newSHA256 == nil {
+		if h.o.requireNewSHA256 {
+			return ErrNoNewSHA256
+		}
+		return fn(w)
+	}
+	d := sha256.New()
+	if r := h.o.resume; r != nil && r.prefix != nil {
+		// What Resume fast-forwards over isn't written again
+		d = r.prefix
+	}
+	if err = fn(io.MultiWriter(w, d)); err != nil {
+		return err
+	}
+	got := d.Sum(nil)
+	if want != nil && !bytes.Equal(got, want) {
+		return patchErrorf(ErrCorruptPatch, "", -1, nil, "corrupt patch (new file SHA-256 %x, expected %x)", got, want)
+	}
+	if want = h.o.newSHA256; want != nil && !bytes.Equal(got, want) {
+		return patchErrorf(ErrCorruptPatch, "", -1, nil, "corrupt patch (new file SHA-256 %x, expected %x by the caller)", got, want)
+	}
+	return nil
+}
+
+// checkOld compares the SHA-256 of oldfile with the one the patch records
+// and the one WithOldSHA256 expects, if any
+func (h *header) checkOld(oldfile io.ReaderAt) error {
+	want, err := h.digest(extSHA256Old)
+	if err != nil {
+		return err
+	}
+	if want == nil && h.o.oldSHA256 == nil {
+		return nil
+	}
+	d := sha256.New()
+	if _, err = io.Copy(d, io.NewSectionReader(oldfile, 0, 1<<62)); err != nil {
+		return fmt.Errorf("could not read old file: %w", err)
+	}
+	got := d.Sum(nil)
+	if want != nil && !bytes.Equal(got, want) {
+		return fmt.Errorf("%w (SHA-256 %x, expected %x)", ErrWrongOld, got, want)
+	}
+	if want = h.o.oldSHA256; want != nil && !bytes.Equal(got, want) {
+		return fmt.Errorf("%w (SHA-256 %x, expected %x by the caller)", ErrWrongOld, got, want)
+	}
+	return nil
+}
+
+// digest returns the SHA-256 recorded under key, or nil
+func (h *header) digest(key string) ([]byte, error) {
+	v, ok := h.ext[key]
+	if ok && len(v) != sha256.Size {
+		return nil, patchErrorf(ErrCorruptPatch, SectionExtension, 40, nil, "corrupt patch (%v is %v bytes)", key, len(v))
+	}
+	return v, nil
+}
+
+// Applied reports whether target already is the new file of patch, by the
+// SHA-256 the patch records or WithNewSHA256 gives. It's false when there's
+// neither.
+func Applied(target, patch io.ReaderAt, opts ...Option) (_ bool, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return false, err
+	}
+	return h.applied(target, patchSize(target))
+}
+
+// applied reports whether target, of size bytes (-1 if unknown), is the new
+// file
+func (h *header) applied(target io.ReaderAt, size int64) (bool, error) {
+	want, err := h.digest(extSHA256New)
+	if err != nil {
+		return false, err
+	}
+	if want == nil {
+		want = h.o.newSHA256
+	} else if h.o.newSHA256 != nil && !bytes.Equal(want, h.o.newSHA256) {
+		return false, nil
+	}
+	if want == nil {
+		return false, nil
+	}
+	if h.plain() && size >= 0 && size != int64(h.newsize) {
+		return false, nil
+	}
+	d := sha256.New()
+	if _, err = io.Copy(d, io.NewSectionReader(target, 0, 1<<62)); err != nil {
+		return false, err
+	}
+	return bytes.Equal(d.Sum(nil), want), nil
+}
+
+// appliedFile is applied for the file name, false if there's none
+func (h *header) appliedFile(name string) (bool, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return false, err
+	}
+	return h.applied(f, patchSize(f))
+}
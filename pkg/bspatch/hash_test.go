@@ -0,0 +1,214 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestHashes(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	var streamed util.BufWriter
+	if err := bsdiff.Stream(bytes.NewReader(w.Old), bytes.NewReader(w.New), &streamed, bsdiff.WithHashes(), bsdiff.WithCompressor(bsdiff.Raw), bsdiff.WithWindow(1<<14)); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithHashes(), bsdiff.WithCompressor(bsdiff.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := append([]byte{}, w.Old...)
+	wrong[len(wrong)/2] ^= 1
+	for _, p := range [][]byte{patch, streamed.Bytes()} {
+		newbs, err := bspatch.Bytes(w.Old, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newbs, w.New) {
+			t.Fatal("round trip failed")
+		}
+		_, err = bspatch.Bytes(wrong, p)
+		if !errors.Is(err, bspatch.ErrWrongOld) || errors.Is(err, bspatch.ErrCorruptPatch) {
+			t.Fatal("expected a wrong old file error, got", err)
+		}
+		err = bspatch.ApplyStream(bytes.NewReader(wrong), bytes.NewReader(p), io.Discard)
+		if !errors.Is(err, bspatch.ErrWrongOld) {
+			t.Fatal("expected a wrong old file error from ApplyStream, got", err)
+		}
+
+		// A flipped byte of the raw extra block only shows in the new file
+		corrupt := append([]byte{}, p...)
+		corrupt[len(corrupt)-1] ^= 1
+		for _, apply := range []func() error{
+			func() error { _, err := bspatch.Bytes(w.Old, corrupt); return err },
+			func() error {
+				return bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(corrupt), io.Discard)
+			},
+		} {
+			err = apply()
+			var pe *bspatch.PatchError
+			if !errors.As(err, &pe) || !errors.Is(err, bspatch.ErrCorruptPatch) || errors.Is(err, bspatch.ErrWrongOld) {
+				t.Fatal("expected a corrupt patch error, got", err)
+			}
+		}
+		if _, err = bspatch.Bytes(w.Old, p, bspatch.WithRequireNewSHA256()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plain, err := bsdiff.Bytes(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(plain), io.Discard, bspatch.WithRequireNewSHA256())
+	if !errors.Is(err, bspatch.ErrNoNewSHA256) {
+		t.Fatal("expected a missing digest error, got", err)
+	}
+	sum := sha256.Sum256(w.New)
+	if _, err = bspatch.Bytes(w.Old, plain, bspatch.WithRequireNewSHA256(), bspatch.WithNewSHA256(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrecondition(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithCompressor(bsdiff.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(w.Old)
+	var out bytes.Buffer
+	if err = bspatch.ApplyWithPrecondition(bytes.NewReader(w.Old), bytes.NewReader(patch), &out, sum[:]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), w.New) {
+		t.Fatal("round trip failed")
+	}
+
+	// The old file drifted since the patch was made
+	wrong := append([]byte{}, w.Old...)
+	wrong[len(wrong)/2] ^= 1
+	out.Reset()
+	err = bspatch.ApplyWithPrecondition(bytes.NewReader(wrong), bytes.NewReader(patch), &out, sum[:])
+	if !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error, got", err)
+	}
+	if out.Len() != 0 {
+		t.Fatal("wrote", out.Len(), "bytes before refusing the old file")
+	}
+	err = bspatch.ApplyStream(bytes.NewReader(wrong), bytes.NewReader(patch), io.Discard, bspatch.WithOldSHA256(sum[:]))
+	if !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error from ApplyStream, got", err)
+	}
+	dir := t.TempDir()
+	oldn, patchn := patchtest.WriteFile(t, dir, "old", wrong), patchtest.WriteFile(t, dir, "patch", patch)
+	newn := filepath.Join(dir, "new")
+	if err = bspatch.File(oldn, newn, patchn, bspatch.WithOldSHA256(sum[:])); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error from File, got", err)
+	}
+	if _, err = os.Stat(newn); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("File left the new file behind:", err)
+	}
+
+	// The new file is checked once written, by every apply function
+	newSum := sha256.Sum256(w.New)
+	err = bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(patch), io.Discard, bspatch.WithNewSHA256(newSum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.File(oldn, newn, patchn, bspatch.WithNewSHA256(newSum[:])); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error from File, got", err)
+	}
+	if _, err = os.Stat(newn); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("File left a wrong new file behind:", err)
+	}
+	delta, err := vcdiff.Diff(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(delta), io.Discard, bspatch.WithNewSHA256(sum[:]))
+	if !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error from a VCDIFF delta, got", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithHashes(), bsdiff.WithCompressor(bsdiff.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.Verify(bytes.NewReader(w.Old), bytes.NewReader(patch)); err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.Verify(bytes.NewReader(w.New), bytes.NewReader(patch)); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error, got", err)
+	}
+	corrupt := append([]byte{}, patch...)
+	corrupt[len(corrupt)-1] ^= 1
+	if err = bspatch.Verify(bytes.NewReader(w.Old), bytes.NewReader(corrupt)); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error, got", err)
+	}
+}
+
+func TestAlreadyApplied(t *testing.T) {
+	oldbs := bytes.Repeat([]byte("old version "), 1000)
+	newbs := bytes.Repeat([]byte("new version "), 1000)
+	dir := t.TempDir()
+	oldfile, newfile := patchtest.WriteFile(t, dir, "old", oldbs), patchtest.WriteFile(t, dir, "new", newbs)
+	patchfile := filepath.Join(dir, "patch")
+	if err := bsdiff.File(oldfile, newfile, patchfile, bsdiff.WithHashes()); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := os.ReadFile(patchfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bspatch.Applied(bytes.NewReader(newbs), bytes.NewReader(patch)); !ok || err != nil {
+		t.Fatal("new file not detected", err)
+	}
+	if ok, err := bspatch.Applied(bytes.NewReader(oldbs), bytes.NewReader(patch)); ok || err != nil {
+		t.Fatal("old file detected as new", err)
+	}
+	for _, apply := range []func() error{
+		func() error { return bspatch.File(oldfile, newfile, patchfile) },
+		func() error { return bspatch.Resume(oldfile, newfile, patchfile) },
+		func() error { return bspatch.InPlace(newfile, bytes.NewReader(patch)) },
+	} {
+		if err = apply(); !errors.Is(err, bspatch.ErrAlreadyApplied) {
+			t.Fatal("expected ErrAlreadyApplied, got", err)
+		}
+	}
+	if err = bspatch.InPlace(oldfile, bytes.NewReader(patch)); err != nil {
+		t.Fatal(err)
+	}
+	if err = bspatch.InPlace(oldfile, bytes.NewReader(patch)); !errors.Is(err, bspatch.ErrAlreadyApplied) {
+		t.Fatal("retrying InPlace:", err)
+	}
+	if b, _ := os.ReadFile(oldfile); !bytes.Equal(b, newbs) {
+		t.Fatal("wrong new file")
+	}
+
+	// Without digests, patches are applied again
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bspatch.Applied(bytes.NewReader(newbs), bytes.NewReader(plain)); ok || err != nil {
+		t.Fatal("detected without a digest", err)
+	}
+	sum := sha256.Sum256(newbs)
+	if ok, err := bspatch.Applied(bytes.NewReader(newbs), bytes.NewReader(plain), bspatch.WithNewSHA256(sum[:])); !ok || err != nil {
+		t.Fatal("not detected by the caller's digest", err)
+	}
+}
@@ -0,0 +1,312 @@
+package bspatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	magicBSDIFF40 = "BSDIFF40"
+	magicZstd     = "BSDIFZS0"
+	magicXz       = "BSDIFXZ0"
+	magicRaw      = "BSDIFRW0"
+	magicBrotli   = "BSDIFBR0"
+	magicEndsley  = "ENDSLEY/BSDIFF43"
+	// magicBSDF2 is followed by the compression types of the three blocks
+	magicBSDF2 = "BSDF2"
+	// magicHDiff starts the patches of HDiffPatch, e.g. "HDIFFSF20&zlib"
+	magicHDiff = "HDIFF"
+	// magicVCDIFF starts a VCDIFF (RFC 3284) delta, e.g. from xdelta3
+	magicVCDIFF = "\xd6\xc3\xc4\x00"
+	// magicExtended marks a patch with an extension area after the header.
+	magicExtended = "BSDIFF4X"
+	// magicSealed starts a patch encrypted by package seal
+	magicSealed = "BSDIFFAE"
+)
+
+// Extension record keys
+const (
+	extName  = "name"
+	extMode  = "mode"
+	extMtime = "mtime"
+	// extCodec + "." + block name overrides the codec of a block
+	extCodec = "codec"
+	// extZstdDict is the ID of the zstd dictionary the blocks need
+	extZstdDict = "zdict"
+	// extExec is the executable transform to reverse (see internal/exe)
+	extExec = "exec"
+	// extTar is the permutation of the old tar archive (see
+	// internal/tarball)
+	extTar = "tar"
+	// extZip is the zip transform to reverse (see internal/zipfile)
+	extZip = "zip"
+	// extMeta prefixes the keys of user metadata
+	extMeta = "meta."
+)
+
+// blockNames are the names of the ctrl, diff and extra blocks
+var blockNames = [3]string{"ctrl", "diff", "extra"}
+
+// header holds the parsed patch header
+type header struct {
+	magic    string
+	ctrllen  int
+	datalen  int
+	newsize  int
+	blockoff int // offset of the ctrl block
+	ext      map[string][]byte
+	// codec decompresses the blocks, unless codecs overrides it
+	codec Decompressor
+	// codecs decompress the ctrl, diff and extra blocks
+	codecs [3]Decompressor
+	// o are the options the patch is applied with
+	o *options
+}
+
+func readHeader(patch io.ReaderAt, o *options) (*header, error) {
+	h, err := parseHeader(patch, o)
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != magicVCDIFF {
+		// Endsley patches only declare the new size, at 16
+		off := int64(24)
+		if h.magic == magicEndsley {
+			off = 16
+		}
+		if h.ctrllen > maxOffset || h.datalen > maxOffset || h.newsize > maxOffset {
+			return nil, patchErrorf(ErrCorruptPatch, SectionHeader, 8, nil, "corrupt patch (bzctrllen %v bzdatalen %v newsize %v)", h.ctrllen, h.datalen, h.newsize)
+		}
+		if o.maxNewSize > 0 && int64(h.newsize) > o.maxNewSize {
+			return nil, patchErrorf(ErrTooLarge, SectionHeader, off, nil, "new file of %v bytes exceeds the limit of %v", h.newsize, o.maxNewSize)
+		}
+	}
+	h.o = o
+	return h, nil
+}
+
+func parseHeader(patch io.ReaderAt, o *options) (*header, error) {
+	buf := make([]byte, 32)
+	f := io.NewSectionReader(patch, 0, int64(len(buf)))
+	// Read header
+	n, err := f.Read(buf)
+	if n >= len(magicVCDIFF) && string(buf[:len(magicVCDIFF)]) == magicVCDIFF {
+		return &header{magic: magicVCDIFF}, nil
+	}
+	if err != nil || n < 32 {
+		if err != nil {
+			return nil, patchErrorf(ErrCorruptPatch, SectionHeader, int64(n), err, "corrupt patch %v", err.Error())
+		}
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, int64(n), nil, "corrupt patch (n %v < 32)", n)
+	}
+	if string(buf[:16]) == magicEndsley {
+		return readEndsleyHeader(buf, o)
+	}
+	if string(buf[:5]) == magicBSDF2 {
+		return readBSDF2Header(buf, o)
+	}
+	if string(buf[:8]) == magicSealed {
+		return nil, patchErrorf(ErrUnsupportedFormat, SectionHeader, 0, nil, "encrypted patch, open it with package seal")
+	}
+	if string(buf[:5]) == magicHDiff {
+		version := buf
+		if i := bytes.IndexAny(buf, "&\x00"); i >= 0 {
+			version = buf[:i]
+		}
+		return nil, patchErrorf(ErrUnsupportedFormat, SectionHeader, 0, nil, "unsupported patch format %q (HDiffPatch)", version)
+	}
+	h := &header{
+		magic:    string(buf[:8]),
+		ctrllen:  offtin(buf[8:]),
+		datalen:  offtin(buf[16:]),
+		newsize:  offtin(buf[24:]),
+		blockoff: 32,
+	}
+	// Check for appropriate magic
+	codec := h.magic
+	if h.magic == magicExtended {
+		if err := h.readExt(patch, o); err != nil {
+			return nil, err
+		}
+		codec = magicBSDIFF40
+		if v, ok := h.ext[extCodec]; ok {
+			codec = string(v)
+		}
+	}
+	if h.codec = o.decompressor(codec); h.codec == nil {
+		if h.magic == magicExtended {
+			return nil, patchErrorf(ErrUnsupportedFormat, SectionExtension, 40, nil, "unsupported patch compression %q", codec)
+		}
+		return nil, patchErrorf(ErrBadMagic, SectionHeader, 0, nil, "corrupt patch (header BSDIFF40)")
+	}
+	for i := range h.codecs {
+		h.codecs[i] = h.codec
+		if v, ok := h.ext[extCodec+"."+blockNames[i]]; ok {
+			if h.codecs[i] = o.decompressor(string(v)); h.codecs[i] == nil {
+				return nil, patchErrorf(ErrUnsupportedFormat, SectionExtension, 40, nil, "unsupported %v block compression %q", blockNames[i], v)
+			}
+		}
+	}
+	// One zstd dictionary is shared by every zstd block
+	if v, ok := h.ext[extZstdDict]; ok && len(v) == 4 {
+		id := binary.LittleEndian.Uint32(v)
+		for _, c := range h.codecs {
+			if c.Magic() != magicZstd {
+				continue
+			}
+			d, ok := c.(interface{ dictID() uint32 })
+			if !ok || d.dictID() != id {
+				return nil, patchErrorf(ErrUnsupportedFormat, SectionExtension, 40, nil, "patch needs zstd dictionary %v", id)
+			}
+		}
+	}
+	if h.ctrllen < 0 || h.datalen < 0 || h.newsize < 0 {
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, 8, nil, "corrupt patch (bzctrllen %v bzdatalen %v newsize %v)", h.ctrllen, h.datalen, h.newsize)
+	}
+	return h, nil
+}
+
+// bsdf2Codecs are the magics of the BSDF2 compression types
+var bsdf2Codecs = map[byte]string{0: magicRaw, 1: magicBSDIFF40, 2: magicBrotli}
+
+// readBSDF2Header reads the header of a ChromiumOS/Android bsdiff patch
+//
+//	0	5	"BSDF2"
+//	5	3	compression type of the ctrl, diff and extra blocks
+//	8	24	lengths as in BSDIFF40
+func readBSDF2Header(buf []byte, o *options) (*header, error) {
+	h := &header{
+		magic:    magicBSDF2,
+		ctrllen:  offtin(buf[8:]),
+		datalen:  offtin(buf[16:]),
+		newsize:  offtin(buf[24:]),
+		blockoff: 32,
+	}
+	for i := range h.codecs {
+		magic, ok := bsdf2Codecs[buf[5+i]]
+		if !ok {
+			return nil, patchErrorf(ErrUnsupportedFormat, SectionHeader, int64(5+i), nil, "unsupported %v block compression type %v", blockNames[i], buf[5+i])
+		}
+		if h.codecs[i] = o.decompressor(magic); h.codecs[i] == nil {
+			return nil, patchErrorf(ErrUnsupportedFormat, SectionHeader, int64(5+i), nil, "unsupported %v block compression %q", blockNames[i], magic)
+		}
+	}
+	h.codec = h.codecs[0]
+	if h.ctrllen < 0 || h.datalen < 0 || h.newsize < 0 {
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, 8, nil, "corrupt patch (bzctrllen %v bzdatalen %v newsize %v)", h.ctrllen, h.datalen, h.newsize)
+	}
+	return h, nil
+}
+
+// readEndsleyHeader reads the header of a mendsley/bsdiff patch
+//
+//	0	16	"ENDSLEY/BSDIFF43"
+//	16	8	sizeof(newfile)
+//	24	??	bzip2(ctrl triple, diff data, extra data)...
+func readEndsleyHeader(buf []byte, o *options) (*header, error) {
+	h := &header{
+		magic:    magicEndsley,
+		newsize:  offtin(buf[16:]),
+		blockoff: 24,
+		codec:    o.decompressor(magicBSDIFF40),
+	}
+	if h.newsize < 0 {
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, 16, nil, "corrupt patch (newsize %v)", h.newsize)
+	}
+	h.codecs = [3]Decompressor{h.codec, h.codec, h.codec}
+	return h, nil
+}
+
+// readExt reads the extension area of a BSDIFF4X patch
+func (h *header) readExt(patch io.ReaderAt, o *options) error {
+	buf := make([]byte, 8)
+	if _, err := patch.ReadAt(buf, 32); err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 32, err, "corrupt patch (extension length) %v", err.Error())
+	}
+	extlen := offtin(buf)
+	if extlen < 0 || extlen > maxOffset {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 32, nil, "corrupt patch (extension length %v)", extlen)
+	}
+	if err := o.alloc("extension area", extlen); err != nil {
+		return err
+	}
+	// The area is read as it comes rather than allocated up front, so a
+	// bogus length fails at the end of the patch
+	ext, err := io.ReadAll(io.NewSectionReader(patch, 40, int64(extlen)))
+	if err == nil && len(ext) < extlen {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (extension area) %v", err.Error())
+	}
+	h.blockoff = 40 + extlen
+	h.ext = make(map[string][]byte)
+	r := bytes.NewReader(ext)
+	for r.Len() > 0 {
+		key, err := readField(r)
+		if err != nil {
+			return err
+		}
+		value, err := readField(r)
+		if err != nil {
+			return err
+		}
+		h.ext[string(key)] = value
+	}
+	return nil
+}
+
+// readField reads a length-prefixed field of the extension area
+func readField(r *bytes.Reader) ([]byte, error) {
+	off := 40 + r.Size() - int64(r.Len())
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, patchErrorf(ErrCorruptPatch, SectionExtension, off, nil, "corrupt patch (extension record)")
+	}
+	field := make([]byte, n)
+	r.Read(field)
+	return field, nil
+}
+
+func (h *header) extUint(key string) (uint64, bool) {
+	v, ok := h.ext[key]
+	if !ok || len(v) != 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(v), true
+}
+
+// fileInfoSupported reports whether the platform can represent the unix
+// permission bits and modification times recorded in a patch. When it
+// can't, the recorded attributes are ignored.
+var fileInfoSupported = runtime.GOOS != "windows" && runtime.GOOS != "js" && runtime.GOOS != "wasip1"
+
+// restoreFileInfo applies the mode and modification time recorded by
+// bsdiff.File (WithFileInfo) to the reconstructed file
+func (h *header) restoreFileInfo(name string) error {
+	if !fileInfoSupported {
+		return nil
+	}
+	if mode, ok := h.extUint(extMode); ok {
+		if err := os.Chmod(name, os.FileMode(mode).Perm()); err != nil {
+			return err
+		}
+	}
+	if mtime, ok := h.extUint(extMtime); ok {
+		t := time.Unix(0, int64(mtime))
+		if err := os.Chtimes(name, t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plain reports whether the patch is a bsdiff patch without a transform
+// (executable, tar or zip) to reverse
+func (h *header) plain() bool {
+	return h.magic != magicVCDIFF && h.ext[extExec] == nil && h.ext[extTar] == nil && h.ext[extZip] == nil
+}
@@ -0,0 +1,273 @@
+package bspatch
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// InPlace applies a patch to the file at path, overwriting the old file with
+// the new one, for devices without room for both. Before a range of the file
+// is overwritten, the old bytes that later controls still read are copied to
+// memory, so memory use depends on how far data moved between the files
+// rather than on their size. The controls are scanned once before applying
+// the patch, which decompresses it twice.
+//
+// The file is left corrupt if applying the patch fails midway, unless
+// WithBackup keeps a copy to restore; the patch should be verified
+// beforehand. Patches made with bsdiff.WithExecutable aren't supported.
+// A file already patched fails with ErrAlreadyApplied, so retrying is safe.
+func InPlace(path string, patch io.ReaderAt, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if applied, err := h.appliedFile(path); err != nil || applied {
+		if applied {
+			err = ErrAlreadyApplied
+		}
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if h.ext[extExec] != nil {
+		return fmt.Errorf("bspatch: executable patches can't be applied in place")
+	}
+	if h.ext[extTar] != nil || h.ext[extZip] != nil {
+		return fmt.Errorf("bspatch: archive patches can't be applied in place")
+	}
+	if backup := h.o.backup; backup != "" {
+		if err = Backup(path, backup); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.Rename(backup, path)
+			}
+		}()
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("could not open file '%v': %w", path, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat file '%v': %w", path, err)
+	}
+	ip := &inPlace{f: f, oldsize: int(fi.Size()), o: h.o}
+	if err = ip.schedule(patch, opts); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = h.patch(ip, patch, ip); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = f.Truncate(int64(ip.written)); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = h.restoreFileInfo(path); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	return nil
+}
+
+// inPlace is the old file (io.ReaderAt) and the new file (io.Writer) of an
+// in-place patch. The new file is written in order, so the old bytes before
+// written have been overwritten and are read from saved instead.
+type inPlace struct {
+	f       *os.File
+	oldsize int
+	written int
+	// newStarts are the new positions of the controls
+	newStarts []int
+	// reads are the old ranges the controls read, by start, and maxEnd[k]
+	// the largest end of reads[:k+1]
+	reads  []oldRead
+	maxEnd []int
+	// saved are old bytes overwritten before being read, by offset
+	saved []savedRange
+	// cur is the control being applied
+	cur int
+	// o accounts for the memory of saved
+	o *options
+}
+
+// oldRead is the range of the old file read by a control
+type oldRead struct {
+	start, end int
+	ctrl       int
+}
+
+// savedRange is a copy of old bytes, kept until control last is applied
+type savedRange struct {
+	off  int
+	data []byte
+	last int
+}
+
+// schedule records where each control writes and which old bytes it reads
+func (ip *inPlace) schedule(patch io.ReaderAt, opts []Option) error {
+	var oldpos, newpos int
+	err := Scan(patch, func(c Control) error {
+		k := len(ip.newStarts)
+		ip.newStarts = append(ip.newStarts, newpos)
+		start, end := oldpos, oldpos+len(c.Diff)
+		if start < 0 {
+			start = 0
+		}
+		if end > ip.oldsize {
+			end = ip.oldsize
+		}
+		if start < end {
+			ip.reads = append(ip.reads, oldRead{start, end, k})
+		}
+		newpos += len(c.Diff) + len(c.Extra)
+		oldpos += len(c.Diff) + c.Seek
+		return nil
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	sort.Slice(ip.reads, func(a, b int) bool { return ip.reads[a].start < ip.reads[b].start })
+	ip.maxEnd = make([]int, len(ip.reads))
+	for k, r := range ip.reads {
+		ip.maxEnd[k] = r.end
+		if k > 0 && ip.maxEnd[k-1] > r.end {
+			ip.maxEnd[k] = ip.maxEnd[k-1]
+		}
+	}
+	return nil
+}
+
+// ctrlAt returns the control writing the new byte at pos
+func (ip *inPlace) ctrlAt(pos int) int {
+	return sort.Search(len(ip.newStarts), func(k int) bool { return ip.newStarts[k] > pos }) - 1
+}
+
+// advance drops the saved bytes no control from the current one on reads
+func (ip *inPlace) advance() {
+	k := ip.ctrlAt(ip.written)
+	if k <= ip.cur {
+		return
+	}
+	ip.cur = k
+	saved := ip.saved[:0]
+	for _, s := range ip.saved {
+		if s.last >= k {
+			saved = append(saved, s)
+		} else {
+			ip.o.free(len(s.data))
+		}
+	}
+	for i := len(saved); i < len(ip.saved); i++ {
+		ip.saved[i] = savedRange{}
+	}
+	ip.saved = saved
+}
+
+// ReadAt reads the old file. Bytes past its end read as missing, like from
+// the old file itself.
+func (ip *inPlace) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	ip.advance()
+	pos := int(off)
+	var err error
+	if pos >= ip.oldsize {
+		return 0, io.EOF
+	}
+	if rest := ip.oldsize - pos; len(p) > rest {
+		p, err = p[:rest], io.EOF
+	}
+	n := 0
+	for n < len(p) && pos+n < ip.written {
+		x := pos + n
+		k := sort.Search(len(ip.saved), func(k int) bool { return ip.saved[k].off+len(ip.saved[k].data) > x })
+		if k == len(ip.saved) || ip.saved[k].off > x {
+			return n, fmt.Errorf("in-place schedule lost old byte %v", x)
+		}
+		s := ip.saved[k]
+		end := len(p)
+		if w := ip.written - pos; end > w {
+			end = w
+		}
+		n += copy(p[n:end], s.data[x-s.off:])
+	}
+	if n < len(p) {
+		if _, rerr := ip.f.ReadAt(p[n:], int64(pos+n)); rerr != nil {
+			return n, rerr
+		}
+	}
+	return len(p), err
+}
+
+// Write writes the next bytes of the new file, first saving the old bytes
+// it overwrites that are still to be read
+func (ip *inPlace) Write(p []byte) (int, error) {
+	ip.advance()
+	if err := ip.save(ip.written, ip.written+len(p)); err != nil {
+		return 0, err
+	}
+	n, err := ip.f.WriteAt(p, int64(ip.written))
+	ip.written += n
+	return n, err
+}
+
+// save copies the old bytes in [a, b) read by the current or later controls
+func (ip *inPlace) save(a, b int) error {
+	if b > ip.oldsize {
+		b = ip.oldsize
+	}
+	if a >= b {
+		return nil
+	}
+	var ranges []oldRead
+	k := sort.Search(len(ip.reads), func(k int) bool { return ip.reads[k].start >= b }) - 1
+	for ; k >= 0 && ip.maxEnd[k] > a; k-- {
+		r := ip.reads[k]
+		if r.ctrl < ip.cur || r.end <= a {
+			continue
+		}
+		if r.start < a {
+			r.start = a
+		}
+		if r.end > b {
+			r.end = b
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	// Merge the overlapping ranges, keeping the last control to read them
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		m := &merged[len(merged)-1]
+		if r.start > m.end {
+			merged = append(merged, r)
+			continue
+		}
+		if r.end > m.end {
+			m.end = r.end
+		}
+		if r.ctrl > m.ctrl {
+			m.ctrl = r.ctrl
+		}
+	}
+	for _, r := range merged {
+		if err := ip.o.alloc("old bytes kept in place", r.end-r.start); err != nil {
+			return err
+		}
+		data := make([]byte, r.end-r.start)
+		if _, err := ip.f.ReadAt(data, int64(r.start)); err != nil {
+			return err
+		}
+		ip.saved = append(ip.saved, savedRange{off: r.start, data: data, last: r.ctrl})
+	}
+	return nil
+}
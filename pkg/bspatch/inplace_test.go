@@ -0,0 +1,56 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	oldbs := testdata.Random(1, 1024*64)
+	insert := testdata.Random(2, 3000)
+	// Data moved forward, moved backward, swapped and truncated
+	cases := [][]byte{
+		append(append([]byte(nil), insert...), oldbs...),
+		append(append([]byte(nil), oldbs[5000:]...), insert...),
+		append(append([]byte(nil), oldbs[32768:]...), oldbs[:32768]...),
+		append(append([]byte(nil), oldbs[:1000]...), oldbs[40000:42000]...),
+	}
+	changed := append([]byte(nil), oldbs...)
+	copy(changed[100:200], testdata.Random(3, 100))
+	cases = append(cases, changed)
+	for i, newbs := range cases {
+		vpatch, err := vcdiff.Diff(oldbs, newbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patches := [][]byte{vpatch}
+		for _, opts := range [][]bsdiff.Option{nil, {bsdiff.WithFormat(bsdiff.FormatEndsley)}} {
+			patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patches = append(patches, patch)
+		}
+		for _, patch := range patches {
+			name := patchtest.WriteFile(t, dir, "file", oldbs)
+			if err := bspatch.InPlace(name, bytes.NewReader(patch)); err != nil {
+				t.Fatal(i, err)
+			}
+			newbs2, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(newbs, newbs2) {
+				t.Fatal("in-place patch failed, case", i, string(patch[:4]))
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package bspatch
+
+import "io"
+
+// Patch is a parsed patch, for applying it many times (e.g. to the identical
+// old files of many devices) without parsing its header again. The options
+// it's loaded with, such as zstd dictionaries, are shared by every Apply. A
+// Patch is safe for concurrent use, but WithStats and WithProgress aren't:
+// their callbacks and stats are shared too.
+type Patch struct {
+	patch io.ReaderAt
+	h     *header
+}
+
+// Load parses the header of patch, which must not change while the Patch is
+// in use
+func Load(patch io.ReaderAt, opts ...Option) (_ *Patch, err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &Patch{patch: patch, h: h}, nil
+}
+
+// Apply applies the patch to oldfile and writes the new file to out, in
+// order
+func (p *Patch) Apply(oldfile io.ReaderAt, out io.Writer) (err error) {
+	defer recoverPanic(&err)
+	// Each call accounts for its own memory
+	o := *p.h.o
+	h := *p.h
+	h.o = &o
+	return h.patch(oldfile, p.patch, out)
+}
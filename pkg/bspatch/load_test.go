@@ -0,0 +1,54 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestLoad(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	dict, err := bsdiff.TrainZstdDict([][]byte{w.Old[:4096], w.Old[4096:8192], w.New[:4096], w.New[4096:8192]}, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp, err := bsdiff.NewZstdDict(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithCompressor(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bspatch.NewZstdDict(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := bspatch.Load(bytes.NewReader(patch), bspatch.WithDecompressor(d), bspatch.WithMemoryLimit(int64(len(w.New))+1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			var out bytes.Buffer
+			err := p.Apply(bytes.NewReader(w.Old), &out)
+			if err == nil && !bytes.Equal(out.Bytes(), w.New) {
+				err = errors.New("Apply output differs")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = bspatch.Load(bytes.NewReader(patch[:16])); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error, got", err)
+	}
+}
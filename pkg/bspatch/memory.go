@@ -0,0 +1,154 @@
+package bspatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+)
+
+// WithMemoryLimit limits the memory allocated to apply a patch to about n
+// bytes: read buffers, blocks staged by ApplyStream, zstd and VCDIFF windows,
+// the new file of Bytes and the old bytes InPlace keeps. A patch needing more,
+// e.g. because a malicious header declares an enormous new file, fails with a
+// *MemoryLimitError instead of exhausting memory.
+func WithMemoryLimit(n int64) Option {
+	return func(o *options) {
+		o.memLimit = n
+	}
+}
+
+// WithMaxNewSize rejects patches making a new file larger than n bytes with
+// an ErrTooLarge PatchError, before anything is written for the sizes
+// patches declare and as soon as it's exceeded for VCDIFF deltas. Unlike
+// WithMemoryLimit, it also bounds what Reader and File write to disk.
+func WithMaxNewSize(n int64) Option {
+	return func(o *options) {
+		o.maxNewSize = n
+	}
+}
+
+// maxOffset bounds the lengths in patch headers and the old positions of the
+// controls, so that positions computed from them can't overflow
+const maxOffset = 1 << 60
+
+// MemoryLimitError is returned when applying a patch would need more memory
+// than WithMemoryLimit allows
+type MemoryLimitError struct {
+	// What needed the memory, e.g. "new file"
+	What string
+	// Need is the memory needed in total, 0 if unknown
+	Need  int64
+	Limit int64
+}
+
+func (e *MemoryLimitError) Error() string {
+	if e.Need == 0 {
+		return fmt.Sprintf("%v exceeds the memory limit of %v bytes", e.What, e.Limit)
+	}
+	return fmt.Sprintf("%v needs %v bytes, over the memory limit of %v", e.What, e.Need, e.Limit)
+}
+
+// memoryLimiter is implemented by decompressors whose memory use depends on
+// the patch
+type memoryLimiter interface {
+	// limitMemory returns a decompressor using at most n bytes
+	limitMemory(n int64) Decompressor
+}
+
+// alloc accounts for n bytes allocated for what
+func (o *options) alloc(what string, n int) error {
+	need := o.memUsed + int64(n)
+	if o.memLimit > 0 && need > o.memLimit {
+		return &MemoryLimitError{What: what, Need: need, Limit: o.memLimit}
+	}
+	o.memUsed = need
+	if s := o.stats; s != nil && need > s.PeakMemory {
+		s.PeakMemory = need
+	}
+	return nil
+}
+
+// free accounts for n bytes released
+func (o *options) free(n int) {
+	o.memUsed -= int64(n)
+}
+
+// remaining returns the bytes left to allocate, or -1 without a limit
+func (o *options) remaining() int64 {
+	if o.memLimit <= 0 {
+		return -1
+	}
+	return o.memLimit - o.memUsed
+}
+
+// readAll reads r, accounting for the bytes as what
+func (o *options) readAll(r io.Reader, what string) ([]byte, error) {
+	n := o.remaining()
+	if n >= 0 {
+		r = io.LimitReader(r, n+1)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if n >= 0 && int64(len(b)) > n {
+		// r was cut short, the total isn't known
+		return nil, &MemoryLimitError{What: what, Limit: o.memLimit}
+	}
+	return b, o.alloc(what, len(b))
+}
+
+// sizeLimitWriter fails once more than limit bytes are written to w
+type sizeLimitWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (s *sizeLimitWriter) Write(p []byte) (int, error) {
+	if s.written+int64(len(p)) > s.limit {
+		return 0, patchErrorf(ErrTooLarge, SectionVCDIFF, -1, nil, "new file exceeds the limit of %v bytes", s.limit)
+	}
+	s.written += int64(len(p))
+	return s.w.Write(p)
+}
+
+// newReader returns a reader decompressing block i (0 ctrl, 1 diff, 2 extra)
+// from r. Decompressors with windows share what's left of the memory limit.
+func (h *header) newReader(i int, r io.Reader) (io.ReadCloser, error) {
+	d := h.codecs[i]
+	if l, ok := d.(memoryLimiter); ok && h.o.memLimit > 0 {
+		d = l.limitMemory(h.o.remaining() / 3)
+	}
+	return d.NewReader(r)
+}
+
+// decodeVCDIFF applies a VCDIFF delta, with its windows limited to what's
+// left of the memory limit
+func (o *options) decodeVCDIFF(oldfile io.ReaderAt, delta io.Reader, w io.Writer) error {
+	limit := 0
+	if n := o.remaining(); n >= 0 {
+		// DecodeLimit takes 0 as no limit
+		limit = int(n) + 1
+	}
+	start := time.Now()
+	if o.maxNewSize > 0 {
+		w = &sizeLimitWriter{w: w, limit: o.maxNewSize}
+	}
+	pw := o.progressWriter(w, -1)
+	err := vcdiff.DecodeLimit(oldfile, delta, pw, limit)
+	if err == nil {
+		pw.done()
+	}
+	if o.stats != nil {
+		o.stats.ApplyTime += time.Since(start)
+	}
+	var le *vcdiff.LimitError
+	if errors.As(err, &le) {
+		return &MemoryLimitError{What: "VCDIFF window", Need: o.memUsed + int64(le.Need), Limit: o.memLimit}
+	}
+	return vcdiffError(err)
+}
@@ -0,0 +1,22 @@
+package bspatch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestMemoryLimitCompressors(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 64)
+	for _, c := range []bsdiff.Compressor{bsdiff.Bzip2, bsdiff.Zstd, bsdiff.Xz} {
+		patch := patchtest.RoundTrip(t, oldbs, newbs, []bsdiff.Option{bsdiff.WithCompressor(c)}, bspatch.WithMemoryLimit(4<<20))
+		_, err := bspatch.Bytes(oldbs, patch, bspatch.WithMemoryLimit(1<<16))
+		var me *bspatch.MemoryLimitError
+		if !errors.As(err, &me) {
+			t.Fatal("expected a memory limit error, got", err)
+		}
+	}
+}
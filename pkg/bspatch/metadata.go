@@ -0,0 +1,39 @@
+package bspatch
+
+import (
+	"io"
+	"strings"
+)
+
+// Well-known metadata keys, as written by bsdiff.WithMetadata
+const (
+	MetaSourceVersion = "source.version"
+	MetaTargetVersion = "target.version"
+	MetaCreated       = "created"
+	MetaTool          = "tool"
+)
+
+// Metadata returns the metadata recorded in the extended (BSDIFF4X) header
+// of a patch, without applying or decompressing it. Other patches have no
+// metadata.
+func Metadata(patch io.ReaderAt, opts ...Option) (_ map[string]string, err error) {
+	defer recoverPanic(&err)
+	buf := make([]byte, 8)
+	if n, _ := patch.ReadAt(buf, 0); n < len(buf) {
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, int64(n), nil, "corrupt patch (n %v < 8)", n)
+	}
+	meta := make(map[string]string)
+	if string(buf) != magicExtended {
+		return meta, nil
+	}
+	h := &header{}
+	if err := h.readExt(patch, newOptions(opts)); err != nil {
+		return nil, err
+	}
+	for k, v := range h.ext {
+		if strings.HasPrefix(k, extMeta) {
+			meta[strings.TrimPrefix(k, extMeta)] = string(v)
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,78 @@
+package bspatch
+
+import "context"
+
+// Option configures how a patch is applied
+type Option func(*options)
+
+type options struct {
+	decompressors []Decompressor
+	// memLimit bounds the memory of a call, memUsed is what it allocated
+	memLimit int64
+	memUsed  int64
+	// strict rejects trailing data, see WithStrict
+	strict bool
+	// oldSHA256 and newSHA256 are the digests of the old and new files,
+	// see WithOldSHA256 and WithNewSHA256
+	oldSHA256, newSHA256 []byte
+	// requireNewSHA256 refuses patches the new file can't be checked with,
+	// see WithRequireNewSHA256
+	requireNewSHA256 bool
+	// maxNewSize bounds the size of the new file, see WithMaxNewSize
+	maxNewSize int64
+	// bufSize is the size of the read buffers, buf the caller's buffer
+	// for them, see WithBuffer
+	bufSize int
+	buf     []byte
+	// ctx cancels the patching, see ReaderCtx
+	ctx      context.Context
+	progress func(stage string, done, total int64)
+	stats    *PatchStats
+	// backup is where File and InPlace copy the file they overwrite, see
+	// WithBackup
+	backup string
+	// resume checkpoints the patching every checkpointInterval bytes, see
+	// Resume
+	resume             *resumer
+	checkpointInterval int64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{bufSize: DefaultBufferSize, ctx: context.Background(), checkpointInterval: DefaultCheckpointInterval}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// DefaultBufferSize is the size of the buffers the old file and the blocks
+// are read with, unless WithBufferSize sets another
+const DefaultBufferSize = 64 * 1024
+
+// WithBufferSize sets the size of the buffers the old file and the blocks of
+// the patch are read with. Two are used at a time. Larger buffers mean fewer
+// reads, which helps on fast disks or network file systems; embedded users
+// can shrink them.
+func WithBufferSize(n int) Option {
+	return func(o *options) {
+		o.bufSize = n
+	}
+}
+
+// WithBuffer reads the old file and the blocks into buf, split in two
+// halves, instead of allocating read buffers, for runtimes where
+// allocations count (see tiny.go). buf mustn't be used by two calls at
+// once.
+func WithBuffer(buf []byte) Option {
+	return func(o *options) {
+		o.buf = buf
+	}
+}
+
+// WithDecompressor makes patches tagged with d's magic readable. It's the
+// counterpart of bsdiff.WithCompressor.
+func WithDecompressor(d Decompressor) Option {
+	return func(o *options) {
+		o.decompressors = append(o.decompressors, d)
+	}
+}
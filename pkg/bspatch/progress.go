@@ -0,0 +1,45 @@
+package bspatch
+
+import (
+	"io"
+
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// StageApply is the stage reported to WithProgress: the writing of the new
+// file, in bytes. The total is -1 for VCDIFF deltas, whose new file size
+// isn't known up front.
+const StageApply = "apply"
+
+// WithProgress calls fn as the new file is written, about every percent of
+// it: done of total bytes are written. fn is called with done 0 first and
+// done == total last, on the goroutine the patch is applied on.
+func WithProgress(fn func(stage string, done, total int64)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// meterWriter reports the bytes written through it to a meter
+type meterWriter struct {
+	w io.Writer
+	m *util.Meter
+	n int64
+}
+
+// progressWriter returns w, reporting StageApply of total bytes
+func (o *options) progressWriter(w io.Writer, total int64) *meterWriter {
+	return &meterWriter{w: w, m: util.NewMeter(o.progress, StageApply, total)}
+}
+
+func (mw *meterWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	mw.n += int64(n)
+	mw.m.Update(mw.n)
+	return n, err
+}
+
+// done reports the end of the stage
+func (mw *meterWriter) done() {
+	mw.m.Done()
+}
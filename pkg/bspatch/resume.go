@@ -0,0 +1,361 @@
+package bspatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// CheckpointSuffix is appended to the name of the new file for that of the
+// sidecar file Resume checkpoints to
+const CheckpointSuffix = ".bspatch-resume"
+
+// DefaultCheckpointInterval is how many bytes of the new file Resume writes
+// between checkpoints, unless WithCheckpointInterval sets another interval
+const DefaultCheckpointInterval = 64 << 20
+
+// WithCheckpointInterval makes Resume checkpoint every n bytes of the new
+// file. Each checkpoint syncs the new file, so shorter intervals lose less
+// work to an interruption but write slower.
+func WithCheckpointInterval(n int64) Option {
+	return func(o *options) {
+		o.checkpointInterval = n
+	}
+}
+
+// Sidecar file is
+//
+//	0	8	"BSRESUM1"
+//	8	32	SHA-256 of the size and first 64 KiB of the patch
+//	40	??	checkpoints
+//
+// Each checkpoint is the state of applyBlocks when it was taken, as nine
+// int64 (the controls read, the current one, how much of it was applied
+// and the new, old, diff and extra positions), the CRC-32C of the new file
+// since the previous checkpoint, and the CRC-32C of the checkpoint. Only
+// the new position is set for patches that aren't plain.
+
+const (
+	resumeMagic    = "BSRESUM1"
+	resumeHeader   = 40
+	checkpointSize = 9*8 + 4 + 4
+)
+
+// Resume applies a patch like File, for large patches on unreliable
+// storage: it checkpoints its progress to newfile+CheckpointSuffix, so that
+// when it's interrupted, by a crash, a power loss or an I/O error, calling
+// it again with the same files continues from the last checkpoint instead
+// of starting over. The ranges of the new file written before are first
+// verified against the checksums of the checkpoints; it resumes from the
+// last intact one.
+//
+// Plain patches are fast-forwarded to the checkpoint: their blocks are
+// decompressed again, but the old file isn't read and nothing is written
+// up to it. Endsley, VCDIFF, executable and archive patches are applied
+// again from the start, without writing the verified range.
+//
+// The new file and the sidecar file are kept when Resume fails, unless the
+// patch is corrupt or isn't for oldfile, and the sidecar file is removed
+// once it succeeds. WithBackup has no effect.
+func Resume(oldfile, newfile, patchfile string, opts ...Option) error {
+	return ResumeCtx(context.Background(), oldfile, newfile, patchfile, opts...)
+}
+
+// ResumeCtx is Resume, stopping with ctx.Err() when ctx is cancelled. The
+// progress up to the last checkpoint is kept.
+func ResumeCtx(ctx context.Context, oldfile, newfile, patchfile string, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	oldF, err := os.Open(oldfile)
+	if err != nil {
+		return fmt.Errorf("could not open oldfile '%v': %w", oldfile, err)
+	}
+	defer oldF.Close()
+	patchF, err := os.Open(patchfile)
+	if err != nil {
+		return fmt.Errorf("could not open patchfile '%v': %w", patchfile, err)
+	}
+	defer patchF.Close()
+	o := newOptions(opts)
+	o.ctx = ctx
+	if o.checkpointInterval <= 0 {
+		return fmt.Errorf("invalid checkpoint interval %v", o.checkpointInterval)
+	}
+	h, err := readHeader(patchF, o)
+	if err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if applied, err := h.appliedFile(newfile); err != nil || applied {
+		if applied {
+			// Interrupted as it completed
+			os.Remove(newfile + CheckpointSuffix)
+			err = ErrAlreadyApplied
+		}
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	r, err := openResumer(newfile, patchF, h)
+	if err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	o.resume = r
+	if err = h.patch(oldF, patchF, r); err == nil {
+		err = r.finish()
+	}
+	if err != nil {
+		r.close()
+		if errors.Is(err, ErrCorruptPatch) || errors.Is(err, ErrWrongOld) || errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrTooLarge) {
+			// Trying again won't help
+			os.Remove(newfile)
+			os.Remove(newfile + CheckpointSuffix)
+		}
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	if err = h.restoreFileInfo(newfile); err != nil {
+		return fmt.Errorf("bspatch: %w", err)
+	}
+	return nil
+}
+
+// applyState is where applyBlocks is in a plain patch
+type applyState struct {
+	// ctrls is the number of controls read, ctrl the last one and ctrlDone
+	// how many bytes of it were applied
+	ctrls    int64
+	ctrl     [3]int64
+	ctrlDone int64
+	// newpos, oldpos, diffpos and extrapos are the positions in the new
+	// and old files and in the decompressed diff and extra blocks
+	newpos, oldpos    int64
+	diffpos, extrapos int64
+}
+
+func (st *applyState) fields() []*int64 {
+	return []*int64{&st.ctrls, &st.ctrl[0], &st.ctrl[1], &st.ctrl[2], &st.ctrlDone, &st.newpos, &st.oldpos, &st.diffpos, &st.extrapos}
+}
+
+// valid reports whether st is a state of a patch making newsize bytes
+func (st *applyState) valid(newsize int64) bool {
+	return st.ctrls > 0 && st.ctrl[0] >= 0 && st.ctrl[1] >= 0 &&
+		st.ctrlDone >= 0 && st.ctrlDone <= st.ctrl[0]+st.ctrl[1] &&
+		st.newpos <= newsize && st.diffpos >= 0 && st.extrapos >= 0
+}
+
+// resumer is the new file of Resume, checkpointed to the sidecar file
+type resumer struct {
+	f, side *os.File
+	// plain patches are fast-forwarded to st, the state of applyBlocks
+	plain bool
+	st    applyState
+	// pos is the size of the new file made, last its size at the last
+	// checkpoint and verified the size resumed from
+	pos, last, verified int64
+	interval            int64
+	// crc is that of the new file since the last checkpoint
+	crc hash.Hash32
+	// prefix is the SHA-256 of the verified range of a fast-forwarded
+	// patch, see checkNew
+	prefix hash.Hash
+}
+
+// openResumer opens newfile and its sidecar file, verifies the checkpoints
+// and returns the resumer of the last intact one, if any
+func openResumer(newfile string, patch io.ReaderAt, h *header) (_ *resumer, err error) {
+	r := &resumer{
+		plain:    h.plain() && h.magic != magicEndsley,
+		interval: h.o.checkpointInterval,
+		crc:      crc32.New(castagnoli),
+	}
+	if r.f, err = os.OpenFile(newfile, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		return nil, fmt.Errorf("could not create newfile '%v': %w", newfile, err)
+	}
+	if r.side, err = os.OpenFile(newfile+CheckpointSuffix, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		r.f.Close()
+		return nil, fmt.Errorf("could not create checkpoint file '%v': %w", newfile+CheckpointSuffix, err)
+	}
+	defer func() {
+		if err != nil {
+			r.close()
+		}
+	}()
+	hdr := make([]byte, 0, resumeHeader)
+	hdr = append(hdr, resumeMagic...)
+	hdr = append(hdr, patchID(patch)...)
+	end, err := r.load(hdr, int64(h.newsize))
+	if err != nil {
+		return nil, err
+	}
+	if r.verified == 0 {
+		// Nothing to resume from
+		r.st = applyState{}
+		if err = r.f.Truncate(0); err != nil {
+			return nil, err
+		}
+		if err = r.side.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err = r.side.WriteAt(hdr, 0); err != nil {
+			return nil, err
+		}
+		end = resumeHeader
+	} else if err = r.side.Truncate(end); err != nil {
+		// Checkpoints after the last intact one are dropped
+		return nil, err
+	}
+	if err = r.side.Sync(); err != nil {
+		return nil, err
+	}
+	if _, err = r.side.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r.last = r.verified
+	if r.plain {
+		r.pos = r.verified
+	} else {
+		r.prefix = nil
+	}
+	return r, nil
+}
+
+// load reads the checkpoints of the sidecar file made for the patch of
+// header hdr and verifies the new file against them, setting the state of
+// the last intact one. It returns where that checkpoint ends.
+func (r *resumer) load(hdr []byte, newsize int64) (int64, error) {
+	b, err := io.ReadAll(r.side)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < resumeHeader || string(b[:resumeHeader]) != string(hdr) {
+		return 0, nil
+	}
+	end := int64(resumeHeader)
+	prefix := sha256.New()
+	buf := make([]byte, 64<<10)
+	for b = b[resumeHeader:]; len(b) >= checkpointSize; b = b[checkpointSize:] {
+		rec := b[:checkpointSize]
+		if crc32.Checksum(rec[:checkpointSize-4], castagnoli) != binary.LittleEndian.Uint32(rec[checkpointSize-4:]) {
+			break
+		}
+		var st applyState
+		for i, v := range st.fields() {
+			*v = int64(binary.LittleEndian.Uint64(rec[8*i:]))
+		}
+		if st.newpos <= r.verified || r.plain && !st.valid(newsize) {
+			break
+		}
+		// The range since the previous checkpoint must be intact
+		snapshot, err := prefix.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		crc := crc32.New(castagnoli)
+		n, err := io.CopyBuffer(io.MultiWriter(crc, prefix), io.NewSectionReader(r.f, r.verified, st.newpos-r.verified), buf)
+		if err != nil {
+			return 0, fmt.Errorf("could not read newfile: %w", err)
+		}
+		if n != st.newpos-r.verified || crc.Sum32() != binary.LittleEndian.Uint32(rec[checkpointSize-8:]) {
+			if err = prefix.(encoding.BinaryUnmarshaler).UnmarshalBinary(snapshot); err != nil {
+				return 0, err
+			}
+			break
+		}
+		r.st, r.verified = st, st.newpos
+		end += checkpointSize
+	}
+	r.prefix = prefix
+	return end, nil
+}
+
+// patchID identifies a patch by its size and first 64 KiB
+func patchID(patch io.ReaderAt) []byte {
+	d := sha256.New()
+	binary.Write(d, binary.LittleEndian, patchSize(patch))
+	io.Copy(d, io.NewSectionReader(patch, 0, 64<<10))
+	return d.Sum(nil)
+}
+
+func (r *resumer) Write(p []byte) (int, error) {
+	n := len(p)
+	if skip := r.verified - r.pos; skip > 0 {
+		// Applied again from the start: the verified range is on disk
+		if int64(len(p)) <= skip {
+			r.pos += int64(len(p))
+			return n, nil
+		}
+		r.pos += skip
+		p = p[skip:]
+	}
+	if _, err := r.f.WriteAt(p, r.pos); err != nil {
+		return 0, err
+	}
+	r.crc.Write(p)
+	r.pos += int64(len(p))
+	if r.pos-r.last >= r.interval {
+		if err := r.checkpoint(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// skip fast-forwards the decompressed blocks of a plain patch to the state
+// resumed from
+func (r *resumer) skip(ctrl, diff, extra io.Reader) error {
+	for i, n := range []int64{24 * r.st.ctrls, r.st.diffpos, r.st.extrapos} {
+		if _, err := io.CopyN(io.Discard, []io.Reader{ctrl, diff, extra}[i], n); err != nil {
+			return patchErrorf(ErrCorruptPatch, []string{SectionCtrl, SectionDiff, SectionExtra}[i], -1, err, "corrupt patch or checkpoint (%v block ends before %v bytes)", blockNames[i], n)
+		}
+	}
+	return nil
+}
+
+// checkpoint syncs the new file, then records the state it's in
+func (r *resumer) checkpoint() error {
+	if err := r.f.Sync(); err != nil {
+		return err
+	}
+	st := r.st
+	if !r.plain {
+		st = applyState{newpos: r.pos}
+	}
+	rec := make([]byte, 0, checkpointSize)
+	for _, v := range st.fields() {
+		rec = binary.LittleEndian.AppendUint64(rec, uint64(*v))
+	}
+	rec = binary.LittleEndian.AppendUint32(rec, r.crc.Sum32())
+	rec = binary.LittleEndian.AppendUint32(rec, crc32.Checksum(rec, castagnoli))
+	if _, err := r.side.Write(rec); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	if err := r.side.Sync(); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	r.last = r.pos
+	r.crc.Reset()
+	return nil
+}
+
+// finish completes the new file and removes the sidecar file
+func (r *resumer) finish() error {
+	if err := r.f.Truncate(r.pos); err != nil {
+		return err
+	}
+	if err := r.f.Sync(); err != nil {
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.side.Close()
+	return os.Remove(r.side.Name())
+}
+
+func (r *resumer) close() {
+	r.f.Close()
+	r.side.Close()
+}
@@ -0,0 +1,85 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestResume(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	oldbs := make([]byte, 1<<20)
+	rnd.Read(oldbs)
+	// Many controls, with diff and extra bytes
+	var newbs []byte
+	for i := 0; i < len(oldbs); i += 100000 {
+		end := i + 100000
+		if end > len(oldbs) {
+			end = len(oldbs)
+		}
+		inserted := make([]byte, 20000)
+		rnd.Read(inserted)
+		newbs = append(append(newbs, oldbs[i:end]...), inserted...)
+		copy(newbs[i+5000:], "a few bytes changed")
+	}
+	dir := t.TempDir()
+	oldfile, newfile := patchtest.WriteFile(t, dir, "old", oldbs), filepath.Join(dir, "new")
+	for _, format := range []bsdiff.Format{bsdiff.FormatBSDIFF40, bsdiff.FormatEndsley} {
+		// Endsley patches are applied again from the start
+		opts := []bsdiff.Option{bsdiff.WithFormat(format)}
+		if format != bsdiff.FormatEndsley {
+			opts = append(opts, bsdiff.WithHashes())
+		}
+		patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patchfile := patchtest.WriteFile(t, dir, "patch", patch)
+		for _, corrupt := range []bool{false, true} {
+			// Interrupted two thirds of the way
+			ctx, cancel := context.WithCancel(context.Background())
+			progress := bspatch.WithProgress(func(stage string, done, total int64) {
+				if done > total*2/3 {
+					cancel()
+				}
+			})
+			opts := []bspatch.Option{bspatch.WithBufferSize(4 << 10), bspatch.WithCheckpointInterval(64 << 10)}
+			err = bspatch.ResumeCtx(ctx, oldfile, newfile, patchfile, append(opts, progress)...)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatal(format, "expected context.Canceled, got", err)
+			}
+			fi, err := os.Stat(newfile + bspatch.CheckpointSuffix)
+			if err != nil || fi.Size() < 40+80*8 {
+				t.Fatal(format, "too few checkpoints", err)
+			}
+			if corrupt {
+				// The checkpoints from there on are dropped
+				f, err := os.OpenFile(newfile, os.O_RDWR, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteAt([]byte("corrupt"), 300000)
+				f.Close()
+			}
+			if err = bspatch.Resume(oldfile, newfile, patchfile, opts...); err != nil {
+				t.Fatal(format, err)
+			}
+			if b, _ := os.ReadFile(newfile); !bytes.Equal(b, newbs) {
+				t.Fatal(format, corrupt, "resumed the wrong file")
+			}
+			if _, err = os.Stat(newfile + bspatch.CheckpointSuffix); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal(format, "checkpoint file left behind")
+			}
+			os.Remove(newfile)
+		}
+	}
+}
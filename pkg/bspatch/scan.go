@@ -0,0 +1,145 @@
+package bspatch
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+)
+
+// Control is a control triple of a patch with its data: the new file
+// continues with the Diff bytes added to the old file, then the Extra bytes,
+// and the old position moves forward by len(Diff)+Seek.
+type Control struct {
+	Diff  []byte
+	Extra []byte
+	Seek  int
+}
+
+// Scan reads the control triples of a patch without applying it and calls
+// fn with each, in order; the old file isn't needed. The slices of a Control
+// are only valid during the call. VCDIFF deltas are translated to control
+// triples: COPYs from the source have zero diff bytes and everything else is
+// extra data. Patches made with bsdiff.WithExecutable, WithTar or WithZip
+// can't be scanned: Scan returns a PatchError of kind ErrUnsupportedFormat.
+func Scan(patch io.ReaderAt, fn func(c Control) error, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	h, err := readHeader(patch, newOptions(opts))
+	if err != nil {
+		return err
+	}
+	if h.magic == magicVCDIFF {
+		return scanVCDIFF(patch, fn)
+	}
+	if h.ext[extExec] != nil {
+		// the triples apply to normalized executables
+		return patchErrorf(ErrUnsupportedFormat, SectionExtension, -1, nil, "patch needs the old file (executable transform)")
+	}
+	if h.ext[extTar] != nil {
+		// the triples apply to the reordered archive
+		return patchErrorf(ErrUnsupportedFormat, SectionExtension, -1, nil, "patch needs the old file (tar permutation)")
+	}
+	if h.ext[extZip] != nil {
+		// the triples apply to the inflated archives
+		return patchErrorf(ErrUnsupportedFormat, SectionExtension, -1, nil, "patch needs the old file (zip transform)")
+	}
+	cpfbz2, dpfbz2, epfbz2, err := h.openBlocks(patch)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 24)
+	var db, eb []byte
+	// The bytes of the ctrl, diff and extra blocks read
+	var ctrlpos, diffpos, extrapos int64
+	for newpos := 0; newpos < h.newsize; {
+		if n, err := io.ReadFull(cpfbz2, buf); err != nil {
+			return patchErrorf(ErrCorruptPatch, SectionCtrl, ctrlpos+int64(n), err, "corrupt patch or bzstream ended: %s", err.Error())
+		}
+		add, cp, seek := offtin(buf), offtin(buf[8:]), offtin(buf[16:])
+		if add < 0 || cp < 0 || seek < -maxOffset || seek > maxOffset {
+			return patchErrorf(ErrCorruptPatch, SectionCtrl, ctrlpos, nil, "corrupt patch (sanity check)")
+		}
+		if newpos+add+cp > h.newsize {
+			return patchErrorf(ErrSizeMismatch, SectionCtrl, ctrlpos, nil, "corrupt patch (sanity check)")
+		}
+		ctrlpos += 24
+		if db, err = readBlock(dpfbz2, db, add); err != nil {
+			return patchErrorf(ErrCorruptPatch, SectionDiff, diffpos, err, "corrupt patch or bzstream ended (2): %s", err.Error())
+		}
+		diffpos += int64(add)
+		if eb, err = readBlock(epfbz2, eb, cp); err != nil {
+			return patchErrorf(ErrCorruptPatch, SectionExtra, extrapos, err, "corrupt patch or bzstream ended (3): %s", err.Error())
+		}
+		extrapos += int64(cp)
+		if err = fn(Control{Diff: db, Extra: eb, Seek: seek}); err != nil {
+			return err
+		}
+		newpos += add + cp
+	}
+	if err = h.o.checkTrailing([3]io.Reader{cpfbz2, dpfbz2, epfbz2}, [3]int64{ctrlpos, diffpos, extrapos}); err != nil {
+		return err
+	}
+	if err = cpfbz2.Close(); err != nil {
+		return err
+	}
+	if err = dpfbz2.Close(); err != nil {
+		return err
+	}
+	return epfbz2.Close()
+}
+
+// readBlock reads n bytes of r into buf, growing it as the bytes come, so a
+// bogus length fails at the end of the block rather than allocating it
+func readBlock(r io.Reader, buf []byte, n int) ([]byte, error) {
+	if cap(buf) >= n {
+		buf = buf[:n]
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	b := bytes.NewBuffer(buf[:0])
+	_, err := io.CopyN(b, r, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b.Bytes(), err
+}
+
+// scanVCDIFF translates the pieces of a VCDIFF target to control triples,
+// merging contiguous source copies
+func scanVCDIFF(patch io.ReaderAt, fn func(c Control) error) error {
+	var (
+		oldpos, add int
+		extra       []byte
+		zeros       []byte
+	)
+	flush := func(seek int) error {
+		if cap(zeros) < add {
+			zeros = make([]byte, add)
+		}
+		return fn(Control{Diff: zeros[:add], Extra: extra, Seek: seek})
+	}
+	err := vcdiff.Scan(io.NewSectionReader(patch, 0, 1<<62), func(op vcdiff.Op) error {
+		if op.Data != nil {
+			extra = append(extra, op.Data...)
+			return nil
+		}
+		if len(extra) == 0 && op.Pos == oldpos+add {
+			add += op.Len
+			return nil
+		}
+		if add > 0 || len(extra) > 0 {
+			if err := flush(op.Pos - (oldpos + add)); err != nil {
+				return err
+			}
+		}
+		oldpos, add, extra = op.Pos, op.Len, extra[:0]
+		return nil
+	})
+	if err != nil {
+		return vcdiffError(err)
+	}
+	if add > 0 || len(extra) > 0 {
+		return flush(0)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package bspatch
+
+import "time"
+
+// PatchStats are statistics of applying a patch, for tuning and monitoring
+type PatchStats struct {
+	// Controls is the number of control triples; VCDIFF deltas have none
+	Controls int
+	// Matched is the number of bytes of the new file from the diff block,
+	// Extra those from the extra block
+	Matched, Extra int64
+	// ApplyTime is the time taken to decompress the blocks and write the
+	// new file
+	ApplyTime time.Duration
+	// PeakMemory is the most memory allocated at once for the buffers
+	// WithMemoryLimit accounts for, other than the windows of decompressors
+	PeakMemory int64
+}
+
+// WithStats fills s with the statistics of applying the patch. s is reset
+// by every call the option is passed to, so it must not be shared by
+// concurrent calls.
+func WithStats(s *PatchStats) Option {
+	return func(o *options) {
+		*s = PatchStats{}
+		o.stats = s
+	}
+}
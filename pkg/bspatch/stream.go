@@ -0,0 +1,174 @@
+package bspatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// ApplyStream applies a patch read strictly sequentially from patch, e.g.
+// while it's being downloaded, and writes the new file to out in order.
+//
+// Endsley patches and VCDIFF deltas are applied as they're read. The other
+// layouts store the ctrl and diff blocks one after the other, so both are
+// staged in memory (compressed) and the extra block is streamed. Patches made
+// with bsdiff.WithExecutable are staged whole.
+func ApplyStream(oldfile io.ReaderAt, patch io.Reader, out io.Writer, opts ...Option) (err error) {
+	defer recoverPanic(&err)
+	o := newOptions(opts)
+	br := bufio.NewReaderSize(patch, o.bufSize)
+	if magic, _ := br.Peek(len(magicVCDIFF)); string(magic) == magicVCDIFF {
+		// Only the digests of WithOldSHA256 and WithNewSHA256 apply
+		h := &header{magic: magicVCDIFF, o: o}
+		if err = h.checkOld(oldfile); err != nil {
+			return err
+		}
+		return h.checkNew(out, func(w io.Writer) error {
+			return o.decodeVCDIFF(oldfile, br, w)
+		})
+	}
+	hdr, err := readStreamHeader(br, o)
+	if err != nil {
+		return err
+	}
+	h, err := readHeader(bytes.NewReader(hdr), o)
+	if err != nil {
+		return err
+	}
+	if h.magic == magicExtended {
+		// The extension area is accounted for by readStreamHeader and
+		// readHeader
+		o.free(h.blockoff - 40)
+	}
+	if err = h.checkOld(oldfile); err != nil {
+		return err
+	}
+	if h.ext[extExec] != nil || h.ext[extTar] != nil || h.ext[extZip] != nil {
+		rest, err := o.readAll(br, "patch")
+		if err != nil {
+			return err
+		}
+		return h.checkNew(out, func(w io.Writer) error {
+			patch := bytes.NewReader(append(hdr, rest...))
+			switch {
+			case h.ext[extZip] != nil:
+				return h.applyZip(oldfile, patch, w)
+			case h.ext[extTar] != nil:
+				return h.applyTar(oldfile, patch, w)
+			}
+			return h.applyExe(oldfile, patch, w)
+		})
+	}
+	// Bytes read past the header belong to the blocks
+	blocks := io.MultiReader(bytes.NewReader(hdr[h.blockoff:]), br)
+
+	var ctrl, diff, extra io.ReadCloser
+	var eb io.Reader
+	if h.magic == magicEndsley {
+		if ctrl, err = h.newReader(0, blocks); err != nil {
+			return err
+		}
+		diff, extra = io.NopCloser(ctrl), io.NopCloser(ctrl)
+	} else {
+		cb, err := readStreamBlock(blocks, h.ctrllen, SectionCtrl, "ctrl block", o)
+		if err != nil {
+			return err
+		}
+		db, err := readStreamBlock(blocks, h.datalen, SectionDiff, "diff block", o)
+		if err != nil {
+			return err
+		}
+		if err = h.checkBlock(0, bytes.NewReader(cb)); err != nil {
+			return err
+		}
+		if err = h.checkBlock(1, bytes.NewReader(db)); err != nil {
+			return err
+		}
+		// The extra block is only checked once it's read
+		if eb, err = h.checkedBlock(2, blocks); err != nil {
+			return err
+		}
+		if ctrl, err = h.newReader(0, bytes.NewReader(cb)); err != nil {
+			return err
+		}
+		if len(db) == 0 {
+			// A stripped empty block, see bsdiff.WithStripEmptyBlocks
+			diff = io.NopCloser(bytes.NewReader(nil))
+		} else if diff, err = h.newReader(1, bytes.NewReader(db)); err != nil {
+			return err
+		}
+		// The extra block is the rest of the stream
+		ebr := bufio.NewReader(eb)
+		if _, perr := ebr.Peek(1); perr == io.EOF {
+			extra = io.NopCloser(ebr)
+		} else if extra, err = h.newReader(2, ebr); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriterSize(out, o.bufSize)
+	err = h.checkNew(bw, func(w io.Writer) error {
+		if err := h.applyBlocks(oldfile, ctrl, diff, extra, w); err != nil {
+			return err
+		}
+		for _, rc := range []io.Closer{ctrl, diff, extra} {
+			if err := rc.Close(); err != nil {
+				return err
+			}
+		}
+		// Decompressors may stop short of the end of the block
+		if cr, ok := eb.(*crcReader); ok {
+			_, err := io.Copy(io.Discard, cr)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readStreamHeader reads the 32 byte header and, for BSDIFF4X patches, the
+// extension area
+func readStreamHeader(r io.Reader, o *options) ([]byte, error) {
+	hdr := make([]byte, 32, 40)
+	if n, err := io.ReadFull(r, hdr); err != nil {
+		return nil, patchErrorf(ErrCorruptPatch, SectionHeader, int64(n), err, "corrupt patch (n %v < 32)", n)
+	}
+	if string(hdr[:8]) != magicExtended {
+		return hdr, nil
+	}
+	hdr = hdr[:40]
+	if _, err := io.ReadFull(r, hdr[32:]); err != nil {
+		return nil, patchErrorf(ErrCorruptPatch, SectionExtension, 32, err, "corrupt patch (extension length) %v", err.Error())
+	}
+	extlen := offtin(hdr[32:])
+	if extlen < 0 {
+		return nil, patchErrorf(ErrCorruptPatch, SectionExtension, 32, nil, "corrupt patch (extension length %v)", extlen)
+	}
+	ext, err := readStreamBlock(r, extlen, SectionExtension, "extension area", o)
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr, ext...), nil
+}
+
+// readStreamBlock reads n bytes of section of the patch. n comes from the
+// patch, so the buffer grows with the data actually read.
+func readStreamBlock(r io.Reader, n int, section, name string, o *options) ([]byte, error) {
+	if err := o.alloc(name, n); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != n {
+		off := int64(len(b))
+		if section == SectionExtension {
+			off += 40
+		}
+		return nil, patchErrorf(ErrCorruptPatch, section, off, nil, "corrupt patch (%v truncated at %v of %v bytes)", name, len(b), n)
+	}
+	return b, nil
+}
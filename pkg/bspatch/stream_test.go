@@ -0,0 +1,55 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabstv/go-bsdiff/internal/patchtest"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestApplyStream(t *testing.T) {
+	oldbs, newbs := patchtest.Files(1024 * 16)
+	for _, opts := range [][]bsdiff.Option{
+		nil,
+		{bsdiff.WithCompressor(bsdiff.Zstd)},
+		{bsdiff.WithBlockCompressors(bsdiff.Bzip2, bsdiff.Raw, bsdiff.Xz)},
+		{bsdiff.WithMetadata("channel", "beta")},
+		{bsdiff.WithFormat(bsdiff.FormatEndsley)},
+		{bsdiff.WithFormat(bsdiff.FormatBSDF2), bsdiff.WithCompressor(bsdiff.Brotli)},
+		{bsdiff.WithExecutable()},
+	} {
+		patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		// The patch is read one byte at a time, without io.ReaderAt
+		if err = bspatch.ApplyStream(bytes.NewReader(oldbs), iotest.OneByteReader(bytes.NewReader(patch)), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(newbs, out.Bytes()) {
+			t.Fatal("round trip failed", string(patch[:8]))
+		}
+		err = bspatch.ApplyStream(bytes.NewReader(oldbs), bytes.NewReader(patch[:len(patch)/2]), io.Discard)
+		if err == nil {
+			t.Fatal("expected an error for a truncated patch", string(patch[:8]))
+		}
+	}
+
+	delta, err := vcdiff.Diff(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = bspatch.ApplyStream(bytes.NewReader(oldbs), iotest.OneByteReader(bytes.NewReader(delta)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newbs, out.Bytes()) {
+		t.Fatal("VCDIFF round trip failed")
+	}
+}
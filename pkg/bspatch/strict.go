@@ -0,0 +1,32 @@
+package bspatch
+
+import "io"
+
+// WithStrict rejects patches that apply but carry more than the new file
+// needs: trailing data after the controls, diff or extra bytes a block
+// declares, e.g. a block longer than its compressed stream. It's recommended
+// for untrusted patches. Without it such data is ignored, as by the classic
+// bspatch. Truncated blocks and out of range lengths fail either way.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// checkTrailing checks that the decompressed ctrl, diff and extra blocks
+// end at offs, where their last bytes were read, in strict mode
+func (o *options) checkTrailing(blocks [3]io.Reader, offs [3]int64) error {
+	if !o.strict {
+		return nil
+	}
+	b := make([]byte, 1)
+	for i, r := range blocks {
+		if n, err := io.ReadFull(r, b); n > 0 || err != io.EOF {
+			if err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			return patchErrorf(ErrCorruptPatch, blockSections[i], offs[i], err, "corrupt patch (trailing data after the %v block)", blockNames[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package bspatch_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestStrictCompressors(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	for _, c := range []bsdiff.Compressor{bsdiff.Bzip2, bsdiff.Zstd, bsdiff.Xz, bsdiff.Brotli, bsdiff.Raw} {
+		patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithCompressor(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = bspatch.Bytes(w.Old, patch, bspatch.WithStrict()); err != nil {
+			t.Fatal(c.Magic(), err)
+		}
+		if err = bspatch.ApplyStream(bytes.NewReader(w.Old), bytes.NewReader(patch), io.Discard, bspatch.WithStrict()); err != nil {
+			t.Fatal(c.Magic(), err)
+		}
+	}
+	patch, err := bsdiff.Bytes(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := append(patch, "garbage"...)
+	if _, err = bspatch.Bytes(w.Old, garbage); err != nil {
+		t.Fatal("lenient mode should ignore trailing garbage, got", err)
+	}
+	if _, err = bspatch.Bytes(w.Old, garbage, bspatch.WithStrict()); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected strict mode to reject trailing garbage, got", err)
+	}
+}
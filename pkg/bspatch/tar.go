@@ -0,0 +1,35 @@
+//go:build !bspatch_tiny
+
+package bspatch
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/tarball"
+)
+
+// applyTar applies a patch made with bsdiff.WithTar or bsdiff.WithBlockAlign:
+// the entries (or blocks) of the old archive are reordered the same way as when diffing, and the patch applied
+// to the result. The old file is held in memory, twice.
+func (h *header) applyTar(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	ranges, err := tarball.Unmarshal(h.ext[extTar])
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (%v)", err.Error())
+	}
+	oldbs, err := h.o.readAll(io.NewSectionReader(oldfile, 0, 1<<62), "old file")
+	if err != nil {
+		return err
+	}
+	if !tarball.Check(ranges, len(oldbs)) {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, nil, "corrupt patch (tar entries out of bounds)")
+	}
+	if err = h.o.alloc("old file", len(oldbs)); err != nil {
+		return err
+	}
+	aligned := bytes.NewReader(tarball.Permute(oldbs, ranges))
+	if h.ext[extExec] != nil {
+		return h.applyExe(aligned, patch, w)
+	}
+	return h.apply(aligned, patch, w)
+}
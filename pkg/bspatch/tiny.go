@@ -0,0 +1,31 @@
+//go:build bspatch_tiny
+
+package bspatch
+
+import "io"
+
+// Building with the bspatch_tiny tag is the profile for TinyGo, WASI and
+// other constrained runtimes, such as plugin sandboxes and edge workers: on
+// top of bspatch_stdlib, it drops the executable, tar and zip transforms
+// and their dependencies (debug/elf, archive/tar, archive/zip and the
+// like). Patches made with bsdiff.WithExecutable, WithTar, WithZip,
+// WithSquashfs or WithBlockAlign fail with ErrUnsupportedFormat. Combined
+// with BytesInto and WithBuffer, patching allocates little beyond what the
+// decompressor needs, which for BSDIFRW0 patches is nothing, and uses no
+// temporary files.
+
+func (h *header) applyExe(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	return h.unsupported("executable")
+}
+
+func (h *header) applyTar(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	return h.unsupported("tar")
+}
+
+func (h *header) applyZip(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	return h.unsupported("zip")
+}
+
+func (h *header) unsupported(transform string) error {
+	return patchErrorf(ErrUnsupportedFormat, SectionExtension, 40, nil, "unsupported patch format (%v transform, left out of bspatch_tiny builds)", transform)
+}
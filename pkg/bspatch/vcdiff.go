@@ -0,0 +1,38 @@
+package bspatch
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+)
+
+// patchVCDIFF applies a VCDIFF delta. Only the default code table is
+// supported, and of xdelta3's secondary compressors lzma and djw.
+func (h *header) patchVCDIFF(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	return h.o.decodeVCDIFF(oldfile, io.NewSectionReader(patch, 0, 1<<62), w)
+}
+
+// vcdiffError returns the errors of corrupt or unsupported VCDIFF deltas as
+// a PatchError, and other errors as they are
+func vcdiffError(err error) error {
+	switch {
+	case errors.Is(err, vcdiff.ErrCorrupt):
+		return patchErrorf(ErrCorruptPatch, SectionVCDIFF, -1, err, "%v", err.Error())
+	case errors.Is(err, vcdiff.ErrUnsupported):
+		return patchErrorf(ErrUnsupportedFormat, SectionVCDIFF, -1, err, "%v", err.Error())
+	}
+	return err
+}
+
+// offsetWriter writes sequentially to an io.WriterAt
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
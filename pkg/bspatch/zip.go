@@ -0,0 +1,51 @@
+//go:build !bspatch_tiny
+
+package bspatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/zipfile"
+)
+
+// applyZip applies a patch made with bsdiff.WithZip or bsdiff.WithSquashfs:
+// the entries of the old archive (or the blocks of the old image) are
+// inflated the same way as when diffing, the patch applied to
+// the result, and the entries of the new archive deflated again. Both
+// archives are held in memory, inflated.
+func (h *header) applyZip(oldfile io.ReaderAt, patch io.ReaderAt, w io.Writer) error {
+	t, err := zipfile.Unmarshal(h.ext[extZip])
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (%v)", err.Error())
+	}
+	oldbs, err := h.o.readAll(io.NewSectionReader(oldfile, 0, 1<<62), "old file")
+	if err != nil {
+		return err
+	}
+	size, err := zipfile.ExpandedSize(t.Old, len(oldbs))
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (zip entries out of bounds)")
+	}
+	if err = h.o.alloc("old file", size); err != nil {
+		return err
+	}
+	if oldbs, err = zipfile.Expand(oldbs, t.Old); err != nil {
+		// the entries recorded don't inflate to their size: not this old file
+		return fmt.Errorf("%w (zip entries don't inflate)", ErrWrongOld)
+	}
+	if err = h.o.alloc("new file", h.newsize); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = h.apply(bytes.NewReader(oldbs), patch, &buf); err != nil {
+		return err
+	}
+	newbs, err := zipfile.Compress(buf.Bytes(), t.New)
+	if err != nil {
+		return patchErrorf(ErrCorruptPatch, SectionExtension, 40, err, "corrupt patch (zip entries don't deflate back)")
+	}
+	_, err = w.Write(newbs)
+	return err
+}
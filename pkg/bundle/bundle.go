@@ -0,0 +1,435 @@
+// Package bundle ships a set of named patches as a single file: the patches
+// concatenated, followed by an index of their names, offsets, sizes and
+// SHA-256 digests. Bundles are written in one pass, and read with
+// io.ReaderAt, so listing one or extracting a patch doesn't read the others.
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Bundle is
+//
+//	0	8	"BSBUNDL1"
+//	8	??	patches
+//	??	??	index
+//	??	8	offset of the index
+//	??	8	"BSBUNDL1"
+//
+// The index is the uvarint number of entries, then for each the uvarint
+// length of its name and the name, its uvarint offset and size, the SHA-256
+// of the patch, and a byte of flags: 1 if the SHA-256 of the old file
+// follows, 2 if that of the new file does.
+
+// Magic starts and ends every bundle
+const Magic = "BSBUNDL1"
+
+const (
+	flagOld = 1 << iota
+	flagNew
+)
+
+// Extension record keys, as written by bsdiff
+const (
+	extSHA256Old = "sha256.old"
+	extSHA256New = "sha256.new"
+)
+
+// ReverseSuffix is appended to the name of a patch for that of its undo
+// patch, from its new file back to its old one (see Writer.AddBothWays)
+const ReverseSuffix = ".reverse"
+
+// footerSize is the size of the index offset and the magic ending a bundle
+const footerSize = 16
+
+// maxIndex is the largest index read
+const maxIndex = 64 << 20
+
+// Entry is a patch of a bundle
+type Entry struct {
+	// Name is the slash separated name of the patch, usually that of the
+	// file it patches
+	Name string
+	// Offset and Size locate the patch in the bundle
+	Offset, Size int64
+	// SHA256 is the digest of the patch
+	SHA256 []byte
+	// OldSHA256 and NewSHA256 are the digests of the old and new files
+	// recorded by bsdiff.WithHashes, nil if the patch doesn't have them
+	OldSHA256, NewSHA256 []byte
+}
+
+// Writer writes a bundle
+type Writer struct {
+	w       io.Writer
+	off     int64
+	entries []Entry
+	names   map[string]bool
+	err     error
+}
+
+// NewWriter returns a Writer writing a bundle to w. Close writes the index.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, names: make(map[string]bool)}
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.off += int64(n)
+}
+
+// Add appends patch to the bundle as name, with the digests of the old and
+// new files its header records
+func (w *Writer) Add(name string, patch []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid patch name %q", name)
+	}
+	if w.names[name] {
+		return fmt.Errorf("duplicate patch name %q", name)
+	}
+	h, err := bspatch.ReadHeader(bytes.NewReader(patch))
+	if err != nil {
+		return fmt.Errorf("could not add '%v': %w", name, err)
+	}
+	if w.off == 0 {
+		w.write([]byte(Magic))
+	}
+	sum := sha256.Sum256(patch)
+	e := Entry{Name: name, Offset: w.off, Size: int64(len(patch)), SHA256: sum[:]}
+	if s := h.Records[extSHA256Old]; len(s) == sha256.Size {
+		e.OldSHA256 = s
+	}
+	if s := h.Records[extSHA256New]; len(s) == sha256.Size {
+		e.NewSHA256 = s
+	}
+	w.write(patch)
+	w.entries = append(w.entries, e)
+	w.names[name] = true
+	return w.err
+}
+
+// AddBothWays appends forward as name and reverse, its undo patch made by
+// bsdiff.Reverse or bsdiff.BothWays, as name+ReverseSuffix, so the update
+// can be rolled back (see Revert) without keeping the previous version.
+// Digests recorded in both must mirror each other.
+func (w *Writer) AddBothWays(name string, forward, reverse []byte) error {
+	if err := w.Add(name, forward); err != nil {
+		return err
+	}
+	if err := w.Add(name+ReverseSuffix, reverse); err != nil {
+		return err
+	}
+	f, r := w.entries[len(w.entries)-2], w.entries[len(w.entries)-1]
+	if f.OldSHA256 != nil && r.NewSHA256 != nil && !bytes.Equal(f.OldSHA256, r.NewSHA256) ||
+		f.NewSHA256 != nil && r.OldSHA256 != nil && !bytes.Equal(f.NewSHA256, r.OldSHA256) {
+		w.err = fmt.Errorf("the reverse patch of %q isn't its undo patch", name)
+	}
+	return w.err
+}
+
+// Close writes the index of the bundle. It doesn't close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.off == 0 {
+		w.write([]byte(Magic))
+	}
+	index := w.off
+	b := binary.AppendUvarint(nil, uint64(len(w.entries)))
+	for _, e := range w.entries {
+		b = binary.AppendUvarint(b, uint64(len(e.Name)))
+		b = append(b, e.Name...)
+		b = binary.AppendUvarint(b, uint64(e.Offset))
+		b = binary.AppendUvarint(b, uint64(e.Size))
+		b = append(b, e.SHA256...)
+		var flags byte
+		if e.OldSHA256 != nil {
+			flags |= flagOld
+		}
+		if e.NewSHA256 != nil {
+			flags |= flagNew
+		}
+		b = append(b, flags)
+		b = append(b, e.OldSHA256...)
+		b = append(b, e.NewSHA256...)
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(index))
+	w.write(append(b, Magic...))
+	return w.err
+}
+
+// Reader reads a bundle
+type Reader struct {
+	r io.ReaderAt
+	// Entries are the patches of the bundle, in order
+	Entries []Entry
+}
+
+// NewReader reads the index of the bundle r of size bytes
+func NewReader(r io.ReaderAt, size int64) (_ *Reader, err error) {
+	defer util.Recover(&err)
+	if size < int64(len(Magic))+footerSize {
+		return nil, corrupt("truncated")
+	}
+	var footer [footerSize]byte
+	if _, err = r.ReadAt(footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(Magic))
+	if _, err = r.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	if string(magic) != Magic || string(footer[8:]) != Magic {
+		return nil, corrupt("bad magic")
+	}
+	index := int64(binary.LittleEndian.Uint64(footer[:8]))
+	if index < int64(len(Magic)) || index > size-footerSize || size-footerSize-index > maxIndex {
+		return nil, corrupt("bad index offset")
+	}
+	b := make([]byte, size-footerSize-index)
+	if _, err = r.ReadAt(b, index); err != nil {
+		return nil, err
+	}
+	entries, err := readIndex(b, index)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, Entries: entries}, nil
+}
+
+// readIndex decodes the index b of a bundle whose patches end at end
+func readIndex(b []byte, end int64) ([]Entry, error) {
+	br := bytes.NewReader(b)
+	uvarint := func() (int64, error) {
+		v, err := binary.ReadUvarint(br)
+		if err != nil || v > uint64(end) {
+			return 0, corrupt("bad index")
+		}
+		return int64(v), nil
+	}
+	read := func(n int) ([]byte, error) {
+		if n > br.Len() {
+			return nil, corrupt("truncated index")
+		}
+		p := make([]byte, n)
+		br.Read(p)
+		return p, nil
+	}
+	n, err := uvarint()
+	if err != nil || n > int64(len(b)) {
+		return nil, corrupt("bad index")
+	}
+	entries := make([]Entry, 0, n)
+	names := make(map[string]bool)
+	next := int64(len(Magic))
+	for i := int64(0); i < n; i++ {
+		var e Entry
+		l, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		name, err := read(int(l))
+		if err != nil {
+			return nil, err
+		}
+		e.Name = string(name)
+		if !fs.ValidPath(e.Name) || e.Name == "." || names[e.Name] {
+			return nil, corrupt(fmt.Sprintf("invalid or duplicate patch name %q", e.Name))
+		}
+		names[e.Name] = true
+		if e.Offset, err = uvarint(); err != nil {
+			return nil, err
+		}
+		if e.Size, err = uvarint(); err != nil {
+			return nil, err
+		}
+		if e.Offset < next || e.Size > end-e.Offset {
+			return nil, corrupt(fmt.Sprintf("patch %q out of bounds", e.Name))
+		}
+		next = e.Offset + e.Size
+		if e.SHA256, err = read(sha256.Size); err != nil {
+			return nil, err
+		}
+		flags, err := read(1)
+		if err != nil {
+			return nil, err
+		}
+		if flags[0]&flagOld != 0 {
+			if e.OldSHA256, err = read(sha256.Size); err != nil {
+				return nil, err
+			}
+		}
+		if flags[0]&flagNew != 0 {
+			if e.NewSHA256, err = read(sha256.Size); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	if br.Len() > 0 {
+		return nil, corrupt("trailing data after the index")
+	}
+	return entries, nil
+}
+
+// Lookup returns the entry of the patch name, nil if there's none
+func (r *Reader) Lookup(name string) *Entry {
+	for i := range r.Entries {
+		if r.Entries[i].Name == name {
+			return &r.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Reverse returns the entry of the undo patch of the patch name, nil if
+// there's none
+func (r *Reader) Reverse(name string) *Entry {
+	return r.Lookup(name + ReverseSuffix)
+}
+
+// Patch returns the patch of e, failing with bspatch.ErrCorruptPatch if it
+// doesn't match its SHA-256
+func (r *Reader) Patch(e *Entry) (_ []byte, err error) {
+	defer util.Recover(&err)
+	var b bytes.Buffer
+	if _, err = io.Copy(&b, io.NewSectionReader(r.r, e.Offset, e.Size)); err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(b.Bytes()); !bytes.Equal(sum[:], e.SHA256) {
+		return nil, corrupt(fmt.Sprintf("SHA-256 of %q is %x, expected %x", e.Name, sum, e.SHA256))
+	}
+	return b.Bytes(), nil
+}
+
+// Apply applies the patch of e to oldfile, writing the new file to out, with
+// opts
+func (r *Reader) Apply(e *Entry, oldfile io.ReaderAt, out io.Writer, opts ...bspatch.Option) error {
+	patch, err := r.Patch(e)
+	if err != nil {
+		return err
+	}
+	if err = bspatch.Apply(oldfile, bytes.NewReader(patch), out, opts...); err != nil {
+		return fmt.Errorf("could not apply %q: %w", e.Name, err)
+	}
+	return nil
+}
+
+// Create writes the bundle of patchfiles, by name, to bundlefile, through a
+// temporary file renamed once complete. The patches are sorted by name.
+func Create(bundlefile string, patchfiles map[string]string) (err error) {
+	defer util.Recover(&err)
+	names := make([]string, 0, len(patchfiles))
+	for name := range patchfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return util.WriteFile(bundlefile, func(f *os.File) error {
+		w := NewWriter(f)
+		for _, name := range names {
+			patch, err := os.ReadFile(patchfiles[name])
+			if err != nil {
+				return fmt.Errorf("could not read patchfile '%v': %w", patchfiles[name], err)
+			}
+			if err = w.Add(name, patch); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	})
+}
+
+// List returns the entries of bundlefile
+func List(bundlefile string) ([]Entry, error) {
+	var entries []Entry
+	err := open(bundlefile, func(r *Reader) error {
+		entries = r.Entries
+		return nil
+	})
+	return entries, err
+}
+
+// Extract writes the patch name of bundlefile to patchfile
+func Extract(bundlefile, name, patchfile string) error {
+	return open(bundlefile, func(r *Reader) error {
+		e := r.Lookup(name)
+		if e == nil {
+			return fmt.Errorf("no patch %q in bundlefile '%v': %w", name, bundlefile, fs.ErrNotExist)
+		}
+		patch, err := r.Patch(e)
+		if err != nil {
+			return err
+		}
+		return util.WriteFile(patchfile, func(f *os.File) error {
+			_, err := f.Write(patch)
+			return err
+		})
+	})
+}
+
+// Apply applies the patch name of bundlefile to oldfile, writing newfile
+// through a temporary file renamed once complete
+func Apply(bundlefile, name, oldfile, newfile string, opts ...bspatch.Option) error {
+	return open(bundlefile, func(r *Reader) error {
+		e := r.Lookup(name)
+		if e == nil {
+			return fmt.Errorf("no patch %q in bundlefile '%v': %w", name, bundlefile, fs.ErrNotExist)
+		}
+		old, err := os.Open(oldfile)
+		if err != nil {
+			return fmt.Errorf("could not open oldfile '%v': %w", oldfile, err)
+		}
+		defer old.Close()
+		return util.WriteFile(newfile, func(f *os.File) error {
+			return r.Apply(e, old, f, opts...)
+		})
+	})
+}
+
+// Revert applies the undo patch of the patch name of bundlefile to newfile,
+// the file the patch made, writing oldfile back through a temporary file
+// renamed once complete
+func Revert(bundlefile, name, newfile, oldfile string, opts ...bspatch.Option) error {
+	return Apply(bundlefile, name+ReverseSuffix, newfile, oldfile, opts...)
+}
+
+// open calls fn with the Reader of bundlefile
+func open(bundlefile string, fn func(r *Reader) error) (err error) {
+	defer util.Recover(&err)
+	f, err := os.Open(bundlefile)
+	if err != nil {
+		return fmt.Errorf("could not open bundlefile '%v': %w", bundlefile, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		return err
+	}
+	return fn(r)
+}
+
+// corrupt returns an error wrapping bspatch.ErrCorruptPatch for a bundle
+// malformed as described by msg
+func corrupt(msg string) error {
+	return fmt.Errorf("%w (bundle %v)", bspatch.ErrCorruptPatch, msg)
+}
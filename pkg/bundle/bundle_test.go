@@ -0,0 +1,200 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestBundle(t *testing.T) {
+	files := map[string][2][]byte{
+		"bin/app":     {bytes.Repeat([]byte("old app "), 1000), bytes.Repeat([]byte("new app "), 1000)},
+		"lib/lib.so":  {[]byte("old library"), []byte("new library")},
+		"share/empty": {nil, []byte("now with contents")},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, name := range []string{"bin/app", "lib/lib.so", "share/empty"} {
+		var opts []bsdiff.Option
+		if name != "lib/lib.so" {
+			opts = append(opts, bsdiff.WithHashes())
+		}
+		patch, err := bsdiff.Bytes(files[name][0], files[name][1], opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Add(name, patch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add("bin/app", []byte("BSDIFF40")); err == nil {
+		t.Fatal("expected a duplicate name error")
+	}
+	if err := w.Add("../x", nil); err == nil {
+		t.Fatal("expected an invalid name error")
+	}
+	if err := w.Add("junk", []byte("not a patch")); err == nil {
+		t.Fatal("expected an error adding junk")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Entries) != 3 {
+		t.Fatal("got", len(r.Entries), "entries")
+	}
+	for _, e := range r.Entries {
+		old, want := files[e.Name][0], files[e.Name][1]
+		if e.Name == "lib/lib.so" {
+			if e.OldSHA256 != nil || e.NewSHA256 != nil {
+				t.Fatal(e.Name, "unexpected digests")
+			}
+		} else {
+			oldsum, newsum := sha256.Sum256(old), sha256.Sum256(want)
+			if !bytes.Equal(e.OldSHA256, oldsum[:]) || !bytes.Equal(e.NewSHA256, newsum[:]) {
+				t.Fatal(e.Name, "bad digests")
+			}
+		}
+		var out bytes.Buffer
+		if err = r.Apply(r.Lookup(e.Name), bytes.NewReader(old), &out); err != nil || !bytes.Equal(out.Bytes(), want) {
+			t.Fatal(e.Name, "round trip failed", err)
+		}
+	}
+	if r.Lookup("missing") != nil {
+		t.Fatal("found a missing patch")
+	}
+	e := r.Lookup("bin/app")
+	if err = r.Apply(e, bytes.NewReader([]byte("wrong")), &bytes.Buffer{}); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error, got", err)
+	}
+
+	// A flipped byte in a patch fails its digest
+	bad := append([]byte(nil), b...)
+	bad[e.Offset+e.Size-1] ^= 1
+	r, err = NewReader(bytes.NewReader(bad), int64(len(bad)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Patch(r.Lookup("bin/app")); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a corrupt patch error, got", err)
+	}
+
+	// Corrupt bundles
+	for _, p := range [][]byte{nil, b[:len(b)-1], b[1:], append(append([]byte(Magic), 1, 0, 0, 0, 0, 0, 0, 0), Magic...)} {
+		if _, err = NewReader(bytes.NewReader(p), int64(len(p))); !errors.Is(err, bspatch.ErrCorruptPatch) {
+			t.Fatalf("expected a corrupt bundle error, got %v", err)
+		}
+	}
+	// An empty bundle
+	buf.Reset()
+	if err = NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil || len(r.Entries) != 0 {
+		t.Fatal("bad empty bundle", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	patch, err := bsdiff.Bytes([]byte("old contents"), []byte("new contents"), bsdiff.WithHashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundlefile := filepath.Join(dir, "out.bsbundle")
+	if err = Create(bundlefile, map[string]string{"a/f": write("f.patch", patch)}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := List(bundlefile)
+	if err != nil || len(entries) != 1 || entries[0].Name != "a/f" {
+		t.Fatal("bad list", entries, err)
+	}
+	if err = Extract(bundlefile, "a/f", filepath.Join(dir, "g.patch")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "g.patch")); err != nil || !bytes.Equal(b, patch) {
+		t.Fatal("bad extracted patch", err)
+	}
+	oldfile, newfile := write("old", []byte("old contents")), filepath.Join(dir, "new")
+	if err = Apply(bundlefile, "a/f", oldfile, newfile); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(newfile); err != nil || string(b) != "new contents" {
+		t.Fatalf("got %q (%v)", b, err)
+	}
+	if err = Apply(bundlefile, "missing", oldfile, newfile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected a missing patch error, got", err)
+	}
+	if err = Create(bundlefile, map[string]string{"x": filepath.Join(dir, "missing")}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected a missing patchfile error, got", err)
+	}
+}
+
+func TestBothWays(t *testing.T) {
+	dir := t.TempDir()
+	v1, v2 := []byte("version one of the contents"), []byte("version two of the contents, longer")
+	forward, reverse, err := bsdiff.BothWays(v1, v2, bsdiff.WithHashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := bsdiff.Reverse(v1, v2, bsdiff.WithHashes()); err != nil || !bytes.Equal(r, reverse) {
+		t.Fatal("Reverse and BothWays differ", err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err = w.AddBothWays("app", forward, reverse); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bundlefile := filepath.Join(dir, "app.bsbundle")
+	if err = os.WriteFile(bundlefile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "app")
+	if err = os.WriteFile(file, v1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = Apply(bundlefile, "app", file, file); err != nil {
+		t.Fatal(err)
+	}
+	if err = Revert(bundlefile, "app", file, file); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(file); err != nil || !bytes.Equal(b, v1) {
+		t.Fatalf("reverted to %q (%v)", b, err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := r.Reverse("app"); e == nil || !bytes.Equal(e.NewSHA256, r.Lookup("app").OldSHA256) {
+		t.Fatal("bad reverse entry", e)
+	}
+
+	// A patch that isn't the undo patch of the other
+	if err = NewWriter(io.Discard).AddBothWays("app", forward, forward); err == nil {
+		t.Fatal("added a mismatched reverse patch")
+	}
+}
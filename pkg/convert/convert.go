@@ -0,0 +1,144 @@
+// Package convert transcodes patches between formats without the old and
+// new files, by decoding the control, diff and extra streams of a patch and
+// serializing them again.
+//
+// Patches are read with bspatch.Scan, so any patch bspatch can apply can be
+// converted. Extended header records (file info, dictionary IDs) aren't
+// carried over.
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// ErrNeedsOld is returned when converting to VCDIFF a patch whose diff
+// block has non zero bytes. VCDIFF can only copy the old file verbatim, so
+// approximate matches can't be expressed without the old file.
+var ErrNeedsOld = errors.New("convert: patch can't be converted without the old file")
+
+// Bytes converts patch to the format to
+func Bytes(patch []byte, to bspatch.Format, opts ...bspatch.Option) ([]byte, error) {
+	var buf util.BufWriter
+	if err := convert(bytes.NewReader(patch), &buf, to, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Reader converts the patch read from src to the format to and writes it to
+// dst. opts configure how src is read, e.g. with additional decompressors.
+func Reader(src io.ReaderAt, dst io.WriteSeeker, to bspatch.Format, opts ...bspatch.Option) error {
+	return convert(src, dst, to, opts)
+}
+
+// File converts the patch in srcfile to the format to and writes it to
+// dstfile, which is replaced only once the conversion succeeds
+func File(srcfile, dstfile string, to bspatch.Format, opts ...bspatch.Option) error {
+	srcF, err := os.Open(srcfile)
+	if err != nil {
+		return fmt.Errorf("could not open patchfile '%v': %w", srcfile, err)
+	}
+	defer srcF.Close()
+	return util.WriteFile(dstfile, func(f *os.File) error {
+		if err := convert(srcF, f, to, opts); err != nil {
+			return fmt.Errorf("convert: %w", err)
+		}
+		return nil
+	})
+}
+
+func convert(src io.ReaderAt, dst io.WriteSeeker, to bspatch.Format, opts []bspatch.Option) error {
+	var bsopts []bsdiff.Option
+	switch to {
+	case bspatch.FormatVCDIFF:
+		return toVCDIFF(src, dst, opts)
+	case bspatch.FormatEndsley:
+		bsopts = append(bsopts, bsdiff.WithFormat(bsdiff.FormatEndsley))
+	case bspatch.FormatBSDF2:
+		bsopts = append(bsopts, bsdiff.WithFormat(bsdiff.FormatBSDF2))
+	case bspatch.FormatBzip2:
+	case bspatch.FormatZstd:
+		bsopts = append(bsopts, bsdiff.WithCompressor(bsdiff.Zstd))
+	case bspatch.FormatXz:
+		bsopts = append(bsopts, bsdiff.WithCompressor(bsdiff.Xz))
+	case bspatch.FormatRaw:
+		bsopts = append(bsopts, bsdiff.WithCompressor(bsdiff.Raw))
+	case bspatch.FormatBrotli:
+		bsopts = append(bsopts, bsdiff.WithCompressor(bsdiff.Brotli))
+	default:
+		return fmt.Errorf("unsupported patch format %q", to)
+	}
+	w, err := bsdiff.NewWriter(dst, bsopts...)
+	if err != nil {
+		return err
+	}
+	err = bspatch.Scan(src, func(c bspatch.Control) error {
+		return w.WriteControl(c.Diff, c.Extra, c.Seek)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// toVCDIFF writes the control triples of src as VCDIFF instructions: runs of
+// zero diff bytes become COPYs from the old file and extra bytes ADDs. The
+// first pass finds the extent of the old file the patch uses, which is the
+// source segment of every window.
+func toVCDIFF(src io.ReaderAt, dst io.Writer, opts []bspatch.Option) error {
+	srclen := 0
+	err := scanOld(src, opts, func(c bspatch.Control, oldpos int) error {
+		for _, b := range c.Diff {
+			if b != 0 {
+				return ErrNeedsOld
+			}
+		}
+		if len(c.Diff) > 0 && oldpos+len(c.Diff) > srclen {
+			srclen = oldpos + len(c.Diff)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e, err := vcdiff.NewEncoder(dst, srclen)
+	if err != nil {
+		return err
+	}
+	err = scanOld(src, opts, func(c bspatch.Control, oldpos int) error {
+		if len(c.Diff) > 0 {
+			if err := e.Copy(oldpos, len(c.Diff)); err != nil {
+				return err
+			}
+		}
+		return e.Add(c.Extra)
+	})
+	if err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// scanOld scans src, tracking the old position of each control triple
+func scanOld(src io.ReaderAt, opts []bspatch.Option, fn func(c bspatch.Control, oldpos int) error) error {
+	oldpos := 0
+	return bspatch.Scan(src, func(c bspatch.Control) error {
+		if len(c.Diff) > 0 && oldpos < 0 {
+			return fmt.Errorf("%w (old position %v)", bspatch.ErrCorruptPatch, oldpos)
+		}
+		if err := fn(c, oldpos); err != nil {
+			return err
+		}
+		oldpos += len(c.Diff) + c.Seek
+		return nil
+	}, opts...)
+}
@@ -0,0 +1,145 @@
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func testFiles(edits int) (oldbs, newbs []byte) {
+	rng := rand.New(rand.NewSource(1))
+	oldbs = make([]byte, 1024*16)
+	rng.Read(oldbs)
+	newbs = append([]byte(nil), oldbs[:1024*8]...)
+	newbs = append(newbs, "inserted in the middle"...)
+	newbs = append(newbs, oldbs[1024*4:]...)
+	for i := 0; i < edits; i++ {
+		newbs[rng.Intn(len(newbs))] ^= 0x5A
+	}
+	return oldbs, newbs
+}
+
+func TestBsdiffFormats(t *testing.T) {
+	oldbs, newbs := testFiles(50)
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []bspatch.Format{
+		bspatch.FormatZstd, bspatch.FormatXz, bspatch.FormatRaw,
+		bspatch.FormatBrotli, bspatch.FormatEndsley, bspatch.FormatBSDF2,
+	} {
+		converted, err := Bytes(patch, f)
+		if err != nil {
+			t.Fatal(f, err)
+		}
+		if got, err := bspatch.Detect(bytes.NewReader(converted)); err != nil || got != f {
+			t.Fatal(f, "detected as", got, err)
+		}
+		got, err := bspatch.Bytes(oldbs, converted)
+		if err != nil {
+			t.Fatal(f, err)
+		}
+		if !bytes.Equal(got, newbs) {
+			t.Fatal(f, "converted patch doesn't apply")
+		}
+		back, err := Bytes(converted, bspatch.FormatBzip2)
+		if err != nil {
+			t.Fatal(f, err)
+		}
+		if !bytes.Equal(back, patch) {
+			t.Fatal(f, "converting back didn't restore the patch")
+		}
+	}
+}
+
+func TestVCDIFF(t *testing.T) {
+	oldbs, newbs := testFiles(50)
+	delta, err := vcdiff.Diff(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := Bytes(delta, bspatch.FormatZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bspatch.Bytes(oldbs, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newbs) {
+		t.Fatal("converted VCDIFF delta doesn't apply")
+	}
+
+	// without edits, the bsdiff matches are exact
+	oldbs, newbs = testFiles(0)
+	if patch, err = bsdiff.Bytes(oldbs, newbs); err != nil {
+		t.Fatal(err)
+	}
+	if delta, err = Bytes(patch, bspatch.FormatVCDIFF); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = vcdiff.Patch(oldbs, delta); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newbs) {
+		t.Fatal("converted patch doesn't apply as VCDIFF")
+	}
+
+	oldbs, newbs = testFiles(50)
+	if patch, err = bsdiff.Bytes(oldbs, newbs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Bytes(patch, bspatch.FormatVCDIFF); !errors.Is(err, ErrNeedsOld) {
+		t.Fatal("expected ErrNeedsOld, got", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	srcfile := filepath.Join(dir, "patch")
+	dstfile := filepath.Join(dir, "delta")
+	oldbs, newbs := testFiles(0)
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(srcfile, patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = File(srcfile, dstfile, bspatch.FormatVCDIFF); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := os.ReadFile(dstfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed conversion returns the cause and leaves dstfile as it was
+	oldbs, newbs = testFiles(50)
+	if patch, err = bsdiff.Bytes(oldbs, newbs); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(srcfile, patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = File(srcfile, dstfile, bspatch.FormatVCDIFF); !errors.Is(err, ErrNeedsOld) {
+		t.Fatal("expected ErrNeedsOld, got", err)
+	}
+	if err = os.WriteFile(srcfile, patch[:len(patch)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = File(srcfile, dstfile, bspatch.FormatZstd); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected ErrCorruptPatch, got", err)
+	}
+	if got, err := os.ReadFile(dstfile); err != nil || !bytes.Equal(got, delta) {
+		t.Fatal("failed conversions changed dstfile", err)
+	}
+}
@@ -0,0 +1,117 @@
+package deltarpc
+
+import (
+	"context"
+	"io"
+)
+
+// Diff diffs oldfile and newfile on the service and writes the patch to
+// patch. A file start refers to by reference is nil.
+func Diff(ctx context.Context, c DeltaClient, start *DiffStart, oldfile, newfile io.Reader, patch io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.GenerateDiff(ctx)
+	if err != nil {
+		return err
+	}
+	return exchange(cancel, stream, func() error {
+		if err := stream.Send(&DiffRequest{Request: &DiffRequest_Start{Start: start}}); err != nil {
+			return err
+		}
+		if err := sendFile(oldfile, func(b []byte) error {
+			return stream.Send(&DiffRequest{Request: &DiffRequest_OldData{OldData: b}})
+		}); err != nil {
+			return err
+		}
+		if err := sendFile(newfile, func(b []byte) error {
+			return stream.Send(&DiffRequest{Request: &DiffRequest_NewData{NewData: b}})
+		}); err != nil {
+			return err
+		}
+		return stream.CloseSend()
+	}, patch)
+}
+
+// Apply applies patch to oldfile on the service and writes the new file to
+// out as it comes back. A file start refers to by reference is nil.
+func Apply(ctx context.Context, c DeltaClient, start *PatchStart, oldfile, patch io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.ApplyPatch(ctx)
+	if err != nil {
+		return err
+	}
+	return exchange(cancel, stream, func() error {
+		if err := stream.Send(&PatchRequest{Request: &PatchRequest_Start{Start: start}}); err != nil {
+			return err
+		}
+		if err := sendFile(oldfile, func(b []byte) error {
+			return stream.Send(&PatchRequest{Request: &PatchRequest_OldData{OldData: b}})
+		}); err != nil {
+			return err
+		}
+		if err := sendFile(patch, func(b []byte) error {
+			return stream.Send(&PatchRequest{Request: &PatchRequest_PatchData{PatchData: b}})
+		}); err != nil {
+			return err
+		}
+		return stream.CloseSend()
+	}, out)
+}
+
+// exchange sends the requests while it receives the response into w, as
+// the service may answer before it has read everything
+func exchange(cancel context.CancelFunc, stream interface{ Recv() (*Chunk, error) }, send func() error, w io.Writer) error {
+	sent := make(chan error, 1)
+	go func() {
+		err := send()
+		// The service stopped reading: its status comes with Recv
+		if err == io.EOF {
+			err = nil
+		}
+		if err != nil {
+			cancel()
+		}
+		sent <- err
+	}()
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = w.Write(chunk.Data)
+		}
+		if err != nil {
+			cancel()
+			if serr := <-sent; serr != nil {
+				return serr
+			}
+			return err
+		}
+	}
+	return <-sent
+}
+
+// sendFile sends r in chunks of ChunkSize
+func sendFile(r io.Reader, send func([]byte) error) error {
+	if r == nil {
+		return nil
+	}
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			// Messages are sent once Send returns, so buf can be reused
+			if err := send(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package deltarpc runs the diff engine as a gRPC service, for build farms
+// that diff artifacts on dedicated machines. deltarpc.proto defines the
+// Delta service; Server implements it and Diff and Apply call it.
+//
+// Files are streamed to the service in chunks, or named by reference to
+// the artifacts of a Store the server was given, so large artifacts the
+// farm already holds aren't sent over again.
+package deltarpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative deltarpc.proto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/patchstore"
+)
+
+// Store holds the artifacts requests refer to. The stores of pkg/httpdelta
+// are Stores.
+type Store interface {
+	// Get returns the artifact named ref, or an error wrapping
+	// fs.ErrNotExist if there's none
+	Get(ref string) ([]byte, error)
+}
+
+// ChunkSize is the size of the chunks files are streamed in
+const ChunkSize = 64 << 10
+
+// DefaultMaxSize bounds the files a Server receives, unless WithMaxSize
+// sets another bound
+const DefaultMaxSize = 1 << 30
+
+// Option configures a Server
+type Option func(*options)
+
+type options struct {
+	store     Store
+	patches   patchstore.Store
+	format    string
+	maxSize   int64
+	diffOpts  []bsdiff.Option
+	patchOpts []bspatch.Option
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{maxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithStore lets requests refer to the artifacts of s instead of streaming
+// them
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithPatchStore looks patches up in s before making them, and stores
+// those made there. Their format is format, which must change with the
+// options of WithDiffOptions, followed by the compressor of the request and
+// +hashes if it asks for hashes, e.g. "app/zstd+hashes", so an
+// httpdelta.Handler storing zstd patches under that format shares them.
+func WithPatchStore(s patchstore.Store, format string) Option {
+	return func(o *options) {
+		o.patches, o.format = s, format
+	}
+}
+
+// WithMaxSize bounds the size of each file a request streams
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithDiffOptions sets the options patches are made with, before those a
+// request asks for
+func WithDiffOptions(opts ...bsdiff.Option) Option {
+	return func(o *options) {
+		o.diffOpts = opts
+	}
+}
+
+// WithPatchOptions sets the options patches are applied with
+func WithPatchOptions(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.patchOpts = opts
+	}
+}
+
+// compressors are the compressors a DiffStart names
+var compressors = map[string]bsdiff.Compressor{
+	"":       bsdiff.Bzip2,
+	"bzip2":  bsdiff.Bzip2,
+	"zstd":   bsdiff.Zstd,
+	"xz":     bsdiff.Xz,
+	"brotli": bsdiff.Brotli,
+	"raw":    bsdiff.Raw,
+}
+
+func compressor(name string) (bsdiff.Compressor, error) {
+	c, ok := compressors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor %q", name)
+	}
+	return c, nil
+}
+
+// formatOf returns the format of the patches start asks for in a patch store
+func (o *options) formatOf(start *DiffStart) string {
+	name := strings.ToLower(start.Compressor)
+	if name == "" {
+		name = "bzip2"
+	}
+	f := o.format + "/" + name
+	if start.Hashes {
+		f += "+hashes"
+	}
+	return f
+}
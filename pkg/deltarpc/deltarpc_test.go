@@ -0,0 +1,135 @@
+package deltarpc
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/httpdelta"
+	"github.com/gabstv/go-bsdiff/pkg/patchstore"
+)
+
+// dial serves s in memory and returns a client of it
+func dial(t *testing.T, s *Server) DeltaClient {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	RegisterDeltaServer(gs, s)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewDeltaClient(conn)
+}
+
+func TestDelta(t *testing.T) {
+	oldbs := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs[:200<<10]...)
+	copy(newbs[1000:], "version 2")
+	newbs = append(newbs, oldbs...)
+	store := httpdelta.FS(fstest.MapFS{
+		hashOf(oldbs): {Data: oldbs},
+		hashOf(newbs): {Data: newbs},
+	})
+	c := dial(t, NewServer(WithStore(store)))
+	ctx := context.Background()
+
+	var patch bytes.Buffer
+	if err := Diff(ctx, c, &DiffStart{Compressor: "zstd", Hashes: true}, bytes.NewReader(oldbs), bytes.NewReader(newbs), &patch); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bspatch.Bytes(oldbs, patch.Bytes()); err != nil || !bytes.Equal(got, newbs) {
+		t.Fatal("streamed diff doesn't apply", err)
+	}
+	var refPatch bytes.Buffer
+	if err := Diff(ctx, c, &DiffStart{OldRef: hashOf(oldbs), NewRef: hashOf(newbs), Compressor: "zstd", Hashes: true}, nil, nil, &refPatch); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(refPatch.Bytes(), patch.Bytes()) {
+		t.Fatal("diffs by reference and streamed differ")
+	}
+
+	var out bytes.Buffer
+	if err := Apply(ctx, c, &PatchStart{}, bytes.NewReader(oldbs), bytes.NewReader(patch.Bytes()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("streamed apply gave the wrong file")
+	}
+	out.Reset()
+	if err := Apply(ctx, c, &PatchStart{OldRef: hashOf(oldbs)}, nil, bytes.NewReader(patch.Bytes()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("apply by reference gave the wrong file")
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"wrong old", Apply(ctx, c, &PatchStart{}, bytes.NewReader(newbs), bytes.NewReader(patch.Bytes()), &out), codes.FailedPrecondition},
+		{"corrupt", Apply(ctx, c, &PatchStart{}, bytes.NewReader(oldbs), bytes.NewReader(patch.Bytes()[:100]), &out), codes.InvalidArgument},
+		{"not found", Diff(ctx, c, &DiffStart{OldRef: hashOf(nil), NewRef: hashOf(newbs)}, nil, nil, &out), codes.NotFound},
+		{"data and ref", Diff(ctx, c, &DiffStart{OldRef: hashOf(oldbs)}, bytes.NewReader(oldbs), bytes.NewReader(newbs), &out), codes.InvalidArgument},
+		{"compressor", Diff(ctx, c, &DiffStart{Compressor: "lzma"}, bytes.NewReader(oldbs), bytes.NewReader(newbs), &out), codes.InvalidArgument},
+	} {
+		if got := status.Code(tc.err); got != tc.code {
+			t.Errorf("%v: got %v (%v), want %v", tc.name, got, tc.err, tc.code)
+		}
+	}
+
+	small := dial(t, NewServer(WithMaxSize(100<<10)))
+	err := Diff(ctx, small, &DiffStart{}, bytes.NewReader(oldbs), bytes.NewReader(newbs), &out)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatal("size limit:", err)
+	}
+	err = Diff(ctx, small, &DiffStart{OldRef: hashOf(oldbs)}, nil, bytes.NewReader(newbs[:10]), &out)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatal("ref without a store:", err)
+	}
+}
+
+func TestPatchStore(t *testing.T) {
+	oldbs := make([]byte, 100<<10)
+	rand.New(rand.NewSource(2)).Read(oldbs)
+	newbs := append([]byte("v2"), oldbs...)
+	ps, err := patchstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, NewServer(WithPatchStore(ps, "app")))
+	var patch bytes.Buffer
+	if err = Diff(context.Background(), c, &DiffStart{Compressor: "zstd", Hashes: true}, bytes.NewReader(oldbs), bytes.NewReader(newbs), &patch); err != nil {
+		t.Fatal(err)
+	}
+	stored, ok := ps.Get(patchstore.Key{Old: hashOf(oldbs), New: hashOf(newbs), Format: "app/zstd+hashes"})
+	if !ok || !bytes.Equal(stored, patch.Bytes()) {
+		t.Fatal("patch not stored")
+	}
+
+	// A handler without the versions gets the patch from the store
+	h := httpdelta.NewHandler(httpdelta.FS(fstest.MapFS{}), httpdelta.WithCache(nil),
+		httpdelta.WithPatchStore(ps, "app/zstd+hashes"), httpdelta.WithDiffOptions(bsdiff.WithCompressor(bsdiff.Zstd)))
+	got, err := h.Patch(hashOf(oldbs), hashOf(newbs))
+	if err != nil || !bytes.Equal(got, patch.Bytes()) {
+		t.Fatal("handler didn't share the patch:", err)
+	}
+}
@@ -0,0 +1,292 @@
+package deltarpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/patchstore"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Server implements the Delta service. Errors carry gRPC status codes:
+// NotFound for an unknown reference, FailedPrecondition for a patch of
+// another old file (bspatch.ErrWrongOld), InvalidArgument for malformed
+// requests and corrupt patches and ResourceExhausted for files over the
+// size limit.
+type Server struct {
+	UnimplementedDeltaServer
+	o *options
+}
+
+// NewServer returns a Delta service. Register it with
+//
+//	RegisterDeltaServer(grpcServer, deltarpc.NewServer(opts...))
+func NewServer(opts ...Option) *Server {
+	return &Server{o: newOptions(opts)}
+}
+
+// errTooLarge is returned for files over the size limit
+var errTooLarge = errors.New("file too large")
+
+// GenerateDiff receives the old and new files, diffs them and streams the
+// patch back
+func (s *Server) GenerateDiff(stream Delta_GenerateDiffServer) (err error) {
+	defer func() { err = toStatus(err) }()
+	defer util.Recover(&err)
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStart()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "the first request isn't a DiffStart")
+	}
+	c, err := compressor(start.Compressor)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	var oldbs, newbs []byte
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch r := req.Request.(type) {
+		case *DiffRequest_OldData:
+			oldbs, err = s.append(oldbs, r.OldData, start.OldRef)
+		case *DiffRequest_NewData:
+			newbs, err = s.append(newbs, r.NewData, start.NewRef)
+		default:
+			err = status.Error(codes.InvalidArgument, "a DiffStart after the first request")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if oldbs, err = s.resolve(start.OldRef, oldbs); err != nil {
+		return err
+	}
+	if newbs, err = s.resolve(start.NewRef, newbs); err != nil {
+		return err
+	}
+	var key patchstore.Key
+	if s.o.patches != nil {
+		key = patchstore.Key{Old: hashOf(oldbs), New: hashOf(newbs), Format: s.o.formatOf(start)}
+		if patch, ok := s.o.patches.Get(key); ok {
+			return sendPatch(stream, patch)
+		}
+	}
+	opts := append(append([]bsdiff.Option(nil), s.o.diffOpts...), bsdiff.WithCompressor(c))
+	if start.Hashes {
+		opts = append(opts, bsdiff.WithHashes())
+	}
+	patch, err := bsdiff.BytesCtx(stream.Context(), oldbs, newbs, opts...)
+	if err != nil {
+		return err
+	}
+	if s.o.patches != nil {
+		// The store only saves work: the patch is good even if it can't
+		// be stored
+		s.o.patches.Put(key, patch)
+	}
+	return sendPatch(stream, patch)
+}
+
+// sendPatch sends patch in chunks of ChunkSize
+func sendPatch(stream Delta_GenerateDiffServer, patch []byte) error {
+	w := &chunkWriter{send: func(b []byte) error { return stream.Send(&Chunk{Data: b}) }}
+	for len(patch) > 0 {
+		n := len(patch)
+		if n > ChunkSize {
+			n = ChunkSize
+		}
+		if _, err := w.Write(patch[:n]); err != nil {
+			return err
+		}
+		patch = patch[n:]
+	}
+	return nil
+}
+
+// ApplyPatch receives the old file, then applies the patch as it arrives
+// and streams the new file back
+func (s *Server) ApplyPatch(stream Delta_ApplyPatchServer) (err error) {
+	defer func() { err = toStatus(err) }()
+	defer util.Recover(&err)
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStart()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "the first request isn't a PatchStart")
+	}
+	// The old file ends with the first chunk of the patch
+	var oldbs, pending []byte
+	eof := false
+	for pending == nil && !eof {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch r := req.Request.(type) {
+		case *PatchRequest_OldData:
+			oldbs, err = s.append(oldbs, r.OldData, start.OldRef)
+		case *PatchRequest_PatchData:
+			pending = append([]byte{}, r.PatchData...)
+		default:
+			err = status.Error(codes.InvalidArgument, "a PatchStart after the first request")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if oldbs, err = s.resolve(start.OldRef, oldbs); err != nil {
+		return err
+	}
+	var patch io.Reader = &patchReader{stream: stream, buf: pending, eof: eof}
+	if start.PatchRef != "" {
+		if pending != nil {
+			return status.Error(codes.InvalidArgument, "patch data sent with a patch reference")
+		}
+		b, err := s.resolve(start.PatchRef, nil)
+		if err != nil {
+			return err
+		}
+		patch = bytes.NewReader(b)
+	}
+	w := bufio.NewWriterSize(&chunkWriter{send: func(b []byte) error { return stream.Send(&Chunk{Data: b}) }}, ChunkSize)
+	if err = bspatch.ApplyStream(bytes.NewReader(oldbs), patch, w, s.o.patchOpts...); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// append appends a chunk of a file to b, unless the file is given by
+// reference or the chunk makes it too large
+func (s *Server) append(b, chunk []byte, ref string) ([]byte, error) {
+	if ref != "" {
+		return nil, status.Error(codes.InvalidArgument, "data sent for a file given by reference")
+	}
+	if int64(len(b))+int64(len(chunk)) > s.o.maxSize {
+		return nil, fmt.Errorf("%w (over %v bytes)", errTooLarge, s.o.maxSize)
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return append(b, chunk...), nil
+}
+
+// resolve returns the artifact ref, or b if the file was streamed
+func (s *Server) resolve(ref string, b []byte) ([]byte, error) {
+	if ref == "" {
+		return b, nil
+	}
+	if s.o.store == nil {
+		return nil, status.Error(codes.InvalidArgument, "this server has no store to refer to")
+	}
+	b, err := s.o.store.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > s.o.maxSize {
+		return nil, fmt.Errorf("%w (over %v bytes)", errTooLarge, s.o.maxSize)
+	}
+	return b, nil
+}
+
+// patchReader reads the patch from the chunks of an ApplyPatch stream
+type patchReader struct {
+	stream Delta_ApplyPatchServer
+	buf    []byte
+	eof    bool
+}
+
+func (r *patchReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		req, err := r.stream.Recv()
+		if err == io.EOF {
+			r.eof = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		data, ok := req.Request.(*PatchRequest_PatchData)
+		if !ok {
+			return 0, status.Error(codes.InvalidArgument, "old file data after the patch")
+		}
+		r.buf = data.PatchData
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// chunkWriter sends what's written as chunks
+type chunkWriter struct {
+	send func([]byte) error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if err := w.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func hashOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// toStatus gives err the gRPC status code of its kind
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return status.Error(se.GRPCStatus().Code(), err.Error())
+	}
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, fs.ErrNotExist):
+		code = codes.NotFound
+	case errors.Is(err, bspatch.ErrWrongOld):
+		code = codes.FailedPrecondition
+	case errors.Is(err, bspatch.ErrCorruptPatch):
+		code = codes.InvalidArgument
+	case errors.Is(err, errTooLarge):
+		code = codes.ResourceExhausted
+	case errors.As(err, new(*util.PanicError)):
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,307 @@
+// Package dirdiff diffs two directory trees into a single bundle: a bsdiff
+// patch of each modified file, each new file whole, tombstones for deleted
+// files, and a manifest of the paths, modes, owners, modification times,
+// symlink targets and SHA-256 digests of them all. New files moved or copied
+// from old ones, identical or similar, are copied or patched from them
+// rather than included whole. Applying the bundle to the old tree makes the
+// new tree, checking every file against the manifest and restoring its
+// attributes. Only regular files and symlinks are diffed; empty directories
+// are not recorded.
+package dirdiff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Bundle is
+//
+//	0	8	"BSDIRDF3"
+//	8	??	uvarint length of the manifest
+//	??	??	manifest
+//	??	??	payloads
+//
+// with a payload for each OpModify and OpAdd entry of the manifest, in its
+// order: the uvarint length of the bsdiff patch or new file, and the patch or
+// file.
+
+// Magic starts every bundle
+const Magic = "BSDIRDF3"
+
+// DirFS returns the tree of the directory dir, as os.DirFS, also reading
+// its symlinks
+func DirFS(dir string) fs.FS {
+	return dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+// DiffFS writes the bundle of the differences between the trees oldfs and
+// newfs to bundle, with opts for diffing the modified files. Symlinks are
+// only read from trees with a ReadLink(name string) (string, error) method,
+// like those of DirFS; otherwise they're an error.
+func DiffFS(oldfs, newfs fs.FS, bundle io.Writer, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	m, err := diffManifest(oldfs, newfs)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(bundle)
+	w.WriteString(Magic)
+	writeBytes(w, m.marshal())
+	for _, e := range m.Entries {
+		if !e.hasPayload() {
+			continue
+		}
+		newbs, err := readFile(newfs, e.Path, e.NewSHA256)
+		if err != nil {
+			return err
+		}
+		if e.Op == OpAdd {
+			writeBytes(w, newbs)
+			continue
+		}
+		oldbs, err := readFile(oldfs, e.oldPath(), e.OldSHA256)
+		if err != nil {
+			return err
+		}
+		patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+		if err != nil {
+			return fmt.Errorf("could not diff '%v': %w", e.Path, err)
+		}
+		writeBytes(w, patch)
+	}
+	return w.Flush()
+}
+
+// Diff writes the bundle of the differences between the directories olddir
+// and newdir to bundlefile, through a temporary file renamed once complete
+func Diff(olddir, newdir, bundlefile string, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	return util.WriteFile(bundlefile, func(f *os.File) error {
+		return DiffFS(DirFS(olddir), DirFS(newdir), f, opts...)
+	})
+}
+
+// ReadManifest returns the manifest of bundle, reading only as much of it
+func ReadManifest(bundle io.Reader) (_ *Manifest, err error) {
+	defer util.Recover(&err)
+	return readHeader(bufio.NewReader(bundle))
+}
+
+// ApplyFS applies bundle to the tree oldfs, making the directory newdir,
+// with opts for patching the modified files. newdir must not exist: the new
+// tree is made in a temporary directory renamed once complete. The files get
+// the modes and modification times of the manifest, and when running as
+// root, as with tar, its owners. The files the rules of oldfs exclude, if
+// Filter made it, are skipped. It fails with bspatch.ErrWrongOld if a file of
+// oldfs isn't the one the bundle was made from, and bspatch.ErrCorruptPatch
+// if the bundle is malformed.
+func ApplyFS(oldfs fs.FS, bundle io.Reader, newdir string, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	newdir = filepath.Clean(newdir)
+	if _, err := os.Lstat(newdir); err == nil {
+		return fmt.Errorf("newdir '%v' already exists", newdir)
+	}
+	r := bufio.NewReader(bundle)
+	m, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	tmp, err := util.MkdirTemp(newdir)
+	if err != nil {
+		return fmt.Errorf("could not create newdir '%v': %w", newdir, err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	if err = applyEntries(oldfs, r, m, newTreeWriter(osDir(tmp)), opts); err != nil {
+		return err
+	}
+	return os.Rename(tmp, newdir)
+}
+
+// ApplyTo applies bundle to the tree oldfs as ApplyFS does, making the new
+// tree in dst, which should be empty. Modes, modification times and owners
+// are only restored if dst implements AttrFS, and bundles with symlinks can
+// only be applied if it implements SymlinkFS. On failure, the files and
+// directories made in dst are removed.
+func ApplyTo(oldfs fs.FS, bundle io.Reader, dst WriteFS, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	r := bufio.NewReader(bundle)
+	m, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	w := newTreeWriter(dst)
+	if err = applyEntries(oldfs, r, m, w, opts); err != nil {
+		w.undo()
+	}
+	return err
+}
+
+// applyEntries applies the entries of m, reading their payloads from r, and
+// writes the new files with w
+func applyEntries(oldfs fs.FS, r *bufio.Reader, m *Manifest, w *treeWriter, opts []bspatch.Option) error {
+	rules := rulesOf(oldfs)
+	var links []*Entry
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if rules != nil && rules.Excludes(e.Path, false) {
+			if e.hasPayload() {
+				if _, err := readBytes(r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if e.IsSymlink() {
+			links = append(links, e)
+			continue
+		}
+		newbs, err := apply(oldfs, r, e, opts)
+		if err != nil {
+			return err
+		}
+		if newbs == nil {
+			continue
+		}
+		if sum := sha256.Sum256(newbs); !bytes.Equal(sum[:], e.NewSHA256) {
+			return corrupt(fmt.Sprintf("SHA-256 of '%v' is %x, expected %x", e.Path, sum, e.NewSHA256))
+		}
+		if err = w.writeFile(e, newbs); err != nil {
+			return err
+		}
+	}
+	// Symlinks last, so nothing is written through them
+	for _, e := range links {
+		if err := w.symlink(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply applies bundlefile to the directory olddir, making the directory
+// newdir, as ApplyFS
+func Apply(olddir, bundlefile, newdir string, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	f, err := os.Open(bundlefile)
+	if err != nil {
+		return fmt.Errorf("could not open bundlefile '%v': %w", bundlefile, err)
+	}
+	defer f.Close()
+	return ApplyFS(os.DirFS(olddir), f, newdir, opts...)
+}
+
+// apply returns the new file of e, reading its payload from r, or nil for
+// OpDelete
+func apply(oldfs fs.FS, r *bufio.Reader, e *Entry, opts []bspatch.Option) ([]byte, error) {
+	var payload, oldbs []byte
+	var err error
+	if e.hasPayload() {
+		if payload, err = readBytes(r); err != nil {
+			return nil, err
+		}
+	}
+	if e.Op == OpDelete {
+		return nil, nil
+	}
+	if e.Op == OpAdd {
+		return payload, nil
+	}
+	if oldbs, err = readFile(oldfs, e.oldPath(), e.OldSHA256); err != nil {
+		return nil, err
+	}
+	if e.Op == OpKeep {
+		return oldbs, nil
+	}
+	newbs, err := bspatch.Bytes(oldbs, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not patch '%v': %w", e.Path, err)
+	}
+	return newbs, nil
+}
+
+// readHeader reads the magic and the manifest of a bundle
+func readHeader(r *bufio.Reader) (*Manifest, error) {
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != Magic {
+		return nil, corrupt("bad magic")
+	}
+	b, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	mr := bufio.NewReader(bytes.NewReader(b))
+	m, err := readManifest(mr)
+	if err != nil {
+		return nil, err
+	}
+	if mr.Buffered() > 0 {
+		return nil, corrupt("trailing data after the manifest")
+	}
+	return m, nil
+}
+
+// readFile reads name from fsys, failing with bspatch.ErrWrongOld unless
+// its SHA-256 is sum: the file changed since it was hashed
+func readFile(fsys fs.FS, name string, sum []byte) ([]byte, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read '%v': %w", name, err)
+	}
+	if got := sha256.Sum256(b); !bytes.Equal(got[:], sum) {
+		return nil, fmt.Errorf("%w '%v' (SHA-256 %x, expected %x)", bspatch.ErrWrongOld, name, got, sum)
+	}
+	return b, nil
+}
+
+func writeBytes(w *bufio.Writer, b []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(b)))])
+	w.Write(b)
+}
+
+// readBytes reads a uvarint length and as many bytes from r, growing the
+// buffer as they arrive so a bad length can't allocate much
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > 1<<62 {
+		return nil, corrupt("truncated")
+	}
+	var b bytes.Buffer
+	if _, err = io.CopyN(&b, r, int64(n)); err != nil {
+		return nil, corrupt("truncated")
+	}
+	return b.Bytes(), nil
+}
+
+// corrupt returns an error wrapping bspatch.ErrCorruptPatch for a bundle
+// malformed as described by msg
+func corrupt(msg string) error {
+	return fmt.Errorf("%w (bundle %v)", bspatch.ErrCorruptPatch, msg)
+}
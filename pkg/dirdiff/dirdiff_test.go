@@ -0,0 +1,400 @@
+package dirdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestDirDiff(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	changed := append(append([]byte{}, big...), "tail"...)
+	oldfs := fstest.MapFS{
+		"same.txt":        {Data: []byte("unchanged"), Mode: 0644},
+		"bin/app":         {Data: big, Mode: 0755},
+		"gone.txt":        {Data: []byte("deleted"), Mode: 0644},
+		"lib/chmod.so":    {Data: []byte("mode only"), Mode: 0644},
+		"lib/deep/old.md": {Data: []byte("old"), Mode: 0644},
+	}
+	newfs := fstest.MapFS{
+		"same.txt":          {Data: []byte("unchanged"), Mode: 0644},
+		"bin/app":           {Data: changed, Mode: 0755},
+		"lib/chmod.so":      {Data: []byte("mode only"), Mode: 0600},
+		"lib/deep/old.md":   {Data: []byte("new"), Mode: 0644},
+		"added/empty":       {Data: nil, Mode: 0644},
+		"added/readme.txt":  {Data: []byte("hello"), Mode: 0640, ModTime: time.Unix(1700000000, 5)},
+		"added/nested/file": {Data: big[:1000], Mode: 0644},
+	}
+	var bundle bytes.Buffer
+	if err := DiffFS(oldfs, newfs, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Len() > len(big)/10 {
+		t.Fatalf("bundle of %v bytes is too large", bundle.Len())
+	}
+
+	m, err := ReadManifest(bytes.NewReader(bundle.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := m.Counts()
+	if counts[OpKeep] != 2 || counts[OpModify] != 2 || counts[OpAdd] != 3 || counts[OpDelete] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	for _, e := range m.Entries {
+		if e.Op == OpDelete {
+			if e.Path != "gone.txt" || e.NewSHA256 != nil {
+				t.Fatalf("unexpected tombstone %+v", e)
+			}
+			continue
+		}
+		want := newfs[e.Path]
+		sum := sha256.Sum256(want.Data)
+		if e.Mode != want.Mode || e.Size != int64(len(want.Data)) || !bytes.Equal(e.NewSHA256, sum[:]) {
+			t.Fatalf("%v: unexpected entry %+v", e.Path, e)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "new")
+	if err := ApplyFS(oldfs, bytes.NewReader(bundle.Bytes()), dir); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil || fi.Mode().Perm() != 0755 {
+		t.Fatal("expected a 0755 new directory", fi, err)
+	}
+	files, err := hashTree(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range files {
+		want, ok := newfs[name]
+		if !ok {
+			t.Fatalf("unexpected file %v", name)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || !bytes.Equal(b, want.Data) || f.mode != want.Mode {
+			t.Fatalf("%v: got %v bytes, mode %v (%v)", name, len(b), f.mode, err)
+		}
+		if !want.ModTime.IsZero() && !f.mtime.Equal(want.ModTime) {
+			t.Fatalf("%v: got modification time %v, expected %v", name, f.mtime, want.ModTime)
+		}
+	}
+	if len(files) != len(newfs) {
+		t.Fatalf("got %v files, expected %v", len(files), len(newfs))
+	}
+
+	// newdir must not exist
+	if err = ApplyFS(oldfs, bytes.NewReader(bundle.Bytes()), dir); err == nil {
+		t.Fatal("expected an error applying to an existing directory")
+	}
+
+	// Modified old trees
+	for name, data := range map[string][]byte{"same.txt": []byte("modified"), "bin/app": big[1:]} {
+		modified := fstest.MapFS{}
+		for k, v := range oldfs {
+			modified[k] = v
+		}
+		modified[name] = &fstest.MapFile{Data: data}
+		dir := filepath.Join(t.TempDir(), "new")
+		err = ApplyFS(modified, bytes.NewReader(bundle.Bytes()), dir)
+		if !errors.Is(err, bspatch.ErrWrongOld) {
+			t.Fatalf("%v: expected a wrong old file error, got %v", name, err)
+		}
+		if _, serr := os.Stat(dir); !os.IsNotExist(serr) {
+			t.Fatalf("%v: new directory left behind", name)
+		}
+	}
+
+	// Corrupt bundles
+	b := bundle.Bytes()
+	for _, p := range [][]byte{
+		nil,
+		[]byte("BSDIRDF0\x00"),
+		b[:len(b)-1],
+		[]byte(Magic + "\x02\x01X"),
+		[]byte(Magic + "\x07\x01D\x04../x"),
+		[]byte(Magic + "\x09\x02D\x01b\x00D\x01a\x00"),
+		underLink(),
+		fromSelf(),
+	} {
+		err = ApplyFS(oldfs, bytes.NewReader(p), filepath.Join(t.TempDir(), "new"))
+		if !errors.Is(err, bspatch.ErrCorruptPatch) {
+			t.Fatalf("%q: expected a corrupt bundle error, got %v", p, err)
+		}
+	}
+}
+
+func TestRenames(t *testing.T) {
+	var text bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&text, "line %v of a file that moves\n", i)
+	}
+	big := text.Bytes()
+	edited := bytes.Replace(big, []byte("line 1000 "), []byte("line one thousand "), 1)
+	oldfs := fstest.MapFS{
+		"src/moved.go":    {Data: big, Mode: 0644},
+		"src/renamed.go":  {Data: big[:len(big)/2], Mode: 0644},
+		"src/other.go":    {Data: bytes.Repeat([]byte("unrelated\n"), 3000), Mode: 0644},
+		"docs/kept.txt":   {Data: []byte("copied"), Mode: 0644},
+		"docs/empty.txt":  {Mode: 0644},
+		"docs/deleted.md": {Data: []byte("nothing like it"), Mode: 0644},
+	}
+	newfs := fstest.MapFS{
+		"pkg/moved.go":     {Data: big, Mode: 0644},
+		"pkg/renamed.go":   {Data: edited[:len(edited)/2], Mode: 0644},
+		"pkg/new.go":       {Data: []byte("nothing like it either"), Mode: 0644},
+		"docs/kept.txt":    {Data: []byte("copied"), Mode: 0644},
+		"docs/copy.txt":    {Data: []byte("copied"), Mode: 0644},
+		"docs/empty.txt":   {Mode: 0644},
+		"docs/another.txt": {Mode: 0644},
+	}
+	var bundle bytes.Buffer
+	if err := DiffFS(oldfs, newfs, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Len() > len(big)/10 {
+		t.Fatalf("bundle of %v bytes is too large", bundle.Len())
+	}
+	m, err := ReadManifest(bytes.NewReader(bundle.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Entry{
+		"pkg/moved.go":     {Op: OpKeep, From: "src/moved.go"},
+		"pkg/renamed.go":   {Op: OpModify, From: "src/renamed.go"},
+		"pkg/new.go":       {Op: OpAdd},
+		"docs/copy.txt":    {Op: OpKeep, From: "docs/kept.txt"},
+		"docs/another.txt": {Op: OpAdd},
+	}
+	for _, e := range m.Entries {
+		if w, ok := want[e.Path]; ok && (e.Op != w.Op || e.From != w.From) {
+			t.Fatalf("%v: got %v from %q, expected %v from %q", e.Path, e.Op, e.From, w.Op, w.From)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "new")
+	if err = ApplyFS(oldfs, bytes.NewReader(bundle.Bytes()), dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range newfs {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(b, f.Data) {
+			t.Fatalf("%v: got %v bytes (%v)", name, len(b), err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "src")); !os.IsNotExist(err) {
+		t.Fatal("old directory left behind")
+	}
+
+	// A modified source of a move
+	modified := fstest.MapFS{}
+	for k, v := range oldfs {
+		modified[k] = v
+	}
+	modified["src/moved.go"] = &fstest.MapFile{Data: edited}
+	err = ApplyFS(modified, bytes.NewReader(bundle.Bytes()), filepath.Join(t.TempDir(), "new"))
+	if !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatalf("expected a wrong old file error, got %v", err)
+	}
+}
+
+// underLink returns a bundle writing a file through a symlink
+func underLink() []byte {
+	m := &Manifest{Entries: []Entry{
+		{Path: "a", Op: OpAdd, Mode: fs.ModeSymlink | 0777, UID: -1, GID: -1, Link: "/tmp"},
+		{Path: "a/f", Op: OpAdd, Mode: 0644, UID: -1, GID: -1, NewSHA256: make([]byte, sha256.Size)},
+	}}
+	b := m.marshal()
+	return append(binary.AppendUvarint([]byte(Magic), uint64(len(b))), b...)
+}
+
+// fromSelf returns a bundle keeping a file moved from itself
+func fromSelf() []byte {
+	m := &Manifest{Entries: []Entry{
+		{Path: "a", Op: OpKeep, Mode: 0644, UID: -1, GID: -1, From: "a", OldSHA256: make([]byte, sha256.Size), NewSHA256: make([]byte, sha256.Size)},
+	}}
+	b := m.marshal()
+	return append(binary.AppendUvarint([]byte(Magic), uint64(len(b))), b...)
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	olddir, newdir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	for _, d := range []string{olddir, newdir} {
+		if err := os.MkdirAll(filepath.Join(d, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(olddir, "sub", "f"), []byte("old contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(newdir, "sub", "f"), []byte("new contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bundlefile := filepath.Join(dir, "out.bsdir")
+	if err := Diff(olddir, newdir, bundlefile); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := Apply(olddir, bundlefile, out); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "sub", "f")); err != nil || string(b) != "new contents" {
+		t.Fatalf("got %q (%v)", b, err)
+	}
+	if err := Apply(olddir, filepath.Join(dir, "missing"), filepath.Join(dir, "out2")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing bundle error, got %v", err)
+	}
+
+	// Symlinks and attributes
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(newdir, "sub", "f"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(newdir, "sub", "f"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("f", filepath.Join(newdir, "sub", "link")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(newdir, "dirlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Diff(olddir, newdir, bundlefile); err != nil {
+		t.Fatal(err)
+	}
+	out = filepath.Join(dir, "out3")
+	if err := Apply(olddir, bundlefile, out); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(out, "sub", "f"))
+	if err != nil || fi.Mode() != 0600 || !fi.ModTime().Equal(mtime) {
+		t.Fatal("attributes not restored", fi.Mode(), fi.ModTime(), err)
+	}
+	for name, want := range map[string]string{"sub/link": "f", "dirlink": "sub"} {
+		if got, err := os.Readlink(filepath.Join(out, name)); err != nil || got != want {
+			t.Fatalf("%v: got link %q, expected %q (%v)", name, got, want, err)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "dirlink", "f")); err != nil || string(b) != "new contents" {
+		t.Fatalf("got %q (%v)", b, err)
+	}
+
+	// Symlinks of trees that can't read them are an error
+	newfs := struct{ fs.FS }{fstest.MapFS{"link": {Data: []byte("f"), Mode: fs.ModeSymlink | 0777}}}
+	if err := DiffFS(fstest.MapFS{}, newfs, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error diffing a symlink")
+	}
+}
+
+// memFS is an in-memory WriteFS
+type memFS fstest.MapFS
+
+type memFile struct {
+	bytes.Buffer
+	f *fstest.MapFile
+}
+
+func (f *memFile) Close() error {
+	f.f.Data = f.Bytes()
+	return nil
+}
+
+func (m memFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if _, ok := m[name]; ok {
+		return nil, fs.ErrExist
+	}
+	f := &fstest.MapFile{Mode: perm}
+	m[name] = f
+	return &memFile{f: f}, nil
+}
+
+func (m memFS) Mkdir(name string, perm fs.FileMode) error {
+	if _, ok := m[name]; ok {
+		return fs.ErrExist
+	}
+	m[name] = &fstest.MapFile{Mode: fs.ModeDir | perm}
+	return nil
+}
+
+func (m memFS) Remove(name string) error {
+	delete(m, name)
+	return nil
+}
+
+// linkMemFS is a memFS that makes symlinks and sets attributes
+type linkMemFS struct{ memFS }
+
+func (m linkMemFS) Symlink(target, name string) error {
+	m.memFS[name] = &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0777}
+	return nil
+}
+
+func (m linkMemFS) Chmod(name string, mode fs.FileMode) error {
+	m.memFS[name].Mode = mode
+	return nil
+}
+
+func (m linkMemFS) Chtimes(name string, mtime time.Time) error {
+	m.memFS[name].ModTime = mtime
+	return nil
+}
+
+func (m linkMemFS) Chown(name string, uid, gid int) error { return nil }
+
+func TestApplyTo(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	oldfs := fstest.MapFS{
+		"a/old.txt": {Data: []byte("old contents"), Mode: 0644},
+		"gone":      {Data: []byte("deleted"), Mode: 0644},
+	}
+	newfs := fstest.MapFS{
+		"a/old.txt":    {Data: []byte("new contents"), Mode: 0600, ModTime: mtime},
+		"b/c/new.txt":  {Data: []byte("added"), Mode: 0755 | fs.ModeSetuid},
+		"b/c/link.txt": {Data: []byte("new.txt"), Mode: fs.ModeSymlink | 0777},
+	}
+	var bundle bytes.Buffer
+	if err := DiffFS(oldfs, newfs, &bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := linkMemFS{memFS{}}
+	if err := ApplyTo(oldfs, bytes.NewReader(bundle.Bytes()), dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.memFS) != 6 {
+		t.Fatalf("got %v files and directories, expected 6", len(dst.memFS))
+	}
+	for name, want := range newfs {
+		got := dst.memFS[name]
+		if got == nil || !bytes.Equal(got.Data, want.Data) || got.Mode != want.Mode || !got.ModTime.Equal(want.ModTime) {
+			t.Fatalf("%v: got %+v, expected %+v", name, got, want)
+		}
+	}
+	if d := dst.memFS["b/c"]; d == nil || !d.Mode.IsDir() {
+		t.Fatal("missing directory")
+	}
+
+	// Symlinks need a SymlinkFS, and failures remove what was made
+	mem := memFS{}
+	if err := ApplyTo(oldfs, bytes.NewReader(bundle.Bytes()), mem); err == nil || len(mem) != 0 {
+		t.Fatal("expected an error making a symlink and an empty tree", err, len(mem))
+	}
+	oldfs["a/old.txt"] = &fstest.MapFile{Data: []byte("modified")}
+	dst = linkMemFS{memFS{}}
+	err := ApplyTo(oldfs, bytes.NewReader(bundle.Bytes()), dst)
+	if !errors.Is(err, bspatch.ErrWrongOld) || len(dst.memFS) != 0 {
+		t.Fatalf("expected a wrong old file error and an empty tree, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package dirdiff
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Rules select the files of a tree by path with gitignore style patterns
+type Rules struct {
+	include, exclude []pattern
+}
+
+// pattern is a compiled gitignore style pattern
+type pattern struct {
+	// segs are the path.Match patterns of the slash separated components,
+	// "**" matching any number of them
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+// NewRules compiles rules from patterns in the syntax of .gitignore files:
+// a pattern without a slash but at its end matches a file or directory of
+// that name anywhere, others match paths from the root of the tree, "*", "?"
+// and "[...]" match as path.Match does, "**" matches any number of
+// directories, a trailing "/" matches only directories and excludes all
+// that's under them, and blank lines and those starting with "#" are
+// ignored. Files under a directory an exclude pattern matches, or matched by
+// the last exclude pattern matching them unless it starts with "!", are
+// excluded; so are files no include pattern matches, if there are any.
+func NewRules(include, exclude []string) (*Rules, error) {
+	r := &Rules{}
+	for _, p := range []struct {
+		dst  *[]pattern
+		srcs []string
+	}{{&r.include, include}, {&r.exclude, exclude}} {
+		for _, src := range p.srcs {
+			pat, ok, err := compile(src)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				*p.dst = append(*p.dst, pat)
+			}
+		}
+	}
+	return r, nil
+}
+
+// compile compiles src, returning false for blank lines and comments
+func compile(src string) (pattern, bool, error) {
+	s := strings.TrimRight(src, " \t\r")
+	if s == "" || strings.HasPrefix(s, "#") {
+		return pattern{}, false, nil
+	}
+	var p pattern
+	if strings.HasPrefix(s, "!") {
+		p.negate, s = true, s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly, s = true, strings.TrimRight(s, "/")
+	}
+	if !strings.Contains(s, "/") {
+		p.segs = append(p.segs, "**")
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(s, "/"), "/") {
+		if _, err := path.Match(seg, ""); err != nil || seg == "" {
+			return pattern{}, false, fmt.Errorf("bad pattern %q", src)
+		}
+		p.segs = append(p.segs, seg)
+	}
+	return p, true, nil
+}
+
+// Excludes reports whether r excludes the file or directory name of a tree,
+// a slash separated path as fs.FS takes
+func (r *Rules) Excludes(name string, isDir bool) bool {
+	if name == "." {
+		return false
+	}
+	if dir := path.Dir(name); dir != "." && r.excludesDir(dir) {
+		return true
+	}
+	if isDir {
+		return r.excludesDir(name)
+	}
+	if r.excluded(name, false) {
+		return true
+	}
+	if len(r.include) == 0 {
+		return false
+	}
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		for _, p := range r.include {
+			if p.match(dir, dir != name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// excludesDir reports whether r excludes the directory name or one it's in
+func (r *Rules) excludesDir(name string) bool {
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if r.excluded(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether the last exclude pattern matching name excludes it
+func (r *Rules) excluded(name string, isDir bool) bool {
+	for i := len(r.exclude) - 1; i >= 0; i-- {
+		if p := r.exclude[i]; p.match(name, isDir) {
+			return !p.negate
+		}
+	}
+	return false
+}
+
+func (p pattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegs(p.segs, strings.Split(name, "/"))
+}
+
+func matchSegs(segs, names []string) bool {
+	for len(segs) > 0 {
+		if segs[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchSegs(segs[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(segs[0], names[0]); !ok {
+			return false
+		}
+		segs, names = segs[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// Filter returns the tree fsys without the files and directories rules
+// exclude, reading symlinks if fsys does. Diffing filtered trees leaves the
+// excluded files out of the bundle, and applying a bundle to a filtered old
+// tree skips the files the rules exclude.
+func Filter(fsys fs.FS, rules *Rules) fs.FS {
+	f := &filterFS{fsys: fsys, rules: rules}
+	if _, ok := fsys.(linkFS); ok {
+		return filterLinkFS{f}
+	}
+	return f
+}
+
+type filterFS struct {
+	fsys  fs.FS
+	rules *Rules
+}
+
+func (f *filterFS) Open(name string) (fs.File, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if f.rules.Excludes(name, fi.IsDir()) {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if d, ok := file.(fs.ReadDirFile); ok && fi.IsDir() {
+		return &filterDir{ReadDirFile: d, f: f, name: name}, nil
+	}
+	return file, nil
+}
+
+func (f *filterFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if f.rules.Excludes(name, true) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(f.fsys, name)
+	return f.filter(name, entries), err
+}
+
+// filter returns the entries of the directory name rules don't exclude
+func (f *filterFS) filter(name string, entries []fs.DirEntry) []fs.DirEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if !f.rules.Excludes(path.Join(name, e.Name()), e.IsDir()) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+type filterLinkFS struct {
+	*filterFS
+}
+
+func (f filterLinkFS) ReadLink(name string) (string, error) {
+	if f.rules.Excludes(name, false) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.fsys.(linkFS).ReadLink(name)
+}
+
+type filterDir struct {
+	fs.ReadDirFile
+	f    *filterFS
+	name string
+}
+
+func (d *filterDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		kept := d.f.filter(d.name, entries)
+		if len(kept) > 0 || err != nil || n <= 0 {
+			return kept, err
+		}
+	}
+}
+
+// rulesOf returns the rules of fsys if Filter made it
+func rulesOf(fsys fs.FS) *Rules {
+	switch f := fsys.(type) {
+	case *filterFS:
+		return f.rules
+	case filterLinkFS:
+		return f.rules
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package dirdiff
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRules(t *testing.T) {
+	exclude := []string{
+		"# caches and logs",
+		"*.log",
+		"!keep.log",
+		"",
+		"cache/",
+		"!cache/important",
+		"/build",
+		"docs/**/*.tmp",
+	}
+	r, err := NewRules(nil, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"app.log":              true,
+		"sub/dir/app.log":      true,
+		"keep.log":             false,
+		"sub/keep.log":         false,
+		"cache/x":              true,
+		"cache/important":      true,
+		"src/cache/y":          true,
+		"build/out":            true,
+		"src/build/out":        false,
+		"build":                true,
+		"docs/a.tmp":           true,
+		"docs/x/y/a.tmp":       true,
+		"other/a.tmp":          false,
+		"main.go":              false,
+		"app.logs":             false,
+		"app.log/file-in-dir":  true,
+		"cache":                false,
+		"notcache/cache.go":    false,
+		"sub/dir/app.log.back": false,
+	} {
+		if got := r.Excludes(name, false); got != want {
+			t.Errorf("%v: got %v, expected %v", name, got, want)
+		}
+	}
+	if !r.Excludes("cache", true) || !r.Excludes("app.log", true) || r.Excludes("src", true) {
+		t.Error("directories not matched")
+	}
+
+	r, err = NewRules([]string{"*.go", "assets/"}, []string{"vendor/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"main.go":          false,
+		"pkg/x/lib.go":     false,
+		"vendor/dep/d.go":  true,
+		"README.md":        true,
+		"assets/img/a.png": false,
+	} {
+		if got := r.Excludes(name, false); got != want {
+			t.Errorf("%v: got %v, expected %v", name, got, want)
+		}
+	}
+	if r.Excludes("pkg", true) {
+		t.Error("include patterns excluded a directory")
+	}
+
+	for _, bad := range []string{"[", "a//b", "x/["} {
+		if _, err = NewRules(nil, []string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	oldfs := fstest.MapFS{
+		"main.go":         {Data: []byte("package main"), Mode: 0644},
+		"cache/blob":      {Data: []byte("cached"), Mode: 0644},
+		"logs/app.log":    {Data: []byte("old log"), Mode: 0644},
+		"src/lib.go":      {Data: []byte("package lib"), Mode: 0644},
+		"src/lib.go.orig": {Data: []byte("backup"), Mode: 0644},
+	}
+	newfs := fstest.MapFS{
+		"main.go":      {Data: []byte("package main // v2"), Mode: 0644},
+		"cache/blob":   {Data: []byte("cached again"), Mode: 0644},
+		"logs/app.log": {Data: []byte("new log"), Mode: 0644},
+		"src/lib.go":   {Data: []byte("package lib"), Mode: 0644},
+		"src/gen.go":   {Data: []byte("// generated"), Mode: 0644},
+	}
+	rules, err := NewRules(nil, []string{"cache/", "*.log", "*.orig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := Filter(newfs, rules)
+	if _, err = fs.Stat(filtered, "cache/blob"); err == nil {
+		t.Fatal("excluded file found")
+	}
+	if err = fstest.TestFS(filtered, "main.go", "src/lib.go", "src/gen.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle bytes.Buffer
+	if err = DiffFS(Filter(oldfs, rules), filtered, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(bytes.NewReader(bundle.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"main.go", "src/gen.go", "src/lib.go"}; !equal(paths, want) {
+		t.Fatalf("got entries %v, expected %v", paths, want)
+	}
+
+	// Applying to a filtered old tree skips the files the rules exclude
+	bundle.Reset()
+	if err = DiffFS(oldfs, newfs, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = NewRules(nil, []string{"logs/", "/main.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "new")
+	if err = ApplyFS(Filter(oldfs, rules), bytes.NewReader(bundle.Bytes()), dir); err != nil {
+		t.Fatal(err)
+	}
+	files, err := hashTree(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = paths[:0]
+	for name := range files {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	if want := []string{"cache/blob", "src/gen.go", "src/lib.go"}; !equal(paths, want) {
+		t.Fatalf("got files %v, expected %v", paths, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
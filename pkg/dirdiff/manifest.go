@@ -0,0 +1,368 @@
+package dirdiff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// Op is what an entry of a manifest does to a file
+type Op byte
+
+const (
+	// OpKeep copies an unchanged file from the old tree
+	OpKeep Op = 'K'
+	// OpModify patches a changed file of the old tree with bsdiff
+	OpModify Op = 'M'
+	// OpAdd includes a file missing from the old tree whole
+	OpAdd Op = 'A'
+	// OpDelete is the tombstone of a file missing from the new tree
+	OpDelete Op = 'D'
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpKeep:
+		return "keep"
+	case OpModify:
+		return "modify"
+	case OpAdd:
+		return "add"
+	case OpDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("Op(%q)", byte(op))
+}
+
+// hasOld and hasNew report whether entries of op have an old and a new file
+func (op Op) hasOld() bool { return op != OpAdd }
+func (op Op) hasNew() bool { return op != OpDelete }
+
+// modeMask is the mode bits recorded: the permissions, setuid, setgid and
+// sticky bits, and whether the file is a symlink
+const modeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky | fs.ModeSymlink
+
+// Entry is a file of either tree
+type Entry struct {
+	// Path is the slash separated name of the file in the trees
+	Path string
+	Op   Op
+	// Mode is the permission bits of the new file, with fs.ModeSymlink for
+	// symlinks and the setuid, setgid and sticky bits. 0 for OpDelete.
+	Mode fs.FileMode
+	// Size is the size of the new file, 0 for OpDelete and symlinks
+	Size int64
+	// ModTime is the modification time of the new file, zero for OpDelete
+	ModTime time.Time
+	// UID and GID own the new file, -1 if unknown
+	UID, GID int
+	// Link is the target of a new symlink
+	Link string
+	// From is the path of the old file of an OpKeep or OpModify entry moved
+	// or copied from elsewhere in the old tree, "" if it's Path
+	From string
+	// OldSHA256 and NewSHA256 are the digests of the old and new regular
+	// files, nil for OpAdd and OpDelete respectively, and symlinks
+	OldSHA256, NewSHA256 []byte
+}
+
+// IsSymlink reports whether the new file of e is a symlink
+func (e *Entry) IsSymlink() bool { return e.Mode&fs.ModeSymlink != 0 }
+
+// oldPath returns the path of the old file of e
+func (e *Entry) oldPath() string {
+	if e.From != "" {
+		return e.From
+	}
+	return e.Path
+}
+
+// hasPayload reports whether e has a patch or file in the bundle
+func (e *Entry) hasPayload() bool {
+	return e.Op == OpModify || e.Op == OpAdd && !e.IsSymlink()
+}
+
+// Manifest lists the files of both trees, sorted by path
+type Manifest struct {
+	Entries []Entry
+}
+
+// Counts returns the number of entries of each op
+func (m *Manifest) Counts() map[Op]int {
+	counts := make(map[Op]int)
+	for _, e := range m.Entries {
+		counts[e.Op]++
+	}
+	return counts
+}
+
+// diffManifest hashes the regular files of oldfs and newfs, reads their
+// symlinks and pairs them by path, then new files with the old files they
+// were moved or copied from. Other files but directories are an error.
+func diffManifest(oldfs, newfs fs.FS) (*Manifest, error) {
+	oldFiles, err := hashTree(oldfs)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := hashTree(newfs)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	for name, nf := range newFiles {
+		e := Entry{Path: name, Op: OpAdd, Mode: nf.mode, Size: nf.size, ModTime: nf.mtime, UID: nf.uid, GID: nf.gid, Link: nf.link, NewSHA256: nf.sum}
+		of, ok := oldFiles[name]
+		switch {
+		case !ok:
+		case e.IsSymlink():
+			if of.mode&fs.ModeSymlink != 0 && of.link == nf.link {
+				e.Op = OpKeep
+			}
+		case of.mode&fs.ModeSymlink == 0:
+			e.Op, e.OldSHA256 = OpModify, of.sum
+			if bytes.Equal(of.sum, nf.sum) {
+				e.Op = OpKeep
+			}
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	for name, of := range oldFiles {
+		if _, ok := newFiles[name]; !ok {
+			m.Entries = append(m.Entries, Entry{Path: name, Op: OpDelete, UID: -1, GID: -1, OldSHA256: of.sum})
+		}
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	if err = renames(m, oldfs, newfs, oldFiles, newFiles); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// treeFile is a regular file or symlink of a tree
+type treeFile struct {
+	mode     fs.FileMode
+	size     int64
+	mtime    time.Time
+	uid, gid int
+	link     string
+	sum      []byte
+}
+
+// linkFS is an fs.FS that reads symlinks, as DirFS does
+type linkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+func hashTree(fsys fs.FS) (map[string]treeFile, error) {
+	files := make(map[string]treeFile)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		lfs, canLink := fsys.(linkFS)
+		if !d.Type().IsRegular() && !(d.Type() == fs.ModeSymlink && canLink) {
+			return fmt.Errorf("could not diff '%v': not a regular file", name)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		tf := treeFile{mode: fi.Mode() & modeMask, mtime: fi.ModTime(), uid: -1, gid: -1}
+		if uid, gid, ok := owner(fi); ok {
+			tf.uid, tf.gid = uid, gid
+		}
+		if tf.mode&fs.ModeSymlink != 0 {
+			if tf.link, err = lfs.ReadLink(name); err != nil {
+				return err
+			}
+			files[name] = tf
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if tf.size, err = io.Copy(h, f); err != nil {
+			return fmt.Errorf("could not read '%v': %w", name, err)
+		}
+		tf.sum = h.Sum(nil)
+		files[name] = tf
+		return nil
+	})
+	return files, err
+}
+
+// marshal encodes m: the uvarint number of entries, then for each its op
+// byte, the uvarint length of its path and the path, and for a new file its
+// uvarint mode and size, varint modification time in nanoseconds since
+// 1970 (0 if unknown), uvarint UID and GID plus one (0 if unknown), and
+// either the uvarint length of its target and the target for a symlink or
+// its digest; then for an old file the uvarint length of its digest, 0 for
+// symlinks, and the digest, and for OpKeep and OpModify the uvarint length of
+// From and From
+func (m *Manifest) marshal() []byte {
+	var b []byte
+	b = binary.AppendUvarint(b, uint64(len(m.Entries)))
+	for _, e := range m.Entries {
+		b = append(b, byte(e.Op))
+		b = binary.AppendUvarint(b, uint64(len(e.Path)))
+		b = append(b, e.Path...)
+		if e.Op.hasNew() {
+			b = binary.AppendUvarint(b, uint64(e.Mode))
+			b = binary.AppendUvarint(b, uint64(e.Size))
+			var mtime int64
+			if !e.ModTime.IsZero() {
+				mtime = e.ModTime.UnixNano()
+			}
+			b = binary.AppendVarint(b, mtime)
+			b = binary.AppendUvarint(b, uint64(e.UID+1))
+			b = binary.AppendUvarint(b, uint64(e.GID+1))
+			if e.IsSymlink() {
+				b = binary.AppendUvarint(b, uint64(len(e.Link)))
+				b = append(b, e.Link...)
+			} else {
+				b = append(b, e.NewSHA256...)
+			}
+		}
+		if e.Op.hasOld() {
+			b = binary.AppendUvarint(b, uint64(len(e.OldSHA256)))
+			b = append(b, e.OldSHA256...)
+		}
+		if e.Op == OpKeep || e.Op == OpModify {
+			b = binary.AppendUvarint(b, uint64(len(e.From)))
+			b = append(b, e.From...)
+		}
+	}
+	return b
+}
+
+// readManifest decodes the manifest marshal encoded from r. No new file may
+// be under a new symlink, which applying would write through.
+func readManifest(r *bufio.Reader) (*Manifest, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, corrupt("truncated manifest")
+	}
+	m := &Manifest{}
+	links := make(map[string]bool)
+	for i := uint64(0); i < n; i++ {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, corrupt("truncated manifest")
+		}
+		e := Entry{Op: Op(op), UID: -1, GID: -1}
+		switch e.Op {
+		case OpKeep, OpModify, OpAdd, OpDelete:
+		default:
+			return nil, corrupt(fmt.Sprintf("unknown op %q", op))
+		}
+		name, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Path = string(name)
+		if !fs.ValidPath(e.Path) || e.Path == "." {
+			return nil, corrupt(fmt.Sprintf("invalid file name %q", e.Path))
+		}
+		if len(m.Entries) > 0 && m.Entries[len(m.Entries)-1].Path >= e.Path {
+			return nil, corrupt(fmt.Sprintf("unsorted or duplicate file name %q", e.Path))
+		}
+		if e.Op.hasNew() {
+			for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+				if links[dir] {
+					return nil, corrupt(fmt.Sprintf("file name %q under a symlink", e.Path))
+				}
+			}
+			if err = readNew(r, &e); err != nil {
+				return nil, err
+			}
+			if e.IsSymlink() {
+				links[e.Path] = true
+			}
+		}
+		if e.Op.hasOld() {
+			sum, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(sum) != 0 && len(sum) != sha256.Size || len(sum) == 0 && e.Op != OpDelete && !e.IsSymlink() {
+				return nil, corrupt("bad digest")
+			}
+			if len(sum) > 0 {
+				e.OldSHA256 = sum
+			}
+		}
+		if e.Op == OpKeep || e.Op == OpModify {
+			from, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			e.From = string(from)
+			if e.From != "" && (!fs.ValidPath(e.From) || e.From == "." || e.From == e.Path || e.IsSymlink()) {
+				return nil, corrupt(fmt.Sprintf("invalid old file name %q", e.From))
+			}
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	return m, nil
+}
+
+// readNew reads the fields of the new file of e
+func readNew(r *bufio.Reader, e *Entry) error {
+	mode, err := binary.ReadUvarint(r)
+	if err != nil || fs.FileMode(mode)&^modeMask != 0 {
+		return corrupt("bad mode")
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > 1<<62 {
+		return corrupt("bad size")
+	}
+	e.Mode, e.Size = fs.FileMode(mode), int64(size)
+	mtime, err := binary.ReadVarint(r)
+	if err != nil {
+		return corrupt("bad modification time")
+	}
+	if mtime != 0 {
+		e.ModTime = time.Unix(0, mtime)
+	}
+	for _, id := range []*int{&e.UID, &e.GID} {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > 1<<32 {
+			return corrupt("bad owner")
+		}
+		*id = int(v) - 1
+	}
+	if !e.IsSymlink() {
+		e.NewSHA256, err = readSum(r)
+		return err
+	}
+	if e.Op == OpModify || e.Size != 0 {
+		return corrupt(fmt.Sprintf("bad symlink %q", e.Path))
+	}
+	link, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	if e.Link = string(link); e.Link == "" {
+		return corrupt(fmt.Sprintf("bad symlink %q", e.Path))
+	}
+	return nil
+}
+
+func readSum(r *bufio.Reader) ([]byte, error) {
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return nil, corrupt("truncated manifest")
+	}
+	return sum, nil
+}
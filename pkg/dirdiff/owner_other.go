@@ -0,0 +1,11 @@
+//go:build !unix
+
+package dirdiff
+
+import "io/fs"
+
+// owner returns the user and group IDs owning the file of fi, which aren't
+// known on this platform
+func owner(fi fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package dirdiff
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// owner returns the user and group IDs owning the file of fi
+func owner(fi fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
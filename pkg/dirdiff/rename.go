@@ -0,0 +1,141 @@
+package dirdiff
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// minScore is the similarity, in percent, from which an added file is patched
+// from a deleted one, as git's default for renames
+const minScore = 50
+
+// maxPairs bounds the pairs of deleted and added files scored, past which
+// only identical files are paired
+const maxPairs = 1 << 16
+
+// renames pairs the entries of m adding regular files with the old files they
+// were moved or copied from: any old file with the same digest, which the
+// entry becomes an OpKeep of, or else the most similar deleted old file, by
+// at least minScore, which it becomes an OpModify of. Each deleted file is
+// patched into at most one added file.
+func renames(m *Manifest, oldfs, newfs fs.FS, oldFiles, newFiles map[string]treeFile) error {
+	bySum := make(map[string]string)
+	var deleted []string
+	for name, of := range oldFiles {
+		if of.mode&fs.ModeSymlink != 0 || of.size == 0 {
+			continue
+		}
+		if prev, ok := bySum[string(of.sum)]; !ok || name < prev {
+			bySum[string(of.sum)] = name
+		}
+		if _, ok := newFiles[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	var added []*Entry
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Op != OpAdd || e.IsSymlink() || e.Size == 0 {
+			continue
+		}
+		if from, ok := bySum[string(e.NewSHA256)]; ok {
+			e.Op, e.From, e.OldSHA256 = OpKeep, from, e.NewSHA256
+			continue
+		}
+		added = append(added, e)
+	}
+	if len(added) == 0 || len(deleted) == 0 || len(added)*len(deleted) > maxPairs {
+		return nil
+	}
+	sort.Strings(deleted)
+
+	type pair struct {
+		score int
+		old   string
+		e     *Entry
+	}
+	var pairs []pair
+	oldPrints := make(map[string]map[uint32]int64)
+	for _, e := range added {
+		var newPrint map[uint32]int64
+		for _, name := range deleted {
+			osize := oldFiles[name].size
+			if 2*osize < e.Size || 2*e.Size < osize {
+				// Too different in size to be minScore similar
+				continue
+			}
+			if newPrint == nil {
+				b, err := fs.ReadFile(newfs, e.Path)
+				if err != nil {
+					return fmt.Errorf("could not read '%v': %w", e.Path, err)
+				}
+				newPrint = fingerprint(b)
+			}
+			oldPrint, ok := oldPrints[name]
+			if !ok {
+				b, err := fs.ReadFile(oldfs, name)
+				if err != nil {
+					return fmt.Errorf("could not read '%v': %w", name, err)
+				}
+				oldPrint = fingerprint(b)
+				oldPrints[name] = oldPrint
+			}
+			if score := similarity(oldPrint, newPrint, osize, e.Size); score >= minScore {
+				pairs = append(pairs, pair{score, name, e})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	used := make(map[string]bool)
+	for _, p := range pairs {
+		if used[p.old] || p.e.Op != OpAdd {
+			continue
+		}
+		used[p.old] = true
+		p.e.Op, p.e.From, p.e.OldSHA256 = OpModify, p.old, oldFiles[p.old].sum
+	}
+	return nil
+}
+
+// fingerprint counts the bytes of the chunks of b by their FNV-1a hash, a
+// chunk ending at a newline or after 64 bytes, as git does to score renames
+func fingerprint(b []byte) map[uint32]int64 {
+	fp := make(map[uint32]int64)
+	for len(b) > 0 {
+		n := len(b)
+		if n > 64 {
+			n = 64
+		}
+		if i := bytes.IndexByte(b[:n], '\n'); i >= 0 {
+			n = i + 1
+		}
+		h := uint32(2166136261)
+		for _, c := range b[:n] {
+			h = (h ^ uint32(c)) * 16777619
+		}
+		fp[h] += int64(n)
+		b = b[n:]
+	}
+	return fp
+}
+
+// similarity returns the percentage of the larger of two files of sizes
+// asize and bsize, with fingerprints a and b, that's shared with the other
+func similarity(a, b map[uint32]int64, asize, bsize int64) int {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	var shared int64
+	for h, n := range a {
+		if m := b[h]; m < n {
+			n = m
+		}
+		shared += n
+	}
+	if asize < bsize {
+		asize = bsize
+	}
+	return int(shared * 100 / asize)
+}
@@ -0,0 +1,169 @@
+package dirdiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// WriteFS is a tree ApplyTo makes a new tree in, such as an in-memory tree,
+// an overlay or a remote store. Names are slash separated paths, as fs.FS
+// takes.
+type WriteFS interface {
+	// Create makes the regular file name, which doesn't exist, with the
+	// permissions perm
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	// Mkdir makes the directory name, whose parent exists, with the
+	// permissions perm
+	Mkdir(name string, perm fs.FileMode) error
+	// Remove removes the file or empty directory name
+	Remove(name string) error
+}
+
+// SymlinkFS is a WriteFS that makes symlinks
+type SymlinkFS interface {
+	WriteFS
+	// Symlink makes the symlink name to target
+	Symlink(target, name string) error
+}
+
+// AttrFS is a WriteFS that sets the attributes of files
+type AttrFS interface {
+	WriteFS
+	// Chmod sets the mode of the regular file name, including the setuid,
+	// setgid and sticky bits
+	Chmod(name string, mode fs.FileMode) error
+	// Chtimes sets the modification time of the regular file name
+	Chtimes(name string, mtime time.Time) error
+	// Chown sets the owner of the file or symlink name, not following it
+	Chown(name string, uid, gid int) error
+}
+
+// treeWriter makes the files of a new tree in a WriteFS, remembering them to
+// undo
+type treeWriter struct {
+	dst  WriteFS
+	dirs map[string]bool
+	made []string
+}
+
+func newTreeWriter(dst WriteFS) *treeWriter {
+	return &treeWriter{dst: dst, dirs: map[string]bool{".": true}}
+}
+
+// mkdirAll makes the directory name and those it's in
+func (w *treeWriter) mkdirAll(name string) error {
+	if w.dirs[name] {
+		return nil
+	}
+	if err := w.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	err := w.dst.Mkdir(name, 0755)
+	if err == nil {
+		w.made = append(w.made, name)
+	} else if !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("could not make directory '%v': %w", name, err)
+	}
+	w.dirs[name] = true
+	return nil
+}
+
+// writeFile writes data to the file of e with its mode, owner and
+// modification time
+func (w *treeWriter) writeFile(e *Entry, data []byte) error {
+	if err := w.mkdirAll(path.Dir(e.Path)); err != nil {
+		return err
+	}
+	f, err := w.dst.Create(e.Path, e.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("could not create '%v': %w", e.Path, err)
+	}
+	w.made = append(w.made, e.Path)
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write '%v': %w", e.Path, err)
+	}
+	a, ok := w.dst.(AttrFS)
+	if !ok {
+		return nil
+	}
+	if e.UID >= 0 && e.GID >= 0 {
+		if err = a.Chown(e.Path, e.UID, e.GID); err != nil {
+			return err
+		}
+	}
+	// Create only sets the permissions, and chown clears the setuid and
+	// setgid bits
+	if err = a.Chmod(e.Path, e.Mode); err != nil {
+		return err
+	}
+	if e.ModTime.IsZero() {
+		return nil
+	}
+	return a.Chtimes(e.Path, e.ModTime)
+}
+
+// symlink makes the symlink of e with its owner
+func (w *treeWriter) symlink(e *Entry) error {
+	s, ok := w.dst.(SymlinkFS)
+	if !ok {
+		return fmt.Errorf("could not make symlink '%v': the tree has no symlinks", e.Path)
+	}
+	if err := w.mkdirAll(path.Dir(e.Path)); err != nil {
+		return err
+	}
+	if err := s.Symlink(e.Link, e.Path); err != nil {
+		return err
+	}
+	w.made = append(w.made, e.Path)
+	if a, ok := w.dst.(AttrFS); ok && e.UID >= 0 && e.GID >= 0 {
+		return a.Chown(e.Path, e.UID, e.GID)
+	}
+	return nil
+}
+
+// undo removes the files and directories made, last first
+func (w *treeWriter) undo() {
+	for i := len(w.made) - 1; i >= 0; i-- {
+		w.dst.Remove(w.made[i])
+	}
+}
+
+// osDir is the directory of the OS filesystem ApplyFS makes the new tree in.
+// It only sets owners when running as root, as tar does.
+type osDir string
+
+func (d osDir) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d osDir) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func (d osDir) Mkdir(name string, perm fs.FileMode) error { return os.Mkdir(d.path(name), perm) }
+func (d osDir) Remove(name string) error                  { return os.Remove(d.path(name)) }
+
+func (d osDir) Symlink(target, name string) error { return os.Symlink(target, d.path(name)) }
+
+func (d osDir) Chmod(name string, mode fs.FileMode) error { return os.Chmod(d.path(name), mode) }
+
+func (d osDir) Chtimes(name string, mtime time.Time) error {
+	return os.Chtimes(d.path(name), mtime, mtime)
+}
+
+func (d osDir) Chown(name string, uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(d.path(name), uid, gid)
+}
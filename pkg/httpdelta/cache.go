@@ -0,0 +1,63 @@
+package httpdelta
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache holds patches, or the instances of Middleware, by key. It must be safe for concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, patch []byte)
+}
+
+// NewCache returns an in-memory cache of at most size bytes of patches,
+// evicting the least recently used
+func NewCache(size int64) Cache {
+	return &lru{size: size, items: make(map[string]*list.Element)}
+}
+
+type lru struct {
+	mu    sync.Mutex
+	size  int64
+	used  int64
+	order list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	patch []byte
+}
+
+func (c *lru) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruItem).patch, true
+}
+
+func (c *lru) Put(key string, patch []byte) {
+	if int64(len(patch)) > c.size {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.used -= int64(len(e.Value.(*lruItem).patch))
+		c.order.Remove(e)
+	}
+	c.items[key] = c.order.PushFront(&lruItem{key, patch})
+	c.used += int64(len(patch))
+	for c.used > c.size {
+		e := c.order.Back()
+		item := e.Value.(*lruItem)
+		c.order.Remove(e)
+		delete(c.items, item.key)
+		c.used -= int64(len(item.patch))
+	}
+}
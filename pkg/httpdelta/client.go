@@ -0,0 +1,245 @@
+package httpdelta
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// WithClient sets the HTTP client Update and Remote request with,
+// http.DefaultClient by default
+func WithClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithRetries sets how many times Update and Remote retry a failed request
+// or an interrupted download, 5 by default, waiting delay before the first
+// retry and twice as long before each next one
+func WithRetries(n int, delay time.Duration) Option {
+	return func(o *options) {
+		o.retries, o.retryDelay = n, delay
+	}
+}
+
+// WithPatchOptions sets the options Update applies patches with
+func WithPatchOptions(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.patchOpts = opts
+	}
+}
+
+// Update downloads the patch at url and applies it to the file at path as
+// it arrives, then replaces the file with the new one atomically: the
+// update of a program or firmware done in one call. Interrupted downloads
+// resume with Range requests, or start over if the server ignores them.
+//
+// The patch must record the SHA-256 of the new file, as those a Handler
+// serves do, or bspatch.WithNewSHA256 give it, and the new file must match
+// it: otherwise the file at path is left untouched, as it is on any error.
+// A patch for another old file fails with bspatch.ErrWrongOld.
+func Update(ctx context.Context, url, path string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	oldF, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer oldF.Close()
+	fi, err := oldF.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not create '%v': %w", path, err)
+	}
+	tmpname := tmp.Name()
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpname)
+		}
+	}()
+
+	d := &download{ctx: ctx, url: url, o: o, retries: o.retries, delay: o.retryDelay}
+	defer d.close()
+	bw := bufio.NewWriter(tmp)
+	popts := append([]bspatch.Option{bspatch.WithRequireNewSHA256()}, o.patchOpts...)
+	if err = bspatch.ApplyStream(oldF, d, bw, popts...); err != nil {
+		return fmt.Errorf("could not apply %v: %w", url, err)
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, path)
+}
+
+// GetPlan asks the Handler at url, with WithPlan, for the cheapest chain
+// of patches from the version whose SHA-256 is from to that of to, in lower
+// case hex. A version without a chain to the other fails with a 404 error.
+func GetPlan(ctx context.Context, url, from, to string, opts ...Option) (*Plan, error) {
+	o := newOptions(opts)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Set("from", from)
+	q.Set("to", to)
+	q.Set("plan", "")
+	req.URL.RawQuery = q.Encode()
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", req.URL, resp.Status)
+	}
+	var p Plan
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("GET %v: %w", req.URL, err)
+	}
+	return &p, nil
+}
+
+// download reads the body of url, requesting the rest again after errors
+type download struct {
+	ctx     context.Context
+	url     string
+	o       *options
+	retries int
+	delay   time.Duration
+
+	body io.ReadCloser
+	// etag identifies the patch, so a resumed download is of the same one
+	etag string
+	// off is the number of bytes read
+	off int64
+}
+
+func (d *download) Read(p []byte) (int, error) {
+	for {
+		if d.body == nil {
+			if retry, err := d.open(); err != nil {
+				if !retry {
+					return 0, err
+				}
+				if err = d.wait(err); err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+		n, err := d.body.Read(p)
+		d.off += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		d.close()
+		if n > 0 {
+			return n, nil
+		}
+		if err = d.wait(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// open requests the bytes of the patch from off, reporting whether a
+// failure is worth retrying
+func (d *download) open() (retry bool, err error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if d.off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.off))
+		if d.etag != "" {
+			req.Header.Set("If-Range", d.etag)
+		}
+	}
+	resp, err := d.o.client.Do(req)
+	if err != nil {
+		return d.ctx.Err() == nil, err
+	}
+	status := fmt.Errorf("GET %v: %v", d.url, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.off > 0:
+		var start int64
+		if _, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != d.off {
+			resp.Body.Close()
+			return false, fmt.Errorf("GET %v: bad Content-Range %q", d.url, resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		etag := resp.Header.Get("ETag")
+		if d.off > 0 {
+			if d.etag != "" && etag != d.etag {
+				resp.Body.Close()
+				return false, fmt.Errorf("GET %v: patch changed during the download", d.url)
+			}
+			// Range ignored: skip what was read
+			if _, err = io.CopyN(io.Discard, resp.Body, d.off); err != nil {
+				resp.Body.Close()
+				return true, err
+			}
+		}
+		// Weak ETags can't be used with If-Range
+		if !strings.HasPrefix(etag, "W/") {
+			d.etag = etag
+		}
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		resp.Body.Close()
+		return true, status
+	default:
+		resp.Body.Close()
+		return false, status
+	}
+	d.body = resp.Body
+	return false, nil
+}
+
+// wait waits before retrying after err, or returns err if there are no
+// retries left
+func (d *download) wait(err error) error {
+	if d.retries <= 0 || d.ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	d.retries--
+	t := time.NewTimer(d.delay)
+	defer t.Stop()
+	select {
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	case <-t.C:
+	}
+	d.delay *= 2
+	return nil
+}
+
+func (d *download) close() {
+	if d.body != nil {
+		d.body.Close()
+		d.body = nil
+	}
+}
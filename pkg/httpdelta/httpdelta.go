@@ -0,0 +1,311 @@
+// Package httpdelta serves bsdiff patches between versions of an artifact
+// over HTTP. Clients that have one version ask for a patch to another,
+// naming both by their SHA-256,
+//
+//	GET /patch?from=<hash>&to=<hash>
+//
+// and the handler diffs them on demand, caching the patches. A patch
+// between two versions never changes, so responses carry a strong ETag and
+// honor conditional and Range requests: an interrupted download resumes
+// where it stopped.
+//
+// Update is the client side: it downloads a patch, resuming it as needed,
+// applies it while it arrives and replaces the local file once the new
+// version checks out.
+//
+// With WithPlan, a Handler also tells clients the cheapest chain of
+// prepared patches between two versions,
+//
+//	GET /patch?from=<hash>&to=<hash>&plan
+//
+// as a JSON Plan, which GetPlan requests.
+//
+// Middleware serves RFC 3229 deltas instead, for any handler: clients send
+// the ETag of the response they have and get a patch to the current one.
+// Remote reads an old file that's only on a server with Range requests.
+package httpdelta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/patchstore"
+	"github.com/gabstv/go-bsdiff/pkg/plan"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Store holds the versions of an artifact
+type Store interface {
+	// Get returns the version whose SHA-256 is hash, in lower case hex, or
+	// an error wrapping fs.ErrNotExist if there's none
+	Get(hash string) ([]byte, error)
+}
+
+// FS returns the store of the versions in fsys, each in a file named by its
+// SHA-256 in lower case hex. Get checks the contents against the name.
+func FS(fsys fs.FS) Store {
+	return fsStore{fsys}
+}
+
+type fsStore struct {
+	fsys fs.FS
+}
+
+func (s fsStore) Get(hash string) ([]byte, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("bad hash %q: %w", hash, fs.ErrNotExist)
+	}
+	b, err := fs.ReadFile(s.fsys, hash)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("version %v has SHA-256 %x", hash, sum)
+	}
+	return b, nil
+}
+
+// validHash reports whether h is a SHA-256 in lower case hex
+func validHash(h string) bool {
+	b, err := hex.DecodeString(h)
+	return err == nil && len(b) == sha256.Size && hex.EncodeToString(b) == h
+}
+
+// Option configures a Handler, Middleware, Update or Remote
+type Option func(*options)
+
+type options struct {
+	cache     Cache
+	instances Cache
+	patches   patchstore.Store
+	format    string
+	diffOpts  []bsdiff.Option
+	maxAge    time.Duration
+	graph     *plan.Graph
+	// client, retries and retryDelay are those of Update and Remote,
+	// patchOpts of Update and blockSize of Remote
+	client     *http.Client
+	retries    int
+	retryDelay time.Duration
+	patchOpts  []bspatch.Option
+	blockSize  int
+}
+
+// DefaultCacheSize is the size of the patch cache of a Handler, unless
+// WithCache sets another
+const DefaultCacheSize = 64 << 20
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		cache:      NewCache(DefaultCacheSize),
+		instances:  NewCache(DefaultCacheSize),
+		maxAge:     365 * 24 * time.Hour,
+		client:     http.DefaultClient,
+		retries:    5,
+		retryDelay: time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCache caches patches, or the blocks of a Remote, in c, or nowhere if
+// c is nil
+func WithCache(c Cache) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithPatchStore looks patches up in s before making them, and stores
+// those made there, under format, which must change with the options
+// patches are made with. Unlike the cache, s can be shared with other
+// services, like deltarpc.Server, and kept on disk.
+func WithPatchStore(s patchstore.Store, format string) Option {
+	return func(o *options) {
+		o.patches, o.format = s, format
+	}
+}
+
+// WithDiffOptions sets the options patches are made with, besides
+// bsdiff.WithHashes, which they always record for Update. A patch made
+// again once evicted from the cache must be the same for Range requests to
+// resume its download, so options making patches vary between runs, like
+// WithConcurrency without WithDeterministic, cost whole downloads.
+func WithDiffOptions(opts ...bsdiff.Option) Option {
+	return func(o *options) {
+		o.diffOpts = opts
+	}
+}
+
+// WithMaxAge sets how long clients and proxies may cache patches, a year by
+// default. 0 leaves out the Cache-Control header.
+func WithMaxAge(d time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = d
+	}
+}
+
+// WithPlan serves the cheapest chains of the patches of g to requests with
+// the plan query parameter. The versions of g must be named by their
+// SHA-256 in lower case hex, as those of plan.Files are, and g mustn't
+// change while served.
+func WithPlan(g *plan.Graph) Option {
+	return func(o *options) {
+		o.graph = g
+	}
+}
+
+// Plan is the chain of patches a Handler tells a client to apply
+type Plan struct {
+	// Size is the total size of Patches
+	Size int64 `json:"size"`
+	// Patches are the patches to apply in order, named as in the graph
+	Patches []plan.Patch `json:"patches"`
+}
+
+// Handler serves patches between the versions of a Store
+type Handler struct {
+	store Store
+	o     *options
+
+	mu sync.Mutex
+	// calls are the diffs in progress, so concurrent requests for the same
+	// patch make it once
+	calls map[string]*call
+}
+
+type call struct {
+	done  chan struct{}
+	patch []byte
+	err   error
+}
+
+// NewHandler returns a handler serving patches between the versions of
+// store. It serves every path, so mount it at the one clients request.
+func NewHandler(store Store, opts ...Option) *Handler {
+	return &Handler{store: store, o: newOptions(opts), calls: make(map[string]*call)}
+}
+
+// ServeHTTP serves the patch from the version of the from query parameter to
+// that of to
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	if !validHash(from) || !validHash(to) {
+		http.Error(w, "from and to must be SHA-256 hashes in lower case hex", http.StatusBadRequest)
+		return
+	}
+	if q.Has("plan") {
+		h.servePlan(w, from, to)
+		return
+	}
+	patch, err := h.Patch(from, to)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "no such version", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "could not make the patch", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(patch)
+	hdr := w.Header()
+	hdr.Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	hdr.Set("Content-Type", "application/octet-stream")
+	if h.o.maxAge > 0 {
+		hdr.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int64(h.o.maxAge/time.Second)))
+	}
+	// ServeContent answers Range, If-Range and If-None-Match requests
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(patch))
+}
+
+// servePlan serves the cheapest chain of patches from the version from to
+// that of to
+func (h *Handler) servePlan(w http.ResponseWriter, from, to string) {
+	if h.o.graph == nil {
+		http.Error(w, "no plans served", http.StatusNotFound)
+		return
+	}
+	path, err := h.o.graph.Path(from, to)
+	if err != nil {
+		http.Error(w, "no chain of patches between the versions", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Plan{Size: plan.Size(path), Patches: append([]plan.Patch{}, path...)})
+}
+
+// Patch returns the patch from the version from to the version to, from the
+// cache or made and cached
+func (h *Handler) Patch(from, to string) ([]byte, error) {
+	key := from + " " + to
+	if h.o.cache != nil {
+		if patch, ok := h.o.cache.Get(key); ok {
+			return patch, nil
+		}
+	}
+	h.mu.Lock()
+	c, ok := h.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		h.calls[key] = c
+	}
+	h.mu.Unlock()
+	if ok {
+		<-c.done
+		return c.patch, c.err
+	}
+	c.patch, c.err = h.diff(from, to)
+	if c.err == nil && h.o.cache != nil {
+		h.o.cache.Put(key, c.patch)
+	}
+	h.mu.Lock()
+	delete(h.calls, key)
+	h.mu.Unlock()
+	close(c.done)
+	return c.patch, c.err
+}
+
+func (h *Handler) diff(from, to string) (_ []byte, err error) {
+	defer util.Recover(&err)
+	key := patchstore.Key{Old: from, New: to, Format: h.o.format}
+	if h.o.patches != nil {
+		if patch, ok := h.o.patches.Get(key); ok {
+			return patch, nil
+		}
+	}
+	oldbs, err := h.store.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	newbs, err := h.store.Get(to)
+	if err != nil {
+		return nil, err
+	}
+	// Update checks the new version against its recorded digest
+	opts := append([]bsdiff.Option{bsdiff.WithHashes()}, h.o.diffOpts...)
+	patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+	if err == nil && h.o.patches != nil {
+		// Like the cache, the store only saves work: the patch is good
+		// even if it can't be stored
+		h.o.patches.Put(key, patch)
+	}
+	return patch, err
+}
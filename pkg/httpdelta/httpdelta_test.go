@@ -0,0 +1,388 @@
+package httpdelta
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/plan"
+)
+
+// countingStore counts the versions read
+type countingStore struct {
+	Store
+	n int32
+}
+
+func (s *countingStore) Get(hash string) ([]byte, error) {
+	atomic.AddInt32(&s.n, 1)
+	return s.Store.Get(hash)
+}
+
+func hashOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func get(t *testing.T, url string, header ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+func TestHandler(t *testing.T) {
+	oldbs := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs...)
+	copy(newbs[1000:], "version 2")
+	from, to := hashOf(oldbs), hashOf(newbs)
+	store := &countingStore{Store: FS(fstest.MapFS{
+		from:              {Data: oldbs},
+		to:                {Data: newbs},
+		hashOf([]byte{1}): {Data: []byte("not what the name says")},
+	})}
+	srv := httptest.NewServer(NewHandler(store))
+	defer srv.Close()
+	url := srv.URL + "/patch?from=" + from + "&to=" + to
+
+	resp, patch := get(t, url)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	if got, err := bspatch.Bytes(oldbs, patch); err != nil || !bytes.Equal(got, newbs) {
+		t.Fatal("patch doesn't apply", err)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != `"`+hashOf(patch)+`"` || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatal("bad headers", resp.Header)
+	}
+
+	// Concurrent requests, from the cache
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if b, err := io.ReadAll(resp.Body); err != nil || !bytes.Equal(b, patch) {
+				t.Error("different patch", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if store.n != 2 {
+		t.Fatal("versions read", store.n, "times, expected 2")
+	}
+
+	// Resuming a download
+	resp, b := get(t, url, "Range", "bytes=10-", "If-Range", etag)
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(b, patch[10:]) {
+		t.Fatal("range not served", resp.Status)
+	}
+	resp, b = get(t, url, "Range", "bytes=10-", "If-Range", `"stale"`)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(b, patch) {
+		t.Fatal("stale range served", resp.Status)
+	}
+	if resp, _ = get(t, url, "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatal("expected not modified, got", resp.Status)
+	}
+
+	for query, status := range map[string]int{
+		"":                        http.StatusBadRequest,
+		"?from=" + from:           http.StatusBadRequest,
+		"?from=" + from + "&to=x": http.StatusBadRequest,
+		"?from=" + from + "&to=" + hashOf([]byte("missing")): http.StatusNotFound,
+		"?from=" + from + "&to=" + hashOf([]byte{1}):         http.StatusInternalServerError,
+	} {
+		if resp, _ := get(t, srv.URL+"/patch"+query); resp.StatusCode != status {
+			t.Fatalf("%q: got %v, expected %v", query, resp.Status, status)
+		}
+	}
+	resp, err := http.Post(url, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("POST served", resp.Status)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(10)
+	c.Put("a", []byte("aaaa"))
+	c.Put("b", []byte("bbbb"))
+	c.Get("a")
+	c.Put("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used patch kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatal(key, "evicted")
+		}
+	}
+	c.Put("big", make([]byte, 11))
+	if _, ok := c.Get("big"); ok {
+		t.Fatal("patch larger than the cache kept")
+	}
+}
+
+// cutWriter drops what's written past n bytes, as when a connection breaks
+type cutWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.n -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestUpdate(t *testing.T) {
+	oldbs := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs...)
+	rand.New(rand.NewSource(2)).Read(newbs[1000:2000])
+	from, to := hashOf(oldbs), hashOf(newbs)
+	h := NewHandler(FS(fstest.MapFS{from: {Data: oldbs}, to: {Data: newbs}}))
+
+	// The first response breaks off, the second fails, the third resumes
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch len(ranges) {
+		case 1:
+			h.ServeHTTP(&cutWriter{w, 100}, r)
+		case 2:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+	url := srv.URL + "/patch?from=" + from + "&to=" + to
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app")
+	if err := os.WriteFile(path, oldbs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := []Option{WithRetries(3, time.Millisecond)}
+	if err := Update(context.Background(), url, path, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, newbs) {
+		t.Fatal("not updated", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0755 {
+		t.Fatal("mode not kept", fi.Mode(), err)
+	}
+	if len(ranges) != 3 || ranges[0] != "" || ranges[2] != "bytes=100-" {
+		t.Fatal("expected a resumed download, got ranges", ranges)
+	}
+
+	// Applied again: the file isn't the old version anymore
+	if err := Update(context.Background(), url, path, opts...); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected a wrong old file error, got", err)
+	}
+	// A patch that doesn't record the new file's digest
+	plain, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plain" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(plain)
+	}))
+	defer srv2.Close()
+	if err = os.WriteFile(path, oldbs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = Update(context.Background(), srv2.URL+"/plain", path, opts...); !errors.Is(err, bspatch.ErrNoNewSHA256) {
+		t.Fatal("expected an unverifiable patch error, got", err)
+	}
+	err = Update(context.Background(), srv2.URL+"/plain", path, append(opts, WithPatchOptions(bspatch.WithNewSHA256(make([]byte, 32))))...)
+	if !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected a digest mismatch, got", err)
+	}
+	if err = Update(context.Background(), srv2.URL+"/missing", path, opts...); err == nil {
+		t.Fatal("expected a not found error")
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, oldbs) {
+		t.Fatal("file changed by failed updates", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatal("temporary files left behind", entries)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	oldbs := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs...)
+	copy(newbs[1000:], "version 2")
+	fsys := fstest.MapFS{"app.bin": {Data: oldbs}}
+	srv := httptest.NewServer(Middleware(http.FileServer(http.FS(fsys))))
+	defer srv.Close()
+	url := srv.URL + "/app.bin"
+
+	resp, b := get(t, url, "A-IM", "vcdiff, bsdiff")
+	base := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(b, oldbs) || base != `"`+hashOf(oldbs)+`"` {
+		t.Fatal("first response:", resp.Status, base)
+	}
+	if resp, _ = get(t, url, "A-IM", "bsdiff", "If-None-Match", base); resp.StatusCode != http.StatusNotModified {
+		t.Fatal("unchanged:", resp.Status)
+	}
+
+	fsys["app.bin"] = &fstest.MapFile{Data: newbs}
+	resp, b = get(t, url, "A-IM", "bsdiff", "If-None-Match", `"other", `+base)
+	if resp.StatusCode != http.StatusIMUsed || resp.Header.Get("IM") != "bsdiff" || resp.Header.Get("Delta-Base") != base {
+		t.Fatal("delta:", resp.Status, resp.Header)
+	}
+	if got, err := bspatch.Bytes(oldbs, b); err != nil || !bytes.Equal(got, newbs) {
+		t.Fatal("delta doesn't apply", err)
+	}
+	if resp.Header.Get("ETag") != `"`+hashOf(newbs)+`"` {
+		t.Fatal("delta ETag", resp.Header.Get("ETag"))
+	}
+
+	for _, header := range [][]string{
+		{"A-IM", "bsdiff", "If-None-Match", `"unknown"`},
+		{"A-IM", "bsdiff;q=0", "If-None-Match", base},
+		{"If-None-Match", base},
+	} {
+		resp, b = get(t, url, header...)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(b, newbs) {
+			t.Errorf("%q: %v", header, resp.Status)
+		}
+	}
+}
+
+func TestRemote(t *testing.T) {
+	oldbs := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs[50<<10:]...)
+	newbs = append(newbs, oldbs[:60<<10]...)
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests, failures int32
+	content := oldbs
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/flaky" && atomic.AddInt32(&failures, 1) == 2 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/noranges" {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("ETag", `"`+hashOf(content)+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	old, err := OpenRemote(ctx, srv.URL+"/flaky", WithBlockSize(16<<10), WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Size() != int64(len(oldbs)) {
+		t.Fatal("size", old.Size())
+	}
+	var out bytes.Buffer
+	if err = bspatch.Apply(old, bytes.NewReader(patch), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("patched the wrong file")
+	}
+	// 7 blocks, and the retry
+	if n := atomic.LoadInt32(&requests); n != 8 {
+		t.Error("requests:", n)
+	}
+	b := make([]byte, 10)
+	if n, err := old.ReadAt(b, int64(len(oldbs)-5)); n != 5 || err != io.EOF || !bytes.Equal(b[:5], oldbs[len(oldbs)-5:]) {
+		t.Error("read past the end:", n, err)
+	}
+
+	if _, err = OpenRemote(ctx, srv.URL+"/noranges"); !errors.Is(err, ErrNoRanges) {
+		t.Error("no ranges:", err)
+	}
+	old, err = OpenRemote(ctx, srv.URL+"/file", WithCache(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = newbs
+	if _, err = old.ReadAt(b, 0); err == nil {
+		t.Error("read a file changed on the server")
+	}
+	content = nil
+	if old, err = OpenRemote(ctx, srv.URL+"/empty"); err != nil || old.Size() != 0 {
+		t.Error("empty file:", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	v1, v2, v3 := hashOf([]byte("1")), hashOf([]byte("2")), hashOf([]byte("3"))
+	g := plan.New(
+		plan.Patch{From: v1, To: v3, Size: 1000, Name: "1-3"},
+		plan.Patch{From: v1, To: v2, Size: 100, Name: "1-2"},
+		plan.Patch{From: v2, To: v3, Size: 100, Name: "2-3"},
+	)
+	srv := httptest.NewServer(NewHandler(FS(fstest.MapFS{}), WithPlan(g)))
+	defer srv.Close()
+	p, err := GetPlan(context.Background(), srv.URL+"/patch", v1, v3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Size != 200 || len(p.Patches) != 2 || p.Patches[0].Name != "1-2" || p.Patches[1].Name != "2-3" {
+		t.Fatal("wrong plan", p)
+	}
+	if _, err = GetPlan(context.Background(), srv.URL+"/patch", v3, v1); err == nil {
+		t.Fatal("expected an error without a chain")
+	}
+}
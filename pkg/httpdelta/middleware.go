@@ -0,0 +1,190 @@
+package httpdelta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WithInstances keeps the responses Middleware serves in c, keyed by their
+// ETag, to diff against when clients come back with them. It's an
+// in-memory cache of DefaultCacheSize bytes by default.
+func WithInstances(c Cache) Option {
+	return func(o *options) {
+		o.instances = c
+	}
+}
+
+// Middleware serves RFC 3229 deltas for next: a GET request accepting the
+// bsdiff instance manipulation,
+//
+//	A-IM: bsdiff
+//	If-None-Match: "<ETag of the response the client has>"
+//
+// is answered with 226 IM Used and a patch from the response the client has
+// to the current one, when Middleware served that response before and the
+// patch is smaller, or with the full response otherwise. Responses without
+// a strong ETag get the SHA-256 of their body as one.
+//
+// Other requests go to next as they are. Responses to those accepting
+// bsdiff are buffered, and kept as instances (see WithInstances); WithCache
+// and WithDiffOptions apply to the patches, but not WithPatchStore.
+func Middleware(next http.Handler, opts ...Option) http.Handler {
+	o := newOptions(opts)
+	m := &middleware{next: next, o: o}
+	// Instances are named by ETags, not by the SHA-256 patch stores want
+	ho := *o
+	ho.patches = nil
+	m.h = &Handler{store: instanceStore{o.instances}, o: &ho, calls: make(map[string]*call)}
+	return m
+}
+
+type middleware struct {
+	next http.Handler
+	o    *options
+	// h makes and caches the patches between instances
+	h *Handler
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "A-IM, If-None-Match")
+	if r.Method != http.MethodGet || !acceptsBsdiff(r.Header.Values("A-IM")) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	// A range of the full response isn't one of the patch
+	inner := r.Clone(r.Context())
+	inner.Header.Del("Range")
+	inner.Header.Del("If-Range")
+	rec := &recorder{hdr: w.Header()}
+	m.next.ServeHTTP(rec, inner)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	hdr := w.Header()
+	if rec.status != http.StatusOK || hdr.Get("Content-Encoding") != "" {
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+	body := rec.body.Bytes()
+	etag := hdr.Get("ETag")
+	if !strongETag(etag) {
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		hdr.Set("ETag", etag)
+	}
+	if m.o.instances != nil {
+		m.o.instances.Put(etag, body)
+	}
+	bases := etags(r.Header.Get("If-None-Match"))
+	for _, base := range bases {
+		if base == etag {
+			hdr.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	for _, base := range bases {
+		patch, err := m.h.Patch(base, etag)
+		if err != nil || len(patch) >= len(body) {
+			continue
+		}
+		hdr.Set("IM", "bsdiff")
+		hdr.Set("Delta-Base", base)
+		hdr.Set("Cache-Control", "no-store, im")
+		hdr.Set("Content-Length", strconv.Itoa(len(patch)))
+		hdr.Del("Content-Range")
+		hdr.Del("Accept-Ranges")
+		w.WriteHeader(http.StatusIMUsed)
+		w.Write(patch)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// acceptsBsdiff reports whether the A-IM header values list bsdiff, with a
+// nonzero quality
+func acceptsBsdiff(values []string) bool {
+	for _, v := range values {
+		for _, im := range strings.Split(v, ",") {
+			params := strings.Split(im, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "bsdiff") {
+				continue
+			}
+			q := 1.0
+			for _, p := range params[1:] {
+				if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+					q, _ = strconv.ParseFloat(v, 64)
+				}
+			}
+			if q > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// etags returns the strong entity tags of an If-None-Match header
+func etags(h string) []string {
+	var tags []string
+	for _, t := range strings.Split(h, ",") {
+		if t = strings.TrimSpace(t); strongETag(t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func strongETag(t string) bool {
+	return len(t) >= 2 && t[0] == '"' && t[len(t)-1] == '"' && !strings.Contains(t[1:len(t)-1], `"`)
+}
+
+// instanceStore is the Store of the instances in a Cache
+type instanceStore struct {
+	c Cache
+}
+
+func (s instanceStore) Get(etag string) ([]byte, error) {
+	if s.c != nil {
+		if b, ok := s.c.Get(etag); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no instance %v: %w", etag, fs.ErrNotExist)
+}
+
+// recorder buffers a response
+type recorder struct {
+	hdr    http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.hdr
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
@@ -0,0 +1,169 @@
+package httpdelta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultBlockSize is the size of the blocks a Remote requests, unless
+// WithBlockSize sets another
+const DefaultBlockSize = 256 << 10
+
+// ErrNoRanges is returned by OpenRemote when the server ignores Range
+// requests
+var ErrNoRanges = errors.New("server doesn't support Range requests")
+
+// WithBlockSize sets the size of the blocks a Remote requests and caches
+func WithBlockSize(n int) Option {
+	return func(o *options) {
+		o.blockSize = n
+	}
+}
+
+// Remote is a file on an HTTP server read with Range requests, so bspatch
+// can patch an old file that's only on a CI cache or a CDN:
+//
+//	old, err := httpdelta.OpenRemote(ctx, "https://cache.example.com/app-1.0")
+//	err = bspatch.Apply(old, patch, out)
+//
+// It reads whole blocks and keeps them in the cache of WithCache, so the
+// small reads of patching cost few requests. Failed requests are retried
+// as with WithRetries, and a file replaced on the server fails reads rather
+// than mix two versions. It's safe for concurrent use.
+type Remote struct {
+	ctx  context.Context
+	url  string
+	o    *options
+	size int64
+	// etag identifies the version read, for If-Range
+	etag string
+}
+
+// OpenRemote returns the Remote of the file at url, requesting its first
+// block
+func OpenRemote(ctx context.Context, url string, opts ...Option) (*Remote, error) {
+	o := newOptions(opts)
+	if o.blockSize <= 0 {
+		o.blockSize = DefaultBlockSize
+	}
+	r := &Remote{ctx: ctx, url: url, o: o, size: -1}
+	if _, err := r.block(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Size returns the size of the file
+func (r *Remote) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes of the file from off
+func (r *Remote) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%v: negative offset", r.url)
+	}
+	n := 0
+	for n < len(p) && off < r.size {
+		bs := int64(r.o.blockSize)
+		b, err := r.block(off / bs)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], b[off%bs:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the i-th block of the file, from the cache or requested
+func (r *Remote) block(i int64) ([]byte, error) {
+	key := fmt.Sprintf("%v %d", r.url, i)
+	if r.o.cache != nil {
+		if b, ok := r.o.cache.Get(key); ok {
+			return b, nil
+		}
+	}
+	delay := r.o.retryDelay
+	for try := 0; ; try++ {
+		b, retry, err := r.get(i)
+		if err == nil {
+			if r.o.cache != nil {
+				r.o.cache.Put(key, b)
+			}
+			return b, nil
+		}
+		if !retry || try >= r.o.retries || r.ctx.Err() != nil {
+			return nil, err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-r.ctx.Done():
+			t.Stop()
+			return nil, r.ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// get requests the i-th block, reporting whether a failure is worth
+// retrying
+func (r *Remote) get(i int64) (_ []byte, retry bool, err error) {
+	bs := int64(r.o.blockSize)
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", i*bs, i*bs+bs-1))
+	if r.etag != "" {
+		req.Header.Set("If-Range", r.etag)
+	}
+	resp, err := r.o.client.Do(req)
+	if err != nil {
+		return nil, r.ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case (resp.StatusCode == http.StatusRequestedRangeNotSatisfiable || resp.StatusCode == http.StatusOK && resp.ContentLength == 0) && r.size < 0:
+		// An empty file has no first byte to request
+		r.size = 0
+		return []byte{}, false, nil
+	case resp.StatusCode == http.StatusOK && r.size < 0:
+		return nil, false, fmt.Errorf("GET %v: %w", r.url, ErrNoRanges)
+	case resp.StatusCode == http.StatusOK:
+		return nil, false, fmt.Errorf("GET %v: file changed on the server", r.url)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		return nil, true, fmt.Errorf("GET %v: %v", r.url, resp.Status)
+	default:
+		return nil, false, fmt.Errorf("GET %v: %v", r.url, resp.Status)
+	}
+	var start, end, size int64
+	cr := resp.Header.Get("Content-Range")
+	if _, err = fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &size); err != nil || start != i*bs || end < start || end-start >= bs || end >= size {
+		return nil, false, fmt.Errorf("GET %v: bad Content-Range %q", r.url, cr)
+	}
+	if r.size < 0 {
+		r.size = size
+		if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+			r.etag = etag
+		}
+	} else if size != r.size {
+		return nil, false, fmt.Errorf("GET %v: file changed on the server", r.url)
+	}
+	b := make([]byte, end-start+1)
+	if _, err = io.ReadFull(resp.Body, b); err != nil {
+		return nil, true, err
+	}
+	return b, false, nil
+}
@@ -0,0 +1,286 @@
+// Package inspect describes patches without the old or new files: their
+// header fields, format, block sizes, number of control triples, metadata
+// and the digests recorded by bsdiff.WithHashes and
+// bsdiff.WithBlockChecksums.
+package inspect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Extension record keys, as written by bsdiff
+const (
+	extName      = "name"
+	extMode      = "mode"
+	extMtime     = "mtime"
+	extExec      = "exec"
+	extTar       = "tar"
+	extZip       = "zip"
+	extMeta      = "meta."
+	extSHA256Old = "sha256.old"
+	extSHA256New = "sha256.new"
+	extCRC       = "crc32c"
+)
+
+// blockNames are the names of the ctrl, diff and extra blocks
+var blockNames = [3]string{"ctrl", "diff", "extra"}
+
+// Info describes a patch
+type Info struct {
+	bspatch.Header
+	// Size is the size of the patch, -1 if unknown
+	Size int64
+	// Controls is the number of control triples, -1 for patches made with
+	// bsdiff.WithExecutable, bsdiff.WithTar, bsdiff.WithZip or the like, which
+	// can't be scanned without the old file
+	Controls int
+	// CtrlBytes, DiffBytes and ExtraBytes are the decompressed sizes of the
+	// blocks, -1 when Controls is
+	CtrlBytes, DiffBytes, ExtraBytes int64
+	// Seeks is the number of controls moving in the old file, BackSeeks of
+	// them backwards, and MaxSeek the longest move, in bytes
+	Seeks, BackSeeks int
+	MaxSeek          int64
+	// MaxDiff and MaxExtra are the longest diff and extra strings of a
+	// control, in bytes
+	MaxDiff, MaxExtra int64
+	// Name, Mode and ModTime are the file info recorded by
+	// bsdiff.WithFileInfo: empty, 0 and the zero time if absent
+	Name    string
+	Mode    fs.FileMode
+	ModTime time.Time
+	// OldSHA256 and NewSHA256 are the digests recorded by
+	// bsdiff.WithHashes, nil if absent
+	OldSHA256, NewSHA256 []byte
+	// CRC32C are the checksums of the compressed ctrl, diff and extra
+	// blocks recorded by bsdiff.WithBlockChecksums, nil if absent
+	CRC32C []uint32
+	// Metadata is the metadata recorded by bsdiff.WithMetadata
+	Metadata map[string]string
+}
+
+// needsOld reports whether the controls of a patch apply to a transform of
+// the old file, so can't be scanned without it
+func needsOld(h *bspatch.Header) bool {
+	_, exec := h.Records[extExec]
+	_, tar := h.Records[extTar]
+	_, zip := h.Records[extZip]
+	return exec || tar || zip
+}
+
+// Patch describes patch. Its blocks are decompressed to count the control
+// triples; opts configure how, e.g. with additional decompressors.
+func Patch(patch io.ReaderAt, opts ...bspatch.Option) (*Info, error) {
+	h, err := bspatch.ReadHeader(patch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Header: *h, Size: -1, Metadata: make(map[string]string)}
+	if s, ok := patch.(interface{ Size() int64 }); ok {
+		info.Size = s.Size()
+	}
+	info.records()
+	if needsOld(h) {
+		info.Controls = -1
+		info.CtrlBytes, info.DiffBytes, info.ExtraBytes = -1, -1, -1
+		return info, nil
+	}
+	err = bspatch.Scan(patch, func(c bspatch.Control) error {
+		info.Controls++
+		info.DiffBytes += int64(len(c.Diff))
+		info.ExtraBytes += int64(len(c.Extra))
+		if n := int64(len(c.Diff)); n > info.MaxDiff {
+			info.MaxDiff = n
+		}
+		if n := int64(len(c.Extra)); n > info.MaxExtra {
+			info.MaxExtra = n
+		}
+		if c.Seek != 0 {
+			info.Seeks++
+			seek := int64(c.Seek)
+			if seek < 0 {
+				info.BackSeeks++
+				seek = -seek
+			}
+			if seek > info.MaxSeek {
+				info.MaxSeek = seek
+			}
+		}
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	info.CtrlBytes = 24 * int64(info.Controls)
+	return info, nil
+}
+
+// File describes the patch in patchfile
+func File(patchfile string, opts ...bspatch.Option) (*Info, error) {
+	f, err := os.Open(patchfile)
+	if err != nil {
+		return nil, fmt.Errorf("could not open patchfile '%v': %w", patchfile, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat patchfile '%v': %w", patchfile, err)
+	}
+	info, err := Patch(f, opts...)
+	if err != nil {
+		return nil, err
+	}
+	info.Size = fi.Size()
+	return info, nil
+}
+
+// records decodes the well-known extension records
+func (info *Info) records() {
+	for k, v := range info.Records {
+		switch {
+		case k == extName:
+			info.Name = string(v)
+		case k == extMode && len(v) == 8:
+			info.Mode = fs.FileMode(binary.LittleEndian.Uint64(v)).Perm()
+		case k == extMtime && len(v) == 8:
+			info.ModTime = time.Unix(0, int64(binary.LittleEndian.Uint64(v)))
+		case k == extSHA256Old:
+			info.OldSHA256 = v
+		case k == extSHA256New:
+			info.NewSHA256 = v
+		case strings.HasPrefix(k, extMeta):
+			info.Metadata[strings.TrimPrefix(k, extMeta)] = string(v)
+		}
+	}
+	for i, name := range blockNames {
+		v, ok := info.Records[extCRC+"."+name]
+		if !ok || len(v) != 4 {
+			continue
+		}
+		if info.CRC32C == nil {
+			info.CRC32C = make([]uint32, 3)
+		}
+		info.CRC32C[i] = binary.LittleEndian.Uint32(v)
+	}
+}
+
+// WriteTo writes a human readable description of the patch to w
+func (info *Info) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 2, ' ', 0)
+	line := func(key, format string, args ...interface{}) {
+		fmt.Fprintf(tw, "%s:\t%s\n", key, fmt.Sprintf(format, args...))
+	}
+	line("format", "%v (magic %q)", formatName(info.Format), info.Magic)
+	if info.Size >= 0 {
+		line("patch size", "%v bytes", info.Size)
+	}
+	if info.NewSize >= 0 {
+		line("new size", "%v bytes", info.NewSize)
+	}
+	if info.Format != bspatch.FormatVCDIFF {
+		line("header", "%v bytes", info.BlockOffset)
+		sizes := [3]int64{info.CtrlSize, info.DiffSize, info.ExtraSize}
+		raw := [3]int64{info.CtrlBytes, info.DiffBytes, info.ExtraBytes}
+		for i, name := range blockNames {
+			if info.Format == bspatch.FormatEndsley && i > 0 {
+				line(name+" block", "in the ctrl block, %v", size(raw[i]))
+				continue
+			}
+			line(name+" block", "%v, %v compressed (%v)", size(raw[i]), size(sizes[i]), formatName(info.Codecs[i]))
+		}
+	}
+	if info.Controls >= 0 {
+		line("controls", "%v", info.Controls)
+		line("seeks", "%v (%v backwards), longest %v bytes", info.Seeks, info.BackSeeks, info.MaxSeek)
+		line("longest diff", "%v bytes", info.MaxDiff)
+		line("longest extra", "%v bytes", info.MaxExtra)
+	} else {
+		line("controls", "unknown (needs the old file)")
+	}
+	if info.Name != "" {
+		line("name", "%q", info.Name)
+	}
+	if info.Mode != 0 {
+		line("mode", "%v", info.Mode)
+	}
+	if !info.ModTime.IsZero() {
+		line("mtime", "%v", info.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	if info.OldSHA256 != nil {
+		line("old sha256", "%x", info.OldSHA256)
+	}
+	if info.NewSHA256 != nil {
+		line("new sha256", "%x", info.NewSHA256)
+	}
+	if info.CRC32C != nil {
+		line("crc32c", "ctrl %08x, diff %08x, extra %08x", info.CRC32C[0], info.CRC32C[1], info.CRC32C[2])
+	}
+	keys := make([]string, 0, len(info.Metadata))
+	for k := range info.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line("meta "+k, "%q", info.Metadata[k])
+	}
+	if err := tw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// formatNames are the names of the builtin formats
+var formatNames = map[bspatch.Format]string{
+	bspatch.FormatBzip2:   "bzip2",
+	bspatch.FormatZstd:    "zstd",
+	bspatch.FormatXz:      "xz",
+	bspatch.FormatRaw:     "raw",
+	bspatch.FormatBrotli:  "brotli",
+	bspatch.FormatEndsley: "endsley",
+	bspatch.FormatBSDF2:   "bsdf2",
+	bspatch.FormatVCDIFF:  "vcdiff",
+}
+
+// formatName returns the name of f, or its magic if it isn't builtin
+func formatName(f bspatch.Format) string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return string(f)
+}
+
+// size formats a size in bytes, -1 being unknown
+func size(n int64) string {
+	if n < 0 {
+		return "unknown size"
+	}
+	return fmt.Sprintf("%v bytes", n)
+}
+
+// countWriter counts the bytes written to w and keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,77 @@
+package inspect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/vcdiff"
+)
+
+func TestPatch(t *testing.T) {
+	w := testdata.SmallEdits(1 << 16)
+	var stats bsdiff.DiffStats
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithCompressor(bsdiff.Zstd), bsdiff.WithHashes(), bsdiff.WithBlockChecksums(),
+		bsdiff.WithMetadata(bspatch.MetaTargetVersion, "1.1.0"), bsdiff.WithStats(&stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Patch(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSum, newSum := sha256.Sum256(w.Old), sha256.Sum256(w.New)
+	switch {
+	case info.Format != bspatch.FormatZstd || info.Magic != "BSDIFF4X" || info.NewSize != int64(len(w.New)) || info.Size != int64(len(patch)):
+		t.Fatal("wrong header", info.Format, info.Magic, info.NewSize, info.Size)
+	case info.Controls != stats.Controls || info.CtrlBytes != 24*int64(stats.Controls) || info.DiffBytes != stats.Matched || info.ExtraBytes != stats.Extra:
+		t.Fatal("wrong controls", info.Controls, info.DiffBytes, info.ExtraBytes, stats)
+	case info.MaxDiff == 0 || info.MaxDiff > info.DiffBytes || info.MaxExtra > info.ExtraBytes || info.BackSeeks > info.Seeks || info.Seeks > info.Controls:
+		t.Fatal("wrong control summary", info.MaxDiff, info.MaxExtra, info.Seeks, info.BackSeeks, info.MaxSeek)
+	case info.CtrlSize != stats.CtrlSize || info.DiffSize != stats.DiffSize || info.ExtraSize != stats.ExtraSize:
+		t.Fatal("wrong block sizes", info.CtrlSize, info.DiffSize, info.ExtraSize, stats)
+	case info.BlockOffset+info.CtrlSize+info.DiffSize+info.ExtraSize != info.Size:
+		t.Fatal("blocks don't add up to the patch", info.BlockOffset, info.Size)
+	case !bytes.Equal(info.OldSHA256, oldSum[:]) || !bytes.Equal(info.NewSHA256, newSum[:]):
+		t.Fatal("wrong digests")
+	case len(info.CRC32C) != 3 || info.CRC32C[0] == 0:
+		t.Fatal("missing block checksums", info.CRC32C)
+	case info.Metadata[bspatch.MetaTargetVersion] != "1.1.0":
+		t.Fatal("wrong metadata", info.Metadata)
+	}
+	var out strings.Builder
+	if _, err = info.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"zstd", "BSDIFF4X", "target.version", "1.1.0", "seeks"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("%q missing from\n%v", s, out.String())
+		}
+	}
+
+	// File knows the size of the patch
+	name := filepath.Join(t.TempDir(), "patch")
+	if err = os.WriteFile(name, patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = File(name); err != nil || info.Size != int64(len(patch)) {
+		t.Fatal("wrong patch file info", info, err)
+	}
+
+	delta, err := vcdiff.Diff(w.Old, w.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err = Patch(bytes.NewReader(delta)); err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != bspatch.FormatVCDIFF || info.NewSize != -1 || info.DiffBytes+info.ExtraBytes != int64(len(w.New)) {
+		t.Fatal("wrong VCDIFF info", info.Format, info.NewSize, info.DiffBytes, info.ExtraBytes)
+	}
+}
@@ -0,0 +1,149 @@
+//go:build js && wasm
+
+// Package jsbsdiff exposes diffing and patching to JavaScript when built
+// with GOOS=js GOARCH=wasm, so browsers can update cached assets
+// client-side. Register sets an object of Promise-returning functions:
+//
+//	const patched = await bsdiff.patch(oldBytes, patchBytes)
+//	const patch = await bsdiff.diff(oldBytes, newBytes, {compressor: "zstd", hashes: true})
+//
+// Bytes are passed as Uint8Arrays or ArrayBuffers and returned as
+// Uint8Arrays. Errors reject the Promise with an Error. cmd/bsdiff-wasm is
+// a module registering it as the global bsdiff.
+package jsbsdiff
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// compressors are the compressors the diff options name
+var compressors = map[string]bsdiff.Compressor{
+	"bzip2":  bsdiff.Bzip2,
+	"zstd":   bsdiff.Zstd,
+	"xz":     bsdiff.Xz,
+	"brotli": bsdiff.Brotli,
+	"raw":    bsdiff.Raw,
+}
+
+// Register sets the object of the diff and patch functions as name on
+// target, e.g. js.Global(). Its functions stay valid for the life of the
+// program.
+func Register(target js.Value, name string) {
+	obj := js.Global().Get("Object").New()
+	obj.Set("diff", js.FuncOf(diff))
+	obj.Set("patch", js.FuncOf(patch))
+	target.Set(name, obj)
+}
+
+// diff(old, new, options) resolves to the patch from old to new. options
+// may set compressor (bzip2 by default, zstd, xz, brotli or raw) and
+// hashes, to record the SHA-256 of both files.
+func diff(this js.Value, args []js.Value) interface{} {
+	return promise(func() (js.Value, error) {
+		if len(args) < 2 {
+			return js.Undefined(), fmt.Errorf("diff needs the old and new files")
+		}
+		oldbs, err := bytesOf(args[0])
+		if err != nil {
+			return js.Undefined(), err
+		}
+		newbs, err := bytesOf(args[1])
+		if err != nil {
+			return js.Undefined(), err
+		}
+		var opts []bsdiff.Option
+		if len(args) > 2 && args[2].Type() == js.TypeObject {
+			if c := args[2].Get("compressor"); c.Type() == js.TypeString {
+				comp, ok := compressors[strings.ToLower(c.String())]
+				if !ok {
+					return js.Undefined(), fmt.Errorf("unknown compressor %q", c.String())
+				}
+				opts = append(opts, bsdiff.WithCompressor(comp))
+			}
+			if args[2].Get("hashes").Truthy() {
+				opts = append(opts, bsdiff.WithHashes())
+			}
+		}
+		p, err := bsdiff.Bytes(oldbs, newbs, opts...)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return toJS(p), nil
+	})
+}
+
+// patch(old, patch) resolves to the new file
+func patch(this js.Value, args []js.Value) interface{} {
+	return promise(func() (js.Value, error) {
+		if len(args) < 2 {
+			return js.Undefined(), fmt.Errorf("patch needs the old file and the patch")
+		}
+		oldbs, err := bytesOf(args[0])
+		if err != nil {
+			return js.Undefined(), err
+		}
+		p, err := bytesOf(args[1])
+		if err != nil {
+			return js.Undefined(), err
+		}
+		newbs, err := bspatch.Bytes(oldbs, p)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return toJS(newbs), nil
+	})
+}
+
+// promise returns a Promise of the result of fn, run on a goroutine so the
+// JavaScript event loop isn't blocked
+func promise(fn func() (js.Value, error)) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			var (
+				v   js.Value
+				err error
+			)
+			func() {
+				defer util.Recover(&err)
+				v, err = fn()
+			}()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(v)
+		}()
+		return nil
+	})
+	// The executor runs within the constructor
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// bytesOf copies a Uint8Array or ArrayBuffer
+func bytesOf(v js.Value) ([]byte, error) {
+	u8 := js.Global().Get("Uint8Array")
+	switch {
+	case v.InstanceOf(u8):
+	case v.InstanceOf(js.Global().Get("ArrayBuffer")):
+		v = u8.New(v)
+	default:
+		return nil, fmt.Errorf("expected a Uint8Array or an ArrayBuffer")
+	}
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+func toJS(b []byte) js.Value {
+	v := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(v, b)
+	return v
+}
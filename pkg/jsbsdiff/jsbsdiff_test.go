@@ -0,0 +1,64 @@
+//go:build js && wasm
+
+package jsbsdiff
+
+import (
+	"bytes"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+// await waits for a Promise, returning its value or the message of its
+// error
+func await(p js.Value) (js.Value, string) {
+	type result struct {
+		v   js.Value
+		err string
+	}
+	ch := make(chan result, 1)
+	then := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		ch <- result{v: args[0]}
+		return nil
+	})
+	defer then.Release()
+	catch := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		ch <- result{err: args[0].Get("message").String()}
+		return nil
+	})
+	defer catch.Release()
+	p.Call("then", then, catch)
+	r := <-ch
+	return r.v, r.err
+}
+
+func TestRegister(t *testing.T) {
+	target := js.Global().Get("Object").New()
+	Register(target, "bsdiff")
+	b := target.Get("bsdiff")
+	oldbs := []byte(strings.Repeat("the old asset, ", 200))
+	newbs := append([]byte("the new asset, "), oldbs...)
+
+	p, errmsg := await(b.Call("diff", toJS(oldbs), toJS(newbs), map[string]interface{}{"compressor": "zstd", "hashes": true}))
+	if errmsg != "" {
+		t.Fatal(errmsg)
+	}
+	// An ArrayBuffer works as well as a Uint8Array
+	got, errmsg := await(b.Call("patch", toJS(oldbs).Get("buffer"), p))
+	if errmsg != "" {
+		t.Fatal(errmsg)
+	}
+	if gotbs, _ := bytesOf(got); !bytes.Equal(gotbs, newbs) {
+		t.Fatal("patched the wrong file")
+	}
+
+	if _, errmsg = await(b.Call("patch", toJS(newbs), p)); errmsg == "" {
+		t.Error("patched the wrong old file")
+	}
+	if _, errmsg = await(b.Call("diff", toJS(oldbs), toJS(newbs), map[string]interface{}{"compressor": "lzma"})); !strings.Contains(errmsg, "lzma") {
+		t.Error("unknown compressor:", errmsg)
+	}
+	if _, errmsg = await(b.Call("patch", "old", p)); errmsg == "" {
+		t.Error("patched a string")
+	}
+}
@@ -0,0 +1,231 @@
+// Package merge combines two patches made independently from the same base
+// file, as when two teams change a configuration or data file that's
+// distributed as patches: if they change separate regions of the base, the
+// merged file has both changes; otherwise Merge reports where they
+// conflict.
+//
+// A patch is read as the edits it makes to the base: the bytes of the new
+// file its diff copies unchanged from the base, in order, anchor it, and
+// everything between the anchors replaces the base bytes between them.
+package merge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// ErrConflict is matched by the *ConflictError of patches that change the
+// same region of the base
+var ErrConflict = errors.New("patches conflict")
+
+// Edit replaces the base bytes from Start to End with Data. Start == End
+// inserts Data.
+type Edit struct {
+	Start, End int64
+	Data       []byte
+}
+
+// Conflict is a region of the base both patches change differently
+type Conflict struct {
+	// Start and End are the region of the base
+	Start, End int64
+	// Ours and Theirs are the edits of each patch in the region
+	Ours, Theirs []Edit
+}
+
+// ConflictError lists the conflicts of two patches, in the order of the
+// base
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	c := e.Conflicts[0]
+	if len(e.Conflicts) == 1 {
+		return fmt.Sprintf("%v at bytes %v to %v of the base", ErrConflict, c.Start, c.End)
+	}
+	return fmt.Sprintf("%v at bytes %v to %v of the base and %v more regions", ErrConflict, c.Start, c.End, len(e.Conflicts)-1)
+}
+
+// Is matches ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Edits returns the edits patch makes to base, in order. The patch must
+// apply to base, and match the digests it records, if any.
+func Edits(base, patch []byte, opts ...bspatch.Option) ([]Edit, error) {
+	if err := bspatch.Verify(bytes.NewReader(base), bytes.NewReader(patch), opts...); err != nil {
+		return nil, err
+	}
+	var (
+		edits []Edit
+		// next is the first base byte the next anchor can copy, pending
+		// the new bytes since the last anchor
+		next    int64
+		pending []byte
+		oldpos  int64
+	)
+	inBase := func(pos int64) bool { return pos >= 0 && pos < int64(len(base)) }
+	// anchor copies base[pos:pos+n] unchanged
+	anchor := func(pos, n int64) {
+		if pos < next {
+			// Out of order: the bytes are new to the edits
+			pending = append(pending, base[pos:pos+n]...)
+			return
+		}
+		if pos > next || len(pending) > 0 {
+			edits = append(edits, Edit{Start: next, End: pos, Data: pending})
+			pending = nil
+		}
+		next = pos + n
+	}
+	err := bspatch.Scan(bytes.NewReader(patch), func(c bspatch.Control) error {
+		for i := 0; i < len(c.Diff); {
+			// A run of zero diff bytes within the base is copied from it
+			j := i
+			for j < len(c.Diff) && c.Diff[j] == 0 && inBase(oldpos+int64(j)) {
+				j++
+			}
+			if j > i {
+				anchor(oldpos+int64(i), int64(j-i))
+				i = j
+				continue
+			}
+			// bspatch adds the diff bytes past the base to zeros
+			b := c.Diff[i]
+			if inBase(oldpos + int64(i)) {
+				b += base[oldpos+int64(i)]
+			}
+			pending = append(pending, b)
+			i++
+		}
+		pending = append(pending, c.Extra...)
+		oldpos += int64(len(c.Diff) + c.Seek)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if end := int64(len(base)); next < end || len(pending) > 0 {
+		edits = append(edits, Edit{Start: next, End: end, Data: pending})
+	}
+	return edits, nil
+}
+
+// Merge returns base with the edits of both patches, ours and theirs, or a
+// *ConflictError if they change the same region differently. Edits both
+// make are made once, and insertions at the same place as another edit
+// come first.
+func Merge(base, ours, theirs []byte, opts ...bspatch.Option) ([]byte, error) {
+	a, err := Edits(base, ours, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("ours: %w", err)
+	}
+	b, err := Edits(base, theirs, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("theirs: %w", err)
+	}
+	if conflicts := conflicts(a, b); len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
+	}
+	edits := append(append([]Edit(nil), a...), b...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].Start != edits[j].Start {
+			return edits[i].Start < edits[j].Start
+		}
+		return edits[i].End < edits[j].End
+	})
+	var out []byte
+	var pos int64
+	for i, e := range edits {
+		if i > 0 && equal(e, edits[i-1]) {
+			continue
+		}
+		out = append(out, base[pos:e.Start]...)
+		out = append(out, e.Data...)
+		pos = e.End
+	}
+	return append(out, base[pos:]...), nil
+}
+
+// Patch returns the patch from base to the merge of ours and theirs,
+// written with opts
+func Patch(base, ours, theirs []byte, opts ...bsdiff.Option) ([]byte, error) {
+	merged, err := Merge(base, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	return bsdiff.Bytes(base, merged, opts...)
+}
+
+func equal(a, b Edit) bool {
+	return a.Start == b.Start && a.End == b.End && bytes.Equal(a.Data, b.Data)
+}
+
+// conflict reports whether edits a and b, of different patches, conflict:
+// their regions overlap, or one inserts inside the other, or both insert
+// different bytes at the same place
+func conflict(a, b Edit) bool {
+	if equal(a, b) {
+		return false
+	}
+	switch {
+	case a.Start == a.End && b.Start == b.End:
+		return a.Start == b.Start
+	case a.Start == a.End:
+		return b.Start < a.Start && a.Start < b.End
+	case b.Start == b.End:
+		return a.Start < b.Start && b.Start < a.End
+	}
+	return a.Start < b.End && b.Start < a.End
+}
+
+// conflicts returns the conflicts of the edits a and b, each in order, with
+// the conflicting edits of a region grouped
+func conflicts(a, b []Edit) []Conflict {
+	var cs []Conflict
+	// add adds the conflict of a[i] and b[j], to the last one if it
+	// overlaps
+	add := func(i, j int) {
+		start, end := a[i].Start, a[i].End
+		if b[j].Start < start {
+			start = b[j].Start
+		}
+		if b[j].End > end {
+			end = b[j].End
+		}
+		if n := len(cs); n > 0 && start <= cs[n-1].End {
+			c := &cs[n-1]
+			if end > c.End {
+				c.End = end
+			}
+			if last := c.Ours[len(c.Ours)-1]; !equal(last, a[i]) {
+				c.Ours = append(c.Ours, a[i])
+			}
+			if last := c.Theirs[len(c.Theirs)-1]; !equal(last, b[j]) {
+				c.Theirs = append(c.Theirs, b[j])
+			}
+			return
+		}
+		cs = append(cs, Conflict{Start: start, End: end, Ours: []Edit{a[i]}, Theirs: []Edit{b[j]}})
+	}
+	// The edits of b starting before b[j] end before a[i] starts
+	j := 0
+	for i := range a {
+		for j < len(b) && b[j].End < a[i].Start {
+			j++
+		}
+		for k := j; k < len(b) && b[k].Start <= a[i].End; k++ {
+			if conflict(a[i], b[k]) {
+				add(i, k)
+			}
+		}
+	}
+	return cs
+}
@@ -0,0 +1,97 @@
+package merge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func config(n int, edits map[int]string) []byte {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		if s, ok := edits[i]; ok {
+			b.WriteString(s)
+			continue
+		}
+		fmt.Fprintf(&b, "setting.%03d = value of setting number %v\n", i, i)
+	}
+	return []byte(b.String())
+}
+
+func diff(t *testing.T, oldbs, newbs []byte) []byte {
+	patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithHashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestMerge(t *testing.T) {
+	base := config(100, nil)
+	ours := diff(t, base, config(100, map[int]string{10: "setting.010 = ours\n"}))
+	theirs := diff(t, base, config(100, map[int]string{
+		80: "setting.080 = theirs\nsetting.new = added by theirs\n",
+		10: "setting.010 = ours\n",
+	}))
+	merged, err := Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config(100, map[int]string{
+		10: "setting.010 = ours\n",
+		80: "setting.080 = theirs\nsetting.new = added by theirs\n",
+	})
+	if !bytes.Equal(merged, want) {
+		t.Fatalf("merged:\n%s", merged)
+	}
+	patch, err := Patch(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bspatch.Bytes(base, patch); err != nil || !bytes.Equal(got, want) {
+		t.Fatal("wrong merged patch", err)
+	}
+
+	conflicting := diff(t, base, config(100, map[int]string{10: "setting.010 = conflicting\n"}))
+	_, err = Merge(base, ours, conflicting)
+	var ce *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &ce) {
+		t.Fatal("expected a conflict, got", err)
+	}
+	line := int64(bytes.Index(base, []byte("setting.010")))
+	if len(ce.Conflicts) != 1 || ce.Conflicts[0].Start < line || ce.Conflicts[0].End > line+int64(len("setting.010 = value of setting number 10\n")) {
+		t.Fatal("wrong conflicts", ce.Conflicts)
+	}
+
+	if _, err = Merge(config(99, nil), ours, theirs); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected ErrWrongOld, got", err)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	x, y := []byte("x"), []byte("y")
+	for _, c := range []struct {
+		a, b Edit
+		want bool
+	}{
+		{Edit{0, 5, x}, Edit{5, 10, y}, false},
+		{Edit{0, 6, x}, Edit{5, 10, y}, true},
+		{Edit{5, 5, x}, Edit{5, 10, y}, false},
+		{Edit{7, 7, x}, Edit{5, 10, y}, true},
+		{Edit{5, 5, x}, Edit{5, 5, y}, true},
+		{Edit{5, 5, x}, Edit{5, 5, x}, false},
+		{Edit{2, 8, x}, Edit{2, 8, x}, false},
+	} {
+		if got := conflict(c.a, c.b); got != c.want {
+			t.Error(c.a, c.b, "conflict:", got)
+		}
+		if got := conflict(c.b, c.a); got != c.want {
+			t.Error(c.b, c.a, "conflict:", got)
+		}
+	}
+}
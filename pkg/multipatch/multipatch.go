@@ -0,0 +1,136 @@
+// Package multipatch makes patches that rebuild a new file from any of
+// several old versions, so one download serves an install base spread over
+// versions. A multi-source patch is a bundle (see pkg/bundle) of patches,
+// one per old version, named by the hex SHA-256 of their old file and
+// recording the digests of both files; Apply picks the patch of the old
+// file it's given by its digest.
+package multipatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/bundle"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Write writes the multi-source patch from each of olds to newbs to w.
+// Identical old versions share a patch.
+func Write(w io.Writer, olds [][]byte, newbs []byte, opts ...bsdiff.Option) error {
+	return WriteCtx(context.Background(), w, olds, newbs, opts...)
+}
+
+// WriteCtx is Write, stopping with ctx.Err() when ctx is cancelled
+func WriteCtx(ctx context.Context, w io.Writer, olds [][]byte, newbs []byte, opts ...bsdiff.Option) error {
+	// The digests let Apply pick the patch and check the new file
+	opts = append(append([]bsdiff.Option(nil), opts...), bsdiff.WithHashes())
+	bw := bundle.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, oldbs := range olds {
+		name := digest(oldbs)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		patch, err := bsdiff.BytesCtx(ctx, oldbs, newbs, opts...)
+		if err != nil {
+			return err
+		}
+		if err = bw.Add(name, patch); err != nil {
+			return err
+		}
+	}
+	return bw.Close()
+}
+
+// Base returns the entry of r patching the old file of SHA-256 sum, nil if
+// there's none
+func Base(r *bundle.Reader, sum []byte) *bundle.Entry {
+	return r.Lookup(hex.EncodeToString(sum))
+}
+
+// Apply applies the multi-source patch r to old, writing the new file to
+// out. It fails with bspatch.ErrWrongOld if old isn't one of the old
+// versions of r.
+func Apply(r *bundle.Reader, old io.ReaderAt, out io.Writer, opts ...bspatch.Option) error {
+	e, err := base(r, old)
+	if err != nil {
+		return err
+	}
+	return r.Apply(e, old, out, opts...)
+}
+
+// base returns the entry of r patching old
+func base(r *bundle.Reader, old io.ReaderAt) (*bundle.Entry, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(old, 0, 1<<62)); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	e := Base(r, sum)
+	if e == nil {
+		return nil, fmt.Errorf("%w (SHA-256 %x isn't one of the %v old versions of the patch)", bspatch.ErrWrongOld, sum, len(r.Entries))
+	}
+	return e, nil
+}
+
+// File writes the multi-source patch from each of oldfiles to newfile to
+// patchfile, through a temporary file renamed once complete
+func File(oldfiles []string, newfile, patchfile string, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	olds := make([][]byte, len(oldfiles))
+	for i, name := range oldfiles {
+		if olds[i], err = os.ReadFile(name); err != nil {
+			return fmt.Errorf("could not read oldfile '%v': %w", name, err)
+		}
+	}
+	newbs, err := os.ReadFile(newfile)
+	if err != nil {
+		return fmt.Errorf("could not read newfile '%v': %w", newfile, err)
+	}
+	return util.WriteFile(patchfile, func(f *os.File) error {
+		return Write(f, olds, newbs, opts...)
+	})
+}
+
+// ApplyFile applies the multi-source patchfile to oldfile, writing newfile
+// through a temporary file renamed once complete
+func ApplyFile(oldfile, newfile, patchfile string, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	pf, err := os.Open(patchfile)
+	if err != nil {
+		return fmt.Errorf("could not open patchfile '%v': %w", patchfile, err)
+	}
+	defer pf.Close()
+	fi, err := pf.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := bundle.NewReader(pf, fi.Size())
+	if err != nil {
+		return err
+	}
+	old, err := os.Open(oldfile)
+	if err != nil {
+		return fmt.Errorf("could not open oldfile '%v': %w", oldfile, err)
+	}
+	defer old.Close()
+	e, err := base(r, old)
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(newfile, func(f *os.File) error {
+		return r.Apply(e, old, f, opts...)
+	})
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
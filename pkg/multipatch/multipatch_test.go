@@ -0,0 +1,66 @@
+package multipatch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/bundle"
+)
+
+func TestMultipatch(t *testing.T) {
+	v1 := bytes.Repeat([]byte("version 1 "), 500)
+	v2 := append(bytes.Repeat([]byte("version 2 "), 500), "and more"...)
+	v3 := bytes.Repeat([]byte("version 3 "), 600)
+	var buf bytes.Buffer
+	if err := Write(&buf, [][]byte{v1, v2, v1}, v3); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	r, err := bundle.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Entries) != 2 {
+		t.Fatal("got", len(r.Entries), "patches, identical old versions not shared")
+	}
+	for _, old := range [][]byte{v1, v2} {
+		var out bytes.Buffer
+		if err = Apply(r, bytes.NewReader(old), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), v3) {
+			t.Fatal("wrong new file")
+		}
+	}
+	var out bytes.Buffer
+	if err = Apply(r, bytes.NewReader([]byte("version 0")), &out); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected ErrWrongOld, got", err)
+	}
+
+	dir := t.TempDir()
+	name := func(s string) string { return filepath.Join(dir, s) }
+	for s, b := range map[string][]byte{"v1": v1, "v2": v2, "v3": v3} {
+		if err = os.WriteFile(name(s), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = File([]string{name("v1"), name("v2")}, name("v3"), name("patch")); err != nil {
+		t.Fatal(err)
+	}
+	if err = ApplyFile(name("v2"), name("out"), name("patch")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(name("out")); !bytes.Equal(got, v3) {
+		t.Fatal("wrong new file")
+	}
+	if err = ApplyFile(name("v3"), name("out2"), name("patch")); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected ErrWrongOld, got", err)
+	}
+	if _, err = os.Stat(name("out2")); err == nil {
+		t.Fatal("new file written for a wrong old file")
+	}
+}
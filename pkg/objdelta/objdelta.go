@@ -0,0 +1,218 @@
+// Package objdelta diffs and patches objects in a cloud object store, such
+// as S3 or GCS, without keeping them on local disk: Diff reads both objects
+// with ranged reads into a windowed diff, and Apply patches an object while
+// it uploads the new one in parts.
+//
+// The package doesn't depend on any cloud SDK. A Store adapts one, mapping
+// the object URLs it's given (s3://bucket/key, gs://bucket/key or whatever
+// it understands) to ranged GETs and multipart uploads; HTTP is the Store
+// of objects readable over plain HTTP, such as presigned or public URLs.
+package objdelta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/httpdelta"
+)
+
+// Store reads and writes the objects of an object store
+type Store interface {
+	// Open returns the object at url
+	Open(ctx context.Context, url string) (Object, error)
+	// Create starts a multipart upload of the object at url
+	Create(ctx context.Context, url string) (Upload, error)
+}
+
+// Object is an object read with ranged reads
+type Object interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// Upload is a multipart upload
+type Upload interface {
+	// Part uploads the part number n, from 1. data is reused once Part
+	// returns.
+	Part(ctx context.Context, n int, data []byte) error
+	// Complete makes the object of the parts uploaded
+	Complete(ctx context.Context) error
+	// Abort cancels the upload
+	Abort(ctx context.Context) error
+}
+
+// ErrReadOnly is returned by the Create of a Store that can't upload
+var ErrReadOnly = errors.New("store is read-only")
+
+// DefaultPartSize is the size of the parts Apply uploads, unless
+// WithPartSize sets another. S3 requires parts of at least 5 MiB, but for
+// the last.
+const DefaultPartSize = 8 << 20
+
+// Option configures Diff or Apply
+type Option func(*options)
+
+type options struct {
+	partSize  int
+	index     *bsdiff.Index
+	diffOpts  []bsdiff.Option
+	patchOpts []bspatch.Option
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{partSize: DefaultPartSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPartSize sets the size of the parts Apply uploads
+func WithPartSize(n int) Option {
+	return func(o *options) {
+		o.partSize = n
+	}
+}
+
+// WithIndex diffs against x, a stored index of the old object, which Diff
+// then doesn't read
+func WithIndex(x *bsdiff.Index) Option {
+	return func(o *options) {
+		o.index = x
+	}
+}
+
+// WithDiffOptions sets the options Diff makes the patch with. Without
+// WithIndex, the diff is windowed: see bsdiff.WithWindow.
+func WithDiffOptions(opts ...bsdiff.Option) Option {
+	return func(o *options) {
+		o.diffOpts = opts
+	}
+}
+
+// WithPatchOptions sets the options Apply applies the patch with
+func WithPatchOptions(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.patchOpts = opts
+	}
+}
+
+// Diff writes the patch from the object at oldURL to that at newURL. The
+// new object is read sequentially and the old one around the same offsets,
+// as bsdiff.Stream does, or, with WithIndex, the new object is read into
+// memory and the old one not at all.
+func Diff(ctx context.Context, s Store, oldURL, newURL string, patch io.WriteSeeker, opts ...Option) error {
+	o := newOptions(opts)
+	newobj, err := s.Open(ctx, newURL)
+	if err != nil {
+		return fmt.Errorf("could not open %v: %w", newURL, err)
+	}
+	newr := io.NewSectionReader(newobj, 0, newobj.Size())
+	if o.index != nil {
+		newbs, err := io.ReadAll(newr)
+		if err != nil {
+			return fmt.Errorf("could not read %v: %w", newURL, err)
+		}
+		return o.index.Write(newbs, patch)
+	}
+	oldobj, err := s.Open(ctx, oldURL)
+	if err != nil {
+		return fmt.Errorf("could not open %v: %w", oldURL, err)
+	}
+	return bsdiff.StreamCtx(ctx, oldobj, newr, patch, o.diffOpts...)
+}
+
+// Apply applies patch to the object at oldURL and uploads the new object to
+// newURL as it's made, a part at a time, so only the part being uploaded is
+// held in memory. The upload is aborted on any error.
+func Apply(ctx context.Context, s Store, oldURL string, patch io.Reader, newURL string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	oldobj, err := s.Open(ctx, oldURL)
+	if err != nil {
+		return fmt.Errorf("could not open %v: %w", oldURL, err)
+	}
+	up, err := s.Create(ctx, newURL)
+	if err != nil {
+		return fmt.Errorf("could not upload %v: %w", newURL, err)
+	}
+	defer func() {
+		if err != nil {
+			up.Abort(context.Background())
+		}
+	}()
+	w := &partWriter{ctx: ctx, up: up, size: o.partSize}
+	if err = bspatch.ApplyStream(oldobj, patch, w, o.patchOpts...); err != nil {
+		return err
+	}
+	if err = w.flush(); err != nil {
+		return err
+	}
+	if err = up.Complete(ctx); err != nil {
+		return fmt.Errorf("could not upload %v: %w", newURL, err)
+	}
+	return nil
+}
+
+// partWriter uploads what's written in parts of size bytes
+type partWriter struct {
+	ctx  context.Context
+	up   Upload
+	size int
+	buf  []byte
+	n    int
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.size)
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		written += c
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush uploads the part buffered. An empty object is one empty part.
+func (w *partWriter) flush() error {
+	if len(w.buf) == 0 && w.n > 0 {
+		return nil
+	}
+	w.n++
+	if err := w.up.Part(w.ctx, w.n, w.buf); err != nil {
+		return fmt.Errorf("could not upload part %d: %w", w.n, err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// HTTP returns the Store of objects readable over HTTP with Range
+// requests, such as presigned or public S3 and GCS URLs, read with
+// httpdelta.OpenRemote and opts. It can't upload.
+func HTTP(opts ...httpdelta.Option) Store {
+	return httpStore{opts}
+}
+
+type httpStore struct {
+	opts []httpdelta.Option
+}
+
+func (s httpStore) Open(ctx context.Context, url string) (Object, error) {
+	return httpdelta.OpenRemote(ctx, url, s.opts...)
+}
+
+func (s httpStore) Create(ctx context.Context, url string) (Upload, error) {
+	return nil, ErrReadOnly
+}
@@ -0,0 +1,149 @@
+package objdelta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// memStore is a Store in memory
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// parts are the sizes of the parts uploaded
+	parts   []int
+	aborted int
+	// failPart fails the upload of that part
+	failPart int
+}
+
+func (s *memStore) Open(ctx context.Context, url string) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[url]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return bytes.NewReader(b), nil
+}
+
+func (s *memStore) Create(ctx context.Context, url string) (Upload, error) {
+	return &memUpload{s: s, url: url}, nil
+}
+
+type memUpload struct {
+	s     *memStore
+	url   string
+	parts [][]byte
+}
+
+func (u *memUpload) Part(ctx context.Context, n int, data []byte) error {
+	if n != len(u.parts)+1 {
+		return errors.New("parts out of order")
+	}
+	if n == u.s.failPart {
+		return errors.New("network down")
+	}
+	u.parts = append(u.parts, append([]byte(nil), data...))
+	u.s.parts = append(u.s.parts, len(data))
+	return nil
+}
+
+func (u *memUpload) Complete(ctx context.Context) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+	u.s.objects[u.url] = bytes.Join(u.parts, nil)
+	return nil
+}
+
+func (u *memUpload) Abort(ctx context.Context) error {
+	u.s.aborted++
+	return nil
+}
+
+func TestObjDelta(t *testing.T) {
+	oldbs := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(oldbs)
+	newbs := append([]byte(nil), oldbs[:200<<10]...)
+	copy(newbs[1000:], "version 2")
+	newbs = append(newbs, oldbs[100<<10:]...)
+	s := &memStore{objects: map[string][]byte{"s3://b/v1": oldbs, "s3://b/v2": newbs}}
+	ctx := context.Background()
+
+	var patch util.BufWriter
+	if err := Diff(ctx, s, "s3://b/v1", "s3://b/v2", &patch, WithDiffOptions(bsdiff.WithWindow(64<<10), bsdiff.WithHashes())); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bspatch.Bytes(oldbs, patch.Bytes()); err != nil || !bytes.Equal(got, newbs) {
+		t.Fatal("streamed diff doesn't apply", err)
+	}
+	x, err := bsdiff.NewIndex(oldbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ipatch util.BufWriter
+	if err = Diff(ctx, s, "s3://b/gone", "s3://b/v2", &ipatch, WithIndex(x)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bspatch.Bytes(oldbs, ipatch.Bytes()); err != nil || !bytes.Equal(got, newbs) {
+		t.Fatal("indexed diff doesn't apply", err)
+	}
+
+	if err = Apply(ctx, s, "s3://b/v1", bytes.NewReader(patch.Bytes()), "s3://b/v2b", WithPartSize(64<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.objects["s3://b/v2b"], newbs) {
+		t.Fatal("uploaded the wrong object")
+	}
+	for i, n := range s.parts {
+		if n != 64<<10 && i != len(s.parts)-1 || n == 0 {
+			t.Fatal("part sizes:", s.parts)
+		}
+	}
+
+	s.failPart = 2
+	err = Apply(ctx, s, "s3://b/v1", bytes.NewReader(patch.Bytes()), "s3://b/v2c", WithPartSize(64<<10))
+	if err == nil || s.aborted != 1 || s.objects["s3://b/v2c"] != nil {
+		t.Fatal("failed upload:", err, s.aborted)
+	}
+	if err = Apply(ctx, s, "s3://b/v2", bytes.NewReader(patch.Bytes()), "s3://b/v2c"); !errors.Is(err, bspatch.ErrWrongOld) || s.aborted != 2 {
+		t.Fatal("wrong old:", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	oldbs := make([]byte, 100<<10)
+	rand.New(rand.NewSource(2)).Read(oldbs)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(oldbs))
+	}))
+	defer srv.Close()
+	newbs := append([]byte("header"), oldbs...)
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := HTTP()
+	old, err := s.Open(context.Background(), srv.URL+"/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = bspatch.Apply(old, bytes.NewReader(patch), &out); err != nil || !bytes.Equal(out.Bytes(), newbs) {
+		t.Fatal("patched the wrong file", err)
+	}
+	if _, err = s.Create(context.Background(), srv.URL+"/v2"); !errors.Is(err, ErrReadOnly) {
+		t.Fatal(err)
+	}
+}
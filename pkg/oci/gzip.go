@@ -0,0 +1,95 @@
+package oci
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/zipfile"
+)
+
+// Gzip header flags (RFC 1952)
+const (
+	gzipHCRC    = 1 << 1
+	gzipExtra   = 1 << 2
+	gzipName    = 1 << 3
+	gzipComment = 1 << 4
+)
+
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// splitGzip returns the header of the single member gzip file b, its
+// contents, and the level compress/flate deflates them back to the exact
+// deflate stream of b at. ok is false if b isn't such a file.
+func splitGzip(b []byte) (header, data []byte, level int, ok bool) {
+	if len(b) < 18 || !isGzip(b) || b[2] != 8 || b[3]&0xe0 != 0 {
+		return nil, nil, 0, false
+	}
+	flags, n := b[3], 10
+	if flags&gzipExtra != 0 {
+		n += 2 + int(binary.LittleEndian.Uint16(b[n:]))
+	}
+	for _, f := range []byte{gzipName, gzipComment} {
+		if flags&f == 0 || n >= len(b) {
+			continue
+		}
+		i := bytes.IndexByte(b[n:], 0)
+		if i < 0 {
+			return nil, nil, 0, false
+		}
+		n += i + 1
+	}
+	if flags&gzipHCRC != 0 {
+		n += 2
+	}
+	if n > len(b)-8 {
+		return nil, nil, 0, false
+	}
+	// A bytes.Reader is an io.ByteReader, so flate reads no further than
+	// the end of the stream
+	r := bytes.NewReader(b[n:])
+	data, err := io.ReadAll(flate.NewReader(r))
+	if err != nil || r.Len() != 8 {
+		return nil, nil, 0, false
+	}
+	trailer := b[len(b)-8:]
+	if binary.LittleEndian.Uint32(trailer) != crc32.ChecksumIEEE(data) || binary.LittleEndian.Uint32(trailer[4:]) != uint32(len(data)) {
+		return nil, nil, 0, false
+	}
+	if level, ok = zipfile.Level(data, b[n:len(b)-8]); !ok {
+		return nil, nil, 0, false
+	}
+	return b[:n], data, level, true
+}
+
+// joinGzip compresses data at level into a gzip file with header, undoing
+// splitGzip
+func joinGzip(header, data []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte(nil), header...))
+	fw, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	b := binary.LittleEndian.AppendUint32(buf.Bytes(), crc32.ChecksumIEEE(data))
+	return binary.LittleEndian.AppendUint32(b, uint32(len(data))), nil
+}
+
+// gunzip decompresses the gzip file b, of any number of members
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,195 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Media types of the indexes and image manifests walked, OCI and Docker
+var (
+	indexTypes = map[string]bool{
+		"application/vnd.oci.image.index.v1+json":                   true,
+		"application/vnd.docker.distribution.manifest.list.v2+json": true,
+	}
+	manifestTypes = map[string]bool{
+		"application/vnd.oci.image.manifest.v1+json":           true,
+		"application/vnd.docker.distribution.manifest.v2+json": true,
+	}
+)
+
+// maxDepth bounds the nesting of indexes
+const maxDepth = 8
+
+// descriptor is an OCI content descriptor
+type descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *platform `json:"platform,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// manifest is the layers of an image manifest, with the key pairing it with
+// the manifest of the other image: its platform, or its position in the
+// indexes
+type manifest struct {
+	key    string
+	layers []descriptor
+}
+
+// image is the blobs of an OCI image layout reachable from its index.json
+type image struct {
+	index     []byte
+	blobs     []descriptor
+	has       map[string]bool
+	manifests []manifest
+}
+
+// readImage walks the indexes and manifests of the OCI image layout fsys
+func readImage(fsys fs.FS) (*image, error) {
+	b, err := fs.ReadFile(fsys, "oci-layout")
+	if err != nil {
+		return nil, fmt.Errorf("not an OCI image layout: %w", err)
+	}
+	var layout struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err = json.Unmarshal(b, &layout); err != nil || layout.Version != "1.0.0" {
+		return nil, fmt.Errorf("unsupported OCI image layout %q", b)
+	}
+	img := &image{has: make(map[string]bool)}
+	if img.index, err = fs.ReadFile(fsys, "index.json"); err != nil {
+		return nil, err
+	}
+	if err = img.walk(fsys, img.index, "", 0); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// walk adds the blobs the index b refers to, and those they refer to
+func (img *image) walk(fsys fs.FS, b []byte, key string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("indexes nested too deep")
+	}
+	var index struct {
+		Manifests []descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("bad index: %w", err)
+	}
+	for i, d := range index.Manifests {
+		k := key + "/" + strconv.Itoa(i)
+		if p := d.Platform; p != nil {
+			k = path.Join(p.OS, p.Architecture, p.Variant)
+		}
+		if !img.add(d) || !indexTypes[d.MediaType] && !manifestTypes[d.MediaType] {
+			continue
+		}
+		b, err := readBlob(fsys, d)
+		if err != nil {
+			return err
+		}
+		if indexTypes[d.MediaType] {
+			if err = img.walk(fsys, b, k, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		var m struct {
+			Config descriptor   `json:"config"`
+			Layers []descriptor `json:"layers"`
+		}
+		if err = json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("bad manifest %v: %w", d.Digest, err)
+		}
+		img.add(m.Config)
+		for _, l := range m.Layers {
+			img.add(l)
+		}
+		img.manifests = append(img.manifests, manifest{key: k, layers: m.Layers})
+	}
+	return nil
+}
+
+// add adds the blob of d, reporting whether it's new
+func (img *image) add(d descriptor) bool {
+	if img.has[d.Digest] {
+		return false
+	}
+	img.has[d.Digest] = true
+	img.blobs = append(img.blobs, d)
+	return true
+}
+
+// bases pairs the layers of img that old doesn't have with the layers at the
+// same position of the corresponding manifests of old: those of the same key,
+// or the only manifests of both images
+func (img *image) bases(old *image) map[string]descriptor {
+	oldManifests := make(map[string]manifest)
+	for _, m := range old.manifests {
+		oldManifests[m.key] = m
+	}
+	bases := make(map[string]descriptor)
+	for _, m := range img.manifests {
+		om, ok := oldManifests[m.key]
+		if !ok && len(img.manifests) == 1 && len(old.manifests) == 1 {
+			om, ok = old.manifests[0], true
+		}
+		if !ok {
+			continue
+		}
+		for i, l := range m.layers {
+			if !old.has[l.Digest] && i < len(om.layers) {
+				bases[l.Digest] = om.layers[i]
+			}
+		}
+	}
+	return bases
+}
+
+// blobPath returns the path of the blob of digest in a layout
+func blobPath(digest string) (string, error) {
+	h, ok := strings.CutPrefix(digest, "sha256:")
+	if b, err := hex.DecodeString(h); !ok || err != nil || len(b) != sha256.Size || strings.ToLower(h) != h {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return "blobs/sha256/" + h, nil
+}
+
+// readBlob reads the blob of d from the layout fsys, checking its size and
+// digest
+func readBlob(fsys fs.FS, d descriptor) ([]byte, error) {
+	name, err := blobPath(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob: %w", err)
+	}
+	if err = checkBlob(b, d); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// checkBlob checks the size and digest of the blob b of d
+func checkBlob(b []byte, d descriptor) error {
+	sum := sha256.Sum256(b)
+	if int64(len(b)) != d.Size || d.Digest != "sha256:"+hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("blob %v has %v bytes and SHA-256 %x, expected %v bytes", d.Digest, len(b), sum, d.Size)
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+// Package oci diffs two container images in OCI image layouts, the
+// directories skopeo, crane, buildah and docker save write, into a delta
+// that rebuilds the new image from the old one where bandwidth is scarce:
+// the blobs of the new image the old one has are kept, each other layer is
+// patched from the layer at the same position of the corresponding old
+// image manifest, and the rest, such as manifests and configs, is included
+// whole.
+//
+// Layers are diffed decompressed and entry by entry, as bsdiff.WithTar
+// does. Patching must give back the exact bytes of each new layer, which its
+// digest names, so only gzip layers compress/gzip compresses back exactly,
+// in practice those written by Go, are decompressed; other layers are
+// diffed as they are, or included whole if that's smaller.
+package oci
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Delta is
+//
+//	0	8	"BSOCIDL1"
+//	8	??	uvarint length of the index.json of the new image, index.json
+//	??	??	uvarint number of blobs of the new image
+//	??	??	blobs
+//
+// with for each blob an op byte, the uvarint length of its digest and the
+// digest, its uvarint size, and then for opAdd the uvarint length of the
+// blob and the blob, or for opPatch the uvarint length of the digest of the
+// old layer, the digest and its uvarint size, a flags byte, for flagGzip
+// the uvarint length of the gzip header, the header and the varint
+// compression level, and the uvarint length of the bsdiff patch and the
+// patch.
+
+// Magic starts every delta
+const Magic = "BSOCIDL1"
+
+// layout is the oci-layout file of the new image
+const layout = `{"imageLayoutVersion":"1.0.0"}`
+
+// Ops making the blobs of the new image
+const (
+	// opKeep copies a blob of the old image
+	opKeep = 'K'
+	// opAdd includes a blob whole
+	opAdd = 'A'
+	// opPatch patches a layer of the old image
+	opPatch = 'P'
+)
+
+// Flags of opPatch blobs
+const (
+	// flagInflate marks patches of the decompressed old gzip layer
+	flagInflate = 1 << 0
+	// flagGzip marks patches making the decompressed new layer, compressed
+	// again after patching
+	flagGzip = 1 << 1
+)
+
+// DiffFS writes the delta between the OCI image layouts oldfs and newfs to
+// delta, with opts for diffing the layers
+func DiffFS(oldfs, newfs fs.FS, delta io.Writer, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	oldImg, err := readImage(oldfs)
+	if err != nil {
+		return fmt.Errorf("old image: %w", err)
+	}
+	newImg, err := readImage(newfs)
+	if err != nil {
+		return fmt.Errorf("new image: %w", err)
+	}
+	bases := newImg.bases(oldImg)
+	opts = append(opts[:len(opts):len(opts)], bsdiff.WithTar())
+	w := bufio.NewWriter(delta)
+	w.WriteString(Magic)
+	writeBytes(w, newImg.index)
+	writeUvarint(w, uint64(len(newImg.blobs)))
+	for _, d := range newImg.blobs {
+		if _, err = blobPath(d.Digest); err != nil {
+			return err
+		}
+		if oldImg.has[d.Digest] {
+			writeHeader(w, opKeep, d)
+			continue
+		}
+		newbs, err := readBlob(newfs, d)
+		if err != nil {
+			return err
+		}
+		if base, ok := bases[d.Digest]; ok {
+			oldbs, err := readBlob(oldfs, base)
+			if err != nil {
+				return err
+			}
+			p, err := diffLayer(oldbs, newbs, opts)
+			if err != nil {
+				return fmt.Errorf("could not diff layer %v: %w", d.Digest, err)
+			}
+			if len(p.patch) < len(newbs) {
+				writeHeader(w, opPatch, d)
+				writeBytes(w, []byte(base.Digest))
+				writeUvarint(w, uint64(base.Size))
+				w.WriteByte(p.flags)
+				if p.flags&flagGzip != 0 {
+					writeBytes(w, p.header)
+					w.Write(binary.AppendVarint(nil, int64(p.level)))
+				}
+				writeBytes(w, p.patch)
+				continue
+			}
+		}
+		writeHeader(w, opAdd, d)
+		writeBytes(w, newbs)
+	}
+	return w.Flush()
+}
+
+// Diff writes the delta between the OCI image layouts olddir and newdir to
+// deltafile, through a temporary file renamed once complete
+func Diff(olddir, newdir, deltafile string, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	return util.WriteFile(deltafile, func(f *os.File) error {
+		return DiffFS(os.DirFS(olddir), os.DirFS(newdir), f, opts...)
+	})
+}
+
+// layerPatch is the patch of a layer, and how to compress it again
+type layerPatch struct {
+	flags  byte
+	header []byte
+	level  int
+	patch  []byte
+}
+
+// diffLayer diffs the layers oldbs and newbs, decompressed if newbs can be
+// compressed back exactly
+func diffLayer(oldbs, newbs []byte, opts []bsdiff.Option) (*layerPatch, error) {
+	p := &layerPatch{}
+	newData := newbs
+	if header, data, level, ok := splitGzip(newbs); ok {
+		p.flags |= flagGzip
+		p.header, p.level, newData = header, level, data
+	}
+	oldData := oldbs
+	if isGzip(oldbs) && (p.flags&flagGzip != 0 || !isGzip(newbs)) {
+		if data, err := gunzip(oldbs); err == nil {
+			p.flags |= flagInflate
+			oldData = data
+		}
+	}
+	patch, err := bsdiff.Bytes(oldData, newData, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.patch = patch
+	return p, nil
+}
+
+// ApplyFS applies delta to the OCI image layout oldfs, making the layout of
+// the new image in the directory newdir, with opts for patching the layers.
+// newdir must not exist: the layout is made in a temporary directory renamed
+// once complete. It fails with bspatch.ErrWrongOld if a blob of oldfs isn't
+// the one the delta was made from, and bspatch.ErrCorruptPatch if the delta
+// is malformed.
+func ApplyFS(oldfs fs.FS, delta io.Reader, newdir string, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	newdir = filepath.Clean(newdir)
+	if _, err := os.Lstat(newdir); err == nil {
+		return fmt.Errorf("newdir '%v' already exists", newdir)
+	}
+	r := bufio.NewReader(delta)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != Magic {
+		return corrupt("bad magic")
+	}
+	index, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(index) {
+		return corrupt("bad index.json")
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return corrupt("truncated")
+	}
+	tmp, err := util.MkdirTemp(newdir)
+	if err != nil {
+		return fmt.Errorf("could not create newdir '%v': %w", newdir, err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	if err = os.MkdirAll(filepath.Join(tmp, "blobs", "sha256"), 0755); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(tmp, "oci-layout"), []byte(layout), 0644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(tmp, "index.json"), index, 0644); err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if err = applyBlob(oldfs, r, tmp, opts); err != nil {
+			return err
+		}
+	}
+	if _, rerr := r.ReadByte(); rerr != io.EOF {
+		return corrupt("trailing data")
+	}
+	return os.Rename(tmp, newdir)
+}
+
+// Apply applies deltafile to the OCI image layout olddir, making the layout
+// newdir, as ApplyFS
+func Apply(olddir, deltafile, newdir string, opts ...bspatch.Option) (err error) {
+	defer util.Recover(&err)
+	f, err := os.Open(deltafile)
+	if err != nil {
+		return fmt.Errorf("could not open deltafile '%v': %w", deltafile, err)
+	}
+	defer f.Close()
+	return ApplyFS(os.DirFS(olddir), f, newdir, opts...)
+}
+
+// applyBlob reads a blob of the delta from r and writes it to the layout dir
+func applyBlob(oldfs fs.FS, r *bufio.Reader, dir string, opts []bspatch.Option) error {
+	op, err := r.ReadByte()
+	if err != nil {
+		return corrupt("truncated")
+	}
+	d, err := readDescriptor(r)
+	if err != nil {
+		return err
+	}
+	name, _ := blobPath(d.Digest)
+	name = filepath.Join(dir, filepath.FromSlash(name))
+	var newbs []byte
+	switch op {
+	case opKeep:
+		return copyBlob(oldfs, d, name)
+	case opAdd:
+		if newbs, err = readBytes(r); err != nil {
+			return err
+		}
+	case opPatch:
+		if newbs, err = patchLayer(oldfs, r, opts); err != nil {
+			return fmt.Errorf("could not patch layer %v: %w", d.Digest, err)
+		}
+	default:
+		return corrupt(fmt.Sprintf("unknown op %q", op))
+	}
+	if err = checkBlob(newbs, d); err != nil {
+		return corrupt(err.Error())
+	}
+	return writeBlob(name, func(w io.Writer) error {
+		_, err := w.Write(newbs)
+		return err
+	})
+}
+
+// patchLayer reads the patch of a layer from r and applies it
+func patchLayer(oldfs fs.FS, r *bufio.Reader, opts []bspatch.Option) ([]byte, error) {
+	base, err := readDescriptor(r)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := r.ReadByte()
+	if err != nil || flags&^(flagInflate|flagGzip) != 0 {
+		return nil, corrupt("bad flags")
+	}
+	var header []byte
+	var level int64
+	if flags&flagGzip != 0 {
+		if header, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if level, err = binary.ReadVarint(r); err != nil {
+			return nil, corrupt("truncated")
+		}
+	}
+	patch, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	oldbs, err := readOld(oldfs, base)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagInflate != 0 {
+		if oldbs, err = gunzip(oldbs); err != nil {
+			return nil, corrupt("old layer isn't gzip compressed")
+		}
+	}
+	newbs, err := bspatch.Bytes(oldbs, patch, opts...)
+	if err != nil || flags&flagGzip == 0 {
+		return newbs, err
+	}
+	if newbs, err = joinGzip(header, newbs, int(level)); err != nil {
+		return nil, corrupt(err.Error())
+	}
+	return newbs, nil
+}
+
+// readOld reads the blob of d from oldfs, failing with bspatch.ErrWrongOld
+// unless its size and digest match
+func readOld(oldfs fs.FS, d descriptor) ([]byte, error) {
+	name, _ := blobPath(d.Digest)
+	b, err := fs.ReadFile(oldfs, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read old blob: %w", err)
+	}
+	if err = checkBlob(b, d); err != nil {
+		return nil, fmt.Errorf("%w (%v)", bspatch.ErrWrongOld, err)
+	}
+	return b, nil
+}
+
+// copyBlob copies the blob of d from oldfs to the file name, failing with
+// bspatch.ErrWrongOld unless its size and digest match
+func copyBlob(oldfs fs.FS, d descriptor, name string) error {
+	path, _ := blobPath(d.Digest)
+	f, err := oldfs.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read old blob: %w", err)
+	}
+	defer f.Close()
+	return writeBlob(name, func(w io.Writer) error {
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, h), f)
+		if err != nil {
+			return err
+		}
+		if sum := h.Sum(nil); n != d.Size || d.Digest != "sha256:"+hex.EncodeToString(sum) {
+			return fmt.Errorf("%w (blob %v has %v bytes and SHA-256 %x)", bspatch.ErrWrongOld, d.Digest, n, sum)
+		}
+		return nil
+	})
+}
+
+// writeBlob makes the file name, which mustn't exist, writing it with fn
+func writeBlob(name string, fn func(w io.Writer) error) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return corrupt("duplicate blob")
+	}
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeHeader(w *bufio.Writer, op byte, d descriptor) {
+	w.WriteByte(op)
+	writeBytes(w, []byte(d.Digest))
+	writeUvarint(w, uint64(d.Size))
+}
+
+// readDescriptor reads the digest and size of a blob
+func readDescriptor(r *bufio.Reader) (descriptor, error) {
+	digest, err := readBytes(r)
+	if err != nil {
+		return descriptor{}, err
+	}
+	d := descriptor{Digest: string(digest)}
+	if _, err = blobPath(d.Digest); err != nil {
+		return d, corrupt(err.Error())
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > 1<<62 {
+		return d, corrupt("bad size")
+	}
+	d.Size = int64(size)
+	return d, nil
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	w.Write(binary.AppendUvarint(nil, v))
+}
+
+func writeBytes(w *bufio.Writer, b []byte) {
+	writeUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+// readBytes reads a uvarint length and as many bytes from r, growing the
+// buffer as they arrive so a bad length can't allocate much
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > 1<<62 {
+		return nil, corrupt("truncated")
+	}
+	var b bytes.Buffer
+	if _, err = io.CopyN(&b, r, int64(n)); err != nil {
+		return nil, corrupt("truncated")
+	}
+	return b.Bytes(), nil
+}
+
+// corrupt returns an error wrapping bspatch.ErrCorruptPatch for a delta
+// malformed as described by msg
+func corrupt(msg string) error {
+	return fmt.Errorf("%w (delta %v)", bspatch.ErrCorruptPatch, msg)
+}
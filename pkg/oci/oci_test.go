@@ -0,0 +1,196 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// makeTar returns a tar archive of files named by their index
+func makeTar(t *testing.T, files [][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("app/file%03d", i), Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeLayout returns an OCI image layout of an image with layers
+func makeLayout(t *testing.T, layers ...[]byte) fstest.MapFS {
+	fsys := fstest.MapFS{"oci-layout": {Data: []byte(layout)}}
+	add := func(mediaType string, b []byte) descriptor {
+		sum := sha256.Sum256(b)
+		fsys["blobs/sha256/"+hex.EncodeToString(sum[:])] = &fstest.MapFile{Data: b}
+		return descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(b))}
+	}
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        add("application/vnd.oci.image.config.v1+json", []byte(fmt.Sprintf(`{"os":"linux","layers":%v}`, len(layers)))),
+	}
+	var descs []descriptor
+	for _, l := range layers {
+		mediaType := "application/vnd.oci.image.layer.v1.tar"
+		if isGzip(l) {
+			mediaType += "+gzip"
+		}
+		descs = append(descs, add(mediaType, l))
+	}
+	m["layers"] = descs
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := add("application/vnd.oci.image.manifest.v1+json", b)
+	md.Platform = &platform{Architecture: "amd64", OS: "linux"}
+	index, err := json.Marshal(map[string]interface{}{"schemaVersion": 2, "manifests": []descriptor{md}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["index.json"] = &fstest.MapFile{Data: index}
+	return fsys
+}
+
+func TestOCI(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var files [][]byte
+	for i := 0; i < 200; i++ {
+		b := make([]byte, 1000+rng.Intn(1000))
+		rng.Read(b)
+		files = append(files, b)
+	}
+	edited := append([][]byte(nil), files...)
+	edited[42] = append([]byte("version 2"), edited[42][9:]...)
+	edited = append(edited[100:], edited[:100]...)
+	base := gzipped(t, makeTar(t, [][]byte{[]byte("base layer")}))
+	oldApp := makeTar(t, files)
+	newApp := makeTar(t, edited)
+	extra := gzipped(t, makeTar(t, [][]byte{[]byte("a new layer")}))
+	oldfs := makeLayout(t, base, gzipped(t, oldApp))
+
+	half := len(newApp) / 2
+	for name, layer := range map[string][]byte{
+		"gzip":         gzipped(t, newApp),
+		"uncompressed": newApp,
+		// Not compressed back exactly
+		"multi-member gzip": append(gzipped(t, newApp[:half]), gzipped(t, newApp[half:])...),
+	} {
+		newfs := makeLayout(t, base, layer, extra)
+		var delta bytes.Buffer
+		if err := DiffFS(oldfs, newfs, &delta); err != nil {
+			t.Fatal(name, err)
+		}
+		if name != "multi-member gzip" && delta.Len() > len(layer)/10 {
+			t.Fatalf("%v: delta of %v bytes for a layer of %v bytes", name, delta.Len(), len(layer))
+		}
+		dir := filepath.Join(t.TempDir(), "new")
+		if err := ApplyFS(oldfs, bytes.NewReader(delta.Bytes()), dir); err != nil {
+			t.Fatal(name, err)
+		}
+		got := 0
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			b, err := os.ReadFile(path)
+			if want, ok := newfs[filepath.ToSlash(rel)]; err != nil || !ok || !bytes.Equal(b, want.Data) {
+				t.Fatalf("%v: %v differs (%v)", name, rel, err)
+			}
+			got++
+			return nil
+		})
+		if err != nil || got != len(newfs) {
+			t.Fatalf("%v: got %v files, expected %v (%v)", name, got, len(newfs), err)
+		}
+	}
+
+	newfs := makeLayout(t, base, gzipped(t, newApp))
+	var delta bytes.Buffer
+	if err := DiffFS(oldfs, newfs, &delta); err != nil {
+		t.Fatal(err)
+	}
+	// Modified old blobs, patched and kept
+	for digest, f := range oldfs {
+		if filepath.Dir(digest) != "blobs/sha256" || !isGzip(f.Data) {
+			continue
+		}
+		modified := fstest.MapFS{}
+		for k, v := range oldfs {
+			modified[k] = v
+		}
+		modified[digest] = &fstest.MapFile{Data: gzipped(t, []byte("modified"))}
+		err := ApplyFS(modified, bytes.NewReader(delta.Bytes()), filepath.Join(t.TempDir(), "new"))
+		if !errors.Is(err, bspatch.ErrWrongOld) {
+			t.Fatalf("%v: expected a wrong old blob error, got %v", digest, err)
+		}
+	}
+	// Corrupt deltas
+	b := delta.Bytes()
+	for _, p := range [][]byte{nil, []byte("BSOCIDL0"), b[:len(b)-1], append(b[:len(b):len(b)], 0)} {
+		dir := filepath.Join(t.TempDir(), "new")
+		if err := ApplyFS(oldfs, bytes.NewReader(p), dir); !errors.Is(err, bspatch.ErrCorruptPatch) {
+			t.Fatalf("expected a corrupt delta error, got %v", err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatal("new layout left behind")
+		}
+	}
+	if err := DiffFS(fstest.MapFS{}, newfs, &delta); err == nil {
+		t.Fatal("expected an error diffing a directory that isn't a layout")
+	}
+}
+
+func TestGzip(t *testing.T) {
+	data := bytes.Repeat([]byte("gzip me "), 1000)
+	for _, level := range []int{gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression} {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, level)
+		zw.Name, zw.Comment, zw.Extra = "name", "comment", []byte("extra")
+		zw.Write(data)
+		zw.Close()
+		header, got, l, ok := splitGzip(buf.Bytes())
+		if !ok || !bytes.Equal(got, data) {
+			t.Fatal(level, "not split")
+		}
+		b, err := joinGzip(header, got, l)
+		if err != nil || !bytes.Equal(b, buf.Bytes()) {
+			t.Fatal(level, "not joined back", err)
+		}
+	}
+	for _, b := range [][]byte{nil, []byte("\x1f\x8b"), data} {
+		if _, _, _, ok := splitGzip(b); ok {
+			t.Fatalf("%q split", b)
+		}
+	}
+}
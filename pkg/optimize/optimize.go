@@ -0,0 +1,252 @@
+// Package optimize shrinks existing patches without their old and new
+// files. It decodes a patch and writes it again with the best compression
+// of its codecs, or another compressor, merges the control triples that
+// only continue the previous one and leaves out the streams of empty
+// blocks (see bsdiff.WithStripEmptyBlocks). The new file the patch makes is
+// the same, and the digests, file info and metadata it records are kept.
+//
+// Patches are read with bspatch.Scan, so patches of executables and
+// archives, which transform the files they're made from, aren't supported,
+// nor are VCDIFF deltas.
+package optimize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// DefaultMergeLimit is the size up to which control triples are merged,
+// unless WithMergeLimit sets another
+const DefaultMergeLimit = 64 << 10
+
+// Option configures an optimization
+type Option func(*options)
+
+type options struct {
+	diffOpts   []bsdiff.Option
+	patchOpts  []bspatch.Option
+	mergeLimit int
+	stats      *Stats
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{mergeLimit: DefaultMergeLimit}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithDiffOptions sets options the patch is written with, after those
+// keeping its format and codecs, e.g. bsdiff.WithCompressor to recompress
+// it with another codec
+func WithDiffOptions(opts ...bsdiff.Option) Option {
+	return func(o *options) {
+		o.diffOpts = opts
+	}
+}
+
+// WithPatchOptions sets the options the patch is read with, e.g. the
+// dictionaries of its blocks
+func WithPatchOptions(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.patchOpts = opts
+	}
+}
+
+// WithMergeLimit merges control triples into ones of up to n new bytes. 0
+// doesn't merge them.
+func WithMergeLimit(n int) Option {
+	return func(o *options) {
+		o.mergeLimit = n
+	}
+}
+
+// Stats describes an optimization
+type Stats struct {
+	// Controls and Merged are the number of control triples of the patch,
+	// and of those merged into the previous one
+	Controls, Merged int
+	// Size and OptimizedSize are the sizes of the patch before and after
+	Size, OptimizedSize int64
+}
+
+// WithStats fills s with the statistics of the optimization
+func WithStats(s *Stats) Option {
+	return func(o *options) {
+		o.stats = s
+	}
+}
+
+// Bytes returns patch optimized
+func Bytes(patch []byte, opts ...Option) ([]byte, error) {
+	var buf util.BufWriter
+	if err := optimize(bytes.NewReader(patch), int64(len(patch)), &buf, newOptions(opts)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Reader writes the patch of size bytes read from src, optimized, to dst
+func Reader(src io.ReaderAt, size int64, dst io.WriteSeeker, opts ...Option) error {
+	return optimize(src, size, dst, newOptions(opts))
+}
+
+// File writes the patch in srcfile, optimized, to dstfile, through a
+// temporary file renamed once complete, so dstfile can be srcfile
+func File(srcfile, dstfile string, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	src, err := os.Open(srcfile)
+	if err != nil {
+		return fmt.Errorf("could not open patchfile '%v': %w", srcfile, err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(dstfile, func(f *os.File) error {
+		return optimize(src, fi.Size(), f, newOptions(opts))
+	})
+}
+
+// compressors are the best compressors of the codecs of patches
+var compressors = map[bspatch.Format]bsdiff.Compressor{
+	bspatch.FormatBzip2:  bsdiff.Bzip2,
+	bspatch.FormatZstd:   bsdiff.Zstd,
+	bspatch.FormatXz:     bsdiff.Xz,
+	bspatch.FormatBrotli: bsdiff.Brotli,
+	bspatch.FormatRaw:    bsdiff.Raw,
+}
+
+// diffOptions returns the options writing a patch like that of h
+func diffOptions(h *bspatch.Header) ([]bsdiff.Option, error) {
+	var opts []bsdiff.Option
+	switch h.Format {
+	case bspatch.FormatVCDIFF:
+		return nil, fmt.Errorf("%w (VCDIFF deltas can't be optimized)", bspatch.ErrUnsupportedFormat)
+	case bspatch.FormatEndsley:
+		return append(opts, bsdiff.WithFormat(bsdiff.FormatEndsley)), nil
+	case bspatch.FormatBSDF2:
+		opts = append(opts, bsdiff.WithFormat(bsdiff.FormatBSDF2))
+	}
+	var comps [3]bsdiff.Compressor
+	for i, f := range h.Codecs {
+		c, ok := compressors[f]
+		if !ok {
+			return nil, fmt.Errorf("%w (unknown codec %q)", bspatch.ErrUnsupportedFormat, f)
+		}
+		comps[i] = c
+	}
+	opts = append(opts, bsdiff.WithBlockCompressors(comps[0], comps[1], comps[2]), bsdiff.WithRecords(h.Records))
+	for k := range h.Records {
+		if strings.HasPrefix(k, "crc32c.") {
+			// Checksummed patches stay so
+			opts = append(opts, bsdiff.WithBlockChecksums())
+			break
+		}
+	}
+	return opts, nil
+}
+
+func optimize(src io.ReaderAt, size int64, dst io.WriteSeeker, o *options) (err error) {
+	defer util.Recover(&err)
+	h, err := bspatch.ReadHeader(src, o.patchOpts...)
+	if err != nil {
+		return err
+	}
+	dopts, err := diffOptions(h)
+	if err != nil {
+		return err
+	}
+	dopts = append(append(dopts, bsdiff.WithStripEmptyBlocks()), o.diffOpts...)
+	start, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	w, err := bsdiff.NewWriter(dst, dopts...)
+	if err != nil {
+		return err
+	}
+	var s Stats
+	m := &merger{w: w, limit: o.mergeLimit}
+	err = bspatch.Scan(src, func(c bspatch.Control) error {
+		s.Controls++
+		if m.merge(c) {
+			s.Merged++
+			return nil
+		}
+		return m.flush(c)
+	}, o.patchOpts...)
+	if err == nil {
+		err = m.flush(bspatch.Control{})
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if o.stats != nil {
+		end, err := dst.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		s.Size, s.OptimizedSize = size, end-start
+		*o.stats = s
+	}
+	return nil
+}
+
+// merger holds the last control triple, merging those that continue it
+type merger struct {
+	w     *bsdiff.Writer
+	limit int
+	// diff, extra and seek are the pending control, if ok
+	diff, extra []byte
+	seek        int
+	ok          bool
+}
+
+// merge merges c into the pending control if the two make one: a control
+// without extra bytes or seek continues with the diff of the next, and one
+// without diff bytes only adds extra bytes and a seek
+func (m *merger) merge(c bspatch.Control) bool {
+	if !m.ok || m.limit <= 0 || len(m.diff)+len(m.extra)+len(c.Diff)+len(c.Extra) > m.limit {
+		return false
+	}
+	switch {
+	case len(m.extra) == 0 && m.seek == 0:
+		m.diff = append(m.diff, c.Diff...)
+		m.extra = append(m.extra, c.Extra...)
+		m.seek = c.Seek
+	case len(c.Diff) == 0:
+		m.extra = append(m.extra, c.Extra...)
+		m.seek += c.Seek
+	default:
+		return false
+	}
+	return true
+}
+
+// flush writes the pending control and makes c pending. c's bytes are
+// copied, as those of bspatch.Scan are only valid during its call.
+func (m *merger) flush(c bspatch.Control) error {
+	if m.ok {
+		if err := m.w.WriteControl(m.diff, m.extra, m.seek); err != nil {
+			return err
+		}
+	}
+	m.diff = append(m.diff[:0], c.Diff...)
+	m.extra = append(m.extra[:0], c.Extra...)
+	m.seek, m.ok = c.Seek, true
+	return nil
+}
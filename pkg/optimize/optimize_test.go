@@ -0,0 +1,151 @@
+package optimize
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// fragmented writes the patch from oldbs to newbs with every control split
+// in small ones, like patches of naive encoders
+func fragmented(t *testing.T, oldbs, newbs []byte, opts ...bsdiff.Option) []byte {
+	patch, err := bsdiff.Bytes(oldbs, newbs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := bspatch.ReadHeader(bytes.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf util.BufWriter
+	w, err := bsdiff.NewWriter(&buf, bsdiff.WithRecords(h.Records), bsdiff.WithCompressor(bsdiff.NewBzip2(bsdiff.Bzip2Config{Level: 1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bspatch.Scan(bytes.NewReader(patch), func(c bspatch.Control) error {
+		for len(c.Diff) > 16 {
+			if err := w.WriteControl(c.Diff[:16], nil, 0); err != nil {
+				return err
+			}
+			c.Diff = c.Diff[16:]
+		}
+		if err := w.WriteControl(c.Diff, nil, 0); err != nil {
+			return err
+		}
+		for len(c.Extra) > 16 {
+			if err := w.WriteControl(nil, c.Extra[:16], 0); err != nil {
+				return err
+			}
+			c.Extra = c.Extra[16:]
+		}
+		return w.WriteControl(nil, c.Extra, c.Seek)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOptimize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	oldbs := make([]byte, 1<<15)
+	rng.Read(oldbs)
+	newbs := append([]byte(nil), oldbs...)
+	for i := 0; i < 40; i++ {
+		newbs[rng.Intn(len(newbs))]++
+	}
+	newbs = append(newbs[:20000], append([]byte("inserted bytes, more than sixteen"), newbs[20000:]...)...)
+
+	patch := fragmented(t, oldbs, newbs, bsdiff.WithHashes(), bsdiff.WithMetadata("tool", "test"))
+	var s Stats
+	opt, err := Bytes(patch, WithStats(&s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opt) >= len(patch) || s.Merged == 0 || s.Size != int64(len(patch)) || s.OptimizedSize != int64(len(opt)) {
+		t.Fatal("not optimized:", len(patch), "to", len(opt), s)
+	}
+	got, err := bspatch.Bytes(oldbs, opt, bspatch.WithRequireNewSHA256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newbs) {
+		t.Fatal("wrong new file")
+	}
+	meta, err := bspatch.Metadata(bytes.NewReader(opt))
+	if err != nil || meta["tool"] != "test" {
+		t.Fatal("metadata not kept", meta, err)
+	}
+	if err = bspatch.Verify(bytes.NewReader(newbs), bytes.NewReader(opt)); err == nil {
+		t.Fatal("old file digest not kept")
+	}
+
+	// A patch without extra bytes has its extra block stripped
+	same := append([]byte(nil), oldbs...)
+	same[100]++
+	patch, err = bsdiff.Bytes(oldbs, same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, err = Bytes(patch, WithDiffOptions(bsdiff.WithCompressor(bsdiff.Zstd)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := bspatch.ReadHeader(bytes.NewReader(opt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ExtraSize != 0 || h.Codecs[0] != bspatch.FormatZstd {
+		t.Fatal("extra block of", h.ExtraSize, "bytes, codec", h.Codecs[0])
+	}
+	for _, stream := range []bool{false, true} {
+		var got []byte
+		if stream {
+			var out bytes.Buffer
+			err = bspatch.ApplyStream(bytes.NewReader(oldbs), bytes.NewReader(opt), &out, bspatch.WithStrict())
+			got = out.Bytes()
+		} else {
+			got, err = bspatch.Bytes(oldbs, opt, bspatch.WithStrict())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, same) {
+			t.Fatal("wrong new file")
+		}
+	}
+
+	for _, f := range []bsdiff.Format{bsdiff.FormatEndsley, bsdiff.FormatBSDF2} {
+		patch, err := bsdiff.Bytes(oldbs, newbs, bsdiff.WithFormat(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opt, err = Bytes(patch); err != nil {
+			t.Fatal(f, err)
+		}
+		if got, err := bspatch.Bytes(oldbs, opt); err != nil || !bytes.Equal(got, newbs) {
+			t.Fatal(f, "wrong new file", err)
+		}
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "patch")
+	if err = os.WriteFile(name, patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = File(name, name); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(name); len(b) >= len(patch) {
+		t.Fatal("patch file not optimized")
+	}
+}
@@ -0,0 +1,233 @@
+// Package patchstore keeps patches by the SHA-256 of the files they're
+// between and the format they're made in, so services that make the same
+// patches, like httpdelta.Handler and deltarpc.Server, share them instead of
+// diffing again. Dir is a Store on local disk that evicts the least
+// recently used patches beyond a size, and those older than a TTL.
+package patchstore
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key names a patch
+type Key struct {
+	// Old and New are the SHA-256 of the old and new files, in lower case
+	// hex
+	Old, New string
+	// Format tells patches between the same files apart, e.g. by the
+	// compressor and options they're made with
+	Format string
+}
+
+// Store holds patches by key. It must be safe for concurrent use.
+type Store interface {
+	// Get returns the patch of k, or false if there's none
+	Get(k Key) ([]byte, bool)
+	// Put stores patch as that of k
+	Put(k Key, patch []byte) error
+}
+
+// DefaultMaxSize is the size of a Dir, unless WithMaxSize sets another
+const DefaultMaxSize = 1 << 30
+
+// Option configures a Dir
+type Option func(*options)
+
+type options struct {
+	maxSize int64
+	ttl     time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{maxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxSize bounds the total size of the patches a Dir keeps
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithTTL evicts patches stored more than d ago. 0, the default, keeps
+// them until the Dir is full.
+func WithTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// Dir is a Store in a directory, with each patch in a file named by the
+// SHA-256 of its key. Its index of the patches is in memory, rebuilt by
+// Open, so only one Dir should use a directory at a time.
+type Dir struct {
+	dir string
+	o   *options
+
+	mu   sync.Mutex
+	used int64
+	// order has the most recently used patch in front
+	order list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+// Open returns the Dir of dir, creating the directory if needed
+func Open(dir string, opts ...Option) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &Dir{dir: dir, o: newOptions(opts), items: make(map[string]*list.Element)}
+	var entries []*entry
+	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(de.Name(), ".") {
+			// Left over by a Put that didn't finish
+			os.Remove(path)
+			return nil
+		}
+		if len(de.Name()) != 2*sha256.Size {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &entry{name: de.Name(), size: fi.Size(), created: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read patch store '%v': %w", dir, err)
+	}
+	// Without access times, the newest patches count as the most recently
+	// used
+	sort.Slice(entries, func(i, j int) bool { return entries[i].created.Before(entries[j].created) })
+	for _, e := range entries {
+		d.items[e.name] = d.order.PushFront(e)
+		d.used += e.size
+	}
+	d.mu.Lock()
+	d.evict()
+	d.mu.Unlock()
+	return d, nil
+}
+
+func name(k Key) string {
+	sum := sha256.Sum256([]byte(k.Old + "\x00" + k.New + "\x00" + k.Format))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Dir) path(name string) string {
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+// Get returns the patch of k, or false if there's none or it expired
+func (d *Dir) Get(k Key) ([]byte, bool) {
+	n := name(k)
+	d.mu.Lock()
+	e, ok := d.items[n]
+	if ok && d.expired(e.Value.(*entry)) {
+		d.remove(e)
+		ok = false
+	}
+	if ok {
+		d.order.MoveToFront(e)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	b, err := os.ReadFile(d.path(n))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Put stores patch as that of k, evicting patches to make room. A patch
+// larger than the Dir isn't stored.
+func (d *Dir) Put(k Key, patch []byte) (err error) {
+	if int64(len(patch)) > d.o.maxSize {
+		return nil
+	}
+	n := name(k)
+	if err = os.MkdirAll(filepath.Dir(d.path(n)), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.path(n)), "."+n+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpname := tmp.Name()
+	_, err = tmp.Write(patch)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpname, d.path(n))
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return fmt.Errorf("could not store patch: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.items[n]; ok {
+		d.used -= e.Value.(*entry).size
+		d.order.Remove(e)
+	}
+	d.items[n] = d.order.PushFront(&entry{name: n, size: int64(len(patch)), created: time.Now()})
+	d.used += int64(len(patch))
+	d.evict()
+	return nil
+}
+
+// evict removes expired patches, then the least recently used ones until
+// the rest fit
+func (d *Dir) evict() {
+	if d.o.ttl > 0 {
+		for e := d.order.Front(); e != nil; {
+			next := e.Next()
+			if d.expired(e.Value.(*entry)) {
+				d.remove(e)
+			}
+			e = next
+		}
+	}
+	for d.used > d.o.maxSize {
+		d.remove(d.order.Back())
+	}
+}
+
+func (d *Dir) expired(e *entry) bool {
+	return d.o.ttl > 0 && time.Since(e.created) > d.o.ttl
+}
+
+func (d *Dir) remove(e *list.Element) {
+	ent := e.Value.(*entry)
+	d.order.Remove(e)
+	delete(d.items, ent.name)
+	d.used -= ent.size
+	os.Remove(d.path(ent.name))
+}
@@ -0,0 +1,72 @@
+package patchstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, WithMaxSize(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := func(i byte) Key { return Key{Old: "old", New: string('a' + i), Format: "bsdiff4"} }
+	patch := func(i byte) []byte { return bytes.Repeat([]byte{i}, 100) }
+	for i := byte(0); i < 3; i++ {
+		if err = d.Put(k(i), patch(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := d.Get(Key{Old: "old", New: "a", Format: "bsdiff4+zstd"}); ok {
+		t.Fatal("formats mixed up")
+	}
+	// 0 is now the most recently used, and 1 is evicted for 3
+	if b, ok := d.Get(k(0)); !ok || !bytes.Equal(b, patch(0)) {
+		t.Fatal("missing 0")
+	}
+	if err = d.Put(k(3), patch(3)); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, true, true} {
+		if _, ok := d.Get(k(byte(i))); ok != want {
+			t.Errorf("%d: got %v, want %v", i, ok, want)
+		}
+	}
+	if err = d.Put(k(4), make([]byte, 301)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Get(k(4)); ok {
+		t.Error("stored a patch larger than the store")
+	}
+
+	// Reopened, the index comes from the files, the oldest of which expires
+	if err = os.MkdirAll(filepath.Join(dir, "aa"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "aa", ".left.tmp1"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(d.path(name(k(0))), old, old); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open(dir, WithMaxSize(300), WithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{false, false, true, true} {
+		if _, ok := d.Get(k(byte(i))); ok != want {
+			t.Errorf("reopened %d: got %v, want %v", i, ok, want)
+		}
+	}
+	if _, err = os.Stat(d.path(name(k(0)))); !os.IsNotExist(err) {
+		t.Error("expired patch left on disk")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "aa", ".left.tmp1")); !os.IsNotExist(err) {
+		t.Error("temporary file left")
+	}
+}
@@ -0,0 +1,183 @@
+// Package plan finds the cheapest chain of patches from the version a
+// client has to the one it wants, among the patches available between
+// versions: a client three versions behind may download less with two
+// small patches than with the one patch from its version, or have no
+// direct patch at all. The chain is applied with bspatch.Chain.
+package plan
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// ErrNoPath is the error of Path when no chain of patches leads from a
+// version to the other
+var ErrNoPath = errors.New("no chain of patches between the versions")
+
+// Patch is a patch between two versions
+type Patch struct {
+	// From and To name the old and new versions, e.g. by their SHA-256
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Size is what the patch costs, usually its size in bytes
+	Size int64 `json:"size"`
+	// Name locates the patch, e.g. by its file name or URL
+	Name string `json:"name,omitempty"`
+}
+
+// Graph holds the patches available between versions. It isn't safe for
+// concurrent use while patches are added.
+type Graph struct {
+	from map[string][]Patch
+}
+
+// New returns the graph of patches
+func New(patches ...Patch) *Graph {
+	g := &Graph{from: make(map[string][]Patch)}
+	for _, p := range patches {
+		g.Add(p)
+	}
+	return g
+}
+
+// Add adds p to the patches of g
+func (g *Graph) Add(p Patch) {
+	g.from[p.From] = append(g.from[p.From], p)
+}
+
+// Len returns the number of patches of g
+func (g *Graph) Len() int {
+	n := 0
+	for _, ps := range g.from {
+		n += len(ps)
+	}
+	return n
+}
+
+// Path returns the chain of patches of least total size from the version
+// from to the version to, in the order they apply, preferring fewer
+// patches between chains of the same size. It's empty if from is to, and
+// fails with ErrNoPath if no chain leads there.
+func (g *Graph) Path(from, to string) ([]Patch, error) {
+	if from == to {
+		return nil, nil
+	}
+	// Dijkstra's algorithm over the versions, by size then patches
+	best := map[string]*node{from: {version: from}}
+	q := &queue{best[from]}
+	for q.Len() > 0 {
+		n := heap.Pop(q).(*node)
+		if n.done {
+			continue
+		}
+		n.done = true
+		if n.version == to {
+			path := make([]Patch, n.patches)
+			for ; n.via != nil; n = best[n.via.From] {
+				path[n.patches-1] = *n.via
+			}
+			return path, nil
+		}
+		for i := range g.from[n.version] {
+			p := &g.from[n.version][i]
+			next := &node{version: p.To, size: n.size + p.Size, patches: n.patches + 1, via: p}
+			if b, ok := best[p.To]; ok && (b.done || !next.less(b)) {
+				continue
+			}
+			best[p.To] = next
+			heap.Push(q, next)
+		}
+	}
+	return nil, fmt.Errorf("%w %v and %v", ErrNoPath, from, to)
+}
+
+// Size returns the total size of the patches of path
+func Size(path []Patch) int64 {
+	var n int64
+	for _, p := range path {
+		n += p.Size
+	}
+	return n
+}
+
+type node struct {
+	version string
+	size    int64
+	patches int
+	// via is the last patch of the chain to version
+	via  *Patch
+	done bool
+}
+
+func (n *node) less(o *node) bool {
+	if n.size != o.size {
+		return n.size < o.size
+	}
+	return n.patches < o.patches
+}
+
+type queue []*node
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].less(q[j]) }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*node)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// Read returns the patch of size bytes, named name, between the versions
+// whose SHA-256 it records, as bsdiff.WithHashes does, in lower case hex.
+// It fails with bspatch.ErrNoNewSHA256 if the patch records no digests.
+func Read(name string, patch io.ReaderAt, size int64) (Patch, error) {
+	h, err := bspatch.ReadHeader(patch)
+	if err != nil {
+		return Patch{}, err
+	}
+	oldsum, newsum := h.Records["sha256.old"], h.Records["sha256.new"]
+	if len(oldsum) == 0 || len(newsum) == 0 {
+		return Patch{}, fmt.Errorf("%v: %w", name, bspatch.ErrNoNewSHA256)
+	}
+	return Patch{From: hex.EncodeToString(oldsum), To: hex.EncodeToString(newsum), Size: size, Name: name}, nil
+}
+
+// Files returns the graph of the patch files names, each between the
+// versions whose SHA-256 it records, costing its size
+func Files(names []string) (*Graph, error) {
+	g := New()
+	for _, name := range names {
+		p, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		g.Add(p)
+	}
+	// Files given in any order plan the same
+	for _, ps := range g.from {
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+	}
+	return g, nil
+}
+
+func readFile(name string) (Patch, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Patch{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Patch{}, err
+	}
+	return Read(name, f, fi.Size())
+}
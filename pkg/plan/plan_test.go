@@ -0,0 +1,94 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+func TestPath(t *testing.T) {
+	g := New(
+		Patch{From: "v1", To: "v4", Size: 900},
+		Patch{From: "v1", To: "v2", Size: 100},
+		Patch{From: "v2", To: "v3", Size: 200},
+		Patch{From: "v3", To: "v4", Size: 300},
+		Patch{From: "v2", To: "v4", Size: 500},
+		Patch{From: "v3", To: "v2", Size: 1},
+		Patch{From: "v4", To: "v5", Size: 50},
+	)
+	for _, c := range []struct {
+		from, to string
+		want     []string
+		size     int64
+	}{
+		{"v1", "v4", []string{"v1", "v2", "v4"}, 600},
+		{"v1", "v5", []string{"v1", "v2", "v4", "v5"}, 650},
+		{"v3", "v4", []string{"v3", "v4"}, 300},
+		{"v2", "v2", []string{"v2"}, 0},
+	} {
+		path, err := g.Path(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{c.from}
+		for _, p := range path {
+			got = append(got, p.To)
+		}
+		if !reflect.DeepEqual(got, c.want) || Size(path) != c.size {
+			t.Fatal(c.from, "to", c.to, "got", got, Size(path), "expected", c.want, c.size)
+		}
+	}
+	if _, err := g.Path("v5", "v1"); !errors.Is(err, ErrNoPath) {
+		t.Fatal("expected ErrNoPath, got", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	versions := [][]byte{
+		bytes.Repeat([]byte("version 1 "), 1000),
+		bytes.Repeat([]byte("version 2 "), 1000),
+		bytes.Repeat([]byte("version 3 "), 1000),
+	}
+	var names []string
+	for _, p := range [][2]int{{0, 1}, {1, 2}, {0, 2}} {
+		patch, err := bsdiff.Bytes(versions[p[0]], versions[p[1]], bsdiff.WithHashes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, string(rune('a'+len(names))))
+		if err = os.WriteFile(name, patch, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	g, err := Files(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() != 3 {
+		t.Fatal("got", g.Len(), "patches")
+	}
+	a, _ := readFile(names[0])
+	c, _ := readFile(names[2])
+	path, err := g.Path(a.From, c.To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 1 || path[0].Name != names[2] {
+		t.Fatal("expected the direct patch, got", path)
+	}
+
+	plain, err := bsdiff.Bytes(versions[0], versions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Read("plain", bytes.NewReader(plain), int64(len(plain))); err == nil {
+		t.Fatal("expected an error for a patch without digests")
+	}
+}
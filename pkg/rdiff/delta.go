@@ -0,0 +1,224 @@
+package rdiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Delta commands. Integer parameters are big endian, 1, 2, 4 or 8 bytes
+// wide depending on the opcode.
+const (
+	opEnd = 0x00
+	// 0x01 to 0x40 are literals of that many bytes
+	opLiteral   = 0x41 // + width code, followed by the length
+	opCopy      = 0x45 // + 4 * position width code + length width code
+	opCopyLast  = 0x54
+	maxImmedLit = 0x40
+)
+
+// Delta writes the delta turning the file sig was computed from into the
+// data read from newfile
+func Delta(sig io.Reader, newfile io.Reader, delta io.Writer) error {
+	s, err := readSignature(sig)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(newfile)
+	if err != nil {
+		return err
+	}
+	e := &deltaWriter{w: bufio.NewWriter(delta)}
+	e.put(deltaMagic, 4)
+
+	rs := s.sigType.newRollsum()
+	h := s.sigType.newHash()
+	bl := s.blockLen
+	n := len(data)
+	lit := 0
+	rs.update(data[:min(bl, n)])
+	for p := 0; p < n; {
+		l := min(bl, n-p)
+		if idx, ok := s.match(rs.digest(), data[p:p+l], h); ok {
+			e.literal(data[lit:p])
+			e.copy(idx*bl, l)
+			p += l
+			lit = p
+			rs.reset()
+			rs.update(data[p:min(p+bl, n)])
+			continue
+		}
+		if p+l < n {
+			rs.rotate(data[p], data[p+l])
+		} else {
+			rs.rollout(data[p])
+		}
+		p++
+	}
+	e.literal(data[lit:])
+	e.flush()
+	e.w.WriteByte(opEnd)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// match returns the index of the block of the old file that matches win
+func (s *signature) match(weak uint32, win []byte, h hash.Hash) (int, bool) {
+	blocks := s.blocks[weak]
+	if len(blocks) == 0 {
+		return 0, false
+	}
+	h.Reset()
+	h.Write(win)
+	strong := string(h.Sum(nil)[:s.strongLen])
+	for _, b := range blocks {
+		if b.strong == strong {
+			return b.index, true
+		}
+	}
+	return 0, false
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// deltaWriter writes delta commands, merging adjacent copies
+type deltaWriter struct {
+	w   *bufio.Writer
+	err error
+	// pending copy
+	pos, n int
+}
+
+func (e *deltaWriter) literal(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.flush()
+	if len(b) <= maxImmedLit {
+		e.w.WriteByte(byte(len(b)))
+	} else {
+		code, width := widthOf(len(b))
+		e.w.WriteByte(byte(opLiteral + code))
+		e.put(uint64(len(b)), width)
+	}
+	e.w.Write(b)
+}
+
+func (e *deltaWriter) copy(pos, n int) {
+	if e.n > 0 && e.pos+e.n == pos {
+		e.n += n
+		return
+	}
+	e.flush()
+	e.pos, e.n = pos, n
+}
+
+// flush writes the pending copy
+func (e *deltaWriter) flush() {
+	if e.n == 0 {
+		return
+	}
+	pcode, pwidth := widthOf(e.pos)
+	ncode, nwidth := widthOf(e.n)
+	e.w.WriteByte(byte(opCopy + pcode*4 + ncode))
+	e.put(uint64(e.pos), pwidth)
+	e.put(uint64(e.n), nwidth)
+	e.n = 0
+}
+
+// put writes v as a big endian integer of width bytes
+func (e *deltaWriter) put(v uint64, width int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	if _, err := e.w.Write(buf[8-width:]); err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+// widthOf returns the smallest integer width for v, and its code
+func widthOf(v int) (code, width int) {
+	switch {
+	case v <= 0xff:
+		return 0, 1
+	case v <= 0xffff:
+		return 1, 2
+	case v <= 0xffffffff:
+		return 2, 4
+	}
+	return 3, 8
+}
+
+// Patch applies delta to basis, the file the delta's signature was computed
+// from, and writes the result to newfile
+func Patch(basis io.ReaderAt, delta io.Reader, newfile io.Writer) error {
+	r := bufio.NewReader(delta)
+	magic, err := readInt(r, 4)
+	if err != nil || magic != deltaMagic {
+		return fmt.Errorf("%w (delta magic)", ErrCorrupt)
+	}
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w (delta ended without an end command)", ErrCorrupt)
+		}
+		switch {
+		case op == opEnd:
+			return nil
+		case op <= maxImmedLit:
+			err = literal(r, newfile, int64(op))
+		case op < opCopy:
+			var n int64
+			if n, err = readInt(r, 1<<(op-opLiteral)); err == nil {
+				err = literal(r, newfile, n)
+			}
+		case op <= opCopyLast:
+			var pos, n int64
+			if pos, err = readInt(r, 1<<((op-opCopy)/4)); err != nil {
+				break
+			}
+			if n, err = readInt(r, 1<<((op-opCopy)%4)); err != nil {
+				break
+			}
+			var m int64
+			m, err = io.Copy(newfile, io.NewSectionReader(basis, pos, n))
+			if err == nil && m < n {
+				err = fmt.Errorf("%w (copy past the end of the basis file)", ErrCorrupt)
+			}
+		default:
+			err = fmt.Errorf("%w (unknown command %#x)", ErrCorrupt, op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func literal(r io.Reader, w io.Writer, n int64) error {
+	m, err := io.CopyN(w, r, n)
+	if m < n {
+		return fmt.Errorf("%w (literal)", ErrCorrupt)
+	}
+	return err
+}
+
+// readInt reads a big endian integer of width bytes
+func readInt(r io.Reader, width int) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-width:]); err != nil {
+		return 0, fmt.Errorf("%w (command parameter)", ErrCorrupt)
+	}
+	v := binary.BigEndian.Uint64(buf[:])
+	if v > 1<<62 {
+		return 0, fmt.Errorf("%w (command parameter)", ErrCorrupt)
+	}
+	return int64(v), nil
+}
@@ -0,0 +1,226 @@
+// Package rdiff computes signatures, deltas and patches in the formats of
+// librsync's rdiff. Unlike bsdiff, a delta only needs a signature of the old
+// file: a few bytes of checksums per block, which a client can compute and
+// send instead of the whole old file.
+//
+//	rdiff signature old.bin old.sig   Signature(old, sig)
+//	rdiff delta old.sig new.bin delta Delta(sig, new, delta)
+//	rdiff patch old.bin delta new.bin Patch(old, delta, new)
+package rdiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/md4"
+)
+
+// SigType selects the checksums of a signature. Its value is the magic
+// number of the signature file.
+type SigType uint32
+
+// Signature types of librsync
+const (
+	// MD4 is the signature of librsync before 1.0. MD4 is broken; it's
+	// only here to read old signatures.
+	MD4 SigType = 0x72730136
+	// BLAKE2 is the default of librsync 1.0 to 2.1
+	BLAKE2 SigType = 0x72730137
+	// RabinKarpMD4 is MD4 with the Rabin-Karp rollsum
+	RabinKarpMD4 SigType = 0x72730146
+	// RabinKarpBLAKE2 is the default of librsync 2.2 and later
+	RabinKarpBLAKE2 SigType = 0x72730147
+)
+
+// deltaMagic starts every delta
+const deltaMagic = 0x72730236
+
+// DefaultBlockLen is the block length of signatures, unless WithBlockLen
+// sets another
+const DefaultBlockLen = 2048
+
+// maxBlockLen bounds the block length read from a signature
+const maxBlockLen = 1 << 24
+
+// ErrCorrupt is returned for a malformed signature or delta
+var ErrCorrupt = errors.New("rdiff: corrupt input")
+
+func (t SigType) valid() bool {
+	switch t {
+	case MD4, BLAKE2, RabinKarpMD4, RabinKarpBLAKE2:
+		return true
+	}
+	return false
+}
+
+func (t SigType) newHash() hash.Hash {
+	if t == MD4 || t == RabinKarpMD4 {
+		return md4.New()
+	}
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+func (t SigType) newRollsum() rollsum {
+	var s rollsum = &classicSum{}
+	if t == RabinKarpMD4 || t == RabinKarpBLAKE2 {
+		s = &rabinKarpSum{}
+	}
+	s.reset()
+	return s
+}
+
+// Option configures how a signature is computed
+type Option func(*options)
+
+type options struct {
+	sigType   SigType
+	blockLen  int
+	strongLen int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		sigType:  BLAKE2,
+		blockLen: DefaultBlockLen,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSigType selects the checksums of the signature. BLAKE2 is the default.
+func WithSigType(t SigType) Option {
+	return func(o *options) {
+		o.sigType = t
+	}
+}
+
+// WithBlockLen sets the length of the blocks of the old file. Smaller blocks
+// find more matches, at the cost of a larger signature.
+func WithBlockLen(n int) Option {
+	return func(o *options) {
+		o.blockLen = n
+	}
+}
+
+// WithStrongLen truncates the strong checksums to n bytes. By default they
+// are kept whole (16 bytes for MD4, 32 for BLAKE2).
+func WithStrongLen(n int) Option {
+	return func(o *options) {
+		o.strongLen = n
+	}
+}
+
+// Signature writes the signature of basis to sig
+//
+//	0	4	magic (SigType)
+//	4	4	block length
+//	8	4	strong checksum length
+//	12	??	per block: 4 byte weak checksum, strong checksum
+//
+// All integers are big endian.
+func Signature(basis io.Reader, sig io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	if !o.sigType.valid() {
+		return fmt.Errorf("unknown signature type %#x", uint32(o.sigType))
+	}
+	h := o.sigType.newHash()
+	if o.strongLen == 0 {
+		o.strongLen = h.Size()
+	}
+	if o.blockLen <= 0 || o.blockLen > maxBlockLen {
+		return fmt.Errorf("invalid block length %v", o.blockLen)
+	}
+	if o.strongLen <= 0 || o.strongLen > h.Size() {
+		return fmt.Errorf("invalid strong checksum length %v", o.strongLen)
+	}
+	w := bufio.NewWriter(sig)
+	hdr := make([]byte, 12)
+	binary.BigEndian.PutUint32(hdr, uint32(o.sigType))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(o.blockLen))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(o.strongLen))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	rs := o.sigType.newRollsum()
+	block := make([]byte, o.blockLen)
+	weak := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(basis, block)
+		if n > 0 {
+			rs.reset()
+			rs.update(block[:n])
+			binary.BigEndian.PutUint32(weak, rs.digest())
+			h.Reset()
+			h.Write(block[:n])
+			if _, err := w.Write(weak); err != nil {
+				return err
+			}
+			if _, err := w.Write(h.Sum(nil)[:o.strongLen]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// sigBlock is the checksums of a block of the old file
+type sigBlock struct {
+	index  int
+	strong string
+}
+
+// signature is a parsed signature file
+type signature struct {
+	sigType   SigType
+	blockLen  int
+	strongLen int
+	// blocks by weak checksum. The signature doesn't record the length of
+	// the last block; a shorter window at the end of the new file matches
+	// it through its strong checksum.
+	blocks map[uint32][]sigBlock
+}
+
+func readSignature(r io.Reader) (*signature, error) {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("%w (signature header)", ErrCorrupt)
+	}
+	s := &signature{
+		sigType:   SigType(binary.BigEndian.Uint32(hdr)),
+		blockLen:  int(binary.BigEndian.Uint32(hdr[4:])),
+		strongLen: int(binary.BigEndian.Uint32(hdr[8:])),
+		blocks:    make(map[uint32][]sigBlock),
+	}
+	if !s.sigType.valid() {
+		return nil, fmt.Errorf("%w (signature magic %#x)", ErrCorrupt, uint32(s.sigType))
+	}
+	if s.blockLen <= 0 || s.blockLen > maxBlockLen || s.strongLen <= 0 || s.strongLen > s.sigType.newHash().Size() {
+		return nil, fmt.Errorf("%w (signature header)", ErrCorrupt)
+	}
+	rec := make([]byte, 4+s.strongLen)
+	br := bufio.NewReader(r)
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(br, rec); err != nil {
+			if err == io.EOF {
+				return s, nil
+			}
+			return nil, fmt.Errorf("%w (signature block %v)", ErrCorrupt, i)
+		}
+		weak := binary.BigEndian.Uint32(rec)
+		s.blocks[weak] = append(s.blocks[weak], sigBlock{i, string(rec[4:])})
+	}
+}
@@ -0,0 +1,94 @@
+package rdiff
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRollsum(t *testing.T) {
+	if m, inv := uint32(rkMult), uint32(rkInvm); m*inv != 1 {
+		t.Fatal("rkInvm isn't the inverse of rkMult")
+	}
+	rng := rand.New(rand.NewSource(1))
+	b := make([]byte, 4096)
+	rng.Read(b)
+	const win = 100
+	for _, typ := range []SigType{BLAKE2, RabinKarpBLAKE2} {
+		rs, ref := typ.newRollsum(), typ.newRollsum()
+		rs.update(b[:win])
+		for i := 0; i+win < len(b); i++ {
+			rs.rotate(b[i], b[i+win])
+			ref.reset()
+			ref.update(b[i+1 : i+1+win])
+			if rs.digest() != ref.digest() {
+				t.Fatalf("%#x: rotate at %v", uint32(typ), i)
+			}
+		}
+		for i := len(b) - win; i < len(b)-1; i++ {
+			rs.rollout(b[i])
+			ref.reset()
+			ref.update(b[i+1:])
+			if rs.digest() != ref.digest() {
+				t.Fatalf("%#x: rollout at %v", uint32(typ), i)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	oldbs := make([]byte, 1024*64)
+	rng.Read(oldbs)
+	newbs := append([]byte(nil), oldbs[:1024*20]...)
+	newbs = append(newbs, "inserted in the middle"...)
+	newbs = append(newbs, oldbs[1024*20:1024*60]...)
+	rng.Read(newbs[1024*40 : 1024*41])
+	newbs = append(newbs, oldbs[1024*60+7:]...) // short tail block
+	for _, typ := range []SigType{MD4, BLAKE2, RabinKarpMD4, RabinKarpBLAKE2} {
+		var sig, delta, out bytes.Buffer
+		if err := Signature(bytes.NewReader(oldbs), &sig, WithSigType(typ), WithBlockLen(512), WithStrongLen(8)); err != nil {
+			t.Fatal(err)
+		}
+		if err := Delta(&sig, bytes.NewReader(newbs), &delta); err != nil {
+			t.Fatal(err)
+		}
+		if delta.Len() > 1024*4 {
+			t.Fatalf("%#x: delta is %v bytes", uint32(typ), delta.Len())
+		}
+		if err := Patch(bytes.NewReader(oldbs), &delta, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), newbs) {
+			t.Fatalf("%#x: round trip failed", uint32(typ))
+		}
+	}
+}
+
+func TestPatchCommands(t *testing.T) {
+	delta := []byte{
+		0x72, 0x73, 0x02, 0x36, // magic
+		3, 'a', 'b', 'c', // LITERAL_3
+		0x45, 2, 3, // COPY_N1_N1 2 3
+		0x42, 0, 2, 'x', 'y', // LITERAL_N2 2
+		0x4a, 0, 0, 0, 1, // COPY_N2_N2 0 1
+		0x00,
+	}
+	var out bytes.Buffer
+	if err := Patch(bytes.NewReader([]byte("0123456")), bytes.NewReader(delta), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "abc234xy0" {
+		t.Fatal(out.String())
+	}
+	delta[9] = 6 // copy past the end of the basis
+	err := Patch(bytes.NewReader([]byte("0123456")), bytes.NewReader(delta), &out)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+	err = Patch(nil, bytes.NewReader(delta[:8]), &out)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+}
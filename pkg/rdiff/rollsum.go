@@ -0,0 +1,91 @@
+package rdiff
+
+// rollsum is a rolling weak checksum over a window of bytes
+type rollsum interface {
+	reset()
+	update(b []byte)
+	// rotate slides the window by one byte
+	rotate(out, in byte)
+	// rollout drops the first byte of the window
+	rollout(out byte)
+	digest() uint32
+}
+
+// classicSum is librsync's original rollsum, a variant of Adler-32
+type classicSum struct {
+	count  uint32
+	s1, s2 uint32
+}
+
+// charOffset is added to every byte by the classic rollsum
+const charOffset = 31
+
+func (s *classicSum) reset() {
+	*s = classicSum{}
+}
+
+func (s *classicSum) update(b []byte) {
+	for _, c := range b {
+		s.s1 += uint32(c)
+		s.s2 += s.s1
+	}
+	n := uint32(len(b))
+	s.s1 += n * charOffset
+	s.s2 += n * (n + 1) / 2 * charOffset
+	s.count += n
+}
+
+func (s *classicSum) rotate(out, in byte) {
+	s.s1 += uint32(in) - uint32(out)
+	s.s2 += s.s1 - s.count*(uint32(out)+charOffset)
+}
+
+func (s *classicSum) rollout(out byte) {
+	s.s1 -= uint32(out) + charOffset
+	s.s2 -= s.count * (uint32(out) + charOffset)
+	s.count--
+}
+
+func (s *classicSum) digest() uint32 {
+	return s.s2<<16 | s.s1&0xffff
+}
+
+// Rabin-Karp rollsum constants. invm is the inverse of mult modulo 2^32 and
+// adj is mult-seed.
+const (
+	rkSeed = 1
+	rkMult = 0x08104225
+	rkInvm = 0x98f009ad
+	rkAdj  = 0x08104224
+)
+
+// rabinKarpSum is the polynomial rollsum of librsync 2.2 and later
+type rabinKarpSum struct {
+	hash uint32
+	// mult is rkMult to the power of the window length
+	mult uint32
+}
+
+func (s *rabinKarpSum) reset() {
+	s.hash, s.mult = rkSeed, 1
+}
+
+func (s *rabinKarpSum) update(b []byte) {
+	for _, c := range b {
+		s.hash = s.hash*rkMult + uint32(c)
+		s.mult *= rkMult
+	}
+}
+
+func (s *rabinKarpSum) rotate(out, in byte) {
+	s.hash = s.hash*rkMult + uint32(in) - s.mult*(uint32(out)+rkAdj)
+}
+
+func (s *rabinKarpSum) rollout(out byte) {
+	s.mult *= rkInvm
+	s.hash -= s.mult * (uint32(out) + rkAdj)
+}
+
+func (s *rabinKarpSum) digest() uint32 {
+	return s.hash
+}
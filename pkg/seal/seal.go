@@ -0,0 +1,444 @@
+// Package seal encrypts patches for distribution through untrusted servers
+// and CDNs. A sealed patch is split in chunks, each encrypted and
+// authenticated with AES-256-GCM or ChaCha20-Poly1305, so it can be
+// decrypted while it downloads and applied with bspatch.ApplyStream, or read
+// at random offsets with NewReaderAt. Only its length is revealed.
+package seal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Sealed patch is
+//
+//	0	8	"BSDIFFAE"
+//	8	1	cipher
+//	9	1	log2 of the chunk size
+//	10	2	zero
+//	12	32	salt
+//	44	??	chunks
+//
+// Each chunk is chunk size bytes of the patch, fewer for the last one (which
+// may be empty), encrypted and followed by a 16 byte tag. The chunk key is
+// derived from the key and the salt with HKDF-SHA256. The nonce of a chunk
+// is its index and whether it's the last one, and the header is additional
+// data, so chunks can't be reordered, dropped or cut short.
+
+// Magic starts every sealed patch
+const Magic = "BSDIFFAE"
+
+const (
+	// KeySize is the size of the keys, in bytes
+	KeySize = 32
+	// DefaultChunkSize is the chunk size, unless WithChunkSize sets another
+	DefaultChunkSize = 64 << 10
+
+	headerSize = 44
+	saltSize   = 32
+	tagSize    = 16
+)
+
+// Cipher is the AEAD the chunks are sealed with
+type Cipher byte
+
+const (
+	// AES256GCM is AES-256 in GCM mode, the default. It's fastest on CPUs
+	// with AES instructions.
+	AES256GCM Cipher = 1
+	// ChaCha20Poly1305 is faster on CPUs without AES instructions, e.g.
+	// many embedded ARM cores
+	ChaCha20Poly1305 Cipher = 2
+)
+
+// ErrAuth is returned when a sealed patch was tampered with or cut short, or
+// the key is wrong
+var ErrAuth = errors.New("seal: message authentication failed")
+
+// Option configures how a patch is sealed
+type Option func(*options)
+
+type options struct {
+	cipher    Cipher
+	chunkSize int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{cipher: AES256GCM, chunkSize: DefaultChunkSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCipher sets the AEAD the chunks are sealed with
+func WithCipher(c Cipher) Option {
+	return func(o *options) {
+		o.cipher = c
+	}
+}
+
+// WithChunkSize sets the size of the chunks, a power of two from 1 KiB to
+// 16 MiB. Readers buffer a chunk and check it before returning any of it.
+func WithChunkSize(n int) Option {
+	return func(o *options) {
+		o.chunkSize = n
+	}
+}
+
+// Seal returns patch sealed with key
+func Seal(patch, key []byte, opts ...Option) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(patch); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open returns the patch sealed in sealed with key
+func Open(sealed, key []byte) ([]byte, error) {
+	r, err := NewReaderAt(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		return nil, err
+	}
+	patch := make([]byte, r.Size())
+	if _, err = r.ReadAt(patch, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// sealer seals and opens the chunks of a patch
+type sealer struct {
+	aead   cipher.AEAD
+	header []byte
+	chunk  int
+}
+
+func newSealer(header, key []byte) (*sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("seal: invalid key size %v", len(key))
+	}
+	if string(header[:8]) != Magic {
+		return nil, fmt.Errorf("seal: not a sealed patch")
+	}
+	bits := uint(header[9])
+	if bits < 10 || bits > 24 {
+		return nil, fmt.Errorf("seal: invalid chunk size 2^%v", bits)
+	}
+	k := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, header[12:headerSize], []byte(Magic)), k); err != nil {
+		return nil, err
+	}
+	var aead cipher.AEAD
+	switch Cipher(header[8]) {
+	case AES256GCM:
+		b, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		if aead, err = cipher.NewGCM(b); err != nil {
+			return nil, err
+		}
+	case ChaCha20Poly1305:
+		var err error
+		if aead, err = chacha20poly1305.New(k); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("seal: unknown cipher %v", header[8])
+	}
+	return &sealer{aead: aead, header: header, chunk: 1 << bits}, nil
+}
+
+// nonce returns the nonce of chunk i
+func nonce(i uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:], i)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+func (s *sealer) seal(dst, plain []byte, i uint64, last bool) []byte {
+	return s.aead.Seal(dst, nonce(i, last), plain, s.header)
+}
+
+func (s *sealer) open(dst, ct []byte, i uint64, last bool) ([]byte, error) {
+	plain, err := s.aead.Open(dst, nonce(i, last), ct, s.header)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plain, nil
+}
+
+// NewWriter returns a writer sealing what's written to it with key and
+// writing it to w. Close must be called to write the last chunk; it doesn't
+// close w.
+func NewWriter(w io.Writer, key []byte, opts ...Option) (io.WriteCloser, error) {
+	o := newOptions(opts)
+	bits := 0
+	for 1<<bits < o.chunkSize {
+		bits++
+	}
+	if 1<<bits != o.chunkSize || bits < 10 || bits > 24 {
+		return nil, fmt.Errorf("seal: invalid chunk size %v", o.chunkSize)
+	}
+	header := make([]byte, headerSize)
+	copy(header, Magic)
+	header[8] = byte(o.cipher)
+	header[9] = byte(bits)
+	if _, err := rand.Read(header[12:]); err != nil {
+		return nil, err
+	}
+	s, err := newSealer(header, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, s: s, buf: make([]byte, 0, s.chunk)}, nil
+}
+
+type writer struct {
+	w   io.Writer
+	s   *sealer
+	buf []byte
+	out []byte
+	// n is the index of the chunk in buf
+	n   uint64
+	err error
+}
+
+// Write seals the chunks p completes. A full chunk is only written once
+// more bytes follow, as the last chunk is sealed differently.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.flush(false); w.err != nil {
+				return 0, w.err
+			}
+		}
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf, p = w.buf[:len(w.buf)+k], p[k:]
+	}
+	return n, nil
+}
+
+func (w *writer) flush(last bool) error {
+	w.out = w.s.seal(w.out[:0], w.buf, w.n, last)
+	w.n++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+// Close writes the last chunk
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flush(true)
+	if w.err == nil {
+		w.err = errors.New("seal: write after Close")
+		return nil
+	}
+	return w.err
+}
+
+// NewReader returns a reader of the patch sealed with key in r, checking
+// each chunk before returning it
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("seal: not a sealed patch")
+		}
+		return nil, err
+	}
+	s, err := newSealer(header, key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: bufio.NewReader(r), s: s, ct: make([]byte, s.chunk+tagSize)}, nil
+}
+
+type reader struct {
+	r     *bufio.Reader
+	s     *sealer
+	ct    []byte
+	plain []byte
+	// n is the index of the next chunk
+	n    uint64
+	last bool
+	err  error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.last {
+			r.err = io.EOF
+			continue
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk. A chunk is the last one if it's short
+// or nothing follows it.
+func (r *reader) next() error {
+	k, err := io.ReadFull(r.r, r.ct)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		r.last = true
+	case err != nil:
+		return err
+	default:
+		if _, err = r.r.Peek(1); err == io.EOF {
+			r.last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if k < tagSize {
+		return ErrAuth
+	}
+	if r.plain, err = r.s.open(r.ct[:0], r.ct[:k], r.n, r.last); err != nil {
+		return err
+	}
+	r.n++
+	return nil
+}
+
+// ReaderAt reads a sealed patch at random offsets, e.g. for bspatch.Reader
+// or bspatch.Load. The last chunk read is cached. It's safe for concurrent
+// use.
+type ReaderAt struct {
+	r      io.ReaderAt
+	s      *sealer
+	size   int64
+	chunks int64
+
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+}
+
+// NewReaderAt returns a ReaderAt of the patch sealed with key in r, which is
+// size bytes long
+func NewReaderAt(r io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	header := make([]byte, headerSize)
+	if size < headerSize {
+		return nil, fmt.Errorf("seal: not a sealed patch")
+	}
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	s, err := newSealer(header, key)
+	if err != nil {
+		return nil, err
+	}
+	full := int64(s.chunk + tagSize)
+	body := size - headerSize
+	chunks, rest := body/full, body%full
+	if rest > 0 {
+		if rest < tagSize {
+			return nil, ErrAuth
+		}
+		chunks++
+		rest -= tagSize
+	} else {
+		if chunks == 0 {
+			return nil, ErrAuth
+		}
+		rest = int64(s.chunk)
+	}
+	return &ReaderAt{
+		r:      r,
+		s:      s,
+		size:   (chunks-1)*int64(s.chunk) + rest,
+		chunks: chunks,
+		cached: -1,
+	}, nil
+}
+
+// Size returns the size of the patch
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads the patch at off
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("seal: negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		i := off / int64(r.s.chunk)
+		if err := r.load(i); err != nil {
+			return n, err
+		}
+		k := copy(p[n:], r.plain[off-i*int64(r.s.chunk):])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// load opens chunk i into the cache
+func (r *ReaderAt) load(i int64) error {
+	if i == r.cached {
+		return nil
+	}
+	full := int64(r.s.chunk + tagSize)
+	ct := make([]byte, full)
+	if i == r.chunks-1 {
+		ct = ct[:r.size-i*int64(r.s.chunk)+tagSize]
+	}
+	if _, err := r.r.ReadAt(ct, headerSize+i*full); err != nil && err != io.EOF {
+		return err
+	}
+	plain, err := r.s.open(ct[:0], ct, uint64(i), i == r.chunks-1)
+	if err != nil {
+		r.cached = -1
+		return err
+	}
+	r.cached, r.plain = i, plain
+	return nil
+}
@@ -0,0 +1,143 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabstv/go-bsdiff/internal/testdata"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func TestSeal(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	for _, c := range []Cipher{AES256GCM, ChaCha20Poly1305} {
+		for _, size := range []int{0, 1, 1023, 1024, 1025, 3 * 1024, 5000} {
+			patch := make([]byte, size)
+			rand.Read(patch)
+			sealed, err := Seal(patch, key, WithCipher(c), WithChunkSize(1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sealed, patch[:size/2]) && size > 16 {
+				t.Fatal("sealed patch contains the patch")
+			}
+			opened, err := Open(sealed, key)
+			if err != nil {
+				t.Fatal(c, size, err)
+			}
+			if !bytes.Equal(opened, patch) {
+				t.Fatal("Open round trip failed", c, size)
+			}
+			r, err := NewReader(iotest.HalfReader(bytes.NewReader(sealed)), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamed, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(c, size, err)
+			}
+			if !bytes.Equal(streamed, patch) {
+				t.Fatal("NewReader round trip failed", c, size)
+			}
+			ra, err := NewReaderAt(bytes.NewReader(sealed), int64(len(sealed)), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ra.Size() != int64(size) {
+				t.Fatal("size", ra.Size(), "!=", size)
+			}
+			if size > 0 {
+				if err = iotest.TestReader(io.NewSectionReader(ra, 0, ra.Size()), patch); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func TestSealAuth(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	patch := make([]byte, 4096)
+	rand.Read(patch)
+	sealed, err := Seal(patch, key, WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey := append([]byte{}, key...)
+	wrongKey[0] ^= 1
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)/2] ^= 1
+	header := append([]byte{}, sealed...)
+	header[20] ^= 1
+	// Cut at a chunk boundary, so the rest looks complete
+	cut := sealed[:headerSize+2*(1024+tagSize)]
+	for _, c := range []struct {
+		name        string
+		sealed, key []byte
+	}{{"wrong key", sealed, wrongKey}, {"flipped", flipped, key}, {"salt", header, key}, {"cut", cut, key}} {
+		if _, err := Open(c.sealed, c.key); !errors.Is(err, ErrAuth) {
+			t.Fatal(c.name, "Open: expected ErrAuth, got", err)
+		}
+		r, err := NewReader(bytes.NewReader(c.sealed), c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadAll(r); !errors.Is(err, ErrAuth) {
+			t.Fatal(c.name, "NewReader: expected ErrAuth, got", err)
+		}
+	}
+	if _, err = Seal(patch, key, WithChunkSize(1000)); err == nil {
+		t.Fatal("expected an invalid chunk size error")
+	}
+	if _, err = Seal(patch, key[:16]); err == nil {
+		t.Fatal("expected an invalid key size error")
+	}
+}
+
+func TestSealPatch(t *testing.T) {
+	w := testdata.SmallEdits(1 << 18)
+	patch, err := bsdiff.Bytes(w.Old, w.New, bsdiff.WithHashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	sealed, err := Seal(patch, key, WithCipher(ChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bspatch.Bytes(w.Old, sealed); !errors.Is(err, bspatch.ErrUnsupportedFormat) {
+		t.Fatal("expected an unsupported format error, got", err)
+	}
+
+	// Decrypt while applying, as during a download
+	r, err := NewReader(iotest.HalfReader(bytes.NewReader(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = bspatch.ApplyStream(bytes.NewReader(w.Old), r, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), w.New) {
+		t.Fatal("streamed round trip failed")
+	}
+	ra, err := NewReaderAt(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err = bspatch.Apply(bytes.NewReader(w.Old), ra, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), w.New) {
+		t.Fatal("random access round trip failed")
+	}
+}
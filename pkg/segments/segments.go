@@ -0,0 +1,345 @@
+// Package segments cuts a patch into segment patches, each making a
+// contiguous range of the new file from the old file on its own, so the
+// segments can be downloaded and applied in parallel. Each segment records
+// the SHA-256 of the old file and of its range of the new file, and its
+// place among the segments in its metadata; Join puts them back into one
+// patch.
+package segments
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Metadata keys of a segment: its index and the number of segments, as
+// "index/count", and the offset of its range in the new file
+const (
+	MetaSegment = "segment"
+	MetaOffset  = "segment.offset"
+)
+
+// Split cuts patch, made from oldfile, into at most n segment patches of
+// about the same new size, written with opts (e.g. a compressor). Patches
+// bspatch.Scan can't read, with an executable or archive transform, can't
+// be split.
+func Split(oldfile, patch io.ReaderAt, n int, opts ...bsdiff.Option) (_ [][]byte, err error) {
+	defer util.Recover(&err)
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of segments %v", n)
+	}
+	h, err := bspatch.ReadHeader(patch)
+	if err != nil {
+		return nil, err
+	}
+	newsize := h.NewSize
+	if newsize < 0 {
+		// VCDIFF deltas don't declare it
+		newsize = 0
+		err = bspatch.Scan(patch, func(c bspatch.Control) error {
+			newsize += int64(len(c.Diff) + len(c.Extra))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if int64(n) > newsize {
+		n = int(newsize)
+		if n == 0 {
+			n = 1
+		}
+	}
+	bounds := make([]int64, n+1)
+	for k := range bounds {
+		bounds[k] = newsize * int64(k) / int64(n)
+	}
+	oldsum, newsums, err := digests(oldfile, patch, bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	segs := make([][]byte, n)
+	var (
+		buf *util.BufWriter
+		w   *bsdiff.Writer
+		// k is the segment written, wpos the old position of its patch
+		k    = -1
+		wpos int64
+	)
+	// next closes segment k and starts the next one
+	next := func() error {
+		if w != nil {
+			if err := w.Close(); err != nil {
+				return err
+			}
+			segs[k] = buf.Bytes()
+		}
+		k++
+		sopts := append(append([]bsdiff.Option(nil), opts...),
+			bsdiff.WithDigests(oldsum, newsums[k]),
+			bsdiff.WithMetadata(MetaSegment, fmt.Sprintf("%v/%v", k, n)),
+			bsdiff.WithMetadata(MetaOffset, strconv.FormatInt(bounds[k], 10)))
+		buf, wpos = &util.BufWriter{}, 0
+		var err error
+		w, err = bsdiff.NewWriter(buf, sopts...)
+		return err
+	}
+	var newpos, oldpos int64
+	err = bspatch.Scan(patch, func(c bspatch.Control) error {
+		diff, extra := c.Diff, c.Extra
+		for len(diff)+len(extra) > 0 {
+			for k < 0 || newpos == bounds[k+1] {
+				if err := next(); err != nil {
+					return err
+				}
+			}
+			if wpos != oldpos {
+				// A segment starts where the old position is
+				if err := w.WriteControl(nil, nil, int(oldpos-wpos)); err != nil {
+					return err
+				}
+				wpos = oldpos
+			}
+			room := bounds[k+1] - newpos
+			if int64(len(diff)+len(extra)) <= room {
+				if err := w.WriteControl(diff, extra, c.Seek); err != nil {
+					return err
+				}
+				newpos += int64(len(diff) + len(extra))
+				oldpos += int64(len(diff) + c.Seek)
+				wpos = oldpos
+				return nil
+			}
+			// The control is cut at the end of the segment
+			d, e := diff, extra
+			if int64(len(d)) > room {
+				d, e = d[:room], nil
+			} else {
+				e = e[:room-int64(len(d))]
+			}
+			if err := w.WriteControl(d, e, 0); err != nil {
+				return err
+			}
+			newpos += room
+			oldpos += int64(len(d))
+			wpos = oldpos
+			diff, extra = diff[len(d):], extra[len(e):]
+		}
+		oldpos += int64(c.Seek)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for k < n-1 || w != nil {
+		if k == n-1 {
+			// The last segment is closed by moving past it
+			if err = w.Close(); err != nil {
+				return nil, err
+			}
+			segs[k] = buf.Bytes()
+			break
+		}
+		if err = next(); err != nil {
+			return nil, err
+		}
+	}
+	return segs, nil
+}
+
+// digests returns the SHA-256 of oldfile and of the ranges of the new file
+// between bounds
+func digests(oldfile, patch io.ReaderAt, bounds []int64) (oldsum []byte, newsums [][]byte, err error) {
+	d := sha256.New()
+	if _, err = io.Copy(d, io.NewSectionReader(oldfile, 0, 1<<62)); err != nil {
+		return nil, nil, fmt.Errorf("could not read old file: %w", err)
+	}
+	oldsum = d.Sum(nil)
+	hs := make([]hash.Hash, len(bounds)-1)
+	for k := range hs {
+		hs[k] = sha256.New()
+	}
+	k := 0
+	var newpos, oldpos int64
+	// write hashes b, the new file at newpos
+	write := func(b []byte) {
+		for len(b) > 0 {
+			for newpos == bounds[k+1] {
+				k++
+			}
+			n := int64(len(b))
+			if room := bounds[k+1] - newpos; n > room {
+				n = room
+			}
+			hs[k].Write(b[:n])
+			newpos += n
+			b = b[n:]
+		}
+	}
+	buf := make([]byte, 64<<10)
+	err = bspatch.Scan(patch, func(c bspatch.Control) error {
+		for diff := c.Diff; len(diff) > 0; {
+			b := buf
+			if len(diff) < len(b) {
+				b = b[:len(diff)]
+			}
+			n, _ := oldfile.ReadAt(b, oldpos)
+			util.AddBytes(b[:n], diff[:n])
+			// Past the end of the old file, the diff bytes are the new ones
+			copy(b[n:], diff[n:len(b)])
+			write(b)
+			diff = diff[len(b):]
+			oldpos += int64(len(b))
+		}
+		write(c.Extra)
+		oldpos += int64(c.Seek)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	newsums = make([][]byte, len(hs))
+	for k, h := range hs {
+		newsums[k] = h.Sum(nil)
+	}
+	return oldsum, newsums, nil
+}
+
+// Segment is where a segment patch belongs
+type Segment struct {
+	// Index is the place of the segment among Count segments
+	Index, Count int
+	// Offset and Size are the range of the new file it makes
+	Offset, Size int64
+}
+
+// Read returns where the segment patch belongs, failing with
+// bspatch.ErrCorruptPatch if it isn't a segment
+func Read(patch io.ReaderAt) (*Segment, error) {
+	meta, err := bspatch.Metadata(patch)
+	if err != nil {
+		return nil, err
+	}
+	h, err := bspatch.ReadHeader(patch)
+	if err != nil {
+		return nil, err
+	}
+	var s Segment
+	index, count, _ := strings.Cut(meta[MetaSegment], "/")
+	if s.Index, err = strconv.Atoi(index); err == nil {
+		s.Count, err = strconv.Atoi(count)
+	}
+	if err == nil {
+		s.Offset, err = strconv.ParseInt(meta[MetaOffset], 10, 64)
+	}
+	if err != nil || s.Index < 0 || s.Index >= s.Count || s.Offset < 0 || h.NewSize < 0 {
+		return nil, fmt.Errorf("%w (not a segment)", bspatch.ErrCorruptPatch)
+	}
+	s.Size = h.NewSize
+	return &s, nil
+}
+
+// order checks that segments are all the segments of a patch, in order,
+// and returns where they belong
+func order(segments []io.ReaderAt) ([]*Segment, error) {
+	ss := make([]*Segment, len(segments))
+	var off int64
+	for i, patch := range segments {
+		s, err := Read(patch)
+		if err != nil {
+			return nil, fmt.Errorf("segment %v: %w", i, err)
+		}
+		if s.Index != i || s.Count != len(segments) || s.Offset != off {
+			return nil, fmt.Errorf("%w (segment %v/%v at %v given as segment %v/%v at %v)", bspatch.ErrCorruptPatch, s.Index, s.Count, s.Offset, i, len(segments), off)
+		}
+		ss[i] = s
+		off += s.Size
+	}
+	return ss, nil
+}
+
+// Apply applies segments, all the segments of a patch in order, to
+// oldfile in parallel, writing each range of the new file to out
+func Apply(oldfile io.ReaderAt, segments []io.ReaderAt, out io.WriterAt, opts ...bspatch.Option) error {
+	return ApplyCtx(context.Background(), oldfile, segments, out, opts...)
+}
+
+// ApplyCtx is Apply, stopping with ctx.Err() when ctx is cancelled. The
+// first segment to fail stops the others.
+func ApplyCtx(ctx context.Context, oldfile io.ReaderAt, segments []io.ReaderAt, out io.WriterAt, opts ...bspatch.Option) error {
+	ss, err := order(segments)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(segments))
+	var wg sync.WaitGroup
+	for i := range segments {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := io.NewOffsetWriter(out, ss[i].Offset)
+			if errs[i] = bspatch.ReaderCtx(ctx, oldfile, w, segments[i], opts...); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	// A segment stopped because another failed reports the other's error
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("segment %v: %w", i, err)
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("segment %v: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Join writes the patch of segments, all the segments of a patch in order,
+// to dst with opts. The digests of the segments aren't carried over, but
+// bsdiff.WithDigests can record those of the whole files.
+func Join(segments []io.ReaderAt, dst io.WriteSeeker, opts ...bsdiff.Option) (err error) {
+	defer util.Recover(&err)
+	if _, err = order(segments); err != nil {
+		return err
+	}
+	w, err := bsdiff.NewWriter(dst, opts...)
+	if err != nil {
+		return err
+	}
+	// oldpos is the old position of the joined patch, reset by each
+	// segment
+	var oldpos int64
+	for _, patch := range segments {
+		if oldpos != 0 {
+			if err = w.WriteControl(nil, nil, int(-oldpos)); err != nil {
+				return err
+			}
+			oldpos = 0
+		}
+		err = bspatch.Scan(patch, func(c bspatch.Control) error {
+			oldpos += int64(len(c.Diff) + c.Seek)
+			return w.WriteControl(c.Diff, c.Extra, c.Seek)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
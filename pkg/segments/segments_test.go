@@ -0,0 +1,71 @@
+package segments
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+func readers(segs [][]byte) []io.ReaderAt {
+	rs := make([]io.ReaderAt, len(segs))
+	for i, b := range segs {
+		rs[i] = bytes.NewReader(b)
+	}
+	return rs
+}
+
+func TestSplit(t *testing.T) {
+	oldbs := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 300)
+	newbs := append([]byte("a new start "), oldbs[1000:]...)
+	newbs = append(newbs, bytes.Repeat([]byte("with more at the end "), 100)...)
+	copy(newbs[5000:], "changed")
+	patch, err := bsdiff.Bytes(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, 3, 7, 64} {
+		segs, err := Split(bytes.NewReader(oldbs), bytes.NewReader(patch), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segs) != n {
+			t.Fatal("got", len(segs), "segments, expected", n)
+		}
+		out := &util.BufWriter{}
+		if err = Apply(bytes.NewReader(oldbs), readers(segs), out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), newbs) {
+			t.Fatal(n, "segments: wrong new file")
+		}
+		joined := &util.BufWriter{}
+		if err = Join(readers(segs), joined); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bspatch.Bytes(oldbs, joined.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, newbs) {
+			t.Fatal(n, "segments: wrong new file from the joined patch")
+		}
+	}
+
+	segs, err := Split(bytes.NewReader(oldbs), bytes.NewReader(patch), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := readers(segs)
+	rs[1], rs[2] = rs[2], rs[1]
+	if err = Apply(bytes.NewReader(oldbs), rs, &util.BufWriter{}); !errors.Is(err, bspatch.ErrCorruptPatch) {
+		t.Fatal("expected ErrCorruptPatch for segments out of order, got", err)
+	}
+	if err = Apply(bytes.NewReader(newbs), readers(segs), &util.BufWriter{}); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("expected ErrWrongOld, got", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Package selfupdate updates the running executable with bsdiff patches.
+// Update downloads a patch and its Ed25519 signature, checks both, applies
+// the patch to a copy of the executable, checks the new one against the
+// SHA-256 the patch records and swaps the two, keeping the old one:
+//
+//	err := selfupdate.Update(ctx, "https://example.com/app-1.0-1.1.patch", publicKey)
+//
+// The first start of the new version is on trial: a program calls Startup
+// early and Confirm once it's healthy. Should it fail before Confirm, the
+// next Startup puts the old version back and returns ErrRolledBack.
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+	"github.com/gabstv/go-bsdiff/pkg/util"
+)
+
+// Next to the executable, exe.old is the previous version, exe.pending
+// marks an update not started yet and exe.trial one started but not
+// confirmed. On Windows, exe.del is a version rolled back while it ran.
+const (
+	oldExt     = ".old"
+	pendingExt = ".pending"
+	trialExt   = ".trial"
+	delExt     = ".del"
+)
+
+var (
+	// ErrSignature is returned for a patch whose signature doesn't check
+	// out
+	ErrSignature = errors.New("selfupdate: bad patch signature")
+	// ErrRolledBack is returned by Startup after it put the previous
+	// version back: the program should exit, or restart into it
+	ErrRolledBack = errors.New("selfupdate: update rolled back")
+	// ErrNoUpdate is returned by Rollback when there's no previous version
+	ErrNoUpdate = errors.New("selfupdate: no previous version")
+)
+
+// DefaultMaxPatchSize bounds the patches Update downloads, unless
+// WithMaxPatchSize sets another bound
+const DefaultMaxPatchSize = 256 << 20
+
+// Option configures Update, Apply, Startup, Confirm or Rollback
+type Option func(*options)
+
+type options struct {
+	exe       string
+	client    *http.Client
+	sigURL    string
+	maxPatch  int64
+	patchOpts []bspatch.Option
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{client: http.DefaultClient, maxPatch: DefaultMaxPatchSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithExecutable updates the executable at path instead of the running one
+func WithExecutable(path string) Option {
+	return func(o *options) {
+		o.exe = path
+	}
+}
+
+// WithClient sets the HTTP client Update downloads with,
+// http.DefaultClient by default
+func WithClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithSignatureURL sets where Update downloads the signature of the patch,
+// the URL of the patch with .sig appended by default
+func WithSignatureURL(url string) Option {
+	return func(o *options) {
+		o.sigURL = url
+	}
+}
+
+// WithMaxPatchSize bounds the size of the patches Update downloads
+func WithMaxPatchSize(n int64) Option {
+	return func(o *options) {
+		o.maxPatch = n
+	}
+}
+
+// WithPatchOptions sets the options patches are applied with, e.g.
+// bspatch.WithNewSHA256 to require a given version
+func WithPatchOptions(opts ...bspatch.Option) Option {
+	return func(o *options) {
+		o.patchOpts = opts
+	}
+}
+
+// executable returns the path of the executable to update
+func (o *options) executable() (string, error) {
+	if o.exe != "" {
+		return o.exe, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+// Sign returns the signature of patch with key, for Update and Apply
+func Sign(key ed25519.PrivateKey, patch []byte) []byte {
+	return ed25519.Sign(key, patch)
+}
+
+// Update downloads the patch at url and its signature, then applies it
+// (see Apply)
+func Update(ctx context.Context, url string, key ed25519.PublicKey, opts ...Option) error {
+	o := newOptions(opts)
+	patch, err := o.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	sigURL := o.sigURL
+	if sigURL == "" {
+		sigURL = url + ".sig"
+	}
+	sig, err := o.get(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+	return Apply(patch, sig, key, opts...)
+}
+
+func (o *options) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, o.maxPatch+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %v: %w", url, err)
+	}
+	if int64(len(b)) > o.maxPatch {
+		return nil, fmt.Errorf("GET %v: over %v bytes", url, o.maxPatch)
+	}
+	return b, nil
+}
+
+// Apply checks the signature sig of patch with key, applies the patch to a
+// copy of the executable and swaps the copy in, keeping the executable for
+// Rollback. The patch must record the SHA-256 of the new version, which the
+// copy is checked against, as bsdiff.WithHashes does; with that of the old
+// version, a patch for another one fails with bspatch.ErrWrongOld. The
+// executable is left untouched on any error.
+func Apply(patch, sig []byte, key ed25519.PublicKey, opts ...Option) (err error) {
+	defer util.Recover(&err)
+	o := newOptions(opts)
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, patch, sig) {
+		return ErrSignature
+	}
+	exe, err := o.executable()
+	if err != nil {
+		return err
+	}
+	newname, err := newVersion(exe, patch, o)
+	if err != nil {
+		return err
+	}
+	// The pending marker is written first, so a crash can't leave a new
+	// version that isn't on trial
+	os.Remove(exe + trialExt)
+	if err = os.WriteFile(exe+pendingExt, nil, 0o644); err != nil {
+		os.Remove(newname)
+		return err
+	}
+	if err = replace(exe, newname, exe+oldExt); err != nil {
+		os.Remove(exe + pendingExt)
+		os.Remove(newname)
+		return fmt.Errorf("could not replace '%v': %w", exe, err)
+	}
+	return nil
+}
+
+// newVersion writes the version patch makes of exe to a temporary file
+// next to it and returns its name
+func newVersion(exe string, patch []byte, o *options) (_ string, err error) {
+	oldF, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer oldF.Close()
+	fi, err := oldF.Stat()
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("could not create the new version of '%v': %w", exe, err)
+	}
+	tmpname := tmp.Name()
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpname)
+		}
+	}()
+	bw := bufio.NewWriter(tmp)
+	popts := append([]bspatch.Option{bspatch.WithRequireNewSHA256()}, o.patchOpts...)
+	if err = bspatch.Apply(oldF, bytes.NewReader(patch), bw, popts...); err != nil {
+		return "", err
+	}
+	if err = bw.Flush(); err != nil {
+		return "", err
+	}
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", err
+	}
+	return tmpname, tmp.Close()
+}
+
+// Startup starts the trial of a new version, or rolls it back if its
+// trial ended without Confirm and returns ErrRolledBack. Programs that
+// update themselves call it early, before they can fail.
+func Startup(opts ...Option) error {
+	exe, err := newOptions(opts).executable()
+	if err != nil {
+		return err
+	}
+	os.Remove(exe + delExt)
+	if exists(exe + trialExt) {
+		if err = Rollback(opts...); err != nil {
+			return err
+		}
+		return ErrRolledBack
+	}
+	if exists(exe + pendingExt) {
+		return os.Rename(exe+pendingExt, exe+trialExt)
+	}
+	return nil
+}
+
+// Confirm ends the trial of a new version, which the previous one then no
+// longer replaces
+func Confirm(opts ...Option) error {
+	exe, err := newOptions(opts).executable()
+	if err != nil {
+		return err
+	}
+	for _, ext := range []string{trialExt, pendingExt, oldExt} {
+		if err = os.Remove(exe + ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback puts the previous version back. It fails with ErrNoUpdate
+// once the update is confirmed.
+func Rollback(opts ...Option) error {
+	exe, err := newOptions(opts).executable()
+	if err != nil {
+		return err
+	}
+	if !exists(exe + oldExt) {
+		return ErrNoUpdate
+	}
+	if err = replace(exe, exe+oldExt, ""); err != nil {
+		return fmt.Errorf("could not restore '%v': %w", exe, err)
+	}
+	os.Remove(exe + trialExt)
+	os.Remove(exe + pendingExt)
+	return nil
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
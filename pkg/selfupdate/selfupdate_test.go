@@ -0,0 +1,115 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+func check(t *testing.T, exe string, want []byte) {
+	t.Helper()
+	b, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatal("wrong executable")
+	}
+}
+
+func TestSelfUpdate(t *testing.T) {
+	v1 := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(v1)
+	v2 := append([]byte(nil), v1...)
+	copy(v2[1000:], "version 2")
+	patch, err := bsdiff.Bytes(v1, v2, bsdiff.WithHashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Sign(priv, patch)
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "app")
+	if err = os.WriteFile(exe, v1, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opt := WithExecutable(exe)
+
+	if err = Apply(patch, sig[:len(sig)-1], pub, opt); !errors.Is(err, ErrSignature) {
+		t.Fatal("bad signature:", err)
+	}
+	plain, _ := bsdiff.Bytes(v1, v2)
+	if err = Apply(plain, Sign(priv, plain), pub, opt); !errors.Is(err, bspatch.ErrNoNewSHA256) {
+		t.Fatal("no hashes:", err)
+	}
+	check(t, exe, v1)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+	if err = os.WriteFile(filepath.Join(dir, "v2.patch"), patch, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "v2.patch.sig"), sig, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = Update(context.Background(), srv.URL+"/v2.patch", pub, opt); err != nil {
+		t.Fatal(err)
+	}
+	check(t, exe, v2)
+	if fi, err := os.Stat(exe); err != nil || fi.Mode().Perm() != 0o755 {
+		t.Fatal("mode:", fi, err)
+	}
+	if err = Apply(patch, sig, pub, opt); !errors.Is(err, bspatch.ErrWrongOld) {
+		t.Fatal("applied twice:", err)
+	}
+
+	// The first start begins the trial, the second, unconfirmed, ends it
+	if err = Startup(opt); err != nil {
+		t.Fatal(err)
+	}
+	check(t, exe, v2)
+	if err = Startup(opt); !errors.Is(err, ErrRolledBack) {
+		t.Fatal("rollback:", err)
+	}
+	check(t, exe, v1)
+	if err = Startup(opt); err != nil {
+		t.Fatal(err)
+	}
+	if err = Rollback(opt); !errors.Is(err, ErrNoUpdate) {
+		t.Fatal(err)
+	}
+
+	// A confirmed update stays
+	if err = Apply(patch, sig, pub, opt); err != nil {
+		t.Fatal(err)
+	}
+	if err = Startup(opt); err != nil {
+		t.Fatal(err)
+	}
+	if err = Confirm(opt); err != nil {
+		t.Fatal(err)
+	}
+	if err = Startup(opt); err != nil {
+		t.Fatal(err)
+	}
+	check(t, exe, v2)
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if name := e.Name(); name != "app" && name != "v2.patch" && name != "v2.patch.sig" {
+			t.Error("left over:", name)
+		}
+	}
+}
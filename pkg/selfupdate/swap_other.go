@@ -0,0 +1,24 @@
+//go:build !windows
+
+package selfupdate
+
+import (
+	"os"
+
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// replace renames file over exe, atomically, after linking or copying exe
+// to backup unless backup is empty
+func replace(exe, file, backup string) error {
+	if backup != "" {
+		os.Remove(backup)
+		if err := os.Link(exe, backup); err != nil {
+			// Some file systems have no hard links
+			if err = bspatch.Backup(exe, backup); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(file, exe)
+}
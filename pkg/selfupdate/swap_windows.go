@@ -0,0 +1,24 @@
+//go:build windows
+
+package selfupdate
+
+import "os"
+
+// replace puts file in the place of exe, moving exe to backup, or aside to
+// exe.del if backup is empty. Windows can't overwrite or remove a running
+// executable, but it can rename it.
+func replace(exe, file, backup string) error {
+	aside := backup
+	if aside == "" {
+		aside = exe + delExt
+	}
+	os.Remove(aside)
+	if err := os.Rename(exe, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(file, exe); err != nil {
+		os.Rename(aside, exe)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// MatchLen returns the length of the common prefix of a and b, comparing 8
+// bytes at a time
+func MatchLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		if x := binary.LittleEndian.Uint64(a[i:]) ^ binary.LittleEndian.Uint64(b[i:]); x != 0 {
+			return i + bits.TrailingZeros64(x)/8
+		}
+	}
+	for ; i < n && a[i] == b[i]; i++ {
+	}
+	return i
+}
+
+// AddBytes adds the bytes of src to those of dst, modulo 256, 8 bytes at a
+// time. dst must be at least as long as src.
+func AddBytes(dst, src []byte) {
+	// The high bits of each byte are added apart so carries don't cross
+	// into the next byte
+	const high = 0x8080808080808080
+	dst = dst[:len(src)]
+	i := 0
+	for ; i+8 <= len(src); i += 8 {
+		a, b := binary.LittleEndian.Uint64(dst[i:]), binary.LittleEndian.Uint64(src[i:])
+		binary.LittleEndian.PutUint64(dst[i:], ((a&^high)+(b&^high))^((a^b)&high))
+	}
+	for ; i < len(src); i++ {
+		dst[i] += src[i]
+	}
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMatchLen(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		a := make([]byte, rand.Intn(40))
+		rand.Read(a)
+		b := append([]byte{}, a[:rand.Intn(len(a)+1)]...)
+		if k := rand.Intn(len(b) + 1); k < len(b) {
+			b[k]++
+		}
+		b = append(b, byte(rand.Intn(2)))
+		want := 0
+		for want < len(a) && want < len(b) && a[want] == b[want] {
+			want++
+		}
+		if n := MatchLen(a, b); n != want {
+			t.Fatalf("MatchLen(%v, %v) = %v, want %v", a, b, n, want)
+		}
+	}
+}
+
+func TestAddBytes(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		dst := make([]byte, rand.Intn(40))
+		src := make([]byte, rand.Intn(len(dst)+1))
+		rand.Read(dst)
+		rand.Read(src)
+		want := append([]byte{}, dst...)
+		for j := range src {
+			want[j] += src[j]
+		}
+		AddBytes(dst, src)
+		for j := range want {
+			if dst[j] != want[j] {
+				t.Fatalf("AddBytes byte %v = %v, want %v", j, dst[j], want[j])
+			}
+		}
+	}
+}
+
+func BenchmarkMatchLen(b *testing.B) {
+	a := make([]byte, 64*1024)
+	b.SetBytes(int64(len(a)))
+	for i := 0; i < b.N; i++ {
+		MatchLen(a, a)
+	}
+}
+
+func BenchmarkAddBytes(b *testing.B) {
+	dst, src := make([]byte, 64*1024), make([]byte, 64*1024)
+	b.SetBytes(int64(len(dst)))
+	for i := 0; i < b.N; i++ {
+		AddBytes(dst, src)
+	}
+}
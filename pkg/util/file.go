@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes name with fn through a temporary file next to it, synced
+// and renamed to name once fn succeeds, so name is replaced atomically and
+// never left half written. The file keeps the mode of the file it replaces;
+// a new one gets 0666 less the umask, as with os.Create. The errors of fn,
+// and its panics as a *PanicError, are returned as they are.
+func WriteFile(name string, fn func(f *os.File) error) error {
+	perm, keep := fs.FileMode(0666), false
+	if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+		perm, keep = fi.Mode().Perm(), true
+	}
+	var f *os.File
+	tmp, err := tempName(name, ".tmp", func(tmp string) (err error) {
+		f, err = os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not create '%v': %w", name, err)
+	}
+	err = func() (err error) {
+		defer Recover(&err)
+		return fn(f)
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// The umask may have cleared bits of the mode kept
+	if keep {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write '%v': %w", name, err)
+	}
+	return nil
+}
+
+// MkdirTemp makes a temporary directory next to name, with mode 0777 less
+// the umask as with os.Mkdir, to be renamed to name once complete
+func MkdirTemp(name string) (string, error) {
+	return tempName(name, ".tmp", func(tmp string) error {
+		return os.Mkdir(tmp, 0777)
+	})
+}
+
+// tempName calls create with names next to name, hidden and ending in
+// suffix and a random number, until one doesn't exist yet
+func tempName(name, suffix string, create func(tmp string) error) (string, error) {
+	dir, base := filepath.Split(filepath.Clean(name))
+	for i := 0; i < 10000; i++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%v%v%v", base, suffix, rand.Uint32()))
+		if err := create(tmp); !os.IsExist(err) {
+			return tmp, err
+		}
+	}
+	return "", &fs.PathError{Op: "createtemp", Path: name, Err: fs.ErrExist}
+}
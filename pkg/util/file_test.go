@@ -0,0 +1,102 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "patch")
+	write := func(s string) func(f *os.File) error {
+		return func(f *os.File) error {
+			_, err := f.WriteString(s)
+			return err
+		}
+	}
+	if err := WriteFile(name, write("one")); err != nil {
+		t.Fatal(err)
+	}
+	// A new file gets the mode os.Create gives
+	created := filepath.Join(dir, "created")
+	f, err := os.Create(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Stat(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != want.Mode() {
+		t.Fatal(fi.Mode(), "!=", want.Mode())
+	}
+	// A replaced file keeps its mode
+	if err = os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteFile(name, write("two")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err = os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Fatal(fi.Mode())
+	}
+	// A failure or panic leaves the file as it was and no temporary file
+	fail := errors.New("fail")
+	if err = WriteFile(name, func(f *os.File) error {
+		f.WriteString("three")
+		return fail
+	}); err != fail {
+		t.Fatal("expected fail, got", err)
+	}
+	var pe *PanicError
+	if err = WriteFile(name, func(f *os.File) error {
+		panic("four")
+	}); !errors.As(err, &pe) {
+		t.Fatal("expected a PanicError, got", err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "two" {
+		t.Fatal(string(b))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("expected only patch and created, got", entries)
+	}
+}
+
+func TestMkdirTemp(t *testing.T) {
+	dir := t.TempDir()
+	tmp, err := MkdirTemp(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(tmp) != dir {
+		t.Fatal(tmp)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "made"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Stat(filepath.Join(dir, "made"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != want.Mode() {
+		t.Fatal(fi.Mode(), "!=", want.Mode())
+	}
+}
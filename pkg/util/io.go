@@ -5,10 +5,6 @@ import (
 	"io"
 )
 
-const (
-	buffersize = 1024 * 16
-)
-
 // BufWriter is byte slice buffer that implements io.WriteSeeker
 type BufWriter struct {
 	buf  []byte
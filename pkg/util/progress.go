@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Meter reports the progress of a stage to a callback, about every percent
+// of it rather than on every update. A nil Meter reports nothing.
+type Meter struct {
+	fn    func(stage string, done, total int64)
+	stage string
+	// total is -1 when unknown
+	total    int64
+	done     int64
+	next     int64
+	reported int64
+}
+
+// unknownStep is how often progress of unknown total is reported
+const unknownStep = 1 << 20
+
+// NewMeter reports the start of stage to fn and returns a Meter for it, or
+// nil if fn is nil. total is -1 when unknown.
+func NewMeter(fn func(stage string, done, total int64), stage string, total int64) *Meter {
+	if fn == nil {
+		return nil
+	}
+	m := &Meter{fn: fn, stage: stage, total: total, reported: -1}
+	m.report(0)
+	return m
+}
+
+// Update records that done units of the stage are complete
+func (m *Meter) Update(done int64) {
+	if m == nil {
+		return
+	}
+	m.done = done
+	if done >= m.next || done == m.total {
+		m.report(done)
+	}
+}
+
+// Done reports the end of the stage. When the total was unknown, it's what
+// was done.
+func (m *Meter) Done() {
+	if m == nil {
+		return
+	}
+	if m.total < 0 {
+		m.total = m.done
+	}
+	m.report(m.total)
+}
+
+func (m *Meter) report(done int64) {
+	if done == m.reported {
+		return
+	}
+	m.reported = done
+	step := int64(unknownStep)
+	if m.total >= 0 {
+		step = m.total / 100
+	}
+	if step < 1 {
+		step = 1
+	}
+	m.next = done + step
+	m.fn(m.stage, done, m.total)
+}
+
+// barWidth is the width of a ProgressBar, in characters
+const barWidth = 30
+
+// ProgressBar draws the stages reported to the WithProgress options of
+// bsdiff and bspatch as bars on a terminal, then the time each took. On
+// other writers, such as log files, only the finished stages are printed.
+type ProgressBar struct {
+	w     io.Writer
+	tty   bool
+	stage string
+	start time.Time
+	// open is whether the line of stage is unfinished
+	open bool
+}
+
+// NewProgressBar returns a ProgressBar drawing on w, a terminal if it's a
+// character device
+func NewProgressBar(w io.Writer) *ProgressBar {
+	b := &ProgressBar{w: w}
+	if f, ok := w.(*os.File); ok {
+		fi, err := f.Stat()
+		b.tty = err == nil && fi.Mode()&os.ModeCharDevice != 0
+	}
+	return b
+}
+
+// Update draws that done of total units of stage are complete, total being
+// -1 when unknown. It has the signature of the WithProgress callbacks.
+func (b *ProgressBar) Update(stage string, done, total int64) {
+	if stage != b.stage || !b.open {
+		b.Finish()
+		b.stage, b.start, b.open = stage, time.Now(), true
+	}
+	elapsed := time.Since(b.start).Round(time.Millisecond)
+	end := total >= 0 && done >= total
+	if b.tty {
+		if total < 0 {
+			fmt.Fprintf(b.w, "\r%-8s %6d MiB %10v", stage, done>>20, elapsed)
+		} else {
+			pct, fill := int64(100), int64(barWidth)
+			if total > 0 {
+				pct, fill = done*100/total, done*barWidth/total
+			}
+			fmt.Fprintf(b.w, "\r%-8s [%-*s] %3d%% %10v", stage, barWidth, strings.Repeat("=", int(fill)), pct, elapsed)
+		}
+	}
+	if end {
+		b.Finish()
+	}
+}
+
+// Finish ends the line of the current stage, if any, with the time it took
+func (b *ProgressBar) Finish() {
+	if !b.open {
+		return
+	}
+	b.open = false
+	if b.tty {
+		fmt.Fprintln(b.w)
+		return
+	}
+	fmt.Fprintf(b.w, "%-8s done in %v\n", b.stage, time.Since(b.start).Round(time.Millisecond))
+}
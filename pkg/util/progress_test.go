@@ -0,0 +1,73 @@
+package util
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestMeter(t *testing.T) {
+	var calls [][2]int64
+	fn := func(stage string, done, total int64) {
+		if stage != "scan" {
+			t.Fatal("unexpected stage", stage)
+		}
+		calls = append(calls, [2]int64{done, total})
+	}
+	m := NewMeter(fn, "scan", 10000)
+	for i := int64(0); i <= 10000; i += 10 {
+		m.Update(i)
+	}
+	m.Done()
+	// 0, every 100 bytes, and the end once
+	if len(calls) != 101 || calls[0] != [2]int64{0, 10000} || calls[100] != [2]int64{10000, 10000} {
+		t.Fatal("unexpected reports", len(calls), calls[0], calls[len(calls)-1])
+	}
+
+	calls = nil
+	m = NewMeter(fn, "scan", -1)
+	m.Update(unknownStep / 2)
+	m.Update(unknownStep + 5)
+	m.Update(unknownStep + 6)
+	m.Done()
+	want := [][2]int64{{0, -1}, {unknownStep + 5, -1}, {unknownStep + 6, unknownStep + 6}}
+	if len(calls) != len(want) {
+		t.Fatal("unexpected reports", calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatal("unexpected reports", calls)
+		}
+	}
+
+	// A nil Meter reports nothing
+	m = NewMeter(nil, "scan", 10)
+	m.Update(5)
+	m.Done()
+}
+
+func TestProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	b := NewProgressBar(&out)
+	b.Update("sort", 0, 100)
+	b.Update("sort", 50, 100)
+	b.Update("sort", 100, 100)
+	b.Update("scan", 0, -1)
+	b.Update("scan", 5<<20, -1)
+	// An unfinished stage is ended by the next one
+	b.Update("compress", 0, 3)
+	b.Finish()
+	b.Finish()
+	if !regexp.MustCompile(`^sort +done in \S+\nscan +done in \S+\ncompress +done in \S+\n$`).Match(out.Bytes()) {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	out.Reset()
+	b = &ProgressBar{w: &out, tty: true}
+	b.Update("apply", 0, 4)
+	b.Update("apply", 2, 4)
+	b.Update("apply", 4, 4)
+	if !regexp.MustCompile(`^\rapply +\[ {30}\]   0% +\S+\rapply +\[={15} {15}\]  50% +\S+\rapply +\[={30}\] 100% +\S+\n$`).Match(out.Bytes()) {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
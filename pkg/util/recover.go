@@ -0,0 +1,45 @@
+package util
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a panic turned into an error by Recover, so a crafted patch
+// or pathological input fails the call instead of crashing the program
+type PanicError struct {
+	// Value is what panic was called with
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.Value)
+}
+
+// Unwrap returns the value of the panic if it's an error, such as a
+// runtime.Error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// NewPanicError returns the PanicError of a recovered value v, which is kept
+// as is when it already is one
+func NewPanicError(v interface{}) *PanicError {
+	if pe, ok := v.(*PanicError); ok {
+		return pe
+	}
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Recover stores a panic of the calling function in *err as a *PanicError.
+// It must be deferred directly:
+//
+//	defer util.Recover(&err)
+func Recover(err *error) {
+	if v := recover(); v != nil {
+		*err = NewPanicError(v)
+	}
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	index := func(b []byte, i int) (err error) {
+		defer Recover(&err)
+		_ = b[i]
+		return nil
+	}
+	if err := index(make([]byte, 4), 3); err != nil {
+		t.Fatal(err)
+	}
+	err := index(make([]byte, 4), 4)
+	var pe *PanicError
+	var re runtime.Error
+	if !errors.As(err, &pe) || !errors.As(err, &re) || len(pe.Stack) == 0 {
+		t.Fatal("expected a recovered runtime error, got", err)
+	}
+	if NewPanicError(pe) != pe {
+		t.Fatal("a PanicError raised again was wrapped")
+	}
+}
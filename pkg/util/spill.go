@@ -0,0 +1,163 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// SpillWriter is a buffer like BufWriter that keeps its first bytes in memory
+// and the rest in a temporary file, so large patches or new files don't have
+// to fit in memory. It implements io.WriteSeeker, io.WriterAt and
+// io.ReaderAt. Close removes the temporary file.
+type SpillWriter struct {
+	mem   []byte
+	limit int
+	file  *os.File
+	size  int64
+	pos   int64
+	// Dir is the directory of the temporary file, os.TempDir() if empty
+	Dir string
+}
+
+// NewSpillWriter returns a SpillWriter keeping up to limit bytes in memory
+func NewSpillWriter(limit int) *SpillWriter {
+	return &SpillWriter{limit: limit}
+}
+
+// WriteAt writes p at off, growing the buffer as needed
+func (m *SpillWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off < int64(m.limit) {
+		k := len(p)
+		if end := off + int64(k); end > int64(m.limit) {
+			k = m.limit - int(off)
+		}
+		if end := int(off) + k; end > len(m.mem) {
+			if end > cap(m.mem) {
+				mem := make([]byte, end, 2*end)
+				copy(mem, m.mem)
+				m.mem = mem
+			}
+			m.mem = m.mem[:end]
+		}
+		copy(m.mem[off:], p[:k])
+		n, p, off = k, p[k:], off+int64(k)
+	}
+	if len(p) > 0 {
+		if m.file == nil {
+			if m.file, err = os.CreateTemp(m.Dir, "bsdiff-spill-*"); err != nil {
+				return n, err
+			}
+		}
+		k, err := m.file.WriteAt(p, off-int64(m.limit))
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	if end := off + int64(len(p)); end > m.size {
+		m.size = end
+	}
+	return n, nil
+}
+
+// Write the contents of p and return the bytes written
+func (m *SpillWriter) Write(p []byte) (n int, err error) {
+	n, err = m.WriteAt(p, m.pos)
+	m.pos += int64(n)
+	return n, err
+}
+
+// Seek to a position in the buffer
+func (m *SpillWriter) Seek(offset int64, whence int) (int64, error) {
+	newPos := offset
+	switch whence {
+	case io.SeekCurrent:
+		newPos += m.pos
+	case io.SeekEnd:
+		newPos += m.size
+	}
+	if newPos < 0 {
+		return 0, fmt.Errorf("negative result pos")
+	}
+	m.pos = newPos
+	return newPos, nil
+}
+
+// ReadAt reads the buffer at off. Bytes that were skipped over read as zero.
+func (m *SpillWriter) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	if rest := m.size - off; int64(len(p)) > rest {
+		p, err = p[:rest], io.EOF
+	}
+	for n < len(p) {
+		o := off + int64(n)
+		var k int
+		switch {
+		case o < int64(len(m.mem)):
+			k = copy(p[n:], m.mem[o:])
+		case o < int64(m.limit):
+			// A hole before the spilled bytes
+			k = len(p) - n
+			if hole := int64(m.limit) - o; int64(k) > hole {
+				k = int(hole)
+			}
+			for i := range p[n : n+k] {
+				p[n+i] = 0
+			}
+		default:
+			var ferr error
+			k, ferr = m.file.ReadAt(p[n:], o-int64(m.limit))
+			if ferr == io.EOF {
+				// The file is shorter when its end was skipped over
+				for i := range p[n+k:] {
+					p[n+k+i] = 0
+				}
+				k = len(p) - n
+			} else if ferr != nil {
+				return n + k, ferr
+			}
+		}
+		n += k
+	}
+	return n, err
+}
+
+// Len returns the size of the buffer
+func (m *SpillWriter) Len() int64 {
+	return m.size
+}
+
+// Spilled reports whether part of the buffer is in a temporary file
+func (m *SpillWriter) Spilled() bool {
+	return m.file != nil
+}
+
+// WriteTo writes the whole buffer to w
+func (m *SpillWriter) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(m, 0, m.size))
+}
+
+// Close removes the temporary file, if any. The buffer can't be used
+// afterwards.
+func (m *SpillWriter) Close() error {
+	m.mem = nil
+	if m.file == nil {
+		return nil
+	}
+	name := m.file.Name()
+	err := m.file.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	m.file = nil
+	return err
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestSpillWriter(t *testing.T) {
+	m := NewSpillWriter(1000)
+	m.Dir = t.TempDir()
+	var want []byte
+	for i := 0; i < 200; i++ {
+		p := make([]byte, rand.Intn(100))
+		rand.Read(p)
+		off := rand.Intn(3000)
+		if _, err := m.WriteAt(p, int64(off)); err != nil {
+			t.Fatal(err)
+		}
+		if end := off + len(p); end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[off:], p)
+	}
+	// Writes continue at the end
+	if _, err := m.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	m.Write([]byte("tail"))
+	want = append(want, "tail"...)
+	if m.Len() != int64(len(want)) {
+		t.Fatal(m.Len(), "!=", len(want))
+	}
+	if !m.Spilled() {
+		t.Fatal("expected the buffer to spill")
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatal("contents differ")
+	}
+	p := make([]byte, 500)
+	if n, err := m.ReadAt(p, 900); n != 500 || err != nil || !bytes.Equal(p, want[900:1400]) {
+		t.Fatal("ReadAt across the limit failed", n, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(m.Dir); len(entries) != 0 {
+		t.Fatal("temporary file not removed")
+	}
+
+	small := NewSpillWriter(1 << 20)
+	small.Write([]byte("small"))
+	if small.Spilled() {
+		t.Fatal("a small buffer shouldn't spill")
+	}
+	if err := small.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package vcdiff encodes and decodes VCDIFF (RFC 3284) deltas.
+//
+// The encoder serializes the control triples of the bsdiff matcher, so the
+// deltas are as good as bsdiff's matches and can be applied by any VCDIFF
+// decoder (xdelta3, open-vcdiff, HTTP delta encoding). The decoder reads
+// deltas using the default code table, including the xdelta3 Adler-32
+// window checksum extension and its lzma and djw secondary compression.
+// VCD_TARGET windows may copy from the previous window only, as in xdelta3.
+package vcdiff
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+// ErrUnsupported is returned when a delta uses a VCDIFF feature this package
+// can't decode, e.g. fgk secondary compression or a custom code table
+var ErrUnsupported = vcdiff.ErrUnsupported
+
+// ErrCorrupt is returned when a delta is malformed
+var ErrCorrupt = vcdiff.ErrCorrupt
+
+// minCopy is the shortest run of matching bytes encoded as a COPY; shorter
+// runs are cheaper as part of an ADD
+const minCopy = 4
+
+// Diff returns a VCDIFF delta turning oldbs into newbs
+func Diff(oldbs, newbs []byte, opts ...bsdiff.Option) ([]byte, error) {
+	var out bytes.Buffer
+	if err := Encode(oldbs, newbs, &out, opts...); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Encode writes a VCDIFF delta turning oldbs into newbs to w. The whole of
+// oldbs is the source segment of every window. The matcher options of
+// bsdiff.Match apply.
+func Encode(oldbs, newbs []byte, w io.Writer, opts ...bsdiff.Option) error {
+	e, err := vcdiff.NewEncoder(w, len(oldbs))
+	if err != nil {
+		return err
+	}
+	err = bsdiff.Match(oldbs, newbs, func(c bsdiff.Control) error {
+		return control(e, oldbs, newbs, c)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// control encodes a bsdiff control triple: matching runs of the added
+// region become COPYs from the source, everything else ADDs
+func control(e *vcdiff.Encoder, oldbs, newbs []byte, c bsdiff.Control) error {
+	lit := 0
+	for i := 0; i < c.Add; {
+		if newbs[c.NewPos+i] != oldbs[c.OldPos+i] {
+			i++
+			continue
+		}
+		j := i
+		for j < c.Add && newbs[c.NewPos+j] == oldbs[c.OldPos+j] {
+			j++
+		}
+		if j-i >= minCopy {
+			if err := e.Add(newbs[c.NewPos+lit : c.NewPos+i]); err != nil {
+				return err
+			}
+			if err := e.Copy(c.OldPos+i, j-i); err != nil {
+				return err
+			}
+			lit = j
+		}
+		i = j
+	}
+	return e.Add(newbs[c.NewPos+lit : c.NewPos+c.Add+c.Copy])
+}
+
+// Patch applies a VCDIFF delta to oldbs and returns the target
+func Patch(oldbs, delta []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := Decode(bytes.NewReader(oldbs), bytes.NewReader(delta), &out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decode applies the VCDIFF delta read from delta to the source old and
+// writes the target to w. Windows are decoded one at a time, so memory use
+// is bounded by the target window size rather than the target size.
+func Decode(old io.ReaderAt, delta io.Reader, w io.Writer) error {
+	return vcdiff.Decode(old, delta, w)
+}
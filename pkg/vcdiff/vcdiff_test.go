@@ -0,0 +1,68 @@
+package vcdiff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/gabstv/go-bsdiff/internal/vcdiff"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	oldbs := make([]byte, 1024*64)
+	rng.Read(oldbs)
+	newbs := append([]byte(nil), oldbs[:1024*32]...)
+	newbs = append(newbs, "inserted in the middle"...)
+	newbs = append(newbs, oldbs[1024*32:]...)
+	for i := 0; i < 200; i++ {
+		newbs[rng.Intn(len(newbs))] ^= 0x5A
+	}
+	for _, tc := range []struct {
+		name     string
+		old, new []byte
+	}{
+		{"edits", oldbs, newbs},
+		{"identical", oldbs, oldbs},
+		{"empty old", nil, newbs[:1000]},
+		{"empty new", oldbs, nil},
+	} {
+		delta, err := Diff(tc.old, tc.new)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if !bytes.Equal(delta[:4], vcdiff.Magic) {
+			t.Fatal(tc.name, "bad magic", delta[:4])
+		}
+		got, err := Patch(tc.old, delta)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if !bytes.Equal(got, tc.new) {
+			t.Fatal(tc.name, "round trip failed")
+		}
+	}
+}
+
+func TestWindows(t *testing.T) {
+	defer func(n int) {
+		vcdiff.WindowSize = n
+	}(vcdiff.WindowSize)
+	vcdiff.WindowSize = 1000
+	rng := rand.New(rand.NewSource(2))
+	oldbs := make([]byte, 1024*8)
+	rng.Read(oldbs)
+	newbs := append([]byte(nil), oldbs...)
+	rng.Read(newbs[3000:3500])
+	delta, err := Diff(oldbs, newbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Patch(oldbs, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newbs) {
+		t.Fatal("round trip failed")
+	}
+}
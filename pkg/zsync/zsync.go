@@ -0,0 +1,218 @@
+// Package zsync writes zsync control files. A control file lists checksums
+// of the blocks of a new file, so a zsync client can find the blocks it
+// already has locally and fetch only the other ranges over HTTP, rather
+// than downloading a patch made by the server.
+package zsync
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Version is the zsync version written in control files
+const Version = "0.6.2"
+
+// Option configures a control file
+type Option func(*options)
+
+type options struct {
+	filename  string
+	url       string
+	mtime     time.Time
+	blockSize int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithFilename sets the name clients save the file as
+func WithFilename(name string) Option {
+	return func(o *options) {
+		o.filename = name
+	}
+}
+
+// WithURL sets the URL of the file, relative to the control file's URL or
+// absolute. It defaults to the file name.
+func WithURL(url string) Option {
+	return func(o *options) {
+		o.url = url
+	}
+}
+
+// WithMTime records the modification time of the file
+func WithMTime(t time.Time) Option {
+	return func(o *options) {
+		o.mtime = t
+	}
+}
+
+// WithBlockSize sets the block size, a power of two. By default it's 2048
+// bytes; File uses 4096 for files of 100 MiB and more, like zsyncmake.
+func WithBlockSize(n int) Option {
+	return func(o *options) {
+		o.blockSize = n
+	}
+}
+
+// Write reads the file from r and writes its control file to w
+//
+//	zsync: 0.6.2
+//	Filename: name
+//	MTime: RFC 2822 date
+//	Blocksize: block size
+//	Length: file length
+//	Hash-Lengths: sequential matches,rsum bytes,checksum bytes
+//	URL: url
+//	SHA-1: hex SHA-1 of the file
+//	(blank line)
+//	per block: the last rsum bytes of its rolling checksum and the first
+//	checksum bytes of the MD4 of the block, zero padded to the block size
+func Write(w io.Writer, r io.Reader, opts ...Option) error {
+	o := newOptions(opts)
+	if o.blockSize < 0 || o.blockSize&(o.blockSize-1) != 0 {
+		return fmt.Errorf("block size %v isn't a power of two", o.blockSize)
+	}
+	var sums []byte
+	length := 0
+	whole := sha1.New()
+	h := md4.New()
+	bs := o.blockSize
+	if bs == 0 {
+		bs = 2048
+	}
+	block := make([]byte, bs)
+	br := bufio.NewReader(r)
+	for {
+		n, err := io.ReadFull(br, block)
+		if n > 0 {
+			length += n
+			whole.Write(block[:n])
+			for i := n; i < len(block); i++ {
+				block[i] = 0
+			}
+			sums = binary.BigEndian.AppendUint32(sums, rsum(block))
+			h.Reset()
+			h.Write(block)
+			sums = h.Sum(sums)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	seq, rlen, clen := hashLengths(length, bs)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "zsync: %v\n", Version)
+	if o.filename != "" {
+		fmt.Fprintf(bw, "Filename: %v\n", o.filename)
+	}
+	if !o.mtime.IsZero() {
+		fmt.Fprintf(bw, "MTime: %v\n", o.mtime.UTC().Format(time.RFC1123Z))
+	}
+	fmt.Fprintf(bw, "Blocksize: %v\n", bs)
+	fmt.Fprintf(bw, "Length: %v\n", length)
+	fmt.Fprintf(bw, "Hash-Lengths: %v,%v,%v\n", seq, rlen, clen)
+	url := o.url
+	if url == "" {
+		url = o.filename
+	}
+	if url != "" {
+		fmt.Fprintf(bw, "URL: %v\n", url)
+	}
+	fmt.Fprintf(bw, "SHA-1: %v\n\n", hex.EncodeToString(whole.Sum(nil)))
+	for i := 0; i < len(sums); i += 4 + md4.Size {
+		bw.Write(sums[i+4-rlen : i+4])
+		bw.Write(sums[i+4 : i+4+clen])
+	}
+	return bw.Flush()
+}
+
+// File writes the control file of newfile to controlfile. The file name and
+// modification time are taken from newfile unless opts set them.
+func File(newfile, controlfile string, opts ...Option) error {
+	f, err := os.Open(newfile)
+	if err != nil {
+		return fmt.Errorf("could not open newfile '%v': %v", newfile, err.Error())
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat newfile '%v': %v", newfile, err.Error())
+	}
+	defaults := []Option{WithFilename(filepath.Base(newfile)), WithMTime(fi.ModTime())}
+	if fi.Size() >= 100<<20 {
+		defaults = append(defaults, WithBlockSize(4096))
+	}
+	opts = append(defaults, opts...)
+	cf, err := os.OpenFile(controlfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create controlfile '%v': %v", controlfile, err.Error())
+	}
+	err = Write(cf, f, opts...)
+	_ = cf.Close()
+	if err != nil {
+		os.Remove(controlfile)
+		return fmt.Errorf("zsync: %v", err.Error())
+	}
+	return nil
+}
+
+// rsum is zsync's rolling checksum of a block: a 16 bit sum of the bytes,
+// followed by a 16 bit sum weighted by the distance to the end of the block
+func rsum(block []byte) uint32 {
+	var a, b uint16
+	for i, c := range block {
+		a += uint16(c)
+		b += uint16(len(block)-i) * uint16(c)
+	}
+	return uint32(a)<<16 | uint32(b)
+}
+
+// hashLengths returns how many blocks must match in sequence and how many
+// bytes of the rsum and checksum of each block are kept, with the
+// heuristics of zsyncmake
+func hashLengths(length, blockSize int) (seq, rlen, clen int) {
+	seq = 1
+	if length > blockSize {
+		seq = 2
+	}
+	l := math.Log(float64(length))
+	if length == 0 {
+		l = 0
+	}
+	rlen = int(math.Ceil(((l+math.Log(float64(blockSize)))/math.Log(2) - 8.6) / float64(seq) / 8))
+	if rlen > 4 {
+		rlen = 4
+	}
+	if rlen < 2 {
+		rlen = 2
+	}
+	blocks := math.Log(float64(1+length/blockSize)) / math.Log(2)
+	clen = int(math.Ceil((20 + l/math.Log(2) + blocks) / float64(seq) / 8))
+	if min := int((7.9 + 20 + blocks) / 8); clen < min {
+		clen = min
+	}
+	if clen > 16 {
+		clen = 16
+	}
+	return seq, rlen, clen
+}
@@ -0,0 +1,79 @@
+package zsync
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+func TestWrite(t *testing.T) {
+	data := make([]byte, 1024*5/2)
+	rand.New(rand.NewSource(1)).Read(data)
+	mtime := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	var out bytes.Buffer
+	err := Write(&out, bytes.NewReader(data), WithFilename("new.bin"), WithMTime(mtime), WithBlockSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(out.Bytes(), []byte("\n\n"))
+	if i < 0 {
+		t.Fatal("no end of header")
+	}
+	sum := sha1.Sum(data)
+	header := strings.Join([]string{
+		"zsync: 0.6.2",
+		"Filename: new.bin",
+		"MTime: Mon, 03 Feb 2020 04:05:06 +0000",
+		"Blocksize: 1024",
+		"Length: 2560",
+		"Hash-Lengths: 2,2,3",
+		"URL: new.bin",
+		"SHA-1: " + hex.EncodeToString(sum[:]),
+	}, "\n")
+	if got := out.String()[:i]; got != header {
+		t.Fatal(got)
+	}
+	sums := out.Bytes()[i+2:]
+	if len(sums) != 3*(2+3) {
+		t.Fatal("expected 3 block sums, got", len(sums), "bytes")
+	}
+	// the last block is zero padded
+	last := make([]byte, 1024)
+	copy(last, data[2048:])
+	r := rsum(last)
+	h := md4.New()
+	h.Write(last)
+	want := append([]byte{byte(r >> 8), byte(r)}, h.Sum(nil)[:3]...)
+	if !bytes.Equal(sums[10:], want) {
+		t.Fatal(sums[10:], "!=", want)
+	}
+}
+
+func TestRsum(t *testing.T) {
+	// a = 1+2+3, b = 3*1+2*2+1*3
+	if got := rsum([]byte{1, 2, 3}); got != 6<<16|10 {
+		t.Fatalf("%#x", got)
+	}
+}
+
+func TestHashLengths(t *testing.T) {
+	for _, tc := range []struct {
+		length, blockSize int
+		seq, rlen, clen   int
+	}{
+		{100, 2048, 1, 2, 4},
+		{1 << 20, 2048, 2, 2, 4},
+		{1 << 30, 4096, 2, 3, 5},
+	} {
+		seq, rlen, clen := hashLengths(tc.length, tc.blockSize)
+		if seq != tc.seq || rlen != tc.rlen || clen != tc.clen {
+			t.Fatal(tc, seq, rlen, clen)
+		}
+	}
+}